@@ -1,9 +1,9 @@
 package openwtester
 
 import (
+	"github.com/Assetsadapter/qtum-adapter/qtum"
 	"github.com/blocktree/openwallet/log"
 	"github.com/blocktree/openwallet/openw"
-	"github.com/blocktree/qtum-adapter/qtum"
 )
 
 func init() {
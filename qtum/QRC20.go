@@ -18,30 +18,121 @@ package qtum
 import (
 	"encoding/hex"
 	"fmt"
+	"github.com/Assetsadapter/qtum-adapter/qtum/abi"
 	"github.com/blocktree/go-owcdrivers/addressEncoder"
-	"github.com/blocktree/openwallet/common"
 	"github.com/blocktree/openwallet/openwallet"
 	"github.com/shopspring/decimal"
-	"strconv"
+	"github.com/tidwall/gjson"
+	"math/big"
+	"net/http"
 	"strings"
+	"sync"
+	"time"
 )
 
+var (
+	addressType, _ = abi.NewType("address")
+	uint256Type, _ = abi.NewType("uint256")
+)
+
+//tokenMetadataRetryInterval 确定不是QRC20代币的合约，间隔一段时间再重新查询
+const tokenMetadataRetryInterval = 10 * time.Minute
+
+//ContractCallError 合约不存在、执行异常或返回值不符合ABI，由合约本身决定，重试不会改变结果
+type ContractCallError struct {
+	Contract string
+	Method   string
+	Reason   string
+}
+
+func (e *ContractCallError) Error() string {
+	return fmt.Sprintf("call contract[%s] method[%s] failed: %s", e.Contract, e.Method, e.Reason)
+}
+
+//isDefiniteTokenLookupError 合约确定不是QRC20代币的查询错误，节点或网络错误返回false
+func isDefiniteTokenLookupError(err error) bool {
+	switch e := err.(type) {
+	case *ContractCallError:
+		return true
+	case *explorerError:
+		return e.StatusCode == http.StatusNotFound
+	}
+	return false
+}
+
+//tokenLookupFailure 代币信息查询失败的记录
+type tokenLookupFailure struct {
+	err     error
+	retryAt time.Time
+}
+
 type ContractDecoder struct {
 	*openwallet.SmartContractDecoderBase
 	wm *WalletManager
+
+	tokenCache    map[string]*QRC20Token         //合约地址 -> 代币信息
+	tokenFailures map[string]*tokenLookupFailure //合约地址 -> 查询失败记录，重试间隔内直接返回错误
+	cacheLock     sync.RWMutex
 }
 
 //NewContractDecoder 智能合约解析器
 func NewContractDecoder(wm *WalletManager) *ContractDecoder {
 	decoder := ContractDecoder{}
 	decoder.wm = wm
+	decoder.tokenCache = make(map[string]*QRC20Token)
+	decoder.tokenFailures = make(map[string]*tokenLookupFailure)
 	return &decoder
 }
 
+//GetTokenMetadata 获取代币的name、symbol、decimals、totalSupply，查询结果会被缓存
+//确定不是QRC20代币的合约在tokenMetadataRetryInterval内不再重复查询，节点或网络错误不缓存
+func (decoder *ContractDecoder) GetTokenMetadata(contractAddress string) (*QRC20Token, error) {
+
+	key := strings.ToLower(strings.TrimPrefix(contractAddress, "0x"))
+
+	decoder.cacheLock.RLock()
+	token, ok := decoder.tokenCache[key]
+	failure := decoder.tokenFailures[key]
+	decoder.cacheLock.RUnlock()
+	if ok {
+		return token, nil
+	}
+	if failure != nil && time.Now().Before(failure.retryAt) {
+		return nil, failure.err
+	}
+
+	token, err := decoder.wm.GetQRC20Token(key)
+	if err != nil {
+		if !isDefiniteTokenLookupError(err) {
+			return nil, err
+		}
+		decoder.cacheLock.Lock()
+		decoder.tokenFailures[key] = &tokenLookupFailure{err: err, retryAt: time.Now().Add(tokenMetadataRetryInterval)}
+		decoder.cacheLock.Unlock()
+		return nil, err
+	}
+
+	decoder.cacheLock.Lock()
+	decoder.tokenCache[key] = token
+	delete(decoder.tokenFailures, key)
+	decoder.cacheLock.Unlock()
+
+	return token, nil
+}
+
 func (decoder *ContractDecoder) GetTokenBalanceByAddress(contract openwallet.SmartContract, address ...string) ([]*openwallet.TokenBalance, error) {
 
 	var tokenBalanceList []*openwallet.TokenBalance
 
+	//调用方未提供代币信息，从合约查询
+	if len(contract.Token) == 0 && len(contract.Name) == 0 {
+		token, err := decoder.GetTokenMetadata(contract.Address)
+		if err != nil {
+			return nil, err
+		}
+		contract = token.SmartContract(decoder.wm.Symbol())
+	}
+
  	for i:=0; i<len(address); i++ {
 		balance, _ := decoder.wm.GetQRC20Balance(contract, address[i], decoder.wm.config.isTestNet)
 		//if err != nil {
@@ -69,19 +160,31 @@ func (decoder *ContractDecoder) GetTokenBalanceByAddress(contract openwallet.Sma
 
 func AddressTo32bytesArg(address string, isTestNet bool) ([]byte, error) {
 
-	var addressToHash160 []byte
-	if isTestNet {
-		addressToHash160, _ = addressEncoder.AddressDecode(address, addressEncoder.QTUM_testnetAddressP2PKH)
-	}else {
-		addressToHash160, _ = addressEncoder.AddressDecode(address, addressEncoder.QTUM_mainnetAddressP2PKH)
+	hash160, err := AddressToHash160(address, isTestNet)
+	if err != nil {
+		return nil, err
 	}
 
-	//fmt.Printf("addressToHash160: %s\n",hex.EncodeToString(addressToHash160))
+	return abi.Encode([]*abi.Type{addressType}, []interface{}{hash160})
+}
+
+//AddressToHash160 QTUM地址转换为合约使用的20字节地址
+func AddressToHash160(address string, isTestNet bool) ([]byte, error) {
 
-	to32bytesArg := append([]byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}, addressToHash160[:]...)
-	//fmt.Printf("to32bytesArg: %s\n",hex.EncodeToString(to32bytesArg))
+	var (
+		addressToHash160 []byte
+		err              error
+	)
+	if isTestNet {
+		addressToHash160, err = addressEncoder.AddressDecode(address, addressEncoder.QTUM_testnetAddressP2PKH)
+	}else {
+		addressToHash160, err = addressEncoder.AddressDecode(address, addressEncoder.QTUM_mainnetAddressP2PKH)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("address[%s] decode failed, %v", address, err)
+	}
 
-	return to32bytesArg, nil
+	return addressToHash160, nil
 }
 
 // GetQRC20Balance 获取qrc20余额
@@ -95,74 +198,234 @@ func (wm *WalletManager) GetQRC20Balance(token openwallet.SmartContract, address
 
 func (wm *WalletManager)GetQRC20UnspentByAddress(contractAddress, address string, tokenDecimal uint64, isTestNet bool) (decimal.Decimal, error) {
 
-	trimContractAddr := strings.TrimPrefix(contractAddress, "0x")
+	hash160, err := AddressToHash160(address, isTestNet)
+	if err != nil {
+		return decimal.New(0,0), err
+	}
 
-	to32bytesArg, err := AddressTo32bytesArg(address, isTestNet)
+	result, err := wm.CallContract(contractAddress, abi.QRC20, "balanceOf", hash160)
 	if err != nil {
 		return decimal.New(0,0), err
 	}
 
-	combineString := hex.EncodeToString(append([]byte{0x70, 0xa0, 0x82, 0x31}, to32bytesArg[:]...))
-	//fmt.Printf("combineString: %s\n",combineString)
+	sotashiUnspent := result[0].(*big.Int)
+	unspent := decimal.NewFromBigInt(sotashiUnspent, -int32(tokenDecimal))
+
+	return unspent, nil
+}
+
+//CallContract 调用合约的只读方法，按ABI编码参数并解码返回值
+func (wm *WalletManager) CallContract(contractAddress string, contractABI *abi.ABI, method string, args ...interface{}) ([]interface{}, error) {
+
+	trimContractAddr := strings.TrimPrefix(contractAddress, "0x")
+
+	data, err := contractABI.Pack(method, args...)
+	if err != nil {
+		return nil, err
+	}
 
 	request := []interface{}{
 		trimContractAddr,
-		combineString,
+		hex.EncodeToString(data),
 	}
 
 	result, err := wm.walletClient.Call("callcontract", request)
 	if err != nil {
-		return decimal.New(0,0), err
+		//[-5]contract address does not exist
+		if strings.HasPrefix(err.Error(), "[-5]") {
+			return nil, &ContractCallError{Contract: contractAddress, Method: method, Reason: err.Error()}
+		}
+		return nil, err
 	}
 
 	//fmt.Printf("Callcontract result: %s\n", result.String())
 
 	QRC20Utox := NewQRC20Unspent(result)
 
-	sotashiUnspent, _ := strconv.ParseInt(QRC20Utox.Output,16,64)
-	sotashiUnspentDecimal, _ := decimal.NewFromString(common.NewString(sotashiUnspent).String())
-	unspent := sotashiUnspentDecimal.Div(decimal.New(1, int32(tokenDecimal)))
+	excepted := gjson.Get(result.Raw, "executionResult.excepted").String()
+	if excepted != "" && excepted != "None" {
+		return nil, &ContractCallError{Contract: contractAddress, Method: method, Reason: "excepted: " + excepted}
+	}
 
-	return unspent, nil
+	output, err := hex.DecodeString(QRC20Utox.Output)
+	if err != nil {
+		return nil, &ContractCallError{Contract: contractAddress, Method: method, Reason: err.Error()}
+	}
+
+	values, err := contractABI.Unpack(method, output)
+	if err != nil {
+		return nil, &ContractCallError{Contract: contractAddress, Method: method, Reason: err.Error()}
+	}
+	return values, nil
+}
+
+//GetContractGas 获取合约调用的gas设置，优先使用合约的指定设置
+//开启估算时，通过callcontract试运行调用数据，以gasUsed加安全余量作为gas上限
+func (wm *WalletManager) GetContractGas(contractAddress, sender string, callData []byte) (*ContractGas, error) {
+
+	key := strings.ToLower(strings.TrimPrefix(contractAddress, "0x"))
+
+	gas := &ContractGas{
+		GasLimit: wm.config.GasLimit,
+		GasPrice: wm.config.GasPrice,
+	}
+	if contractGas, ok := wm.config.ContractGas[key]; ok {
+		gas.GasLimit = contractGas.GasLimit
+		gas.GasPrice = contractGas.GasPrice
+	}
+
+	if !wm.config.GasEstimate || wm.config.RPCServerType != RPCServerCore {
+		return gas, nil
+	}
+
+	gasUsed, err := wm.estimateContractGasByCore(key, sender, callData)
+	if err != nil {
+		return nil, err
+	}
+
+	gasLimit := decimal.New(int64(gasUsed), 0).Mul(decimal.New(1, 0).Add(wm.config.GasEstimateMargin)).Ceil()
+	gas.GasLimit = uint64(gasLimit.IntPart())
+	if gas.GasLimit < MIN_GAS_LIMIT {
+		gas.GasLimit = MIN_GAS_LIMIT
+	}
+
+	return gas, nil
+}
+
+//estimateContractGasByCore 通过callcontract试运行合约调用，返回消耗的gas
+func (wm *WalletManager) estimateContractGasByCore(contractAddress, sender string, callData []byte) (uint64, error) {
+
+	request := []interface{}{
+		strings.TrimPrefix(contractAddress, "0x"),
+		hex.EncodeToString(callData),
+		sender,
+	}
+
+	result, err := wm.walletClient.Call("callcontract", request)
+	if err != nil {
+		return 0, err
+	}
+
+	excepted := gjson.Get(result.Raw, "executionResult.excepted").String()
+	if excepted != "" && excepted != "None" {
+		return 0, openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "contract[%s] call will fail, excepted: %s", contractAddress, excepted)
+	}
+
+	return gjson.Get(result.Raw, "executionResult.gasUsed").Uint(), nil
+}
+
+//QRC20TransferCallData 编码代币transfer调用数据
+func QRC20TransferCallData(to string, amount decimal.Decimal, tokenDecimals int32, isTestNet bool) ([]byte, error) {
+
+	sendAmount, err := TokenAmountToBigInt(amount, tokenDecimals)
+	if err != nil {
+		return nil, err
+	}
+
+	addressTo, err := AddressToHash160(to, isTestNet)
+	if err != nil {
+		return nil, err
+	}
+
+	return abi.QRC20.Pack("transfer", addressTo, sendAmount)
+}
+
+//GetQRC20Token 获取代币合约信息
+func (wm *WalletManager) GetQRC20Token(contractAddress string) (*QRC20Token, error) {
+	if wm.config.RPCServerType == RPCServerExplorer {
+		return wm.getQRC20TokenByExplorer(contractAddress)
+	} else {
+		return wm.getQRC20TokenByCore(contractAddress)
+	}
+}
+
+//getQRC20TokenByCore 通过合约的name、symbol、decimals、totalSupply方法获取代币信息
+func (wm *WalletManager) getQRC20TokenByCore(contractAddress string) (*QRC20Token, error) {
+
+	token := &QRC20Token{
+		Address: "0x" + strings.TrimPrefix(contractAddress, "0x"),
+	}
+
+	result, err := wm.CallContract(contractAddress, abi.QRC20, "name")
+	if err != nil {
+		return nil, err
+	}
+	token.Name = result[0].(string)
+
+	result, err = wm.CallContract(contractAddress, abi.QRC20, "symbol")
+	if err != nil {
+		return nil, err
+	}
+	token.Symbol = result[0].(string)
+
+	result, err = wm.CallContract(contractAddress, abi.QRC20, "decimals")
+	if err != nil {
+		return nil, err
+	}
+	token.Decimals = result[0].(*big.Int).Uint64()
+
+	result, err = wm.CallContract(contractAddress, abi.QRC20, "totalSupply")
+	if err != nil {
+		return nil, err
+	}
+	token.TotalSupply = result[0].(*big.Int).String()
+
+	return token, nil
 }
 
-func AmountTo32bytesArg(amount int64) (string, error) {
+func AmountTo32bytesArg(amount *big.Int) (string, error) {
+
+	bytesArg, err := abi.Encode([]*abi.Type{uint256Type}, []interface{}{amount})
+	if err != nil {
+		return "", err
+	}
 
-	hexAmount := strconv.FormatInt(amount, 16)
+	return hex.EncodeToString(bytesArg), nil
+}
 
-	defaultLen := 64
-	addLen := defaultLen - len(hexAmount)
-	var bytesArg string
+//TokenAmountToBigInt 代币数量按精度转换为合约中的整数数值
+func TokenAmountToBigInt(amount decimal.Decimal, tokenDecimals int32) (*big.Int, error) {
 
-	for i := 0; i<addLen; i++ {
-		bytesArg = bytesArg + "0"
+	sotashiAmount := amount.Shift(tokenDecimals)
+	if sotashiAmount.Sign() < 0 {
+		return nil, fmt.Errorf("token amount %s is negative", amount.String())
 	}
 
-	bytesArg = bytesArg + hexAmount
+	coefficient := new(big.Int).Set(sotashiAmount.Coefficient())
+	exp := sotashiAmount.Exponent()
+	ten := big.NewInt(10)
+	if exp >= 0 {
+		return coefficient.Mul(coefficient, new(big.Int).Exp(ten, big.NewInt(int64(exp)), nil)), nil
+	}
 
-	return bytesArg, nil
+	//小数位超过代币精度
+	quo, rem := new(big.Int).QuoRem(coefficient, new(big.Int).Exp(ten, big.NewInt(int64(-exp)), nil), new(big.Int))
+	if rem.Sign() != 0 {
+		return nil, fmt.Errorf("token amount %s exceeds %d decimal places", amount.String(), tokenDecimals)
+	}
+	return quo, nil
 }
 
 func (wm *WalletManager)QRC20Transfer(contractAddress string, from string, to string, gasPrice string, amount decimal.Decimal, gasLimit int64, tokenDecimal uint64, isTestNet bool) (string, error){
 
 	trimContractAddr := strings.TrimPrefix(contractAddress, "0x")
 
-	amountDecimal := amount.Mul(decimal.New(1, int32(tokenDecimal)))
-	sotashiAmount := amountDecimal.IntPart()
-
-	amountToArg, err := AmountTo32bytesArg(sotashiAmount)
+	sotashiAmount, err := TokenAmountToBigInt(amount, int32(tokenDecimal))
 	if err != nil {
 		return "", err
 	}
 
-	addressToArg, err := AddressTo32bytesArg(to, isTestNet)
+	addressTo, err := AddressToHash160(to, isTestNet)
 	if err != nil {
 		return "", err
 	}
 
-	combineString := hex.EncodeToString(append([]byte{0xa9, 0x05, 0x9c, 0xbb}, addressToArg[:]...))
+	data, err := abi.QRC20.Pack("transfer", addressTo, sotashiAmount)
+	if err != nil {
+		return "", err
+	}
 
-	dataHex := combineString + amountToArg
+	dataHex := hex.EncodeToString(data)
 	fmt.Printf("dataHex: %s\n",dataHex)
 
 	request := []interface{}{
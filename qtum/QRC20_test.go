@@ -18,6 +18,12 @@ package qtum
 import (
 	"testing"
 	"encoding/hex"
+	"errors"
+	"time"
+	"net/http"
+	"net/http/httptest"
+	"math/big"
+	"strings"
 	"github.com/shopspring/decimal"
 	"github.com/blocktree/openwallet/openwallet"
 )
@@ -63,7 +69,7 @@ func Test_getUnspentByAddress(t *testing.T) {
 }
 
 func Test_AmountTo32bytesArg(t *testing.T){
-	var amount int64= 100000000
+	amount := big.NewInt(100000000)
 	bytesArg, err := AmountTo32bytesArg(amount)
 	if err != nil {
 		t.Errorf("strconv.ParseInt failed unexpected error: %v\n", err)
@@ -139,4 +145,199 @@ func Test_GetTokenBalanceByAddress(t *testing.T) {
 	for i:=0; i<len(balanceList); i++ {
 		t.Logf("%s: %s\n",addrs[i], balanceList[i].Balance.ConfirmBalance)
 	}
-}
\ No newline at end of file
+}
+func Test_GetTokenMetadata(t *testing.T) {
+	contractAddress := "0x91a6081095ef860d28874c9db613e7a4107b0281"
+
+	decoder := tw.ContractDecoder.(*ContractDecoder)
+	token, err := decoder.GetTokenMetadata(contractAddress)
+	if err != nil {
+		t.Errorf("GetTokenMetadata failed unexpected error: %v\n", err)
+		return
+	}
+	t.Logf("token = %+v\n", token)
+}
+
+func Test_tokenCoinFromCache(t *testing.T) {
+	decoder := NewContractDecoder(tw)
+	decoder.tokenCache["f2033ede578e17fa6231047265010445bca8cf1c"] = &QRC20Token{
+		Address:     "0xf2033ede578e17fa6231047265010445bca8cf1c",
+		Name:        "QCash",
+		Symbol:      "QC",
+		Decimals:    8,
+		TotalSupply: "1000000000000000000",
+	}
+
+	//缓存命中时不访问节点，地址大小写和0x前缀不影响查询
+	token, err := decoder.GetTokenMetadata("0xF2033EDE578E17FA6231047265010445BCA8CF1C")
+	if err != nil {
+		t.Errorf("GetTokenMetadata failed unexpected error: %v\n", err)
+		return
+	}
+	if token.Symbol != "QC" || token.Decimals != 8 {
+		t.Errorf("GetTokenMetadata token = %+v", token)
+	}
+
+	wm := *tw
+	wm.ContractDecoder = decoder
+	bs := NewBTCBlockScanner(&wm)
+	coin, found := bs.tokenCoin("0xf2033ede578e17fa6231047265010445bca8cf1c")
+	if !found {
+		t.Errorf("tokenCoin should find the cached token")
+	}
+	if coin.Contract.Token != "QC" || coin.Contract.Name != "QCash" || coin.Contract.Decimals != 8 {
+		t.Errorf("tokenCoin contract = %+v", coin.Contract)
+	}
+	if coin.ContractID != openwallet.GenContractID(tw.Symbol(), "0xf2033ede578e17fa6231047265010445bca8cf1c") {
+		t.Errorf("tokenCoin contractID = %s", coin.ContractID)
+	}
+}
+
+func Test_tokenMetadataNegativeCache(t *testing.T) {
+	wm := NewWalletManager()
+	wm.config.RPCServerType = RPCServerExplorer
+	wm.ExplorerClient = NewExplorer("http://127.0.0.1:1/", false)
+	decoder := NewContractDecoder(wm)
+	contractAddress := "f2033ede578e17fa6231047265010445bca8cf1c"
+
+	//重试间隔内直接返回上次的错误，不访问节点
+	cached := errors.New("not a qrc20 contract")
+	decoder.tokenFailures[contractAddress] = &tokenLookupFailure{err: cached, retryAt: time.Now().Add(time.Minute)}
+	if _, err := decoder.GetTokenMetadata("0x" + contractAddress); err != cached {
+		t.Errorf("GetTokenMetadata err = %v, want the cached failure", err)
+	}
+
+	//过期后重新查询，网络错误不缓存，下次仍然重新查询
+	decoder.tokenFailures[contractAddress].retryAt = time.Now().Add(-time.Second)
+	if _, err := decoder.GetTokenMetadata(contractAddress); err == nil || err == cached || isDefiniteTokenLookupError(err) {
+		t.Errorf("GetTokenMetadata err = %v, want a transport failure", err)
+	}
+	if failure := decoder.tokenFailures[contractAddress]; failure != nil && failure.retryAt.After(time.Now()) {
+		t.Errorf("GetTokenMetadata cached a transport failure: %+v", failure)
+	}
+
+	//浏览器返回合约不存在，缓存失败记录
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+	wm.ExplorerClient = NewExplorer(server.URL+"/", false)
+	if _, err := decoder.GetTokenMetadata(contractAddress); !isDefiniteTokenLookupError(err) {
+		t.Errorf("GetTokenMetadata err = %v, want a definite failure", err)
+	}
+	if failure := decoder.tokenFailures[contractAddress]; failure == nil || failure.err == cached || !failure.retryAt.After(time.Now()) {
+		t.Errorf("GetTokenMetadata failure record = %+v", failure)
+	}
+
+	//tokenCoin使用未解析的合约信息
+	wm.ContractDecoder = decoder
+	if _, found := NewBTCBlockScanner(wm).tokenCoin(contractAddress); found {
+		t.Errorf("tokenCoin should not find a failed token")
+	}
+}
+
+func Test_extractTokenTransferDecimals(t *testing.T) {
+	decoder := NewContractDecoder(tw)
+	decoder.tokenCache["f2033ede578e17fa6231047265010445bca8cf1c"] = &QRC20Token{
+		Address:  "0xf2033ede578e17fa6231047265010445bca8cf1c",
+		Name:     "QCash",
+		Symbol:   "QC",
+		Decimals: 8,
+	}
+	wm := *tw
+	wm.ContractDecoder = decoder
+	bs := NewBTCBlockScanner(&wm)
+
+	to := "qdphfFinfJutJFvtnr2UaCwNAMxC3HbVxa"
+	trx := &Transaction{
+		TxID:            "a8aab0a4e2a1b1b1f9b3d3e5f0e1b6e4c1d3a5b7a9c1e3f5a7b9c1d3e5f7a9b1",
+		Isqrc20Transfer: true,
+		TokenReceipts: []*TokenReceipt{
+			{
+				TxHash:          "a8aab0a4e2a1b1b1f9b3d3e5f0e1b6e4c1d3a5b7a9c1e3f5a7b9c1d3e5f7a9b1",
+				ContractAddress: "0xf2033ede578e17fa6231047265010445bca8cf1c",
+				From:            "qMrFCXxSuiTEDqd311VxivECpJqH5KsJg6",
+				To:              to,
+				RawAmount:       "123450000",
+				Amount:          "123450000",
+			},
+		},
+	}
+	result := &ExtractResult{
+		extractContractData: make(map[string][]*openwallet.TxExtractData),
+	}
+	bs.extractTokenTransfer(trx, result, func(address string) (string, bool) {
+		return "account", address == to
+	})
+
+	list := result.extractContractData["account"]
+	if len(list) != 1 {
+		t.Errorf("extractTokenTransfer records = %d, want 1", len(list))
+		return
+	}
+	if list[0].TxOutputs[0].Amount != "1.2345" {
+		t.Errorf("extractTokenTransfer amount = %s, want 1.2345", list[0].TxOutputs[0].Amount)
+	}
+	if list[0].Transaction.Decimal != 8 {
+		t.Errorf("extractTokenTransfer decimal = %d, want 8", list[0].Transaction.Decimal)
+	}
+	if raw := list[0].Transaction.GetExtParam().Get("rawAmount").String(); raw != "123450000" {
+		t.Errorf("extractTokenTransfer raw amount = %s, want 123450000", raw)
+	}
+
+	//精度未知时不通知未换算的数量，交易单标记为提取失败等待重扫
+	unknown := "91a6081095ef860d28874c9db613e7a4107b0281"
+	decoder.tokenFailures[unknown] = &tokenLookupFailure{err: errors.New("not a qrc20 contract"), retryAt: time.Now().Add(time.Minute)}
+	trx.TokenReceipts[0].ContractAddress = "0x" + unknown
+	trx.TokenReceipts[0].Amount = "123450000"
+	result = &ExtractResult{
+		Success:             true,
+		extractContractData: make(map[string][]*openwallet.TxExtractData),
+	}
+	bs.extractTokenTransfer(trx, result, func(address string) (string, bool) {
+		return "account", address == to
+	})
+	if result.Success || len(result.extractContractData) != 0 {
+		t.Errorf("extractTokenTransfer with unresolved decimals = %v, records = %d; want failed", result.Success, len(result.extractContractData))
+	}
+}
+
+func Test_TokenAmountToBigInt(t *testing.T) {
+	max := new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 256), big.NewInt(1))
+	maxAmount, _ := decimal.NewFromString(max.String())
+
+	tests := []struct {
+		amount   string
+		decimals int32
+		want     string
+	}{
+		{"100", 18, "100000000000000000000"},
+		{"1.5", 18, "1500000000000000000"},
+		{"115792089237316195423570985008687907853269984665640564039457.584007913129639935", 18, max.String()},
+		{maxAmount.String(), 0, max.String()},
+		{"0.00000001", 8, "1"},
+	}
+	for _, test := range tests {
+		amount, _ := decimal.NewFromString(test.amount)
+		got, err := TokenAmountToBigInt(amount, test.decimals)
+		if err != nil {
+			t.Errorf("TokenAmountToBigInt %s failed unexpected error: %v", test.amount, err)
+			continue
+		}
+		if got.String() != test.want {
+			t.Errorf("TokenAmountToBigInt %s = %s, want %s", test.amount, got.String(), test.want)
+		}
+	}
+
+	//精度不足
+	if _, err := TokenAmountToBigInt(decimal.RequireFromString("0.000000001"), 8); err == nil {
+		t.Errorf("TokenAmountToBigInt should fail when amount exceeds token decimals")
+	}
+
+	//接近2^256的数量编码为32字节
+	arg, err := AmountTo32bytesArg(max)
+	if err != nil || arg != strings.Repeat("f", 64) {
+		t.Errorf("AmountTo32bytesArg max = %s, err = %v", arg, err)
+	}
+	if _, err := AmountTo32bytesArg(new(big.Int).Add(max, big.NewInt(1))); err == nil {
+		t.Errorf("AmountTo32bytesArg 2^256 should fail")
+	}
+}
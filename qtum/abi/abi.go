@@ -0,0 +1,250 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+//Package abi 实现Solidity合约ABI的编码与解码，用于QRC20及任意合约的调用和日志解析
+package abi
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/blocktree/go-owcrypt"
+)
+
+//Argument 方法或事件的参数
+type Argument struct {
+	Name    string
+	Type    *Type
+	Indexed bool
+}
+
+//Method 合约方法
+type Method struct {
+	Name     string
+	Sig      string //规范签名，如transfer(address,uint256)
+	ID       []byte //方法选择器，签名哈希的前4字节
+	Inputs   []Argument
+	Outputs  []Argument
+	Constant bool
+}
+
+//Event 合约事件
+type Event struct {
+	Name      string
+	Sig       string
+	ID        []byte //事件主题，签名的完整哈希
+	Inputs    []Argument
+	Anonymous bool
+}
+
+//ABI 合约接口
+type ABI struct {
+	Methods map[string]*Method
+	Events  map[string]*Event
+}
+
+//abiEntry JSON ABI的条目
+type abiEntry struct {
+	Type            string      `json:"type"`
+	Name            string      `json:"name"`
+	Inputs          []Component `json:"inputs"`
+	Outputs         []Component `json:"outputs"`
+	Constant        bool        `json:"constant"`
+	StateMutability string      `json:"stateMutability"`
+	Anonymous       bool        `json:"anonymous"`
+}
+
+//ParseABI 解析JSON格式的合约ABI
+func ParseABI(abiJSON string) (*ABI, error) {
+	var entries []abiEntry
+	if err := json.Unmarshal([]byte(abiJSON), &entries); err != nil {
+		return nil, fmt.Errorf("abi: invalid json, %v", err)
+	}
+
+	ret := &ABI{
+		Methods: make(map[string]*Method),
+		Events:  make(map[string]*Event),
+	}
+
+	for _, e := range entries {
+		inputs, err := newArguments(e.Inputs)
+		if err != nil {
+			return nil, err
+		}
+		switch e.Type {
+		case "function", "":
+			outputs, err := newArguments(e.Outputs)
+			if err != nil {
+				return nil, err
+			}
+			sig := signature(e.Name, inputs)
+			ret.Methods[e.Name] = &Method{
+				Name:     e.Name,
+				Sig:      sig,
+				ID:       Keccak256([]byte(sig))[:4],
+				Inputs:   inputs,
+				Outputs:  outputs,
+				Constant: e.Constant || e.StateMutability == "view" || e.StateMutability == "pure",
+			}
+		case "event":
+			sig := signature(e.Name, inputs)
+			ret.Events[e.Name] = &Event{
+				Name:      e.Name,
+				Sig:       sig,
+				ID:        Keccak256([]byte(sig)),
+				Inputs:    inputs,
+				Anonymous: e.Anonymous,
+			}
+		}
+	}
+
+	return ret, nil
+}
+
+//Pack 编码方法调用数据，包含方法选择器
+func (a *ABI) Pack(name string, args ...interface{}) ([]byte, error) {
+	method, ok := a.Methods[name]
+	if !ok {
+		return nil, fmt.Errorf("abi: method %s not found", name)
+	}
+	data, err := Encode(argumentTypes(method.Inputs), args)
+	if err != nil {
+		return nil, err
+	}
+	return append(append([]byte{}, method.ID...), data...), nil
+}
+
+//Unpack 解码方法的返回值
+func (a *ABI) Unpack(name string, output []byte) ([]interface{}, error) {
+	method, ok := a.Methods[name]
+	if !ok {
+		return nil, fmt.Errorf("abi: method %s not found", name)
+	}
+	return Decode(argumentTypes(method.Outputs), output)
+}
+
+//UnpackInput 解码方法调用数据，data包含方法选择器
+func (a *ABI) UnpackInput(data []byte) (*Method, []interface{}, error) {
+	if len(data) < 4 {
+		return nil, nil, fmt.Errorf("abi: call data too short")
+	}
+	for _, method := range a.Methods {
+		if bytes.Equal(method.ID, data[:4]) {
+			values, err := Decode(argumentTypes(method.Inputs), data[4:])
+			if err != nil {
+				return nil, nil, err
+			}
+			return method, values, nil
+		}
+	}
+	return nil, nil, fmt.Errorf("abi: method %x not found", data[:4])
+}
+
+//UnpackLog 解码事件日志，返回事件及参数名到值的映射
+//indexed的动态类型参数在主题中只保存哈希，以[]byte返回
+func (a *ABI) UnpackLog(topics [][]byte, data []byte) (*Event, map[string]interface{}, error) {
+	if len(topics) == 0 {
+		return nil, nil, fmt.Errorf("abi: log has no topics")
+	}
+
+	var event *Event
+	for _, e := range a.Events {
+		if !e.Anonymous && bytes.Equal(e.ID, topics[0]) {
+			event = e
+			break
+		}
+	}
+	if event == nil {
+		return nil, nil, fmt.Errorf("abi: event %x not found", topics[0])
+	}
+
+	values := make(map[string]interface{})
+	indexed := make([]Argument, 0)
+	nonIndexed := make([]Argument, 0)
+	for _, arg := range event.Inputs {
+		if arg.Indexed {
+			indexed = append(indexed, arg)
+		} else {
+			nonIndexed = append(nonIndexed, arg)
+		}
+	}
+
+	if len(indexed) != len(topics)-1 {
+		return nil, nil, fmt.Errorf("abi: event %s requires %d topics, got %d", event.Name, len(indexed)+1, len(topics))
+	}
+
+	for i, arg := range indexed {
+		topic := topics[i+1]
+		if arg.Type.IsDynamic() || arg.Type.Kind == ArrayTy || arg.Type.Kind == TupleTy {
+			values[arg.Name] = topic
+			continue
+		}
+		v, err := decodeValue(arg.Type, topic, 0)
+		if err != nil {
+			return nil, nil, err
+		}
+		values[arg.Name] = v
+	}
+
+	decoded, err := Decode(argumentTypes(nonIndexed), data)
+	if err != nil {
+		return nil, nil, err
+	}
+	for i, arg := range nonIndexed {
+		values[arg.Name] = decoded[i]
+	}
+
+	return event, values, nil
+}
+
+//MethodID 计算方法签名的选择器
+func MethodID(sig string) []byte {
+	return Keccak256([]byte(sig))[:4]
+}
+
+//Keccak256 计算keccak256哈希
+func Keccak256(data []byte) []byte {
+	return owcrypt.Hash(data, 0, owcrypt.HASH_ALG_KECCAK256)
+}
+
+func newArguments(components []Component) ([]Argument, error) {
+	args := make([]Argument, 0, len(components))
+	for _, c := range components {
+		t, err := NewType(c.Type, c.Components...)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, Argument{Name: c.Name, Type: t, Indexed: c.Indexed})
+	}
+	return args, nil
+}
+
+func argumentTypes(args []Argument) []*Type {
+	types := make([]*Type, len(args))
+	for i, arg := range args {
+		types[i] = arg.Type
+	}
+	return types
+}
+
+func signature(name string, args []Argument) string {
+	types := make([]string, len(args))
+	for i, arg := range args {
+		types[i] = arg.Type.String()
+	}
+	return name + "(" + strings.Join(types, ",") + ")"
+}
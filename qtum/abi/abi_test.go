@@ -0,0 +1,240 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package abi
+
+import (
+	"encoding/hex"
+	"math/big"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestQRC20MethodID(t *testing.T) {
+	tests := map[string]string{
+		"transfer":     "a9059cbb",
+		"balanceOf":    "70a08231",
+		"approve":      "095ea7b3",
+		"transferFrom": "23b872dd",
+		"decimals":     "313ce567",
+		"symbol":       "95d89b41",
+		"name":         "06fdde03",
+	}
+	for name, id := range tests {
+		if got := hex.EncodeToString(QRC20.Methods[name].ID); got != id {
+			t.Errorf("%s id = %s, want %s", name, got, id)
+		}
+	}
+	if got := hex.EncodeToString(QRC20.Events["Transfer"].ID); got != "ddf252ad1be2c89b69c2b068fc378daa952ba7f163c4a11628f55a4df523b3ef" {
+		t.Errorf("Transfer event id = %s", got)
+	}
+}
+
+func TestPackTransfer(t *testing.T) {
+	data, err := QRC20.Pack("transfer", "a1b2c3d4e5f6a7b8c9d0e1f2a3b4c5d6e7f8a9b0", big.NewInt(1000000))
+	if err != nil {
+		t.Errorf("Pack failed unexpected error: %v", err)
+		return
+	}
+	want := "a9059cbb" +
+		"000000000000000000000000a1b2c3d4e5f6a7b8c9d0e1f2a3b4c5d6e7f8a9b0" +
+		"00000000000000000000000000000000000000000000000000000000000f4240"
+	if got := hex.EncodeToString(data); got != want {
+		t.Errorf("Pack = %s, want %s", got, want)
+	}
+
+	method, values, err := QRC20.UnpackInput(data)
+	if err != nil {
+		t.Errorf("UnpackInput failed unexpected error: %v", err)
+		return
+	}
+	if method.Name != "transfer" || values[0] != "a1b2c3d4e5f6a7b8c9d0e1f2a3b4c5d6e7f8a9b0" || values[1].(*big.Int).Int64() != 1000000 {
+		t.Errorf("UnpackInput = %s %v", method.Name, values)
+	}
+}
+
+//Solidity文档中的动态类型编码示例
+func TestEncodeDynamic(t *testing.T) {
+	a, err := ParseABI(`[{"name":"f","type":"function","inputs":[
+		{"name":"a","type":"uint256"},{"name":"b","type":"uint32[]"},{"name":"c","type":"bytes10"},{"name":"d","type":"bytes"}]}]`)
+	if err != nil {
+		t.Errorf("ParseABI failed unexpected error: %v", err)
+		return
+	}
+	data, err := a.Pack("f", 0x123, []interface{}{0x456, 0x789}, []byte("1234567890"), []byte("Hello, world!"))
+	if err != nil {
+		t.Errorf("Pack failed unexpected error: %v", err)
+		return
+	}
+	want := "8be65246" +
+		"0000000000000000000000000000000000000000000000000000000000000123" +
+		"0000000000000000000000000000000000000000000000000000000000000080" +
+		"3132333435363738393000000000000000000000000000000000000000000000" +
+		"00000000000000000000000000000000000000000000000000000000000000e0" +
+		"0000000000000000000000000000000000000000000000000000000000000002" +
+		"0000000000000000000000000000000000000000000000000000000000000456" +
+		"0000000000000000000000000000000000000000000000000000000000000789" +
+		"000000000000000000000000000000000000000000000000000000000000000d" +
+		"48656c6c6f2c20776f726c642100000000000000000000000000000000000000"
+	if got := hex.EncodeToString(data); got != want {
+		t.Errorf("Pack = %s, want %s", got, want)
+	}
+}
+
+func TestEncodeDecodeRoundTrip(t *testing.T) {
+	tupleType, err := NewType("tuple[]", Component{Type: "string"}, Component{Type: "uint256[2]"}, Component{Type: "bool"})
+	if err != nil {
+		t.Errorf("NewType failed unexpected error: %v", err)
+		return
+	}
+	if tupleType.String() != "(string,uint256[2],bool)[]" {
+		t.Errorf("tuple type = %s", tupleType.String())
+	}
+
+	types := []*Type{
+		mustType(t, "int64"),
+		mustType(t, "uint256[][]"),
+		mustType(t, "string"),
+		tupleType,
+		mustType(t, "address"),
+	}
+	values := []interface{}{
+		big.NewInt(-42),
+		[]interface{}{
+			[]interface{}{big.NewInt(1), big.NewInt(2)},
+			[]interface{}{},
+			[]interface{}{big.NewInt(3)},
+		},
+		"量子链",
+		[]interface{}{
+			[]interface{}{"a", []interface{}{big.NewInt(7), big.NewInt(8)}, true},
+			[]interface{}{"", []interface{}{big.NewInt(0), big.NewInt(9)}, false},
+		},
+		"91a6081095ef860d28874c9db613e7a4107b0281",
+	}
+
+	data, err := Encode(types, values)
+	if err != nil {
+		t.Errorf("Encode failed unexpected error: %v", err)
+		return
+	}
+	decoded, err := Decode(types, data)
+	if err != nil {
+		t.Errorf("Decode failed unexpected error: %v", err)
+		return
+	}
+	if !reflect.DeepEqual(normalize(decoded), normalize(values)) {
+		t.Errorf("Decode = %v, want %v", decoded, values)
+	}
+}
+
+func TestEncodeOutOfRange(t *testing.T) {
+	tests := []struct {
+		typ   string
+		value interface{}
+	}{
+		{"uint8", 256},
+		{"uint256", -1},
+		{"int8", 128},
+		{"int8", -129},
+		{"uint256", new(big.Int).Lsh(big.NewInt(1), 256)},
+		{"bytes4", []byte{1, 2, 3, 4, 5}},
+		{"address", "91a6081095ef860d28874c9db613e7a4107b02"},
+		{"uint256[2]", []interface{}{1}},
+	}
+	for _, test := range tests {
+		if _, err := Encode([]*Type{mustType(t, test.typ)}, []interface{}{test.value}); err == nil {
+			t.Errorf("Encode %s %v should fail", test.typ, test.value)
+		}
+	}
+}
+
+func TestDecodeMalformed(t *testing.T) {
+	types := []*Type{mustType(t, "string")}
+	//偏移超出数据范围
+	data, _ := hex.DecodeString("00000000000000000000000000000000000000000000000000000000000000ff")
+	if _, err := Decode(types, data); err == nil {
+		t.Errorf("Decode with invalid offset should fail")
+	}
+	//长度超出数据范围
+	data, _ = hex.DecodeString("0000000000000000000000000000000000000000000000000000000000000020" +
+		"0000000000000000000000000000000000000000000000000000000000000040")
+	if _, err := Decode(types, data); err == nil {
+		t.Errorf("Decode with invalid length should fail")
+	}
+	//数组长度超出数据范围
+	data, _ = hex.DecodeString("0000000000000000000000000000000000000000000000000000000000000020" +
+		"0000000000000000000000000000000000000000000000000000000000000040")
+	if _, err := Decode([]*Type{mustType(t, "uint256[]")}, data); err == nil {
+		t.Errorf("Decode with invalid array length should fail")
+	}
+}
+
+func TestUnpackTransferLog(t *testing.T) {
+	topics := make([][]byte, 0)
+	for _, topic := range []string{
+		"ddf252ad1be2c89b69c2b068fc378daa952ba7f163c4a11628f55a4df523b3ef",
+		"0000000000000000000000008d1ea1e1c2f2b4b8a3a9a3f9c1d9a7d6e7e8e9f0",
+		"000000000000000000000000a1b2c3d4e5f6a7b8c9d0e1f2a3b4c5d6e7f8a9b0",
+	} {
+		b, _ := hex.DecodeString(topic)
+		topics = append(topics, b)
+	}
+	data, _ := hex.DecodeString(strings.Repeat("f", 64))
+
+	event, values, err := QRC20.UnpackLog(topics, data)
+	if err != nil {
+		t.Errorf("UnpackLog failed unexpected error: %v", err)
+		return
+	}
+	if event.Name != "Transfer" {
+		t.Errorf("UnpackLog event = %s", event.Name)
+	}
+	if values["_from"] != "8d1ea1e1c2f2b4b8a3a9a3f9c1d9a7d6e7e8e9f0" || values["_to"] != "a1b2c3d4e5f6a7b8c9d0e1f2a3b4c5d6e7f8a9b0" {
+		t.Errorf("UnpackLog addresses = %v", values)
+	}
+	max := new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 256), big.NewInt(1))
+	if values["_value"].(*big.Int).Cmp(max) != 0 {
+		t.Errorf("UnpackLog value = %v", values["_value"])
+	}
+
+	if _, _, err := QRC20.UnpackLog(topics[:2], data); err == nil {
+		t.Errorf("UnpackLog with missing topic should fail")
+	}
+}
+
+func mustType(t *testing.T, typ string) *Type {
+	ret, err := NewType(typ)
+	if err != nil {
+		t.Fatalf("NewType %s failed unexpected error: %v", typ, err)
+	}
+	return ret
+}
+
+//normalize 将big.Int转换为字符串以便比较
+func normalize(v interface{}) interface{} {
+	switch x := v.(type) {
+	case *big.Int:
+		return x.String()
+	case []interface{}:
+		ret := make([]interface{}, len(x))
+		for i := range x {
+			ret[i] = normalize(x[i])
+		}
+		return ret
+	}
+	return v
+}
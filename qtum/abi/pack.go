@@ -0,0 +1,257 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package abi
+
+import (
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"reflect"
+	"strings"
+)
+
+var (
+	tt256   = new(big.Int).Lsh(big.NewInt(1), 256)
+	tt256m1 = new(big.Int).Sub(tt256, big.NewInt(1))
+)
+
+//Encode 按类型列表编码参数，等同于编码一个元组
+func Encode(types []*Type, values []interface{}) ([]byte, error) {
+	if len(types) != len(values) {
+		return nil, fmt.Errorf("abi: argument count mismatch, want %d, got %d", len(types), len(values))
+	}
+
+	headSize := 0
+	for _, t := range types {
+		headSize += t.headSize()
+	}
+
+	head := make([]byte, 0, headSize)
+	tail := make([]byte, 0)
+	for i, t := range types {
+		enc, err := encodeValue(t, values[i])
+		if err != nil {
+			return nil, err
+		}
+		if t.IsDynamic() {
+			//头部记录数据相对元组起始位置的偏移
+			head = append(head, EncodeUint256(big.NewInt(int64(headSize+len(tail))))...)
+			tail = append(tail, enc...)
+		} else {
+			head = append(head, enc...)
+		}
+	}
+
+	return append(head, tail...), nil
+}
+
+//encodeValue 编码单个值
+func encodeValue(t *Type, v interface{}) ([]byte, error) {
+	switch t.Kind {
+	case UintTy, IntTy:
+		n, err := toBigInt(v)
+		if err != nil {
+			return nil, err
+		}
+		if err := checkIntRange(t, n); err != nil {
+			return nil, err
+		}
+		return EncodeUint256(n), nil
+	case BoolTy:
+		b, ok := v.(bool)
+		if !ok {
+			return nil, fmt.Errorf("abi: cannot use %T as bool", v)
+		}
+		if b {
+			return EncodeUint256(big.NewInt(1)), nil
+		}
+		return EncodeUint256(big.NewInt(0)), nil
+	case AddressTy:
+		addr, err := toBytes(v)
+		if err != nil {
+			return nil, err
+		}
+		if len(addr) != t.Size {
+			return nil, fmt.Errorf("abi: invalid address length %d", len(addr))
+		}
+		return leftPad(addr), nil
+	case FixedBytesTy:
+		b, err := toBytes(v)
+		if err != nil {
+			return nil, err
+		}
+		if len(b) > t.Size {
+			return nil, fmt.Errorf("abi: %s value too long", t.String())
+		}
+		return rightPad(b), nil
+	case BytesTy, StringTy:
+		var b []byte
+		if t.Kind == StringTy {
+			s, ok := v.(string)
+			if !ok {
+				return nil, fmt.Errorf("abi: cannot use %T as string", v)
+			}
+			b = []byte(s)
+		} else {
+			var err error
+			b, err = toBytes(v)
+			if err != nil {
+				return nil, err
+			}
+		}
+		ret := EncodeUint256(big.NewInt(int64(len(b))))
+		if len(b) > 0 {
+			ret = append(ret, rightPad(b)...)
+		}
+		return ret, nil
+	case SliceTy, ArrayTy:
+		items, err := toSlice(v)
+		if err != nil {
+			return nil, err
+		}
+		if t.Kind == ArrayTy && len(items) != t.Length {
+			return nil, fmt.Errorf("abi: %s requires %d elements, got %d", t.String(), t.Length, len(items))
+		}
+		types := make([]*Type, len(items))
+		for i := range types {
+			types[i] = t.Elem
+		}
+		enc, err := Encode(types, items)
+		if err != nil {
+			return nil, err
+		}
+		if t.Kind == SliceTy {
+			return append(EncodeUint256(big.NewInt(int64(len(items)))), enc...), nil
+		}
+		return enc, nil
+	case TupleTy:
+		items, err := toSlice(v)
+		if err != nil {
+			return nil, err
+		}
+		return Encode(t.Components, items)
+	}
+	return nil, fmt.Errorf("abi: unsupported type %s", t.String())
+}
+
+//EncodeUint256 编码为32字节的大端整数，负数使用补码
+func EncodeUint256(n *big.Int) []byte {
+	v := new(big.Int).Set(n)
+	if v.Sign() < 0 {
+		v.Add(v, tt256)
+	}
+	v.And(v, tt256m1)
+	return leftPad(v.Bytes())
+}
+
+//checkIntRange 检查整数是否在类型的取值范围内
+func checkIntRange(t *Type, n *big.Int) error {
+	if t.Kind == UintTy {
+		if n.Sign() < 0 || n.BitLen() > t.Size {
+			return fmt.Errorf("abi: %s out of range for %s", n.String(), t.String())
+		}
+		return nil
+	}
+	limit := new(big.Int).Lsh(big.NewInt(1), uint(t.Size-1))
+	min := new(big.Int).Neg(limit)
+	if n.Cmp(min) < 0 || n.Cmp(limit) >= 0 {
+		return fmt.Errorf("abi: %s out of range for %s", n.String(), t.String())
+	}
+	return nil
+}
+
+//toBigInt 将常见的整数表示转换为big.Int
+func toBigInt(v interface{}) (*big.Int, error) {
+	switch n := v.(type) {
+	case *big.Int:
+		if n == nil {
+			return nil, fmt.Errorf("abi: nil integer")
+		}
+		return n, nil
+	case big.Int:
+		return &n, nil
+	case int:
+		return big.NewInt(int64(n)), nil
+	case int64:
+		return big.NewInt(n), nil
+	case int32:
+		return big.NewInt(int64(n)), nil
+	case uint:
+		return new(big.Int).SetUint64(uint64(n)), nil
+	case uint64:
+		return new(big.Int).SetUint64(n), nil
+	case uint32:
+		return new(big.Int).SetUint64(uint64(n)), nil
+	case uint8:
+		return new(big.Int).SetUint64(uint64(n)), nil
+	case string:
+		ret, ok := new(big.Int).SetString(n, 0)
+		if !ok {
+			return nil, fmt.Errorf("abi: invalid integer %s", n)
+		}
+		return ret, nil
+	}
+	return nil, fmt.Errorf("abi: cannot use %T as integer", v)
+}
+
+//toBytes 字节数组或十六进制字符串
+func toBytes(v interface{}) ([]byte, error) {
+	switch b := v.(type) {
+	case []byte:
+		return b, nil
+	case string:
+		return hex.DecodeString(strings.TrimPrefix(b, "0x"))
+	}
+	rv := reflect.ValueOf(v)
+	if rv.Kind() == reflect.Array && rv.Type().Elem().Kind() == reflect.Uint8 {
+		ret := make([]byte, rv.Len())
+		reflect.Copy(reflect.ValueOf(ret), rv)
+		return ret, nil
+	}
+	return nil, fmt.Errorf("abi: cannot use %T as bytes", v)
+}
+
+//toSlice 将任意切片或数组转换为[]interface{}
+func toSlice(v interface{}) ([]interface{}, error) {
+	if items, ok := v.([]interface{}); ok {
+		return items, nil
+	}
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+		return nil, fmt.Errorf("abi: cannot use %T as array", v)
+	}
+	items := make([]interface{}, rv.Len())
+	for i := range items {
+		items[i] = rv.Index(i).Interface()
+	}
+	return items, nil
+}
+
+func leftPad(b []byte) []byte {
+	if len(b) >= wordSize {
+		return b
+	}
+	ret := make([]byte, wordSize)
+	copy(ret[wordSize-len(b):], b)
+	return ret
+}
+
+func rightPad(b []byte) []byte {
+	size := (len(b) + wordSize - 1) / wordSize * wordSize
+	ret := make([]byte, size)
+	copy(ret, b)
+	return ret
+}
@@ -0,0 +1,168 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package abi
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+//类型种类
+const (
+	UintTy = iota
+	IntTy
+	BoolTy
+	AddressTy
+	FixedBytesTy
+	BytesTy
+	StringTy
+	SliceTy
+	ArrayTy
+	TupleTy
+)
+
+//wordSize ABI编码的字长
+const wordSize = 32
+
+//Type ABI参数类型
+type Type struct {
+	Kind       int
+	Size       int     //uint/int的位数，bytesN的字节数
+	Length     int     //定长数组的长度
+	Elem       *Type   //数组元素类型
+	Components []*Type //元组成员类型
+	stringKind string
+}
+
+//Component JSON ABI中的参数描述
+type Component struct {
+	Name       string      `json:"name"`
+	Type       string      `json:"type"`
+	Indexed    bool        `json:"indexed"`
+	Components []Component `json:"components"`
+}
+
+//NewType 解析类型字符串，元组类型需提供成员描述
+func NewType(t string, components ...Component) (*Type, error) {
+
+	//数组类型，从最外层的维度开始解析
+	if strings.HasSuffix(t, "]") {
+		i := strings.LastIndex(t, "[")
+		if i < 0 {
+			return nil, fmt.Errorf("invalid abi type: %s", t)
+		}
+		elem, err := NewType(t[:i], components...)
+		if err != nil {
+			return nil, err
+		}
+		inner := t[i+1 : len(t)-1]
+		if len(inner) == 0 {
+			return &Type{Kind: SliceTy, Elem: elem, stringKind: elem.String() + "[]"}, nil
+		}
+		length, err := strconv.Atoi(inner)
+		if err != nil || length <= 0 {
+			return nil, fmt.Errorf("invalid abi array length: %s", t)
+		}
+		return &Type{Kind: ArrayTy, Elem: elem, Length: length, stringKind: elem.String() + "[" + inner + "]"}, nil
+	}
+
+	switch {
+	case t == "tuple":
+		typ := &Type{Kind: TupleTy}
+		names := make([]string, 0, len(components))
+		for _, c := range components {
+			ct, err := NewType(c.Type, c.Components...)
+			if err != nil {
+				return nil, err
+			}
+			typ.Components = append(typ.Components, ct)
+			names = append(names, ct.String())
+		}
+		typ.stringKind = "(" + strings.Join(names, ",") + ")"
+		return typ, nil
+	case t == "address":
+		return &Type{Kind: AddressTy, Size: 20, stringKind: t}, nil
+	case t == "bool":
+		return &Type{Kind: BoolTy, stringKind: t}, nil
+	case t == "string":
+		return &Type{Kind: StringTy, stringKind: t}, nil
+	case t == "bytes":
+		return &Type{Kind: BytesTy, stringKind: t}, nil
+	case strings.HasPrefix(t, "bytes"):
+		size, err := strconv.Atoi(t[len("bytes"):])
+		if err != nil || size <= 0 || size > 32 {
+			return nil, fmt.Errorf("invalid abi type: %s", t)
+		}
+		return &Type{Kind: FixedBytesTy, Size: size, stringKind: t}, nil
+	case strings.HasPrefix(t, "uint"), strings.HasPrefix(t, "int"):
+		kind, prefix := UintTy, "uint"
+		if strings.HasPrefix(t, "int") {
+			kind, prefix = IntTy, "int"
+		}
+		size := 256
+		if len(t) > len(prefix) {
+			var err error
+			size, err = strconv.Atoi(t[len(prefix):])
+			if err != nil || size <= 0 || size > 256 || size%8 != 0 {
+				return nil, fmt.Errorf("invalid abi type: %s", t)
+			}
+		}
+		return &Type{Kind: kind, Size: size, stringKind: fmt.Sprintf("%s%d", prefix, size)}, nil
+	}
+
+	return nil, fmt.Errorf("unsupported abi type: %s", t)
+}
+
+//String 规范的类型名称，用于计算方法签名
+func (t *Type) String() string {
+	return t.stringKind
+}
+
+//IsDynamic 是否为动态类型
+func (t *Type) IsDynamic() bool {
+	switch t.Kind {
+	case BytesTy, StringTy, SliceTy:
+		return true
+	case ArrayTy:
+		return t.Elem.IsDynamic()
+	case TupleTy:
+		for _, c := range t.Components {
+			if c.IsDynamic() {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+//headSize 类型在头部占用的字节数
+func (t *Type) headSize() int {
+	if t.IsDynamic() {
+		return wordSize
+	}
+	switch t.Kind {
+	case ArrayTy:
+		return t.Length * t.Elem.headSize()
+	case TupleTy:
+		size := 0
+		for _, c := range t.Components {
+			size += c.headSize()
+		}
+		return size
+	}
+	return wordSize
+}
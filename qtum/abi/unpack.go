@@ -0,0 +1,155 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package abi
+
+import (
+	"encoding/hex"
+	"fmt"
+	"math/big"
+)
+
+//Decode 按类型列表解码数据，等同于解码一个元组
+func Decode(types []*Type, data []byte) ([]interface{}, error) {
+	values := make([]interface{}, 0, len(types))
+	offset := 0
+	for _, t := range types {
+		var (
+			v   interface{}
+			err error
+		)
+		if t.IsDynamic() {
+			//头部记录数据相对元组起始位置的偏移
+			pos, err := readLength(data, offset)
+			if err != nil {
+				return nil, err
+			}
+			v, err = decodeValue(t, data, pos)
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			v, err = decodeValue(t, data, offset)
+			if err != nil {
+				return nil, err
+			}
+		}
+		values = append(values, v)
+		offset += t.headSize()
+	}
+	return values, nil
+}
+
+//decodeValue 解码data中offset位置的值
+func decodeValue(t *Type, data []byte, offset int) (interface{}, error) {
+	switch t.Kind {
+	case UintTy, IntTy:
+		word, err := readWord(data, offset)
+		if err != nil {
+			return nil, err
+		}
+		return DecodeInt(t, word), nil
+	case BoolTy:
+		word, err := readWord(data, offset)
+		if err != nil {
+			return nil, err
+		}
+		return word[wordSize-1] == 1, nil
+	case AddressTy:
+		word, err := readWord(data, offset)
+		if err != nil {
+			return nil, err
+		}
+		return hex.EncodeToString(word[wordSize-t.Size:]), nil
+	case FixedBytesTy:
+		word, err := readWord(data, offset)
+		if err != nil {
+			return nil, err
+		}
+		ret := make([]byte, t.Size)
+		copy(ret, word)
+		return ret, nil
+	case BytesTy, StringTy:
+		length, err := readLength(data, offset)
+		if err != nil {
+			return nil, err
+		}
+		start := offset + wordSize
+		if start+length > len(data) {
+			return nil, fmt.Errorf("abi: %s length %d exceeds data", t.String(), length)
+		}
+		b := make([]byte, length)
+		copy(b, data[start:start+length])
+		if t.Kind == StringTy {
+			return string(b), nil
+		}
+		return b, nil
+	case SliceTy, ArrayTy:
+		length := t.Length
+		start := offset
+		if t.Kind == SliceTy {
+			var err error
+			length, err = readLength(data, offset)
+			if err != nil {
+				return nil, err
+			}
+			start += wordSize
+		}
+		//每个元素至少占用一个字长，防止恶意长度耗尽内存
+		if length*wordSize > len(data)-start {
+			return nil, fmt.Errorf("abi: %s length %d exceeds data", t.String(), length)
+		}
+		types := make([]*Type, length)
+		for i := range types {
+			types[i] = t.Elem
+		}
+		return Decode(types, data[start:])
+	case TupleTy:
+		if offset > len(data) {
+			return nil, fmt.Errorf("abi: offset %d exceeds data", offset)
+		}
+		return Decode(t.Components, data[offset:])
+	}
+	return nil, fmt.Errorf("abi: unsupported type %s", t.String())
+}
+
+//DecodeInt 解码32字节的整数，int类型按256位补码处理
+func DecodeInt(t *Type, word []byte) *big.Int {
+	n := new(big.Int).SetBytes(word)
+	if t.Kind == IntTy && n.Bit(255) == 1 {
+		n.Sub(n, tt256)
+	}
+	return n
+}
+
+func readWord(data []byte, offset int) ([]byte, error) {
+	if offset < 0 || offset+wordSize > len(data) {
+		return nil, fmt.Errorf("abi: offset %d exceeds data length %d", offset, len(data))
+	}
+	return data[offset : offset+wordSize], nil
+}
+
+//readLength 读取偏移或长度，并检查是否超出数据范围
+func readLength(data []byte, offset int) (int, error) {
+	word, err := readWord(data, offset)
+	if err != nil {
+		return 0, err
+	}
+	n := new(big.Int).SetBytes(word)
+	if !n.IsInt64() || n.Int64() > int64(len(data)) {
+		return 0, fmt.Errorf("abi: invalid length or offset %s", n.String())
+	}
+	return int(n.Int64()), nil
+}
@@ -17,8 +17,10 @@ package qtum
 
 import (
 	"encoding/hex"
+	"fmt"
 	"strings"
 
+	"github.com/Assetsadapter/qtum-adapter/qtum/btcLikeTxDriver"
 	"github.com/blocktree/go-owcdrivers/addressEncoder"
 	"github.com/blocktree/go-owcrypt"
 )
@@ -85,7 +87,7 @@ func (decoder *addressDecoder) PublicKeyToAddress(pub []byte, isTestnet bool) (s
 
 	address := addressEncoder.AddressEncode(pkHash, cfg)
 
-	if decoder.wm.config.RPCServerType == RPCServerCore {
+	if decoder.wm.config.RPCServerType == RPCServerCore && !decoder.wm.config.UTXOIndex {
 		//如果使用core钱包作为全节点，需要导入地址到core，这样才能查询地址余额和utxo
 		err := decoder.wm.ImportAddress(address, "")
 		if err != nil {
@@ -105,16 +107,27 @@ func (decoder *addressDecoder) RedeemScriptToAddress(pubs [][]byte, required uin
 		cfg = addressEncoder.QTUM_testnetAddressP2SH
 	}
 
-	redeemScript := make([]byte, 0)
+	if required > uint64(len(pubs)) {
+		return "", fmt.Errorf("required signatures %d exceed the number of public keys %d", required, len(pubs))
+	}
 
-	for _, pub := range pubs {
-		redeemScript = append(redeemScript, pub...)
+	redeemScript, err := btcLikeTxDriver.MultiSigRedeemScript(byte(required), pubs)
+	if err != nil {
+		return "", err
 	}
 
 	pkHash := owcrypt.Hash(redeemScript, 0, owcrypt.HASH_ALG_HASH160)
 
 	address := addressEncoder.AddressEncode(pkHash, cfg)
 
+	if decoder.wm.config.RPCServerType == RPCServerCore && !decoder.wm.config.UTXOIndex {
+		//如果使用core钱包作为全节点，需要导入地址到core，这样才能查询地址余额和utxo
+		err := decoder.wm.ImportAddress(address, "")
+		if err != nil {
+			return "", err
+		}
+	}
+
 	return address, nil
 
 }
@@ -17,7 +17,13 @@ package qtum
 
 import (
 	"encoding/hex"
+	"fmt"
 	"testing"
+
+	"github.com/Assetsadapter/qtum-adapter/qtum/btcLikeTxDriver"
+	"github.com/blocktree/go-owcdrivers/owkeychain"
+	"github.com/blocktree/go-owcrypt"
+	"github.com/blocktree/openwallet/openwallet"
 )
 
 func TestAddressDecoder_PublicKeyToAddress(t *testing.T) {
@@ -36,4 +42,63 @@ func TestAddressDecoder_PublicKeyToAddress(t *testing.T) {
 func TestHashAddressToBaseAddress(t *testing.T) {
 	addr := HashAddressToBaseAddress("", true)
 	t.Logf("addr: %s", addr)
-}
\ No newline at end of file
+}
+
+func TestAddressDecoder_RedeemScriptToAddress(t *testing.T) {
+
+	wm := NewWalletManager()
+	wm.config.RPCServerType = RPCServerExplorer
+
+	account := &openwallet.AssetsAccount{
+		HDPath:   "m/44'/88'/1'",
+		Required: 2,
+	}
+	pubs := make([][]byte, 0)
+	for i := 0; i < 3; i++ {
+		seed := owcrypt.Hash([]byte(fmt.Sprintf("multisig owner %d", i)), 0, owcrypt.HASH_ALG_SHA256)
+		key, err := owkeychain.DerivedPrivateKeyWithPath(seed, account.HDPath, owcrypt.ECC_CURVE_SECP256K1)
+		if err != nil {
+			t.Fatalf("derive owner key failed unexpected error: %v", err)
+		}
+		account.OwnerKeys = append(account.OwnerKeys, key.GetPublicKey().OWEncode())
+
+		child, err := owkeychain.DerivedPrivateKeyWithPath(seed, account.HDPath+"/0/5", owcrypt.ECC_CURVE_SECP256K1)
+		if err != nil {
+			t.Fatalf("derive child key failed unexpected error: %v", err)
+		}
+		pubs = append(pubs, child.GetPublicKeyBytes())
+	}
+
+	addr, err := wm.Decoder.RedeemScriptToAddress(pubs, account.Required, wm.config.isTestNet)
+	if err != nil {
+		t.Fatalf("RedeemScriptToAddress failed unexpected error: %v", err)
+	}
+
+	decoder := NewTransactionDecoder(wm)
+	redeem, ownerPubs, err := decoder.multiSigRedeemScript(account, account.HDPath+"/0/5")
+	if err != nil {
+		t.Fatalf("multiSigRedeemScript failed unexpected error: %v", err)
+	}
+
+	for i := range pubs {
+		if hex.EncodeToString(ownerPubs[i]) != hex.EncodeToString(pubs[i]) {
+			t.Errorf("owner %d pubkey mismatch", i)
+		}
+	}
+
+	expect, expectRedeem, err := btcLikeTxDriver.CreateMultiSig(2, pubs, wm.config.isTestNet)
+	if err != nil {
+		t.Fatalf("CreateMultiSig failed unexpected error: %v", err)
+	}
+	if hex.EncodeToString(redeem) != expectRedeem {
+		t.Errorf("redeem script mismatch: %x", redeem)
+	}
+	if addr != expect {
+		t.Errorf("address mismatch: %s != %s", addr, expect)
+	}
+	t.Logf("addr: %s", addr)
+
+	if _, err := wm.Decoder.RedeemScriptToAddress(pubs, 4, wm.config.isTestNet); err == nil {
+		t.Errorf("expected error when required exceeds the number of keys")
+	}
+}
@@ -0,0 +1,124 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package qtum
+
+import "sync"
+
+//blockchainSource 区块扫描器读取链上数据的接口，默认由WalletManager按RPCServerType实现
+type blockchainSource interface {
+	GetBlockHeight() (uint64, error)
+	GetBlockHash(height uint64) (string, error)
+	GetBlock(hash string) (*Block, error)
+	GetTransaction(txid string) (*Transaction, error)
+	GetTxIDsInMemPool() ([]string, error)
+}
+
+//prefetchedBlock 预取的区块及其交易单的提取结果
+type prefetchedBlock struct {
+	height     uint64
+	hash       string
+	hashErr    error
+	block      *Block
+	blockErr   error
+	results    []ExtractResult
+	extractErr error
+}
+
+//blockPrefetcher 并发下载和提取[start, end]的区块，最多同时处理window个，按高度顺序交付
+//预取只读取链上数据，分叉检查、保存高度和通知观测者仍由扫描任务按高度顺序执行
+type blockPrefetcher struct {
+	slots    chan chan *prefetchedBlock
+	quit     chan struct{}
+	stopOnce sync.Once
+	next     uint64 //下一个交付的高度
+	end      uint64
+}
+
+//newBlockPrefetcher 从start开始预取区块
+func (bs *BTCBlockScanner) newBlockPrefetcher(start, end uint64) *blockPrefetcher {
+
+	window := bs.wm.config.ScanPrefetchBlocks
+	if window < 1 {
+		window = 1
+	}
+
+	p := &blockPrefetcher{
+		//交付给扫描任务等待中的区块也占用一个位置
+		slots: make(chan chan *prefetchedBlock, window-1),
+		quit:  make(chan struct{}),
+		next:  start,
+		end:   end,
+	}
+
+	go func() {
+		defer close(p.slots)
+		for height := start; height <= end; height++ {
+			slot := make(chan *prefetchedBlock, 1)
+			select {
+			case p.slots <- slot:
+			case <-p.quit:
+				return
+			}
+			go func(height uint64) {
+				slot <- bs.prefetchBlock(height)
+			}(height)
+		}
+	}()
+
+	return p
+}
+
+//has 是否能交付该高度的区块
+func (p *blockPrefetcher) has(height uint64) bool {
+	return height == p.next && height <= p.end
+}
+
+//take 取出下一个高度的区块，等待其下载和提取完成
+func (p *blockPrefetcher) take() *prefetchedBlock {
+	slot, ok := <-p.slots
+	if !ok {
+		return nil
+	}
+	p.next++
+	return <-slot
+}
+
+//stop 停止预取，已开始的下载完成后丢弃
+func (p *blockPrefetcher) stop() {
+	p.stopOnce.Do(func() {
+		close(p.quit)
+	})
+}
+
+//prefetchBlock 下载区块并提取交易单
+func (bs *BTCBlockScanner) prefetchBlock(height uint64) *prefetchedBlock {
+
+	fetched := &prefetchedBlock{height: height}
+
+	fetched.hash, fetched.hashErr = bs.chain.GetBlockHash(height)
+	if fetched.hashErr != nil {
+		return fetched
+	}
+
+	fetched.block, fetched.blockErr = bs.chain.GetBlock(fetched.hash)
+	if fetched.blockErr != nil {
+		return fetched
+	}
+
+	fetched.results, fetched.extractErr = bs.extractBlockTransactions(fetched.block.Height, fetched.block.Hash, fetched.block.tx)
+
+	return fetched
+}
@@ -0,0 +1,221 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package qtum
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/blocktree/openwallet/openwallet"
+)
+
+//fakeChain 内存中的区块链，GetBlock模拟网络延迟并统计最大并发数
+type fakeChain struct {
+	mu            sync.Mutex
+	hashes        map[uint64]string
+	blocks        map[string]*Block
+	fetching      int
+	maxFetching   int
+	fetchDuration time.Duration
+	mempool       []string
+	txs           map[string]*Transaction //指定的交易单，其余交易单为给a的coinbase
+	txErrs        map[string]error        //查询交易单返回的错误
+}
+
+func newFakeChain() *fakeChain {
+	return &fakeChain{
+		hashes:        make(map[uint64]string),
+		blocks:        make(map[string]*Block),
+		txs:           make(map[string]*Transaction),
+		txErrs:        make(map[string]error),
+		fetchDuration: 5 * time.Millisecond,
+	}
+}
+
+//extend 在from高度之后追加分支branch的区块，直到to高度
+func (c *fakeChain) extend(branch string, from, to uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for height := from; height <= to; height++ {
+		hash := fmt.Sprintf("%s%d", branch, height)
+		c.blocks[hash] = &Block{Hash: hash, Height: height, Previousblockhash: c.hashes[height-1], tx: []string{"coinbase_" + hash}}
+		c.hashes[height] = hash
+	}
+	for height := range c.hashes {
+		if height > to {
+			delete(c.hashes, height)
+		}
+	}
+}
+
+func (c *fakeChain) GetBlockHeight() (uint64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return uint64(len(c.hashes) - 1), nil
+}
+
+func (c *fakeChain) GetBlockHash(height uint64) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	hash, ok := c.hashes[height]
+	if !ok {
+		return "", fmt.Errorf("block height %d not found", height)
+	}
+	return hash, nil
+}
+
+func (c *fakeChain) GetBlock(hash string) (*Block, error) {
+	c.mu.Lock()
+	c.fetching++
+	if c.fetching > c.maxFetching {
+		c.maxFetching = c.fetching
+	}
+	block, ok := c.blocks[hash]
+	c.mu.Unlock()
+
+	time.Sleep(c.fetchDuration)
+
+	c.mu.Lock()
+	c.fetching--
+	c.mu.Unlock()
+
+	if !ok {
+		return nil, fmt.Errorf("block %s not found", hash)
+	}
+	return block, nil
+}
+
+func (c *fakeChain) GetTransaction(txid string) (*Transaction, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err, ok := c.txErrs[txid]; ok {
+		return nil, err
+	}
+	if trx, ok := c.txs[txid]; ok {
+		copied := *trx
+		return &copied, nil
+	}
+	return &Transaction{TxID: txid, IsCoinBase: true, Vouts: []*Vout{{N: 0, Addr: "a", Value: "1"}}}, nil
+}
+
+func (c *fakeChain) GetTxIDsInMemPool() ([]string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]string{}, c.mempool...), nil
+}
+
+//fakeBlockchainDAI 记录保存的区块头
+type fakeBlockchainDAI struct {
+	openwallet.BlockchainDAIBase
+	heads  []*openwallet.BlockHeader
+	blocks map[uint64]*openwallet.BlockHeader
+}
+
+func (dai *fakeBlockchainDAI) SaveCurrentBlockHead(header *openwallet.BlockHeader) error {
+	dai.heads = append(dai.heads, header)
+	return nil
+}
+
+func (dai *fakeBlockchainDAI) GetCurrentBlockHead(symbol string) (*openwallet.BlockHeader, error) {
+	return dai.heads[len(dai.heads)-1], nil
+}
+
+func (dai *fakeBlockchainDAI) SaveLocalBlockHead(header *openwallet.BlockHeader) error {
+	dai.blocks[header.Height] = header
+	return nil
+}
+
+func (dai *fakeBlockchainDAI) GetLocalBlockHeadByHeight(height uint64, symbol string) (*openwallet.BlockHeader, error) {
+	header, ok := dai.blocks[height]
+	if !ok {
+		return nil, fmt.Errorf("local block %d not found", height)
+	}
+	return header, nil
+}
+
+func (dai *fakeBlockchainDAI) DeleteUnscanRecordByHeight(height uint64, symbol string) error {
+	return nil
+}
+
+func (dai *fakeBlockchainDAI) GetUnscanRecords(symbol string) ([]*openwallet.UnscanRecord, error) {
+	return nil, nil
+}
+
+func TestScanBlockTaskPrefetch(t *testing.T) {
+
+	chain := newFakeChain()
+	chain.extend("a", 0, 30)
+
+	wm := NewWalletManager()
+	wm.config.ScanPrefetchBlocks = 4
+	bs := wm.blockscanner
+	bs.chain = chain
+	bs.SetBlockScanAddressFunc(testScanAddress("a"))
+	dai := &fakeBlockchainDAI{
+		heads:  []*openwallet.BlockHeader{{Height: 1, Hash: "a1"}},
+		blocks: make(map[uint64]*openwallet.BlockHeader),
+	}
+	bs.SetBlockchainDAI(dai)
+	bs.Scanning = true
+
+	bs.ScanBlockTask()
+
+	//按高度顺序保存
+	if len(dai.heads) != 30 {
+		t.Fatalf("saved %d block heads, want 30", len(dai.heads))
+	}
+	for i, head := range dai.heads {
+		if head.Height != uint64(i+1) || head.Hash != fmt.Sprintf("a%d", i+1) {
+			t.Fatalf("block head %d = %d:%s, want height %d", i, head.Height, head.Hash, i+1)
+		}
+	}
+	if chain.maxFetching < 2 || chain.maxFetching > 4 {
+		t.Errorf("max concurrent block downloads = %d, want 2 to 4", chain.maxFetching)
+	}
+
+	//最后两个区块被分叉替换，新链高度32
+	chain.extend("b", 29, 32)
+	bs.ScanBlockTask()
+
+	head := dai.heads[len(dai.heads)-1]
+	if head.Height != 32 || head.Hash != "b32" {
+		t.Errorf("current block head = %d:%s, want 32:b32", head.Height, head.Hash)
+	}
+	for height := uint64(28); height <= 32; height++ {
+		want, _ := chain.GetBlockHash(height)
+		if dai.blocks[height].Hash != want {
+			t.Errorf("local block %d = %s, want %s", height, dai.blocks[height].Hash, want)
+		}
+	}
+}
+
+func TestBlockPrefetcherExhausted(t *testing.T) {
+
+	wm := NewWalletManager()
+	wm.blockscanner.chain = newFakeChain()
+
+	//没有可交付的区块时take返回nil，不会阻塞
+	p := wm.blockscanner.newBlockPrefetcher(5, 4)
+	if p.has(5) {
+		t.Errorf("prefetcher should not have height 5")
+	}
+	if fetched := p.take(); fetched != nil {
+		t.Errorf("take = %+v, want nil", fetched)
+	}
+	p.stop()
+}
@@ -16,8 +16,11 @@
 package qtum
 
 import (
+	"errors"
 	"testing"
+	"github.com/blocktree/openwallet/openwallet"
 	"github.com/pborman/uuid"
+	"github.com/tidwall/gjson"
 )
 
 func TestGetBTCBlockHeight(t *testing.T) {
@@ -115,6 +118,328 @@ func TestGetTransaction(t *testing.T) {
 
 }
 
+func TestGetTransactionReceiptByCore(t *testing.T) {
+	receipts, err := tw.getTransactionReceiptByCore("a8aab0a4e2a1b1b1f9b3d3e5f0e1b6e4c1d3a5b7a9c1e3f5a7b9c1d3e5f7a9b1")
+	if err != nil {
+		t.Errorf("getTransactionReceiptByCore failed unexpected error: %v\n", err)
+		return
+	}
+	for i, receipt := range receipts {
+		t.Logf("receipt[%d] = %+v \n", i, receipt)
+	}
+}
+
+func TestNewTokenReceiptsByCore(t *testing.T) {
+	raw := `[{
+		"blockHash": "3e5e3b2ca0b1b3b1f6a9aab0ee50f2f40ecbb02abc6ed1c6eb5ef3f6e0a6a3d9",
+		"blockNumber": 390006,
+		"transactionHash": "a8aab0a4e2a1b1b1f9b3d3e5f0e1b6e4c1d3a5b7a9c1e3f5a7b9c1d3e5f7a9b1",
+		"from": "8d1ea1e1c2f2b4b8a3a9a3f9c1d9a7d6e7e8e9f0",
+		"gasUsed": 36024,
+		"contractAddress": "91a6081095ef860d28874c9db613e7a4107b0281",
+		"excepted": "None",
+		"log": [{
+			"address": "91a6081095ef860d28874c9db613e7a4107b0281",
+			"topics": [
+				"ddf252ad1be2c89b69c2b068fc378daa952ba7f163c4a11628f55a4df523b3ef",
+				"0000000000000000000000008d1ea1e1c2f2b4b8a3a9a3f9c1d9a7d6e7e8e9f0",
+				"000000000000000000000000a1b2c3d4e5f6a7b8c9d0e1f2a3b4c5d6e7f8a9b0"
+			],
+			"data": "00000000000000000000000000000000000000000000000000000000000f4240"
+		}]
+	}]`
+	json := gjson.Parse(raw)
+	receipts := newTokenReceiptsByCore(&json, true)
+	if len(receipts) != 1 {
+		t.Errorf("newTokenReceiptsByCore receipts count = %d, want 1", len(receipts))
+		return
+	}
+	receipt := receipts[0]
+	if receipt.Amount != "1000000" {
+		t.Errorf("newTokenReceiptsByCore amount = %s, want 1000000", receipt.Amount)
+	}
+	if receipt.ContractAddress != "0x91a6081095ef860d28874c9db613e7a4107b0281" {
+		t.Errorf("newTokenReceiptsByCore contract = %s", receipt.ContractAddress)
+	}
+	if receipt.From != HashAddressToBaseAddress("8d1ea1e1c2f2b4b8a3a9a3f9c1d9a7d6e7e8e9f0", true) {
+		t.Errorf("newTokenReceiptsByCore from = %s", receipt.From)
+	}
+	t.Logf("receipt = %+v \n", receipt)
+}
+
+//testTokenScanner 代币信息已缓存的扫描器，精度为8，不访问节点
+func testTokenScanner(contracts ...string) *BTCBlockScanner {
+	decoder := NewContractDecoder(tw)
+	for _, contract := range contracts {
+		decoder.tokenCache[contract] = &QRC20Token{Address: "0x" + contract, Symbol: "T", Decimals: 8}
+	}
+	wm := *tw
+	wm.ContractDecoder = decoder
+	return NewBTCBlockScanner(&wm)
+}
+
+func TestExtractMultiTokenTransfer(t *testing.T) {
+	raw := `[{
+		"blockHash": "3e5e3b2ca0b1b3b1f6a9aab0ee50f2f40ecbb02abc6ed1c6eb5ef3f6e0a6a3d9",
+		"blockNumber": 390006,
+		"transactionHash": "a8aab0a4e2a1b1b1f9b3d3e5f0e1b6e4c1d3a5b7a9c1e3f5a7b9c1d3e5f7a9b1",
+		"from": "8d1ea1e1c2f2b4b8a3a9a3f9c1d9a7d6e7e8e9f0",
+		"gasUsed": 56024,
+		"excepted": "None",
+		"log": [{
+			"address": "91a6081095ef860d28874c9db613e7a4107b0281",
+			"topics": [
+				"ddf252ad1be2c89b69c2b068fc378daa952ba7f163c4a11628f55a4df523b3ef",
+				"0000000000000000000000008d1ea1e1c2f2b4b8a3a9a3f9c1d9a7d6e7e8e9f0",
+				"000000000000000000000000a1b2c3d4e5f6a7b8c9d0e1f2a3b4c5d6e7f8a9b0"
+			],
+			"data": "00000000000000000000000000000000000000000000000000000000000f4240"
+		}, {
+			"address": "f2033ede578e17fa6231047265010445bca8cf1c",
+			"topics": [
+				"ddf252ad1be2c89b69c2b068fc378daa952ba7f163c4a11628f55a4df523b3ef",
+				"000000000000000000000000a1b2c3d4e5f6a7b8c9d0e1f2a3b4c5d6e7f8a9b0",
+				"0000000000000000000000008d1ea1e1c2f2b4b8a3a9a3f9c1d9a7d6e7e8e9f0"
+			],
+			"data": "00000000000000000000000000000000000000000000000000000000000003e8"
+		}]
+	}]`
+	json := gjson.Parse(raw)
+	receipts := newTokenReceiptsByCore(&json, true)
+	if len(receipts) != 2 {
+		t.Errorf("newTokenReceiptsByCore receipts count = %d, want 2", len(receipts))
+		return
+	}
+
+	addr := HashAddressToBaseAddress("8d1ea1e1c2f2b4b8a3a9a3f9c1d9a7d6e7e8e9f0", true)
+	trx := &Transaction{
+		TxID:            receipts[0].TxHash,
+		Isqrc20Transfer: true,
+		TokenReceipts:   receipts,
+	}
+	result := &ExtractResult{
+		extractContractData: make(map[string][]*openwallet.TxExtractData),
+	}
+	bs := testTokenScanner("91a6081095ef860d28874c9db613e7a4107b0281", "f2033ede578e17fa6231047265010445bca8cf1c")
+	bs.extractTokenTransfer(trx, result, func(address string) (string, bool) {
+		return "account", address == addr
+	})
+
+	list := result.extractContractData["account"]
+	if len(list) != 2 {
+		t.Errorf("extractTokenTransfer records = %d, want 2", len(list))
+		return
+	}
+	if len(list[0].TxInputs) != 1 || list[0].TxInputs[0].Index != 0 {
+		t.Errorf("extractTokenTransfer first record should spend with index 0")
+	}
+	if len(list[1].TxOutputs) != 1 || list[1].TxOutputs[0].Index != 1 {
+		t.Errorf("extractTokenTransfer second record should receive with index 1")
+	}
+	if list[0].Transaction.WxID == list[1].Transaction.WxID {
+		t.Errorf("extractTokenTransfer records have the same WxID")
+	}
+	if list[0].Transaction.Coin.ContractID == list[1].Transaction.Coin.ContractID {
+		t.Errorf("extractTokenTransfer records have the same contract")
+	}
+}
+
+//Transfer事件的序号按交易单全部日志计数，节点和浏览器的WxID一致
+func TestTokenReceiptLogIndex(t *testing.T) {
+	approval := `{
+		"address": "91a6081095ef860d28874c9db613e7a4107b0281",
+		"topics": [
+			"8c5be1e5ebec7d5bd14f71427d1e84f3dd0314c0f7b2291e5b200ac8c7c3b925",
+			"0000000000000000000000008d1ea1e1c2f2b4b8a3a9a3f9c1d9a7d6e7e8e9f0",
+			"000000000000000000000000a1b2c3d4e5f6a7b8c9d0e1f2a3b4c5d6e7f8a9b0"
+		],
+		"data": "00000000000000000000000000000000000000000000000000000000000f4240"
+	}`
+	transfer := `{
+		"address": "91a6081095ef860d28874c9db613e7a4107b0281",
+		"topics": [
+			"ddf252ad1be2c89b69c2b068fc378daa952ba7f163c4a11628f55a4df523b3ef",
+			"0000000000000000000000008d1ea1e1c2f2b4b8a3a9a3f9c1d9a7d6e7e8e9f0",
+			"000000000000000000000000a1b2c3d4e5f6a7b8c9d0e1f2a3b4c5d6e7f8a9b0"
+		],
+		"data": "00000000000000000000000000000000000000000000000000000000000f4240"
+	}`
+	txid := "a8aab0a4e2a1b1b1f9b3d3e5f0e1b6e4c1d3a5b7a9c1e3f5a7b9c1d3e5f7a9b1"
+
+	core := gjson.Parse(`[{"transactionHash": "` + txid + `", "from": "8d1ea1e1c2f2b4b8a3a9a3f9c1d9a7d6e7e8e9f0", "excepted": "None", "log": [` + approval + `, ` + transfer + `]}]`)
+	coreReceipts := newTokenReceiptsByCore(&core, true)
+	if len(coreReceipts) != 1 || coreReceipts[0].LogIndex != 1 {
+		t.Fatalf("core receipts = %+v, want one transfer with log index 1", coreReceipts)
+	}
+
+	wm := NewWalletManager()
+	explorer := gjson.Parse(`{
+		"id": "` + txid + `",
+		"outputs": [{"value": "0", "scriptPubKey": {"type": "call"}, "receipt": {"excepted": "None", "logs": [` + approval + `, ` + transfer + `]}}],
+		"qrc20TokenTransfers": [{"addressHex": "91a6081095ef860d28874c9db613e7a4107b0281", "decimals": 8, "value": "1000000"}]
+	}`)
+	trx := wm.newTxByExplorer(&explorer, true)
+	if len(trx.TokenReceipts) != 1 || trx.TokenReceipts[0].LogIndex != 1 {
+		t.Fatalf("explorer receipts = %+v, want one transfer with log index 1", trx.TokenReceipts)
+	}
+
+	coin := openwallet.Coin{Symbol: "QTUM", IsContract: true, ContractID: "c"}
+	coreWxID := genTokenTransferWxID(&openwallet.Transaction{TxID: txid, Coin: coin}, coreReceipts[0].LogIndex)
+	explorerWxID := genTokenTransferWxID(&openwallet.Transaction{TxID: txid, Coin: coin}, trx.TokenReceipts[0].LogIndex)
+	if coreWxID != explorerWxID {
+		t.Errorf("core WxID = %s, explorer WxID = %s", coreWxID, explorerWxID)
+	}
+
+	//浏览器没有返回日志时按顺序编号
+	explorer = gjson.Parse(`{"id": "` + txid + `", "qrc20TokenTransfers": [{"addressHex": "91a6081095ef860d28874c9db613e7a4107b0281", "value": "1"}, {"addressHex": "91a6081095ef860d28874c9db613e7a4107b0281", "value": "2"}]}`)
+	if trx = wm.newTxByExplorer(&explorer, true); len(trx.TokenReceipts) != 2 || trx.TokenReceipts[1].LogIndex != 1 {
+		t.Errorf("explorer receipts without logs = %+v", trx.TokenReceipts)
+	}
+}
+
+func TestExtractFailedTokenTransfer(t *testing.T) {
+	sender := HashAddressToBaseAddress("8d1ea1e1c2f2b4b8a3a9a3f9c1d9a7d6e7e8e9f0", true)
+	to := HashAddressToBaseAddress("a1b2c3d4e5f6a7b8c9d0e1f2a3b4c5d6e7f8a9b0", true)
+	//gasLimit 250000, gasPrice 40, transfer(to, 1000000)
+	script := "540390d0030128" + "44" +
+		"a9059cbb000000000000000000000000a1b2c3d4e5f6a7b8c9d0e1f2a3b4c5d6e7f8a9b000000000000000000000000000000000000000000000000000000000000f4240" +
+		"1491a6081095ef860d28874c9db613e7a4107b0281c2"
+
+	trx := &Transaction{
+		TxID:        "a8aab0a4e2a1b1b1f9b3d3e5f0e1b6e4c1d3a5b7a9c1e3f5a7b9c1d3e5f7a9b1",
+		BlockHeight: 390006,
+		Vins: []*Vin{
+			{TxID: "e0c48b1a5d3d3c1d5f0f5c42b1c4e0a1d2c3b4a5968778695a4b3c2d1e0f1a2b", Vout: 1, Addr: sender, Value: "1"},
+		},
+		Vouts: []*Vout{
+			{N: 0, Value: "0", ScriptPubKey: script, Type: "call",
+				Execution: &ContractExecution{Sender: sender, GasUsed: 250000, Excepted: "Revert"}},
+			{N: 1, Addr: sender, Value: "0.89"},
+		},
+		TokenReceipts: make([]*TokenReceipt, 0),
+	}
+
+	//回滚的调用没有Transfer日志，由调用数据还原
+	trx.appendFailedTokenReceipts(true)
+	if !trx.Isqrc20Transfer || len(trx.TokenReceipts) != 1 {
+		t.Errorf("appendFailedTokenReceipts receipts count = %d, want 1", len(trx.TokenReceipts))
+		return
+	}
+	receipt := trx.TokenReceipts[0]
+	if !receipt.Failed() || receipt.From != sender || receipt.To != to || receipt.RawAmount != "1000000" {
+		t.Errorf("appendFailedTokenReceipts receipt = %+v", receipt)
+	}
+	if receipt.ContractAddress != "0x91a6081095ef860d28874c9db613e7a4107b0281" {
+		t.Errorf("appendFailedTokenReceipts contract = %s", receipt.ContractAddress)
+	}
+
+	result := &ExtractResult{
+		extractData:         make(map[string]*openwallet.TxExtractData),
+		extractContractData: make(map[string][]*openwallet.TxExtractData),
+	}
+	bs := testTokenScanner("91a6081095ef860d28874c9db613e7a4107b0281")
+	scanAddressFunc := func(address string) (string, bool) {
+		return "account", address == sender
+	}
+	bs.extractTransaction(trx, result, scanAddressFunc)
+	bs.extractTokenTransfer(trx, result, scanAddressFunc)
+
+	list := result.extractContractData["account"]
+	if len(list) != 1 || list[0].Transaction.Status != openwallet.TxStatusFail {
+		t.Errorf("extractTokenTransfer failed call should have status %s", openwallet.TxStatusFail)
+	}
+
+	//gas全部消耗，没有退款
+	tx := result.extractData["account"].Transaction
+	if tx.Fees != "0.11000000" {
+		t.Errorf("extractTransaction fees = %s, want 0.11000000", tx.Fees)
+	}
+}
+
+func TestContractGasRefund(t *testing.T) {
+	sender := HashAddressToBaseAddress("8d1ea1e1c2f2b4b8a3a9a3f9c1d9a7d6e7e8e9f0", true)
+	script := "540390d0030128" + "44" +
+		"a9059cbb000000000000000000000000a1b2c3d4e5f6a7b8c9d0e1f2a3b4c5d6e7f8a9b000000000000000000000000000000000000000000000000000000000000f4240" +
+		"1491a6081095ef860d28874c9db613e7a4107b0281c2"
+
+	trx := &Transaction{
+		TxID: "a8aab0a4e2a1b1b1f9b3d3e5f0e1b6e4c1d3a5b7a9c1e3f5a7b9c1d3e5f7a9b1",
+		Vins: []*Vin{
+			{TxID: "e0c48b1a5d3d3c1d5f0f5c42b1c4e0a1d2c3b4a5968778695a4b3c2d1e0f1a2b", Vout: 1, Addr: sender, Value: "1"},
+		},
+		Vouts: []*Vout{
+			{N: 0, Value: "0", ScriptPubKey: script, Type: "call",
+				Execution: &ContractExecution{Sender: sender, GasUsed: 36024, Excepted: "None"}},
+			{N: 1, Addr: sender, Value: "0.89"},
+		},
+	}
+
+	//(250000 - 36024) * 40
+	if refund := trx.contractGasRefund(); refund.String() != "0.0855904" {
+		t.Errorf("contractGasRefund = %s, want 0.0855904", refund.String())
+	}
+
+	result := &ExtractResult{
+		extractData:         make(map[string]*openwallet.TxExtractData),
+		extractContractData: make(map[string][]*openwallet.TxExtractData),
+	}
+	bs := NewBTCBlockScanner(tw)
+	bs.extractTransaction(trx, result, func(address string) (string, bool) {
+		return "account", address == sender
+	})
+	tx := result.extractData["account"].Transaction
+	if tx.Fees != "0.02440960" {
+		t.Errorf("extractTransaction fees = %s, want 0.02440960", tx.Fees)
+	}
+
+	//coinstake中退还给调用者的输出
+	staker := HashAddressToBaseAddress("f2033ede578e17fa6231047265010445bca8cf1c", true)
+	coinstake := &Transaction{
+		TxID:        "b1a9f7e5d3c1b9a7f5e3c1a9b7d5f3e1b6e4c1d3a5b7a9c1e3f5a7b9c1d3e5f7",
+		IsCoinstake: true,
+		Vins: []*Vin{
+			{TxID: "e0c48b1a5d3d3c1d5f0f5c42b1c4e0a1d2c3b4a5968778695a4b3c2d1e0f1a2b", Vout: 0, Addr: staker, Value: "100"},
+		},
+		Vouts: []*Vout{
+			{N: 0, Value: "0"},
+			{N: 1, Addr: staker, Value: "104"},
+			{N: 2, Addr: sender, Value: "0.0855904"},
+		},
+	}
+	//委托质押的超级质押者收取的委托费不是gas退款
+	superStaker := HashAddressToBaseAddress("91a6081095ef860d28874c9db613e7a4107b0281", true)
+	coinstake.Vouts = append(coinstake.Vouts, &Vout{N: 3, Addr: superStaker, Value: "0.4"})
+	scanAddress := func(address string) (string, bool) {
+		return address, address == sender || address == staker || address == superStaker
+	}
+	results := make([]ExtractResult, 0)
+	for _, trx := range []*Transaction{coinstake, trx} {
+		result := ExtractResult{
+			TxID:                trx.TxID,
+			Success:             true,
+			rewardTx:            trx,
+			gasRefunds:          trx.contractGasRefunds(),
+			extractData:         make(map[string]*openwallet.TxExtractData),
+			extractContractData: make(map[string][]*openwallet.TxExtractData),
+		}
+		if !trx.IsCoinstake {
+			result.rewardTx = nil
+		}
+		bs.extractTransaction(trx, &result, scanAddress)
+		results = append(results, result)
+	}
+	bs.markGasRefundOutputs(results)
+	extractData := results[0].extractData
+	if ed := extractData[sender]; ed == nil || ed.Transaction.TxAction != "gasRefund" || !ed.TxOutputs[0].GetExtParam().Get("gasRefund").Bool() {
+		t.Errorf("extractTransaction coinstake refund output not tagged")
+	}
+	if ed := extractData[staker]; ed == nil || ed.Transaction.TxAction != "coinstake" {
+		t.Errorf("extractTransaction coinstake staker record should keep coinstake action")
+	}
+	if ed := extractData[superStaker]; ed == nil || ed.Transaction.TxAction != "coinstake" || ed.TxOutputs[0].GetExtParam().Get("gasRefund").Bool() {
+		t.Errorf("extractTransaction coinstake delegation fee should not be a gas refund")
+	}
+}
 
 func TestGetTxOut(t *testing.T) {
 	raw, err := tw.GetTxOut("abaa7238ce271bb9371a010c49bf86506e82f757dc9436932ab9975bccc4e30c", 0)
@@ -294,4 +619,33 @@ func TestBTCBlockScanner_GetBalanceByAddress(t *testing.T) {
 	for i:=0; i<len(balanceList); i++ {
 		t.Logf("%s: %s\n",addrs[i], balanceList[i].Balance)
 	}
-}
\ No newline at end of file
+}
+//testFailingObserver 提取数据通知总是失败
+type testFailingObserver struct {
+	testScanObserver
+}
+
+func (o *testFailingObserver) BlockExtractDataNotify(sourceKey string, data *openwallet.TxExtractData) error {
+	return errors.New("observer is unavailable")
+}
+
+//代币提取数据的通知失败时返回错误
+func TestExtractDataListNotifyError(t *testing.T) {
+	bs := NewBTCBlockScanner(NewWalletManager())
+	data := map[string][]*openwallet.TxExtractData{
+		"account": {{Transaction: &openwallet.Transaction{TxID: "t1"}}, {Transaction: &openwallet.Transaction{TxID: "t1"}}},
+	}
+
+	bs.AddObserver(&testScanObserver{})
+	if err := bs.newExtractDataListNotify(100, data); err != nil {
+		t.Errorf("newExtractDataListNotify err = %v, want nil", err)
+	}
+
+	bs.AddObserver(&testFailingObserver{})
+	if err := bs.newExtractDataListNotify(100, data); err == nil {
+		t.Errorf("newExtractDataListNotify should return the observer error")
+	}
+	if err := bs.newExtractDataNotify(100, map[string]*openwallet.TxExtractData{"account": data["account"][0]}); err == nil {
+		t.Errorf("newExtractDataNotify should return the observer error")
+	}
+}
@@ -27,6 +27,7 @@ import (
 	"github.com/graarh/golang-socketio"
 	"github.com/graarh/golang-socketio/transport"
 	"github.com/shopspring/decimal"
+	"github.com/tidwall/gjson"
 )
 
 const (
@@ -46,12 +47,24 @@ type BTCBlockScanner struct {
 	RescanLastBlockCount uint64             //重扫上N个区块数量
 	socketIO             *gosocketio.Client //socketIO客户端
 	stopSocketIO         chan struct{}
+	utxoIndex            *utxoIndex           //观测地址的utxo索引
+	chain                blockchainSource     //链上数据来源
+	confirmations        *confirmationTracker //跟踪交易单的确认数
+	mempool              *mempoolTracker      //已通知的未确认交易单
 }
 
 //ExtractResult 扫描完成的提取结果
 type ExtractResult struct {
-	extractData         map[string]*openwallet.TxExtractData //主链交易
-	extractContractData map[string]*openwallet.TxExtractData //代币交易
+	extractData         map[string]*openwallet.TxExtractData   //主链交易
+	extractContractData map[string][]*openwallet.TxExtractData //代币交易，每个Transfer事件一条记录
+	utxoCreated         []*UTXORecord                          //观测地址新增的utxo
+	utxoSpent           []*utxoSpend                           //观测地址花费的utxo
+	isStake             bool                                   //coinbase或coinstake交易单
+	rewardTx            *Transaction                           //coinbase或coinstake交易单，区块提取完成后匹配gas退款和计算收益
+	gasRefunds          []*gasRefund                           //应由coinstake退还的gas
+	stakeRewards        []*StakeReward                         //观测地址的质押收益
+	spentOutpoints      []string                               //花费的txid_vout，检测未确认交易单的双花
+	feeRates            []decimal.Decimal                      //交易单的费率样本，区块提交后记录
 	TxID                string
 	BlockHeight         uint64
 	Success             bool
@@ -72,9 +85,13 @@ func NewBTCBlockScanner(wm *WalletManager) *BTCBlockScanner {
 
 	bs.extractingCH = make(chan struct{}, maxExtractingSize)
 	bs.wm = wm
+	bs.chain = wm
 	bs.IsScanMemPool = false
 	bs.RescanLastBlockCount = 0
 	bs.stopSocketIO = make(chan struct{})
+	bs.utxoIndex = &utxoIndex{symbol: wm.Symbol(), store: bs.utxoIndexStore}
+	bs.confirmations = newConfirmationTracker(wm.Symbol(), bs.confirmationStore)
+	bs.mempool = newMempoolTracker()
 
 	//设置扫描任务
 	bs.SetTask(bs.ScanBlockTask)
@@ -89,7 +106,7 @@ func (bs *BTCBlockScanner) SetRescanBlockHeight(height uint64) error {
 		return errors.New("block height to rescan must greater than 0.")
 	}
 
-	hash, err := bs.wm.GetBlockHash(height)
+	hash, err := bs.chain.GetBlockHash(height)
 	if err != nil {
 		return err
 	}
@@ -112,6 +129,14 @@ func (bs *BTCBlockScanner) ScanBlockTask() {
 	currentHeight := blockHeader.Height
 	currentHash := blockHeader.Hash
 
+	//后续区块的预取管道
+	var prefetch *blockPrefetcher
+	defer func() {
+		if prefetch != nil {
+			prefetch.stop()
+		}
+	}()
+
 	for {
 
 		if !bs.Scanning {
@@ -120,7 +145,7 @@ func (bs *BTCBlockScanner) ScanBlockTask() {
 		}
 
 		//获取最大高度
-		maxHeight, err := bs.wm.GetBlockHeight()
+		maxHeight, err := bs.chain.GetBlockHeight()
 		if err != nil {
 			//下一个高度找不到会报异常
 			bs.wm.Log.Std.Info("block scanner can not get rpc-server block height; unexpected error: %v", err)
@@ -138,14 +163,29 @@ func (bs *BTCBlockScanner) ScanBlockTask() {
 
 		bs.wm.Log.Std.Info("block scanner scanning height: %d ...", currentHeight)
 
-		hash, err := bs.wm.GetBlockHash(currentHeight)
+		//分叉回退或预取完毕后，从当前高度重新预取
+		if prefetch == nil || !prefetch.has(currentHeight) {
+			if prefetch != nil {
+				prefetch.stop()
+			}
+			prefetch = bs.newBlockPrefetcher(currentHeight, maxHeight)
+		}
+		fetched := prefetch.take()
+		if fetched == nil {
+			//预取已停止或没有可交付的区块，下次任务重新预取
+			bs.wm.Log.Std.Info("block scanner prefetch stopped before height: %d", currentHeight)
+			prefetch.stop()
+			break
+		}
+
+		hash, err := fetched.hash, fetched.hashErr
 		if err != nil {
 			//下一个高度找不到会报异常
 			bs.wm.Log.Std.Info("block scanner can not get new block hash; unexpected error: %v", err)
 			break
 		}
 
-		block, err := bs.wm.GetBlock(hash)
+		block, err := fetched.block, fetched.blockErr
 		if err != nil {
 			bs.wm.Log.Std.Info("block scanner can not get new block data; unexpected error: %v", err)
 
@@ -165,62 +205,40 @@ func (bs *BTCBlockScanner) ScanBlockTask() {
 			bs.wm.Log.Std.Info("block height: %d local hash = %s ", currentHeight-1, currentHash)
 			bs.wm.Log.Std.Info("block height: %d mainnet hash = %s ", currentHeight-1, block.Previousblockhash)
 
-			bs.wm.Log.Std.Info("delete recharge records on block height: %d.", currentHeight-1)
-
-			//删除上一区块链的所有充值记录
-			//bs.DeleteRechargesByHeight(currentHeight - 1)
-			//删除上一区块链的未扫记录
-
-			//查询本地分叉的区块
-			forkBlock, _ := bs.GetLocalBlock(currentHeight - 1)
-
-			bs.DeleteUnscanRecord(currentHeight - 1)
-			currentHeight = currentHeight - 2 //倒退2个区块重新扫描
-			if currentHeight <= 0 {
-				currentHeight = 1
-			}
-
-			localBlock, err := bs.GetLocalBlock(currentHeight)
+			//回退到与节点区块链一致的共同祖先，逐个撤销孤立的本地区块
+			ancestor, orphaned, err := bs.findForkAncestor(currentHeight - 1)
 			if err != nil {
-				bs.wm.Log.Std.Error("block scanner can not get local block; unexpected error: %v", err)
-
-				//查找core钱包的RPC
-				bs.wm.Log.Info("block scanner prev block height:", currentHeight)
-
-				prevHash, err := bs.wm.GetBlockHash(currentHeight)
-				if err != nil {
-					bs.wm.Log.Std.Error("block scanner can not get prev block; unexpected error: %v", err)
-					break
-				}
+				bs.wm.Log.Std.Error("block scanner can not find the common ancestor of fork; unexpected error: %v", err)
+				break
+			}
 
-				localBlock, err = bs.wm.GetBlock(prevHash)
-				if err != nil {
-					bs.wm.Log.Std.Error("block scanner can not get prev block; unexpected error: %v", err)
-					break
-				}
+			for _, forkBlock := range orphaned {
+				bs.rollbackForkBlock(forkBlock)
 			}
 
-			//重置当前区块的hash
-			currentHash = localBlock.Hash
+			//重置当前区块的高度和hash
+			currentHeight = ancestor.Height
+			currentHash = ancestor.Hash
 
 			bs.wm.Log.Std.Info("rescan block on height: %d, hash: %s .", currentHeight, currentHash)
 
 			//重新记录一个新扫描起点
-			bs.SaveLocalNewBlock(localBlock.Height, localBlock.Hash)
+			bs.SaveLocalNewBlock(ancestor.Height, ancestor.Hash)
 
 			isFork = true
 
-			if forkBlock != nil {
-
-				//通知分叉区块给观测者，异步处理
-				bs.newBlockNotify(forkBlock, isFork)
-			}
-
 		} else {
 
-			err = bs.BatchExtractTransaction(block.Height, block.Hash, block.tx)
+			//预取时已提取交易单，按高度顺序通知观测者
+			err = fetched.extractErr
+			if err == nil {
+				err = bs.commitExtractResults(block.Height, block.Hash, fetched.results)
+			}
 			if err != nil {
 				bs.wm.Log.Std.Info("block scanner can not extractRechargeRecords; unexpected error: %v", err)
+			} else {
+				//跟踪新区块的交易单，通知达到确认数阈值的交易单
+				bs.trackConfirmations(block.Height, fetched.results)
 			}
 
 			//重置当前区块的hash
@@ -269,14 +287,14 @@ func (bs *BTCBlockScanner) ScanBlock(height uint64) error {
 
 func (bs *BTCBlockScanner) scanBlock(height uint64) (*Block, error) {
 
-	hash, err := bs.wm.GetBlockHash(height)
+	hash, err := bs.chain.GetBlockHash(height)
 	if err != nil {
 		//下一个高度找不到会报异常
 		bs.wm.Log.Std.Info("block scanner can not get new block hash; unexpected error: %v", err)
 		return nil, err
 	}
 
-	block, err := bs.wm.GetBlock(hash)
+	block, err := bs.chain.GetBlock(hash)
 	if err != nil {
 		bs.wm.Log.Std.Info("block scanner can not get new block data; unexpected error: %v", err)
 
@@ -300,29 +318,6 @@ func (bs *BTCBlockScanner) scanBlock(height uint64) (*Block, error) {
 	return block, nil
 }
 
-//ScanTxMemPool 扫描交易内存池
-func (bs *BTCBlockScanner) ScanTxMemPool() {
-
-	bs.wm.Log.Std.Info("block scanner scanning mempool ...")
-
-	//提取未确认的交易单
-	txIDsInMemPool, err := bs.wm.GetTxIDsInMemPool()
-	if err != nil {
-		bs.wm.Log.Std.Info("block scanner can not get mempool data; unexpected error: %v", err)
-		return
-	}
-
-	if txIDsInMemPool == nil || len(txIDsInMemPool) == 0 {
-		return
-	}
-
-	err = bs.BatchExtractTransaction(0, "", txIDsInMemPool)
-	if err != nil {
-		bs.wm.Log.Std.Info("block scanner can not extractRechargeRecords; unexpected error: %v", err)
-	}
-
-}
-
 //rescanFailedRecord 重扫失败记录
 func (bs *BTCBlockScanner) RescanFailedRecord() {
 
@@ -362,14 +357,14 @@ func (bs *BTCBlockScanner) RescanFailedRecord() {
 
 		if len(txs) == 0 {
 
-			hash, err := bs.wm.GetBlockHash(height)
+			hash, err := bs.chain.GetBlockHash(height)
 			if err != nil {
 				//下一个高度找不到会报异常
 				bs.wm.Log.Std.Info("block scanner can not get new block hash; unexpected error: %v", err)
 				continue
 			}
 
-			block, err := bs.wm.GetBlock(hash)
+			block, err := bs.chain.GetBlock(hash)
 			if err != nil {
 				bs.wm.Log.Std.Info("block scanner can not get new block data; unexpected error: %v", err)
 				continue
@@ -402,16 +397,25 @@ func (bs *BTCBlockScanner) newBlockNotify(block *Block, isFork bool) {
 //BatchExtractTransaction 批量提取交易单
 //bitcoin 1M的区块链可以容纳3000笔交易，批量多线程处理，速度更快
 func (bs *BTCBlockScanner) BatchExtractTransaction(blockHeight uint64, blockHash string, txs []string) error {
+	results, err := bs.extractBlockTransactions(blockHeight, blockHash, txs)
+	if err != nil {
+		return err
+	}
+	return bs.commitExtractResults(blockHeight, blockHash, results)
+}
+
+//extractBlockTransactions 多线程提取区块的交易单，只读取链上数据，不通知观测者
+func (bs *BTCBlockScanner) extractBlockTransactions(blockHeight uint64, blockHash string, txs []string) ([]ExtractResult, error) {
 
 	var (
 		quit       = make(chan struct{})
-		done       = 0 //完成标记
-		failed     = 0
+		done       = 0        //完成标记
 		shouldDone = len(txs) //需要完成的总数
+		results    = make([]ExtractResult, 0, len(txs))
 	)
 
 	if len(txs) == 0 {
-		return errors.New("BatchExtractTransaction block is nil.")
+		return nil, errors.New("BatchExtractTransaction block is nil.")
 	}
 
 	//生产通道
@@ -422,38 +426,13 @@ func (bs *BTCBlockScanner) BatchExtractTransaction(blockHeight uint64, blockHash
 	worker := make(chan ExtractResult)
 	defer close(worker)
 
-	//保存工作
-	saveWork := func(height uint64, result chan ExtractResult) {
-		//回收创建的地址
+	//收集工作
+	collectWork := func(result chan ExtractResult) {
 		for gets := range result {
-
-			if gets.Success {
-
-				notifyErr := bs.newExtractDataNotify(height, gets.extractData)
-				//saveErr := bs.SaveRechargeToWalletDB(height, gets.Recharges)
-				if notifyErr != nil {
-					failed++ //标记保存失败数
-					bs.wm.Log.Std.Info("newExtractDataNotify unexpected error: %v", notifyErr)
-				}
-
-				notifyErr = nil
-				notifyErr = bs.newExtractDataNotify(height, gets.extractContractData)
-				if notifyErr != nil {
-					failed++ //标记保存失败数
-					bs.wm.Log.Std.Info("newExtractDataNotify unexpected error: %v", notifyErr)
-				}
-
-			} else {
-				//记录未扫区块
-				unscanRecord := openwallet.NewUnscanRecord(height, "", "", bs.wm.Symbol())
-				bs.SaveUnscanRecord(unscanRecord)
-				//bs.wm.Log.Std.Info("block height: %d extract failed.", height)
-				failed++ //标记保存失败数
-			}
+			results = append(results, gets)
 			//累计完成的线程数
 			done++
 			if done == shouldDone {
-				//bs.wm.Log.Std.Info("done = %d, shouldDone = %d ", done, len(txs))
 				close(quit) //关闭通道，等于给通道传入nil
 			}
 		}
@@ -482,7 +461,7 @@ func (bs *BTCBlockScanner) BatchExtractTransaction(blockHeight uint64, blockHash
 	/*	开启导出的线程	*/
 
 	//独立线程运行消费
-	go saveWork(blockHeight, worker)
+	go collectWork(worker)
 
 	//独立线程运行生产
 	go extractWork(blockHeight, blockHash, txs, producer)
@@ -490,13 +469,90 @@ func (bs *BTCBlockScanner) BatchExtractTransaction(blockHeight uint64, blockHash
 	//以下使用生产消费模式
 	bs.extractRuntime(producer, worker, quit)
 
+	//gas退款的标记和coinstake的收益拆分需要区块内全部交易单的gas退款
+	bs.markGasRefundOutputs(results)
+	bs.extractStakeRewards(results)
+
+	return results, nil
+}
+
+//commitExtractResults 通知观测者区块的提取结果，记录失败的交易单并更新utxo索引
+func (bs *BTCBlockScanner) commitExtractResults(blockHeight uint64, blockHash string, results []ExtractResult) error {
+
+	var (
+		failed      = 0
+		utxoCreated = make([]*UTXORecord, 0)
+		utxoSpent   = make([]*utxoSpend, 0)
+		feeRates    = make([]decimal.Decimal, 0)
+	)
+
+	for _, gets := range results {
+
+		if gets.Success {
+
+			notifyErr := bs.newExtractDataNotify(blockHeight, gets.extractData)
+			//saveErr := bs.SaveRechargeToWalletDB(height, gets.Recharges)
+			if notifyErr != nil {
+				failed++ //标记保存失败数
+				bs.wm.Log.Std.Info("newExtractDataNotify unexpected error: %v", notifyErr)
+			}
+
+			notifyErr = nil
+			notifyErr = bs.newExtractDataListNotify(blockHeight, gets.extractContractData)
+			if notifyErr != nil {
+				failed++ //标记保存失败数
+				bs.wm.Log.Std.Info("newExtractDataNotify unexpected error: %v", notifyErr)
+			}
+
+			notifyErr = bs.notifyStakeRewards(gets.stakeRewards)
+			if notifyErr != nil {
+				failed++ //标记保存失败数
+			}
+
+			utxoCreated = append(utxoCreated, gets.utxoCreated...)
+			utxoSpent = append(utxoSpent, gets.utxoSpent...)
+			feeRates = append(feeRates, gets.feeRates...)
+
+		} else {
+			//记录未扫区块
+			unscanRecord := openwallet.NewUnscanRecord(blockHeight, "", "", bs.wm.Symbol())
+			bs.SaveUnscanRecord(unscanRecord)
+			//bs.wm.Log.Std.Info("block height: %d extract failed.", height)
+			failed++ //标记保存失败数
+		}
+	}
+
+	//区块打包的交易单可能替换已通知的未确认交易单
+	if blockHeight > 0 {
+		bs.confirmMempoolTransactions(blockHeight, blockHash, results)
+	}
+
+	//预取的区块可能被重复提取，提交时才记录费率样本，并替换该高度已有的样本
+	if blockHeight > 0 {
+		bs.wm.blockFeeRates.add(blockHeight, feeRates)
+	}
+
+	//交易池的交易单记录在内存中，打包后由区块更新
+	if blockHeight == 0 && bs.wm.config.UTXOIndex {
+		for _, gets := range results {
+			if gets.Success {
+				bs.utxoIndex.applyMempool(gets.TxID, gets.utxoCreated, gets.utxoSpent)
+			}
+		}
+	}
+
+	//区块内的交易并发提取，全部完成后再更新utxo索引，同一区块中先创建后花费的utxo不受顺序影响
+	if blockHeight > 0 && bs.wm.config.UTXOIndex {
+		if err := bs.utxoIndex.applyBlock(blockHeight, utxoCreated, utxoSpent); err != nil {
+			bs.wm.Log.Std.Error("block scanner can not update utxo index on height: %d; unexpected error: %v", blockHeight, err)
+			failed++
+		}
+	}
+
 	if failed > 0 {
 		return fmt.Errorf("block scanner saveWork failed")
-	} else {
-		return nil
 	}
-
-	//return nil
+	return nil
 }
 
 //extractRuntime 提取运行时
@@ -545,12 +601,12 @@ func (bs *BTCBlockScanner) ExtractTransaction(blockHeight uint64, blockHash stri
 			BlockHeight:         blockHeight,
 			TxID:                txid,
 			extractData:         make(map[string]*openwallet.TxExtractData),
-			extractContractData: make(map[string]*openwallet.TxExtractData),
+			extractContractData: make(map[string][]*openwallet.TxExtractData),
 		}
 	)
 
 	//bs.wm.Log.Std.Debug("block scanner scanning tx: %s ...", txid)
-	trx, err := bs.wm.GetTransaction(txid)
+	trx, err := bs.chain.GetTransaction(txid)
 
 	if err != nil {
 		bs.wm.Log.Std.Info("block scanner can not extract transaction data; unexpected error: %v", err)
@@ -563,8 +619,22 @@ func (bs *BTCBlockScanner) ExtractTransaction(blockHeight uint64, blockHash stri
 		trx.BlockHeight = blockHeight
 		trx.BlockHash = blockHash
 	}
+	result.isStake = trx.IsCoinBase || trx.IsCoinstake
+	result.gasRefunds = trx.contractGasRefunds()
+	if trx.IsCoinBase || trx.IsCoinstake {
+		result.rewardTx = trx
+	}
+	if !trx.IsCoinBase {
+		for _, input := range trx.Vins {
+			result.spentOutpoints = append(result.spentOutpoints, utxoRecordKey(input.TxID, input.Vout))
+		}
+	}
 	//提取主币交易单
 	bs.extractTransaction(trx, &result, scanAddressFunc)
+	//记录观测地址的utxo变化
+	if result.Success {
+		bs.collectUTXOChanges(trx, &result, scanAddressFunc)
+	}
 	//提取代币交易单
 	bs.extractTokenTransfer(trx, &result, scanAddressFunc)
 	return result
@@ -601,7 +671,7 @@ func (bs *BTCBlockScanner) extractTransaction(trx *Transaction, result *ExtractR
 				intxid := input.TxID
 				vout := input.Vout
 
-				preTx, err := bs.wm.GetTransaction(intxid)
+				preTx, err := bs.chain.GetTransaction(intxid)
 				if err != nil {
 					success = false
 					break
@@ -646,11 +716,22 @@ func (bs *BTCBlockScanner) extractTransaction(trx *Transaction, result *ExtractR
 			to, totalReceived := bs.extractTxOutput(trx, trx.IsCoinstake, result, scanAddressFunc)
 			//bs.wm.Log.Debug("to:", to, "totalReceived:", totalReceived)
 
+			//未消耗的gas由区块的coinstake/coinbase退还，不计入手续费
+			gasRefund := trx.contractGasRefund()
+			fees := totalSpent.Sub(totalReceived).Sub(gasRefund)
+			//coinbase和coinstake不支付手续费，收益另行计算
+			if trx.IsCoinBase || trx.IsCoinstake {
+				fees = decimal.Zero
+			}
+			if rate, ok := bs.wm.blockFeeRate(trx, fees); ok {
+				result.feeRates = append(result.feeRates, rate)
+			}
+
 			for _, extractData := range result.extractData {
 				tx := &openwallet.Transaction{
 					From: from,
 					To:   to,
-					Fees: totalSpent.Sub(totalReceived).StringFixed(8),
+					Fees: fees.StringFixed(8),
 					Coin: openwallet.Coin{
 						Symbol:     bs.wm.Symbol(),
 						IsContract: false,
@@ -664,6 +745,12 @@ func (bs *BTCBlockScanner) extractTransaction(trx *Transaction, result *ExtractR
 					TxType:      txType,
 					TxAction:    txAction,
 				}
+				if gasRefund.IsPositive() {
+					tx.SetExtParam("gasRefund", gasRefund.StringFixed(8))
+				}
+				if trx.hasFailedContractCall() {
+					tx.SetExtParam("contractExcepted", true)
+				}
 				wxID := openwallet.GenTransactionWxID(tx)
 				tx.WxID = wxID
 				extractData.Transaction = tx
@@ -680,6 +767,56 @@ func (bs *BTCBlockScanner) extractTransaction(trx *Transaction, result *ExtractR
 
 }
 
+//markGasRefundOutputs 区块的交易单全部提取后，按区块内合约调用应退还的gas标记coinstake/coinbase中的退款输出
+//有交易单提取失败时无法确定gas退款，等待重扫
+func (bs *BTCBlockScanner) markGasRefundOutputs(results []ExtractResult) {
+
+	refunds := make([]*gasRefund, 0)
+	for _, result := range results {
+		if !result.Success {
+			return
+		}
+		refunds = append(refunds, result.gasRefunds...)
+	}
+	if len(refunds) == 0 {
+		return
+	}
+
+	for _, result := range results {
+		if result.rewardTx == nil {
+			continue
+		}
+		matched := result.rewardTx.gasRefundOutputs(refunds)
+		if len(matched) == 0 {
+			continue
+		}
+		for _, extractData := range result.extractData {
+			for _, output := range extractData.TxOutputs {
+				if matched[output.Index] {
+					output.SetExtParam("gasRefund", true)
+				}
+			}
+			//只收到gas退款的地址，不属于质押或挖矿收益
+			if extractData.Transaction != nil && isGasRefundOnly(extractData) {
+				extractData.Transaction.TxAction = "gasRefund"
+			}
+		}
+	}
+}
+
+//isGasRefundOnly 提取数据是否只包含gas退款输出
+func isGasRefundOnly(extractData *openwallet.TxExtractData) bool {
+	if len(extractData.TxInputs) > 0 || len(extractData.TxOutputs) == 0 {
+		return false
+	}
+	for _, output := range extractData.TxOutputs {
+		if !output.GetExtParam().Get("gasRefund").Bool() {
+			return false
+		}
+	}
+	return true
+}
+
 //ExtractTxInput 提取交易单输入部分
 func (bs *BTCBlockScanner) extractTxInput(trx *Transaction, isCoinstake bool, result *ExtractResult, scanAddressFunc openwallet.BlockScanAddressFunc) ([]string, decimal.Decimal) {
 
@@ -828,6 +965,7 @@ func (bs *BTCBlockScanner) extractTxOutput(trx *Transaction, isCoinstake bool, r
 }
 
 //extractTokenTransfer 提取交易单中的代币交易
+//一笔交易单可以包含多个Transfer事件（批量转账合约，DEX结算等），每个事件独立生成输入输出及交易记录
 func (bs *BTCBlockScanner) extractTokenTransfer(trx *Transaction, result *ExtractResult, scanAddressFunc openwallet.BlockScanAddressFunc) {
 
 	var (
@@ -841,24 +979,35 @@ func (bs *BTCBlockScanner) extractTokenTransfer(trx *Transaction, result *Extrac
 
 		if trx.Isqrc20Transfer {
 			createAt := time.Now().Unix()
-			//QTUM交易单目前只允许包含一个代币交易
+			blocktime := trx.Blocktime
+
 			for _, tokenReceipt := range trx.TokenReceipts {
 
-				contractId := openwallet.GenContractID(bs.wm.Symbol(), tokenReceipt.ContractAddress)
+				//每个Transfer事件，以事件序号区分
+				n := tokenReceipt.LogIndex
 
-				coin := openwallet.Coin{
-					Symbol:     bs.wm.Symbol(),
-					IsContract: true,
-					ContractID: contractId,
-					Contract: openwallet.SmartContract{
-						ContractID: contractId,
-						Address:    tokenReceipt.ContractAddress,
-						Protocol:   "qrc20",
-						Symbol:     bs.wm.Symbol(),
-					},
-				}
+				coin, found := bs.tokenCoin(tokenReceipt.ContractAddress)
+				contractId := coin.ContractID
 
 				sourceKey, ok := scanAddressFunc(tokenReceipt.From)
+				sourceKey2, ok2 := scanAddressFunc(tokenReceipt.To)
+
+				//以合约查询的精度为准，查询失败时沿用浏览器返回的精度
+				if found {
+					tokenReceipt.ResolveAmount(coin.Contract.Decimals)
+				} else if decimals, reported := tokenReceipt.ReportedDecimals(); reported {
+					tokenReceipt.ResolveAmount(decimals)
+					coin.Contract.Decimals = decimals
+				} else if ok || ok2 {
+					//无法确定精度时不能通知未换算的数量，记录未扫区块等待重扫
+					bs.wm.Log.Std.Error("token contract[%s] decimals unresolved, transaction: %s will be rescanned", tokenReceipt.ContractAddress, trx.TxID)
+					result.Success = false
+					return
+				}
+
+				//本次Transfer事件涉及的提取数据
+				transferData := make(map[string]*openwallet.TxExtractData)
+
 				if ok {
 					input := openwallet.TxInput{}
 					input.TxID = trx.TxID
@@ -866,27 +1015,24 @@ func (bs *BTCBlockScanner) extractTokenTransfer(trx *Transaction, result *Extrac
 					//transaction.AccountID = a.AccountID
 					input.Amount = tokenReceipt.Amount
 					input.Coin = coin
-					input.Index = 0
-					input.Sid = openwallet.GenTxInputSID(tokenReceipt.TxHash, bs.wm.Symbol(), contractId, 0)
+					input.Index = n
+					input.Sid = openwallet.GenTxInputSID(tokenReceipt.TxHash, bs.wm.Symbol(), contractId, n)
 					//input.Sid = base64.StdEncoding.EncodeToString(crypto.SHA1([]byte(fmt.Sprintf("input_%s_%d_%s", result.TxID, i, addr))))
 					input.CreateAt = createAt
 					//在哪个区块高度时消费
 					input.BlockHeight = tokenReceipt.BlockHeight
 					input.BlockHash = tokenReceipt.BlockHash
 
-					//transactions = append(transactions, &transaction)
-
-					ed := result.extractContractData[sourceKey]
+					ed := transferData[sourceKey]
 					if ed == nil {
 						ed = openwallet.NewBlockExtractData()
-						result.extractContractData[sourceKey] = ed
+						transferData[sourceKey] = ed
 					}
 
 					ed.TxInputs = append(ed.TxInputs, &input)
 
 				}
 
-				sourceKey2, ok2 := scanAddressFunc(tokenReceipt.To)
 				if ok2 {
 					output := openwallet.TxOutPut{}
 					output.TxID = trx.TxID
@@ -895,28 +1041,30 @@ func (bs *BTCBlockScanner) extractTokenTransfer(trx *Transaction, result *Extrac
 					output.Amount = tokenReceipt.Amount
 
 					output.Coin = coin
-					output.Index = 0
-					output.Sid = openwallet.GenTxOutPutSID(tokenReceipt.TxHash, bs.wm.Symbol(), contractId, 0)
+					output.Index = n
+					output.Sid = openwallet.GenTxOutPutSID(tokenReceipt.TxHash, bs.wm.Symbol(), contractId, n)
 					//input.Sid = base64.StdEncoding.EncodeToString(crypto.SHA1([]byte(fmt.Sprintf("input_%s_%d_%s", result.TxID, i, addr))))
 					output.CreateAt = createAt
 					//在哪个区块高度时消费
 					output.BlockHeight = tokenReceipt.BlockHeight
 					output.BlockHash = tokenReceipt.BlockHash
 
-					//transactions = append(transactions, &transaction)
-
-					ed := result.extractContractData[sourceKey2]
+					ed := transferData[sourceKey2]
 					if ed == nil {
 						ed = openwallet.NewBlockExtractData()
-						result.extractContractData[sourceKey2] = ed
+						transferData[sourceKey2] = ed
 					}
 
 					ed.TxOutputs = append(ed.TxOutputs, &output)
 				}
 
-				blocktime := trx.Blocktime
+				//合约执行异常回滚，代币余额未变化，只消耗了gas
+				status := openwallet.TxStatusSuccess
+				if tokenReceipt.Failed() {
+					status = openwallet.TxStatusFail
+				}
 
-				for _, extractData := range result.extractContractData {
+				for key, extractData := range transferData {
 					tx := &openwallet.Transaction{
 						From:        []string{tokenReceipt.From + ":" + tokenReceipt.Amount},
 						To:          []string{tokenReceipt.To + ":" + tokenReceipt.Amount},
@@ -925,15 +1073,21 @@ func (bs *BTCBlockScanner) extractTokenTransfer(trx *Transaction, result *Extrac
 						BlockHash:   tokenReceipt.BlockHash,
 						BlockHeight: tokenReceipt.BlockHeight,
 						TxID:        tokenReceipt.TxHash,
-						Decimal:     0,
+						Decimal:     int32(tokenReceipt.Decimals),
 						ConfirmTime: blocktime,
-						Status:      openwallet.TxStatusSuccess,
+						Status:      status,
 						TxType:      0,
 					}
-					wxID := openwallet.GenTransactionWxID(tx)
-					tx.WxID = wxID
+					tx.SetExtParam("logIndex", n)
+					tx.SetExtParam("rawAmount", tokenReceipt.RawAmount)
+					if tokenReceipt.Failed() {
+						tx.SetExtParam("excepted", tokenReceipt.Excepted)
+					}
+					tx.WxID = genTokenTransferWxID(tx, n)
 					extractData.Transaction = tx
 
+					result.extractContractData[key] = append(result.extractContractData[key], extractData)
+
 					//bs.wm.Log.Debug("Transaction:", extractData.Transaction)
 				}
 			}
@@ -947,14 +1101,55 @@ func (bs *BTCBlockScanner) extractTokenTransfer(trx *Transaction, result *Extrac
 
 }
 
-//newExtractDataNotify 发送通知
+//tokenCoin 代币交易的币种信息，未登记的代币通过合约查询名称、符号和精度
+//found表示是否成功获取代币信息
+func (bs *BTCBlockScanner) tokenCoin(contractAddress string) (coin openwallet.Coin, found bool) {
+
+	contract := openwallet.SmartContract{
+		ContractID: openwallet.GenContractID(bs.wm.Symbol(), contractAddress),
+		Address:    contractAddress,
+		Protocol:   "qrc20",
+		Symbol:     bs.wm.Symbol(),
+	}
+
+	if decoder, ok := bs.wm.ContractDecoder.(*ContractDecoder); ok {
+		token, err := decoder.GetTokenMetadata(contractAddress)
+		if err != nil {
+			bs.wm.Log.Std.Error("get token contract[%s] metadata failed, err: %v", contractAddress, err)
+		} else {
+			contract = token.SmartContract(bs.wm.Symbol())
+			found = true
+		}
+	}
+
+	coin = openwallet.Coin{
+		Symbol:     bs.wm.Symbol(),
+		IsContract: true,
+		ContractID: contract.ContractID,
+		Contract:   contract,
+	}
+	return coin, found
+}
+
+//genTokenTransferWxID 生成代币交易记录的WxID，同一交易单的多个Transfer事件以事件序号区分
+//第一个事件沿用交易单的WxID，保持与单事件交易单一致
+func genTokenTransferWxID(tx *openwallet.Transaction, logIndex uint64) string {
+	if logIndex == 0 {
+		return openwallet.GenTransactionWxID(tx)
+	}
+	return openwallet.GenTransactionWxID2(fmt.Sprintf("%s_%d", tx.TxID, logIndex), tx.Coin.Symbol, tx.Coin.ContractID)
+}
+
+//newExtractDataNotify 发送通知，观测者通知失败时记录未扫区块并返回错误
 func (bs *BTCBlockScanner) newExtractDataNotify(height uint64, extractData map[string]*openwallet.TxExtractData) error {
 
+	var failed error
 	for o, _ := range bs.Observers {
 		for key, data := range extractData {
 			err := o.BlockExtractDataNotify(key, data)
 			if err != nil {
 				bs.wm.Log.Error("BlockExtractDataNotify unexpected error:", err)
+				failed = err
 				//记录未扫区块
 				unscanRecord := openwallet.NewUnscanRecord(height, "", "ExtractData Notify failed.", bs.wm.Symbol())
 				err = bs.SaveUnscanRecord(unscanRecord)
@@ -966,9 +1161,23 @@ func (bs *BTCBlockScanner) newExtractDataNotify(height uint64, extractData map[s
 		}
 	}
 
-	return nil
+	return failed
 }
 
+//newExtractDataListNotify 发送通知，每个源标识可包含多条提取数据，返回观测者通知失败的错误
+func (bs *BTCBlockScanner) newExtractDataListNotify(height uint64, extractData map[string][]*openwallet.TxExtractData) error {
+
+	var failed error
+	for key, list := range extractData {
+		for _, data := range list {
+			if err := bs.newExtractDataNotify(height, map[string]*openwallet.TxExtractData{key: data}); err != nil {
+				failed = err
+			}
+		}
+	}
+
+	return failed
+}
 
 //DeleteUnscanRecordNotFindTX 删除未没有找到交易记录的重扫记录
 func (bs *BTCBlockScanner) DeleteUnscanRecordNotFindTX() error {
@@ -1006,7 +1215,7 @@ func (bs *BTCBlockScanner) GetScannedBlockHeader() (*openwallet.BlockHeader, err
 
 	//如果本地没有记录，查询接口的高度
 	if blockHeight == 0 {
-		blockHeight, err = bs.wm.GetBlockHeight()
+		blockHeight, err = bs.chain.GetBlockHeight()
 		if err != nil {
 
 			return nil, err
@@ -1015,7 +1224,7 @@ func (bs *BTCBlockScanner) GetScannedBlockHeader() (*openwallet.BlockHeader, err
 		//就上一个区块链为当前区块
 		blockHeight = blockHeight - 1
 
-		hash, err = bs.wm.GetBlockHash(blockHeight)
+		hash, err = bs.chain.GetBlockHash(blockHeight)
 		if err != nil {
 			return nil, err
 		}
@@ -1033,13 +1242,13 @@ func (bs *BTCBlockScanner) GetCurrentBlockHeader() (*openwallet.BlockHeader, err
 		err         error
 	)
 
-	blockHeight, err = bs.wm.GetBlockHeight()
+	blockHeight, err = bs.chain.GetBlockHeight()
 	if err != nil {
 
 		return nil, err
 	}
 
-	hash, err = bs.wm.GetBlockHash(blockHeight)
+	hash, err = bs.chain.GetBlockHash(blockHeight)
 	if err != nil {
 		return nil, err
 	}
@@ -1082,7 +1291,7 @@ func (bs *BTCBlockScanner) ExtractTransactionData(txid string, scanTargetFunc op
 		if txs == nil {
 			txs = make([]*openwallet.TxExtractData, 0)
 		}
-		txs = append(txs, data)
+		txs = append(txs, data...)
 		extData[key] = txs
 	}
 
@@ -1341,6 +1550,9 @@ func (wm *WalletManager) getTxIDsInMemPoolByCore() ([]string, error) {
 	return txids, nil
 }
 
+//ErrTransactionNotFound 节点或浏览器确定交易单不存在，不在交易池也没有被打包
+var ErrTransactionNotFound = errors.New("transaction not found")
+
 //GetTransaction 获取交易单
 func (wm *WalletManager) GetTransaction(txid string) (*Transaction, error) {
 
@@ -1373,11 +1585,78 @@ func (wm *WalletManager) getTransactionByCore(txid string) (*Transaction, error)
 	}
 
 	result, err := wm.walletClient.Call("getrawtransaction", request)
+	if err != nil {
+		//[-5]No such mempool or blockchain transaction
+		if strings.HasPrefix(err.Error(), "[-5]") {
+			return nil, ErrTransactionNotFound
+		}
+		return nil, err
+	}
+
+	trx := newTxByCore(result, wm.config.isTestNet)
+
+	//包含合约调用的交易单，需要查询交易回执提取代币交易
+	//节点没有开启-logevents时回执查询失败，只提取主币部分
+	if trx.hasContractCall() {
+		result, err := wm.callTransactionReceiptByCore(txid)
+		if err != nil {
+			wm.Log.Std.Error("can not get transaction receipt: %s, token transfers are not extracted, is the node started with -logevents? unexpected error: %v", txid, err)
+			return trx, nil
+		}
+		trx.TokenReceipts = newTokenReceiptsByCore(result, wm.config.isTestNet)
+		trx.Isqrc20Transfer = len(trx.TokenReceipts) > 0
+		trx.setContractExecutionsByCore(result, wm.config.isTestNet)
+		trx.appendFailedTokenReceipts(wm.config.isTestNet)
+	}
+
+	return trx, nil
+}
+
+//GetRawTransactionHex 获取交易单原始数据
+func (wm *WalletManager) GetRawTransactionHex(txid string) (string, error) {
+
+	if wm.config.RPCServerType == RPCServerExplorer {
+		return wm.getRawTransactionHexByExplorer(txid)
+	} else {
+		return wm.getRawTransactionHexByCore(txid)
+	}
+}
+
+//getRawTransactionHexByCore 获取交易单原始数据
+func (wm *WalletManager) getRawTransactionHexByCore(txid string) (string, error) {
+
+	request := []interface{}{
+		txid,
+		false,
+	}
+
+	result, err := wm.walletClient.Call("getrawtransaction", request)
+	if err != nil {
+		return "", err
+	}
+
+	return result.String(), nil
+}
+
+//getTransactionReceiptByCore 获取合约调用的交易回执，解析出代币交易
+func (wm *WalletManager) getTransactionReceiptByCore(txid string) ([]*TokenReceipt, error) {
+
+	result, err := wm.callTransactionReceiptByCore(txid)
 	if err != nil {
 		return nil, err
 	}
 
-	return newTxByCore(result, wm.config.isTestNet), nil
+	return newTokenReceiptsByCore(result, wm.config.isTestNet), nil
+}
+
+//callTransactionReceiptByCore 调用gettransactionreceipt
+func (wm *WalletManager) callTransactionReceiptByCore(txid string) (*gjson.Result, error) {
+
+	request := []interface{}{
+		txid,
+	}
+
+	return wm.walletClient.Call("gettransactionreceipt", request)
 }
 
 //GetTxOut 获取交易单输出信息，用于追溯交易单输入源头
@@ -1499,7 +1778,7 @@ func (bs *BTCBlockScanner) GetTransactionsByAddress(offset, limit int, coin open
 			BlockHeight:         tx.BlockHeight,
 			TxID:                tx.TxID,
 			extractData:         make(map[string]*openwallet.TxExtractData),
-			extractContractData: make(map[string]*openwallet.TxExtractData),
+			extractContractData: make(map[string][]*openwallet.TxExtractData),
 		}
 
 		bs.extractTransaction(tx, &result, scanAddressFunc)
@@ -0,0 +1,57 @@
+package btcLikeTxDriver
+
+import (
+	"encoding/hex"
+	"errors"
+)
+
+//DefaultDustRelayFee 默认的粉尘费率，单位：聪/KB，与qtumd的-dustrelayfee默认值一致
+const DefaultDustRelayFee = uint64(400000)
+
+//dustThreshold 输出的粉尘阈值：按粉尘费率创建该输出并日后花费它所需的手续费，低于该值的输出不被节点转发
+func dustThreshold(lockScript []byte, dustRelayFee uint64) uint64 {
+	size := 8 + len(varIntToBytes(uint64(len(lockScript)))) + len(lockScript)
+	if isWitnessProgram(lockScript) {
+		//txid + vout + 空解锁脚本 + sequence + 见证数据按1/4计算
+		size += 32 + 4 + 1 + 4 + 107/4
+	} else {
+		//txid + vout + 解锁脚本 + sequence
+		size += 32 + 4 + 1 + 107 + 4
+	}
+	return uint64(size) * dustRelayFee / 1000
+}
+
+//OutputDustThreshold 向地址输出的粉尘阈值，单位：聪
+func OutputDustThreshold(address string, isTestNet bool, dustRelayFee uint64) (uint64, error) {
+	txOut, err := newTxOutForEmptyTrans([]Vout{{address, 0}}, isTestNet)
+	if err != nil {
+		return 0, err
+	}
+	return dustThreshold(txOut[0].lockScript, dustRelayFee), nil
+}
+
+//DustOutputs 交易单中低于粉尘阈值的输出序号，合约调用和创建的输出不检查
+func DustOutputs(txHex string, dustRelayFee uint64) ([]int, error) {
+
+	txBytes, err := hex.DecodeString(txHex)
+	if err != nil {
+		return nil, errors.New("Invalid transaction hex data!")
+	}
+
+	trx, err := DecodeRawTransaction(txBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	dust := make([]int, 0)
+	for i, out := range trx.Vouts {
+		if _, err := DecodeContractScript(out.lockScript); err == nil {
+			continue
+		}
+		if littleEndianBytesToUint64(out.amount) < dustThreshold(out.lockScript, dustRelayFee) {
+			dust = append(dust, i)
+		}
+	}
+
+	return dust, nil
+}
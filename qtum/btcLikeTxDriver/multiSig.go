@@ -1,25 +1,25 @@
 package btcLikeTxDriver
 
 import (
+	"bytes"
 	"encoding/hex"
 	"errors"
+	"sort"
 
 	"github.com/blocktree/go-owcrypt"
 )
 
-func CreateMultiSig(required byte, pubkeys [][]byte, isTestNet bool) (string, string, error) {
-	var (
-		P2SHPrefix byte
-	)
+//MultiSigRedeemScript 构建多重签名赎回脚本：OP_M <pubkey>... OP_N OP_CHECKMULTISIG
+func MultiSigRedeemScript(required byte, pubkeys [][]byte) ([]byte, error) {
 
 	if required < 1 {
-		return "", "", errors.New("A multisignature address must require at least one key to redeem!")
+		return nil, errors.New("A multisignature address must require at least one key to redeem!")
 	}
 	if required > byte(len(pubkeys)) {
-		return "", "", errors.New("Not enough keys supplied for a multisignature address to redeem!")
+		return nil, errors.New("Not enough keys supplied for a multisignature address to redeem!")
 	}
 	if len(pubkeys) > 16 {
-		return "", "", errors.New("Number of keys involved in the multisignature address creation is too big!")
+		return nil, errors.New("Number of keys involved in the multisignature address creation is too big!")
 	}
 
 	redeem := []byte{}
@@ -28,7 +28,7 @@ func CreateMultiSig(required byte, pubkeys [][]byte, isTestNet bool) (string, st
 
 	for _, k := range pubkeys {
 		if len(k) != 33 && len(k) != 65 {
-			return "", "", errors.New("Invalid pubkey data for multisignature address!")
+			return nil, errors.New("Invalid pubkey data for multisignature address!")
 		}
 		redeem = append(redeem, byte(len(k)))
 		redeem = append(redeem, k...)
@@ -39,12 +39,24 @@ func CreateMultiSig(required byte, pubkeys [][]byte, isTestNet bool) (string, st
 	redeem = append(redeem, OpCheckMultiSig)
 
 	if len(redeem) > MaxScriptElementSize {
-		return "", "", errors.New("Redeem script exceeds size limit!")
+		return nil, errors.New("Redeem script exceeds size limit!")
 	}
 
-	redeemHash := owcrypt.Hash(redeem, 0, owcrypt.HASH_ALG_SHA256)
-	redeemHash = append([]byte{0x00, 0x20}, redeemHash...)
-	redeemHash = owcrypt.Hash(redeemHash, 0, owcrypt.HASH_ALG_HASH160)
+	return redeem, nil
+}
+
+//CreateMultiSig 创建P2SH多重签名地址，返回地址和赎回脚本
+func CreateMultiSig(required byte, pubkeys [][]byte, isTestNet bool) (string, string, error) {
+	var (
+		P2SHPrefix byte
+	)
+
+	redeem, err := MultiSigRedeemScript(required, pubkeys)
+	if err != nil {
+		return "", "", err
+	}
+
+	redeemHash := owcrypt.Hash(redeem, 0, owcrypt.HASH_ALG_HASH160)
 
 	if isTestNet {
 		P2SHPrefix = testNetP2SHPrefix
@@ -54,59 +66,161 @@ func CreateMultiSig(required byte, pubkeys [][]byte, isTestNet bool) (string, st
 	return EncodeCheck(P2SHPrefix, redeemHash), hex.EncodeToString(redeem), nil
 }
 
-func (t Transaction) isMultiSig() bool {
-	if len(t.Vins) != 1 {
-		return false
+//decodeMultiSigRedeemScript 解析多重签名赎回脚本，返回必要签名数和公钥列表
+func decodeMultiSigRedeemScript(redeem []byte) (int, [][]byte, error) {
+
+	if len(redeem) < 3 || redeem[len(redeem)-1] != OpCheckMultiSig {
+		return 0, nil, errors.New("Invalid multisig redeem script!")
 	}
-	if t.Vins[0].ScriptPubkeySignature == nil {
-		return false
+
+	required := int(redeem[0]) - int(OpCode_1) + 1
+	total := int(redeem[len(redeem)-2]) - int(OpCode_1) + 1
+	if required < 1 || total < required || total > 16 {
+		return 0, nil, errors.New("Invalid multisig redeem script!")
 	}
-	if t.Vins[0].ScriptPubkeySignature[len(t.Vins[0].ScriptPubkeySignature)-1] != OpCheckMultiSig {
-		return false
+
+	pubkeys := make([][]byte, 0, total)
+	index := 1
+	for index < len(redeem)-2 {
+		length := int(redeem[index])
+		index++
+		if (length != 33 && length != 65) || index+length > len(redeem)-2 {
+			return 0, nil, errors.New("Invalid pubkey in multisig redeem script!")
+		}
+		pubkeys = append(pubkeys, redeem[index:index+length])
+		index += length
 	}
-	return true
+
+	if len(pubkeys) != total {
+		return 0, nil, errors.New("Invalid multisig redeem script!")
+	}
+
+	return required, pubkeys, nil
 }
 
-func (t Contract) isMultiSig() bool {
-	if len(t.Vins) != 1 {
+//isMultiSigRedeemScript 赎回脚本是否为多重签名脚本
+func isMultiSigRedeemScript(redeemScript string) bool {
+	redeemBytes, err := hex.DecodeString(redeemScript)
+	if err != nil {
 		return false
 	}
-	if t.Vins[0].ScriptPubkeySignature == nil {
-		return false
+	_, _, err = decodeMultiSigRedeemScript(redeemBytes)
+	return err == nil
+}
+
+//encodeMultiSigScript 组装多重签名输入的解锁脚本：OP_0 <sig>... <redeem>
+//签名按赎回脚本中公钥的顺序排列，多于必要数量的签名被忽略
+func encodeMultiSigScript(sigPub []SignaturePubkey, redeem []byte) ([]byte, error) {
+
+	required, pubkeys, err := decodeMultiSigRedeemScript(redeem)
+	if err != nil {
+		return nil, err
 	}
-	if t.Vins[0].ScriptPubkeySignature[len(t.Vins[0].ScriptPubkeySignature)-1] != OpCheckMultiSig {
-		return false
+
+	type indexedSig struct {
+		index int
+		sp    SignaturePubkey
 	}
-	return true
-}
 
-func isMultiSig(lockScript, redeemScript string) bool {
-	if len(lockScript) != 0x17*2 {
-		return false
+	sigs := make([]indexedSig, 0, len(sigPub))
+	used := make(map[int]bool)
+	for _, sp := range sigPub {
+		if sp.Signature == nil || len(sp.Signature) != 64 {
+			return nil, errors.New("Invalid signature data!")
+		}
+		index := -1
+		for i, pub := range pubkeys {
+			if bytes.Equal(pub, sp.Pubkey) {
+				index = i
+				break
+			}
+		}
+		if index < 0 {
+			return nil, errors.New("Signature pubkey not found in multisig redeem script!")
+		}
+		if used[index] {
+			continue
+		}
+		used[index] = true
+		sigs = append(sigs, indexedSig{index, sp})
 	}
 
-	lockBytes, err := hex.DecodeString(lockScript)
-	if err != nil {
-		return false
+	if len(sigs) < required {
+		return nil, errors.New("Not enough signatures for the multisig input!")
 	}
-	if !(lockBytes[0] == OpCodeHash160 && lockBytes[1] == 0x14 || lockBytes[22] == OpCodeEqual) {
-		return false
+
+	sort.Slice(sigs, func(i, j int) bool {
+		return sigs[i].index < sigs[j].index
+	})
+
+	script := []byte{0x00}
+	for _, s := range sigs[:required] {
+		script = append(script, s.sp.encodeSignatureToScript(SigHashAll)...)
 	}
+	script = append(script, pushDataPrefix(len(redeem))...)
+	script = append(script, redeem...)
 
-	redeemBytes, err := hex.DecodeString(redeemScript)
+	return script, nil
+}
+
+//decodeMultiSigScript 解析多重签名输入的解锁脚本，返回签名和赎回脚本
+func decodeMultiSigScript(script []byte) ([][]byte, []byte, error) {
+
+	pushes, err := scriptPushes(script)
 	if err != nil {
-		return false
+		return nil, nil, err
 	}
 
-	if len(redeemBytes) == 0 || redeemBytes[len(redeemBytes)-1] != OpCheckMultiSig {
-		return false
+	//OP_0 <sig>... <redeem>
+	if len(pushes) < 3 || len(pushes[0]) != 0 {
+		return nil, nil, errors.New("Invalid multisig unlock script!")
+	}
+
+	redeem := pushes[len(pushes)-1]
+	if _, _, err := decodeMultiSigRedeemScript(redeem); err != nil {
+		return nil, nil, err
+	}
+
+	sigs := make([][]byte, 0, len(pushes)-2)
+	for _, push := range pushes[1 : len(pushes)-1] {
+		if len(push) == 0 || push[len(push)-1] != SigHashAll {
+			return nil, nil, errors.New("Only sigAll supported!")
+		}
+		sig, err := decodeDERSignature(push[:len(push)-1])
+		if err != nil {
+			return nil, nil, err
+		}
+		sigs = append(sigs, sig)
 	}
-	return true
 
+	return sigs, redeem, nil
 }
 
-func calcRedeemHash(redeem []byte) []byte {
-	redeemHash := owcrypt.Hash(redeem, 0, owcrypt.HASH_ALG_SHA256)
+//verifyMultiSig 按OP_CHECKMULTISIG的规则验证签名，签名须按公钥顺序排列
+func verifyMultiSig(hash []byte, sigs [][]byte, redeem []byte) bool {
+
+	required, pubkeys, err := decodeMultiSigRedeemScript(redeem)
+	if err != nil || len(sigs) < required {
+		return false
+	}
 
-	return append([]byte{0x22, 0x00, 0x20}, redeemHash...)
+	k := 0
+	for _, sig := range sigs {
+		matched := false
+		for ; k < len(pubkeys); k++ {
+			pubkey := pubkeys[k]
+			if len(pubkey) == 33 {
+				pubkey = owcrypt.PointDecompress(pubkey, owcrypt.ECC_CURVE_SECP256K1)
+			}
+			if owcrypt.Verify(pubkey[1:], nil, 0, hash, 32, sig, owcrypt.ECC_CURVE_SECP256K1) == owcrypt.SUCCESS {
+				matched = true
+				k++
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	return true
 }
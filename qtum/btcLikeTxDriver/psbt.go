@@ -0,0 +1,772 @@
+package btcLikeTxDriver
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+
+	"github.com/blocktree/go-owcrypt"
+)
+
+//BIP174 部分签名交易（PSBT）
+const (
+	psbtMagic = "psbt\xff"
+
+	PSBTGlobalUnsignedTx = 0x00
+
+	PSBTInNonWitnessUtxo     = 0x00
+	PSBTInWitnessUtxo        = 0x01
+	PSBTInPartialSig         = 0x02
+	PSBTInSighashType        = 0x03
+	PSBTInRedeemScript       = 0x04
+	PSBTInWitnessScript      = 0x05
+	PSBTInFinalScriptSig     = 0x07
+	PSBTInFinalScriptWitness = 0x08
+
+	PSBTOutRedeemScript  = 0x00
+	PSBTOutWitnessScript = 0x01
+)
+
+//PSBTUnknown 未识别的键值对，解析后原样保留
+type PSBTUnknown struct {
+	Key   []byte
+	Value []byte
+}
+
+//PSBTWitnessUtxo 隔离见证输入花费的输出
+type PSBTWitnessUtxo struct {
+	Amount     uint64
+	LockScript []byte
+}
+
+//PSBTPartialSig 输入的部分签名，签名为DER编码并附加签名类型
+type PSBTPartialSig struct {
+	Pubkey    []byte
+	Signature []byte
+}
+
+type PSBTInput struct {
+	NonWitnessUtxo     []byte
+	WitnessUtxo        *PSBTWitnessUtxo
+	PartialSigs        []PSBTPartialSig
+	SighashType        uint32
+	RedeemScript       []byte
+	WitnessScript      []byte
+	FinalScriptSig     []byte
+	FinalScriptWitness []byte
+	Unknowns           []PSBTUnknown
+}
+
+type PSBTOutput struct {
+	RedeemScript  []byte
+	WitnessScript []byte
+	Unknowns      []PSBTUnknown
+}
+
+type PSBT struct {
+	UnsignedTx []byte
+	Inputs     []PSBTInput
+	Outputs    []PSBTOutput
+	Unknowns   []PSBTUnknown
+}
+
+//NewPSBT 由空交易单创建PSBT，解锁数据提供输入的锁定脚本、赎回脚本和金额
+//非隔离见证输入需要调用SetNonWitnessUtxo补充完整的前序交易
+func NewPSBT(txHex string, unlockData []TxUnlock) (*PSBT, error) {
+	txBytes, err := hex.DecodeString(txHex)
+	if err != nil {
+		return nil, errors.New("Invalid transaction hex data!")
+	}
+
+	tx, err := DecodeRawTransaction(txBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(tx.Vins) != len(unlockData) {
+		return nil, errors.New("The number of transaction inputs and the unlock data are not match!")
+	}
+
+	for _, in := range tx.Vins {
+		if len(in.ScriptPubkeySignature) != 0 {
+			return nil, errors.New("Transaction inputs of a PSBT must be unsigned!")
+		}
+	}
+
+	psbt := &PSBT{
+		UnsignedTx: txBytes,
+		Inputs:     make([]PSBTInput, len(tx.Vins)),
+		Outputs:    make([]PSBTOutput, len(tx.Vouts)),
+	}
+
+	if tx.Witness != nil {
+		tx.Witness = nil
+		psbt.UnsignedTx, err = tx.encodeToBytes()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for i, unlock := range unlockData {
+		lockScript, err := hex.DecodeString(unlock.LockScript)
+		if err != nil || len(lockScript) == 0 {
+			return nil, errors.New("Invalid lockscript!")
+		}
+
+		redeem, err := hex.DecodeString(unlock.RedeemScript)
+		if err != nil {
+			return nil, errors.New("Invalid redeem script!")
+		}
+		if len(redeem) > 0 {
+			psbt.Inputs[i].RedeemScript = redeem
+		}
+
+		//隔离见证输入以输出的金额和锁定脚本签名
+		if isWitnessProgram(lockScript) || (len(redeem) > 0 && isWitnessProgram(redeem)) {
+			psbt.Inputs[i].WitnessUtxo = &PSBTWitnessUtxo{Amount: unlock.Amount, LockScript: lockScript}
+		}
+	}
+
+	return psbt, nil
+}
+
+//isWitnessProgram 是否隔离见证脚本：OP_0 <20字节或32字节>
+func isWitnessProgram(script []byte) bool {
+	return len(script) >= 2 && script[0] == 0x00 && int(script[1]) == len(script)-2 && (script[1] == 0x14 || script[1] == 0x20)
+}
+
+//unsignedTransaction 解析PSBT中的空交易单
+func (p *PSBT) unsignedTransaction() (*Transaction, error) {
+	tx, err := DecodeRawTransaction(p.UnsignedTx)
+	if err != nil {
+		return nil, err
+	}
+	if tx.Witness != nil {
+		return nil, errors.New("Unsigned transaction of a PSBT must not have witness!")
+	}
+	for _, in := range tx.Vins {
+		if len(in.ScriptPubkeySignature) != 0 {
+			return nil, errors.New("Transaction inputs of a PSBT must be unsigned!")
+		}
+	}
+	return tx, nil
+}
+
+//UnsignedTxHex 返回PSBT中的空交易单
+func (p *PSBT) UnsignedTxHex() string {
+	return hex.EncodeToString(p.UnsignedTx)
+}
+
+//SetNonWitnessUtxo 设置输入花费的完整前序交易，前序交易的哈希须与输入一致
+func (p *PSBT) SetNonWitnessUtxo(index int, prevTx []byte) error {
+	tx, err := p.unsignedTransaction()
+	if err != nil {
+		return err
+	}
+	if index < 0 || index >= len(tx.Vins) {
+		return errors.New("PSBT input index out of range!")
+	}
+
+	prev, err := DecodeRawTransaction(prevTx)
+	if err != nil {
+		return err
+	}
+
+	//交易哈希按不含见证数据的序列化计算
+	prev.Witness = nil
+	stripped, err := prev.encodeToBytes()
+	if err != nil {
+		return err
+	}
+	txid := doubleSha256(stripped)
+	if !bytes.Equal(txid, tx.Vins[index].TxID) {
+		return errors.New("Previous transaction does not match the PSBT input!")
+	}
+	if int(littleEndianBytesToUint32(tx.Vins[index].Vout)) >= len(prev.Vouts) {
+		return errors.New("Previous transaction does not match the PSBT input!")
+	}
+
+	p.Inputs[index].NonWitnessUtxo = prevTx
+	return nil
+}
+
+//prevOut 输入花费的输出金额和锁定脚本
+func (p *PSBT) prevOut(index int, in TxIn) (uint64, []byte, error) {
+	input := p.Inputs[index]
+	if input.WitnessUtxo != nil {
+		return input.WitnessUtxo.Amount, input.WitnessUtxo.LockScript, nil
+	}
+	if input.NonWitnessUtxo != nil {
+		prev, err := DecodeRawTransaction(input.NonWitnessUtxo)
+		if err != nil {
+			return 0, nil, err
+		}
+		vout := int(littleEndianBytesToUint32(in.Vout))
+		if vout >= len(prev.Vouts) {
+			return 0, nil, errors.New("Previous transaction does not match the PSBT input!")
+		}
+		return littleEndianBytesToUint64(prev.Vouts[vout].amount), prev.Vouts[vout].lockScript, nil
+	}
+	return 0, nil, errors.New("Missing utxo of the PSBT input!")
+}
+
+//TxUnlocks 由PSBT的输入信息生成解锁数据，用于计算签名哈希和验证交易
+func (p *PSBT) TxUnlocks() ([]TxUnlock, error) {
+	tx, err := p.unsignedTransaction()
+	if err != nil {
+		return nil, err
+	}
+	if len(p.Inputs) != len(tx.Vins) {
+		return nil, errors.New("The number of PSBT inputs and transaction inputs are not match!")
+	}
+
+	unlocks := make([]TxUnlock, 0, len(tx.Vins))
+	for i, in := range tx.Vins {
+		amount, lockScript, err := p.prevOut(i, in)
+		if err != nil {
+			return nil, err
+		}
+		unlocks = append(unlocks, TxUnlock{
+			LockScript:   hex.EncodeToString(lockScript),
+			RedeemScript: hex.EncodeToString(p.Inputs[i].RedeemScript),
+			Amount:       amount,
+		})
+	}
+	return unlocks, nil
+}
+
+//SignatureHashes 计算各输入的待签名哈希
+func (p *PSBT) SignatureHashes() ([]string, error) {
+	unlocks, err := p.TxUnlocks()
+	if err != nil {
+		return nil, err
+	}
+	return CreateRawTransactionHashForSig(p.UnsignedTxHex(), unlocks)
+}
+
+//AddPartialSig 添加输入的部分签名，同一公钥的签名会被覆盖
+func (p *PSBT) AddPartialSig(index int, sigPub SignaturePubkey) error {
+	if index < 0 || index >= len(p.Inputs) {
+		return errors.New("PSBT input index out of range!")
+	}
+	if sigPub.Signature == nil || len(sigPub.Signature) != 64 {
+		return errors.New("Invalid signature data!")
+	}
+	if sigPub.Pubkey == nil || (len(sigPub.Pubkey) != 33 && len(sigPub.Pubkey) != 65) {
+		return errors.New("Invalid pubkey data!")
+	}
+	if p.Inputs[index].SighashType != 0 && p.Inputs[index].SighashType != uint32(SigHashAll) {
+		return errors.New("Only sigAll supported!")
+	}
+
+	//去掉入栈长度，保留DER编码和签名类型
+	sig := sigPub.encodeSignatureToScript(SigHashAll)[1:]
+
+	input := &p.Inputs[index]
+	for i, ps := range input.PartialSigs {
+		if bytes.Equal(ps.Pubkey, sigPub.Pubkey) {
+			input.PartialSigs[i].Signature = sig
+			return nil
+		}
+	}
+	input.PartialSigs = append(input.PartialSigs, PSBTPartialSig{Pubkey: sigPub.Pubkey, Signature: sig})
+	return nil
+}
+
+//PartialSignatures 返回输入的部分签名
+func (p *PSBT) PartialSignatures(index int) ([]SignaturePubkey, error) {
+	if index < 0 || index >= len(p.Inputs) {
+		return nil, errors.New("PSBT input index out of range!")
+	}
+
+	ret := make([]SignaturePubkey, 0, len(p.Inputs[index].PartialSigs))
+	for _, ps := range p.Inputs[index].PartialSigs {
+		sig, err := decodePSBTSignature(ps.Signature)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, SignaturePubkey{Signature: sig, Pubkey: ps.Pubkey})
+	}
+	return ret, nil
+}
+
+//decodePSBTSignature 解析DER编码并附加签名类型的签名
+func decodePSBTSignature(sig []byte) ([]byte, error) {
+	if len(sig) == 0 || sig[len(sig)-1] != SigHashAll {
+		return nil, errors.New("Only sigAll supported!")
+	}
+	return decodeDERSignature(sig[:len(sig)-1])
+}
+
+//Merge 合并其他签名方返回的PSBT，两者的空交易单必须一致
+func (p *PSBT) Merge(other *PSBT) error {
+	if other == nil {
+		return nil
+	}
+	if !bytes.Equal(p.UnsignedTx, other.UnsignedTx) {
+		return errors.New("Can not merge PSBTs of different transactions!")
+	}
+	if len(p.Inputs) != len(other.Inputs) || len(p.Outputs) != len(other.Outputs) {
+		return errors.New("Can not merge PSBTs of different transactions!")
+	}
+
+	p.Unknowns = mergePSBTUnknowns(p.Unknowns, other.Unknowns)
+
+	for i := range p.Inputs {
+		in, o := &p.Inputs[i], other.Inputs[i]
+		if in.NonWitnessUtxo == nil {
+			in.NonWitnessUtxo = o.NonWitnessUtxo
+		}
+		if in.WitnessUtxo == nil {
+			in.WitnessUtxo = o.WitnessUtxo
+		}
+		if in.SighashType == 0 {
+			in.SighashType = o.SighashType
+		}
+		if in.RedeemScript == nil {
+			in.RedeemScript = o.RedeemScript
+		}
+		if in.WitnessScript == nil {
+			in.WitnessScript = o.WitnessScript
+		}
+		if in.FinalScriptSig == nil {
+			in.FinalScriptSig = o.FinalScriptSig
+		}
+		if in.FinalScriptWitness == nil {
+			in.FinalScriptWitness = o.FinalScriptWitness
+		}
+		for _, ps := range o.PartialSigs {
+			found := false
+			for _, exist := range in.PartialSigs {
+				if bytes.Equal(exist.Pubkey, ps.Pubkey) {
+					found = true
+					break
+				}
+			}
+			if !found {
+				in.PartialSigs = append(in.PartialSigs, ps)
+			}
+		}
+		in.Unknowns = mergePSBTUnknowns(in.Unknowns, o.Unknowns)
+	}
+
+	for i := range p.Outputs {
+		out, o := &p.Outputs[i], other.Outputs[i]
+		if out.RedeemScript == nil {
+			out.RedeemScript = o.RedeemScript
+		}
+		if out.WitnessScript == nil {
+			out.WitnessScript = o.WitnessScript
+		}
+		out.Unknowns = mergePSBTUnknowns(out.Unknowns, o.Unknowns)
+	}
+
+	return nil
+}
+
+func mergePSBTUnknowns(a, b []PSBTUnknown) []PSBTUnknown {
+	for _, u := range b {
+		found := false
+		for _, exist := range a {
+			if bytes.Equal(exist.Key, u.Key) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			a = append(a, u)
+		}
+	}
+	return a
+}
+
+//IsFinalized 是否所有输入都已生成最终的解锁脚本
+func (p *PSBT) IsFinalized() bool {
+	for _, in := range p.Inputs {
+		if in.FinalScriptSig == nil && in.FinalScriptWitness == nil {
+			return false
+		}
+	}
+	return true
+}
+
+//Finalize 以部分签名生成各输入最终的解锁脚本，并清除签名过程的数据
+func (p *PSBT) Finalize() error {
+	tx, err := p.unsignedTransaction()
+	if err != nil {
+		return err
+	}
+
+	for i, in := range tx.Vins {
+		input := &p.Inputs[i]
+		if input.FinalScriptSig != nil || input.FinalScriptWitness != nil {
+			continue
+		}
+
+		_, lockScript, err := p.prevOut(i, in)
+		if err != nil {
+			return err
+		}
+
+		sigPub, err := p.PartialSignatures(i)
+		if err != nil {
+			return err
+		}
+
+		redeem := input.RedeemScript
+		if _, _, err := decodeMultiSigRedeemScript(redeem); err == nil {
+			//P2SH多重签名
+			input.FinalScriptSig, err = encodeMultiSigScript(sigPub, redeem)
+			if err != nil {
+				return err
+			}
+		} else {
+			program := lockScript
+			if len(redeem) > 0 {
+				program = redeem
+			}
+			if len(program) < 2 {
+				return errors.New("Unknown type of lockscript!")
+			}
+
+			//按公钥哈希找到对应的签名
+			var pubkeyHash []byte
+			if isWitnessProgram(program) && program[1] == 0x14 {
+				pubkeyHash = program[2:]
+			} else if len(redeem) == 0 && len(lockScript) == 25 && checkScriptType(lockScript) == TypeP2PKH {
+				pubkeyHash = lockScript[3:23]
+			} else {
+				return errors.New("Unknown type of lockscript!")
+			}
+
+			var sp *SignaturePubkey
+			for j := range sigPub {
+				if bytes.Equal(owcrypt.Hash(sigPub[j].Pubkey, 0, owcrypt.HASH_ALG_HASH160), pubkeyHash) {
+					sp = &sigPub[j]
+					break
+				}
+			}
+			if sp == nil {
+				return errors.New("Missing signature of the PSBT input!")
+			}
+
+			if isWitnessProgram(program) {
+				sig := sp.encodeSignatureToScript(SigHashAll)
+				witness := []byte{0x02}
+				witness = append(witness, sig...)
+				witness = append(witness, byte(len(sp.Pubkey)))
+				witness = append(witness, sp.Pubkey...)
+				input.FinalScriptWitness = witness
+				if len(redeem) > 0 {
+					input.FinalScriptSig = append(pushDataPrefix(len(redeem)), redeem...)
+				}
+			} else {
+				input.FinalScriptSig = sp.encodeToScript(SigHashAll)
+			}
+		}
+
+		input.PartialSigs = nil
+		input.SighashType = 0
+		input.RedeemScript = nil
+		input.WitnessScript = nil
+	}
+
+	return nil
+}
+
+//Extract 提取已完成签名的交易单
+func (p *PSBT) Extract() (string, error) {
+	if !p.IsFinalized() {
+		return "", errors.New("PSBT is not finalized!")
+	}
+
+	tx, err := p.unsignedTransaction()
+	if err != nil {
+		return "", err
+	}
+
+	segwit := false
+	for _, in := range p.Inputs {
+		if in.FinalScriptWitness != nil {
+			segwit = true
+		}
+	}
+
+	ret := []byte{}
+	ret = append(ret, tx.Version...)
+	if segwit {
+		ret = append(ret, SegWitSymbol, SegWitVersion)
+	}
+	ret = append(ret, varIntToBytes(uint64(len(tx.Vins)))...)
+	for i, in := range tx.Vins {
+		ret = append(ret, in.TxID...)
+		ret = append(ret, in.Vout...)
+		ret = append(ret, varIntToBytes(uint64(len(p.Inputs[i].FinalScriptSig)))...)
+		ret = append(ret, p.Inputs[i].FinalScriptSig...)
+		ret = append(ret, in.Sequence...)
+	}
+	ret = append(ret, varIntToBytes(uint64(len(tx.Vouts)))...)
+	for _, out := range tx.Vouts {
+		ret = append(ret, out.amount...)
+		ret = append(ret, varIntToBytes(uint64(len(out.lockScript)))...)
+		ret = append(ret, out.lockScript...)
+	}
+	if segwit {
+		for _, in := range p.Inputs {
+			if in.FinalScriptWitness == nil {
+				ret = append(ret, 0x00)
+			} else {
+				ret = append(ret, in.FinalScriptWitness...)
+			}
+		}
+	}
+	ret = append(ret, tx.LockTime...)
+
+	return hex.EncodeToString(ret), nil
+}
+
+func appendPSBTKeyValue(ret []byte, key []byte, value []byte) []byte {
+	ret = append(ret, varIntToBytes(uint64(len(key)))...)
+	ret = append(ret, key...)
+	ret = append(ret, varIntToBytes(uint64(len(value)))...)
+	ret = append(ret, value...)
+	return ret
+}
+
+func appendPSBTUnknowns(ret []byte, unknowns []PSBTUnknown) []byte {
+	for _, u := range unknowns {
+		ret = appendPSBTKeyValue(ret, u.Key, u.Value)
+	}
+	return ret
+}
+
+//Serialize 按BIP174格式序列化
+func (p *PSBT) Serialize() ([]byte, error) {
+	tx, err := p.unsignedTransaction()
+	if err != nil {
+		return nil, err
+	}
+	if len(p.Inputs) != len(tx.Vins) || len(p.Outputs) != len(tx.Vouts) {
+		return nil, errors.New("The number of PSBT inputs or outputs and the transaction are not match!")
+	}
+
+	ret := []byte(psbtMagic)
+
+	ret = appendPSBTKeyValue(ret, []byte{PSBTGlobalUnsignedTx}, p.UnsignedTx)
+	ret = appendPSBTUnknowns(ret, p.Unknowns)
+	ret = append(ret, 0x00)
+
+	for _, in := range p.Inputs {
+		if in.NonWitnessUtxo != nil {
+			ret = appendPSBTKeyValue(ret, []byte{PSBTInNonWitnessUtxo}, in.NonWitnessUtxo)
+		}
+		if in.WitnessUtxo != nil {
+			value := uint64ToLittleEndianBytes(in.WitnessUtxo.Amount)
+			value = append(value, varIntToBytes(uint64(len(in.WitnessUtxo.LockScript)))...)
+			value = append(value, in.WitnessUtxo.LockScript...)
+			ret = appendPSBTKeyValue(ret, []byte{PSBTInWitnessUtxo}, value)
+		}
+		for _, ps := range in.PartialSigs {
+			ret = appendPSBTKeyValue(ret, append([]byte{PSBTInPartialSig}, ps.Pubkey...), ps.Signature)
+		}
+		if in.SighashType != 0 {
+			ret = appendPSBTKeyValue(ret, []byte{PSBTInSighashType}, uint32ToLittleEndianBytes(in.SighashType))
+		}
+		if in.RedeemScript != nil {
+			ret = appendPSBTKeyValue(ret, []byte{PSBTInRedeemScript}, in.RedeemScript)
+		}
+		if in.WitnessScript != nil {
+			ret = appendPSBTKeyValue(ret, []byte{PSBTInWitnessScript}, in.WitnessScript)
+		}
+		if in.FinalScriptSig != nil {
+			ret = appendPSBTKeyValue(ret, []byte{PSBTInFinalScriptSig}, in.FinalScriptSig)
+		}
+		if in.FinalScriptWitness != nil {
+			ret = appendPSBTKeyValue(ret, []byte{PSBTInFinalScriptWitness}, in.FinalScriptWitness)
+		}
+		ret = appendPSBTUnknowns(ret, in.Unknowns)
+		ret = append(ret, 0x00)
+	}
+
+	for _, out := range p.Outputs {
+		if out.RedeemScript != nil {
+			ret = appendPSBTKeyValue(ret, []byte{PSBTOutRedeemScript}, out.RedeemScript)
+		}
+		if out.WitnessScript != nil {
+			ret = appendPSBTKeyValue(ret, []byte{PSBTOutWitnessScript}, out.WitnessScript)
+		}
+		ret = appendPSBTUnknowns(ret, out.Unknowns)
+		ret = append(ret, 0x00)
+	}
+
+	return ret, nil
+}
+
+//ToBase64 序列化为base64编码，qtum-cli等工具使用此格式
+func (p *PSBT) ToBase64() (string, error) {
+	data, err := p.Serialize()
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(data), nil
+}
+
+//readPSBTMap 读取一组键值对，直到分隔符
+func readPSBTMap(data []byte, index *int) ([]PSBTUnknown, error) {
+	pairs := make([]PSBTUnknown, 0)
+	seen := make(map[string]bool)
+	for {
+		if *index >= len(data) {
+			return nil, errors.New("Invalid PSBT data length!")
+		}
+		keyLen, size, err := bytesToVarInt(data[*index:])
+		if err != nil {
+			return nil, err
+		}
+		*index += size
+		if keyLen == 0 {
+			return pairs, nil
+		}
+		if keyLen > uint64(len(data)-*index) {
+			return nil, errors.New("Invalid PSBT data length!")
+		}
+		key := data[*index : *index+int(keyLen)]
+		*index += int(keyLen)
+
+		valueLen, size, err := bytesToVarInt(data[*index:])
+		if err != nil {
+			return nil, err
+		}
+		*index += size
+		if valueLen > uint64(len(data)-*index) {
+			return nil, errors.New("Invalid PSBT data length!")
+		}
+		value := data[*index : *index+int(valueLen)]
+		*index += int(valueLen)
+
+		if seen[string(key)] {
+			return nil, errors.New("Duplicated key in PSBT!")
+		}
+		seen[string(key)] = true
+
+		pairs = append(pairs, PSBTUnknown{Key: key, Value: value})
+	}
+}
+
+//ParsePSBT 解析BIP174格式的PSBT
+func ParsePSBT(data []byte) (*PSBT, error) {
+	if !bytes.HasPrefix(data, []byte(psbtMagic)) {
+		return nil, errors.New("Invalid PSBT magic!")
+	}
+	index := len(psbtMagic)
+
+	var psbt PSBT
+
+	globals, err := readPSBTMap(data, &index)
+	if err != nil {
+		return nil, err
+	}
+	for _, kv := range globals {
+		if kv.Key[0] == PSBTGlobalUnsignedTx && len(kv.Key) == 1 {
+			psbt.UnsignedTx = kv.Value
+		} else {
+			psbt.Unknowns = append(psbt.Unknowns, kv)
+		}
+	}
+	if psbt.UnsignedTx == nil {
+		return nil, errors.New("Missing unsigned transaction in PSBT!")
+	}
+
+	tx, err := psbt.unsignedTransaction()
+	if err != nil {
+		return nil, err
+	}
+
+	for range tx.Vins {
+		pairs, err := readPSBTMap(data, &index)
+		if err != nil {
+			return nil, err
+		}
+		var in PSBTInput
+		for _, kv := range pairs {
+			keyType, keyData := kv.Key[0], kv.Key[1:]
+			if keyType == PSBTInPartialSig {
+				if len(keyData) != 33 && len(keyData) != 65 {
+					return nil, errors.New("Invalid pubkey data!")
+				}
+				in.PartialSigs = append(in.PartialSigs, PSBTPartialSig{Pubkey: keyData, Signature: kv.Value})
+				continue
+			}
+			if len(keyData) != 0 {
+				in.Unknowns = append(in.Unknowns, kv)
+				continue
+			}
+			switch keyType {
+			case PSBTInNonWitnessUtxo:
+				in.NonWitnessUtxo = kv.Value
+			case PSBTInWitnessUtxo:
+				if len(kv.Value) < 9 {
+					return nil, errors.New("Invalid witness utxo in PSBT!")
+				}
+				scriptLen, size, err := bytesToVarInt(kv.Value[8:])
+				if err != nil || uint64(len(kv.Value)-8-size) != scriptLen {
+					return nil, errors.New("Invalid witness utxo in PSBT!")
+				}
+				in.WitnessUtxo = &PSBTWitnessUtxo{
+					Amount:     littleEndianBytesToUint64(kv.Value[:8]),
+					LockScript: kv.Value[8+size:],
+				}
+			case PSBTInSighashType:
+				if len(kv.Value) != 4 {
+					return nil, errors.New("Invalid sighash type in PSBT!")
+				}
+				in.SighashType = littleEndianBytesToUint32(kv.Value)
+			case PSBTInRedeemScript:
+				in.RedeemScript = kv.Value
+			case PSBTInWitnessScript:
+				in.WitnessScript = kv.Value
+			case PSBTInFinalScriptSig:
+				in.FinalScriptSig = kv.Value
+			case PSBTInFinalScriptWitness:
+				in.FinalScriptWitness = kv.Value
+			default:
+				in.Unknowns = append(in.Unknowns, kv)
+			}
+		}
+		psbt.Inputs = append(psbt.Inputs, in)
+	}
+
+	for range tx.Vouts {
+		pairs, err := readPSBTMap(data, &index)
+		if err != nil {
+			return nil, err
+		}
+		var out PSBTOutput
+		for _, kv := range pairs {
+			if len(kv.Key) == 1 && kv.Key[0] == PSBTOutRedeemScript {
+				out.RedeemScript = kv.Value
+			} else if len(kv.Key) == 1 && kv.Key[0] == PSBTOutWitnessScript {
+				out.WitnessScript = kv.Value
+			} else {
+				out.Unknowns = append(out.Unknowns, kv)
+			}
+		}
+		psbt.Outputs = append(psbt.Outputs, out)
+	}
+
+	if index != len(data) {
+		return nil, errors.New("Too much PSBT data!")
+	}
+
+	return &psbt, nil
+}
+
+//ParsePSBTBase64 解析base64编码的PSBT
+func ParsePSBTBase64(data string) (*PSBT, error) {
+	raw, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return nil, errors.New("Invalid PSBT base64 data!")
+	}
+	return ParsePSBT(raw)
+}
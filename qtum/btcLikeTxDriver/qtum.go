@@ -31,6 +31,9 @@ var (
 	OpCodeDup         = byte(0x76)
 	OpCode_1          = byte(0x51)
 	OpCheckMultiSig   = byte(0xAE)
+	OpPushData1       = byte(0x4C)
+	OpPushData2       = byte(0x4D)
+	OpCall            = byte(0xC2)
 )
 
 var (
@@ -59,6 +59,15 @@ func calcSignaturePubkey(txHash [][]byte, unlockData []TxUnlock) ([]SignaturePub
 }
 
 func (sp SignaturePubkey) encodeToScript(sigType byte) []byte {
+	rs := sp.encodeSignatureToScript(sigType)
+
+	pub := append([]byte{byte(len(sp.Pubkey))}, sp.Pubkey...)
+
+	return append(rs, pub...)
+}
+
+//encodeSignatureToScript DER编码的签名附加签名类型后入栈
+func (sp SignaturePubkey) encodeSignatureToScript(sigType byte) []byte {
 	r := sp.Signature[:32]
 	s := sp.Signature[32:]
 
@@ -96,9 +105,42 @@ func (sp SignaturePubkey) encodeToScript(sigType byte) []byte {
 	rs = append([]byte{0x30}, rs...)
 	rs = append([]byte{byte(len(rs))}, rs...)
 
-	pub := append([]byte{byte(len(sp.Pubkey))}, sp.Pubkey...)
+	return rs
+}
 
-	return append(rs, pub...)
+//decodeDERSignature DER编码的签名转换为64字节的r||s
+func decodeDERSignature(der []byte) ([]byte, error) {
+	if len(der) < 8 || der[0] != 0x30 || int(der[1]) != len(der)-2 {
+		return nil, errors.New("Invalid signature data!")
+	}
+
+	ret := make([]byte, 0, 64)
+	index := 2
+	for i := 0; i < 2; i++ {
+		if index+2 > len(der) || der[index] != 0x02 {
+			return nil, errors.New("Invalid signature data!")
+		}
+		length := int(der[index+1])
+		index += 2
+		if length == 0 || length > 0x21 || index+length > len(der) {
+			return nil, errors.New("Invalid signature data!")
+		}
+		v := der[index : index+length]
+		if length == 0x21 {
+			if v[0] != 0x00 {
+				return nil, errors.New("Invalid signature data!")
+			}
+			v = v[1:]
+		}
+		ret = append(ret, make([]byte, 32-len(v))...)
+		ret = append(ret, v...)
+		index += length
+	}
+
+	if index != len(der) {
+		return nil, errors.New("Invalid signature data!")
+	}
+	return ret, nil
 }
 
 func decodeFromScriptBytes(script []byte) (*SignaturePubkey, error) {
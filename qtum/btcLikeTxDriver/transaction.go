@@ -4,8 +4,8 @@ import (
 	"encoding/hex"
 	"errors"
 	"fmt"
+	"math/big"
 	"strings"
-	"github.com/shopspring/decimal"
 )
 
 type Vin struct {
@@ -21,10 +21,11 @@ type Vout struct {
 type Vcontract struct {
 	ContractAddr string
 	To string
-	SendAmount decimal.Decimal
+	SendAmount *big.Int //合约中的整数数值，支持完整的uint256
 	GasLimit string
 	GasPrice string
 	Amount uint32
+	CallData string //已ABI编码的调用数据（十六进制），为空时按To和SendAmount构建transfer调用
 }
 
 type TxUnlock struct {
@@ -68,6 +69,59 @@ func CreateQRC20TokenEmptyRawTransaction(vins []Vin, contract Vcontract, vout []
 	return hex.EncodeToString(txBytes), nil
 }
 
+//IsReplaceableTransaction 交易单是否声明可替换（BIP125），任一输入的序列号小于0xFFFFFFFE即可
+func IsReplaceableTransaction(txHex string) (bool, error) {
+	txBytes, err := hex.DecodeString(txHex)
+	if err != nil {
+		return false, errors.New("Invalid transaction hex string!")
+	}
+	trx, err := DecodeRawTransaction(txBytes)
+	if err != nil {
+		return false, err
+	}
+	for _, in := range trx.Vins {
+		if in.GetSequence() <= SequenceMaxBip125RBF {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+//CreateReplacementRawTransaction 以已广播的交易单构建替换交易的空交易单
+//保留原交易的输入、输出（包括合约调用输出）和序列号，追加的手续费从找零输出中扣除
+func CreateReplacementRawTransaction(txHex string, changeIndex int, extraFee uint64) (string, error) {
+	txBytes, err := hex.DecodeString(txHex)
+	if err != nil {
+		return "", errors.New("Invalid transaction hex string!")
+	}
+	trx, err := DecodeRawTransaction(txBytes)
+	if err != nil {
+		return "", err
+	}
+
+	if changeIndex < 0 || changeIndex >= len(trx.Vouts) {
+		return "", errors.New("Change output index out of range!")
+	}
+
+	change := littleEndianBytesToUint64(trx.Vouts[changeIndex].amount)
+	if change <= extraFee {
+		return "", errors.New("Change output is not enough to pay the extra fee!")
+	}
+	trx.Vouts[changeIndex].amount = uint64ToLittleEndianBytes(change - extraFee)
+
+	for i := range trx.Vins {
+		trx.Vins[i].ScriptPubkeySignature = nil
+	}
+	trx.Witness = nil
+
+	txBytes, err = trx.encodeToBytes()
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(txBytes), nil
+}
+
 func CreateRawTransactionHashForSig(txHex string, unlockData []TxUnlock) ([]string, error) {
 	txBytes, err := hex.DecodeString(txHex)
 	if err != nil {
@@ -187,55 +241,72 @@ func InsertSignatureIntoEmptyTransaction(txHex string, sigPub []SignaturePubkey,
 		return "", errors.New("The number of transaction inputs and the unlock data are not match!")
 	}
 
-	if isMultiSig(unlockData[0].LockScript, unlockData[0].RedeemScript) {
-		redeemBytes, _ := hex.DecodeString(unlockData[0].RedeemScript)
-		emptyTrans.Vins[0].ScriptPubkeySignature = redeemBytes
-		for i := 0; i < len(sigPub); i++ {
-			emptyTrans.Witness = append(emptyTrans.Witness, TxWitness{sigPub[i].Signature, sigPub[i].Pubkey})
-		}
-	} else {
-		for i := 0; i < len(emptyTrans.Vins); i++ {
+	//多重签名输入按赎回脚本的必要数量依次取用签名，其他输入各取一个签名
+	k := 0
+	for i := 0; i < len(emptyTrans.Vins); i++ {
 
-			if sigPub[i].Signature == nil || len(sigPub[i].Signature) != 64 {
-				return "", errors.New("Invalid signature data!")
+		if isMultiSigRedeemScript(unlockData[i].RedeemScript) {
+			redeem, _ := hex.DecodeString(unlockData[i].RedeemScript)
+			required, _, _ := decodeMultiSigRedeemScript(redeem)
+			if k+required > len(sigPub) {
+				return "", errors.New("Not enough signatures for the multisig input!")
 			}
-			if sigPub[i].Pubkey == nil || len(sigPub[i].Pubkey) != 33 {
-				return "", errors.New("Invalid pubkey data!")
+
+			script, err := encodeMultiSigScript(sigPub[k:k+required], redeem)
+			if err != nil {
+				return "", err
 			}
+			k += required
 
-			// bech32 branch
-			if unlockData[i].RedeemScript == "" && strings.Index(unlockData[i].LockScript, "0014") == 0 {
-				unlockData[i].RedeemScript = unlockData[i].LockScript
-				unlockData[i].LockScript = "00"
+			emptyTrans.Vins[i].ScriptPubkeySignature = script
+			if emptyTrans.Witness != nil {
+				emptyTrans.Witness = append(emptyTrans.Witness, TxWitness{})
 			}
+			continue
+		}
+
+		if k >= len(sigPub) {
+			return "", errors.New("The number of signatures and the unlock data are not match!")
+		}
+		sp := sigPub[k]
+		k++
+
+		if sp.Signature == nil || len(sp.Signature) != 64 {
+			return "", errors.New("Invalid signature data!")
+		}
+		if sp.Pubkey == nil || len(sp.Pubkey) != 33 {
+			return "", errors.New("Invalid pubkey data!")
+		}
+
+		// bech32 branch
+		if unlockData[i].RedeemScript == "" && strings.Index(unlockData[i].LockScript, "0014") == 0 {
+			unlockData[i].RedeemScript = unlockData[i].LockScript
+			unlockData[i].LockScript = "00"
+		}
 
-			if unlockData[i].RedeemScript == "" {
+		if unlockData[i].RedeemScript == "" {
 
-				emptyTrans.Vins[i].ScriptPubkeySignature = sigPub[i].encodeToScript(SigHashAll)
-				if emptyTrans.Witness != nil {
+			emptyTrans.Vins[i].ScriptPubkeySignature = sp.encodeToScript(SigHashAll)
+			if emptyTrans.Witness != nil {
+				emptyTrans.Witness = append(emptyTrans.Witness, TxWitness{})
+			}
+		} else {
+			if emptyTrans.Witness == nil {
+				for j := 0; j < i; j++ {
 					emptyTrans.Witness = append(emptyTrans.Witness, TxWitness{})
 				}
-			} else {
-				if emptyTrans.Witness == nil {
-					for j := 0; j < i; j++ {
-						emptyTrans.Witness = append(emptyTrans.Witness, TxWitness{})
-					}
-				}
-				emptyTrans.Witness = append(emptyTrans.Witness, TxWitness{sigPub[i].Signature, sigPub[i].Pubkey})
-				if unlockData[i].RedeemScript == "" {
-					return "", errors.New("Missing redeem script for a P2SH input!")
-				}
+			}
+			emptyTrans.Witness = append(emptyTrans.Witness, TxWitness{sp.Signature, sp.Pubkey})
 
-				if unlockData[i].LockScript == "00" {
-					emptyTrans.Vins[i].ScriptPubkeySignature = nil
-				} else {
-					redeem, err := hex.DecodeString(unlockData[i].RedeemScript)
-					if err != nil {
-						return "", errors.New("Invlalid redeem script!")
-					}
-					redeem = append([]byte{byte(len(redeem))}, redeem...)
-					emptyTrans.Vins[i].ScriptPubkeySignature = redeem
+			if unlockData[i].LockScript == "00" {
+				emptyTrans.Vins[i].ScriptPubkeySignature = nil
+			} else {
+				redeem, err := hex.DecodeString(unlockData[i].RedeemScript)
+				if err != nil {
+					return "", errors.New("Invlalid redeem script!")
 				}
+				redeem = append([]byte{byte(len(redeem))}, redeem...)
+				emptyTrans.Vins[i].ScriptPubkeySignature = redeem
 			}
 		}
 	}
@@ -262,30 +333,29 @@ func VerifyRawTransaction(txHex string, unlockData []TxUnlock) bool {
 		return false
 	}
 
-	var sigAndPub []SignaturePubkey
-	if signedTrans.Witness == nil {
-		for _, sp := range signedTrans.Vins {
-			tmp, err := decodeFromScriptBytes(sp.ScriptPubkeySignature)
+	var (
+		sigAndPub = make([]SignaturePubkey, len(signedTrans.Vins))
+		multiSigs = make(map[int][][]byte)
+	)
+	for i := 0; i < len(signedTrans.Vins); i++ {
+		if signedTrans.Witness == nil || signedTrans.Witness[i].Signature == nil {
+			//多重签名输入的解锁脚本：OP_0 <sig>... <redeem>
+			if sigs, redeem, err := decodeMultiSigScript(signedTrans.Vins[i].ScriptPubkeySignature); err == nil {
+				multiSigs[i] = sigs
+				unlockData[i].RedeemScript = hex.EncodeToString(redeem)
+				continue
+			}
+			tmp, err := decodeFromScriptBytes(signedTrans.Vins[i].ScriptPubkeySignature)
 			if err != nil {
 				return false
 			}
-			sigAndPub = append(sigAndPub, *tmp)
-		}
-	} else {
-		for i := 0; i < len(signedTrans.Vins); i++ {
-			if signedTrans.Witness[i].Signature == nil {
-				tmp, err := decodeFromScriptBytes(signedTrans.Vins[i].ScriptPubkeySignature)
-				if err != nil {
-					return false
-				}
-				sigAndPub = append(sigAndPub, *tmp)
-			} else {
-				sigAndPub = append(sigAndPub, SignaturePubkey{signedTrans.Witness[i].Signature, signedTrans.Witness[i].Pubkey})
-				if strings.Index(unlockData[i].LockScript, "0014") == 0 {
-					continue
-				}
-				unlockData[i].RedeemScript = hex.EncodeToString(signedTrans.Vins[i].ScriptPubkeySignature[1:])
+			sigAndPub[i] = *tmp
+		} else {
+			sigAndPub[i] = SignaturePubkey{signedTrans.Witness[i].Signature, signedTrans.Witness[i].Pubkey}
+			if strings.Index(unlockData[i].LockScript, "0014") == 0 {
+				continue
 			}
+			unlockData[i].RedeemScript = hex.EncodeToString(signedTrans.Vins[i].ScriptPubkeySignature[1:])
 		}
 	}
 
@@ -296,7 +366,17 @@ func VerifyRawTransaction(txHex string, unlockData []TxUnlock) bool {
 		return false
 	}
 
-	return verifyHashes(hashes, sigAndPub)
+	for i, hash := range hashes {
+		if sigs, ok := multiSigs[i]; ok {
+			redeem, _ := hex.DecodeString(unlockData[i].RedeemScript)
+			if !verifyMultiSig(hash, sigs, redeem) {
+				return false
+			}
+		} else if !verifyHashes([][]byte{hash}, []SignaturePubkey{sigAndPub[i]}) {
+			return false
+		}
+	}
+	return true
 }
 
 
@@ -1,9 +1,16 @@
 package btcLikeTxDriver
 
 import (
+	"bytes"
 	"encoding/hex"
 	"fmt"
+	"math/big"
+	"strings"
 	"testing"
+
+	"github.com/blocktree/go-owcdrivers/addressEncoder"
+	"github.com/blocktree/go-owcrypt"
+	"github.com/btcsuite/btcd/wire"
 )
 
 var (
@@ -592,3 +599,804 @@ func Test_sigPub_DER_encode(t *testing.T) {
 		fmt.Println(hex.EncodeToString(chk.Pubkey))
 	}
 }
+
+func Test_contractCallData(t *testing.T) {
+	hash160, _ := hex.DecodeString("a1b2c3d4e5f6a7b8c9d0e1f2a3b4c5d6e7f8a9b0")
+	to := addressEncoder.AddressEncode(hash160, addressEncoder.QTUM_testnetAddressP2PKH)
+	vins := []Vin{{TxID: "e0c48b1a5d3d3c1d5f0f5c42b1c4e0a1d2c3b4a5968778695a4b3c2d1e0f1a2b", Vout: 1}}
+	vouts := []Vout{{Address: to, Amount: 100000}}
+
+	//transfer(address,uint256)
+	transfer := Vcontract{ContractAddr: "91a6081095ef860d28874c9db613e7a4107b0281", To: to, SendAmount: big.NewInt(1000000), GasLimit: "250000", GasPrice: "40"}
+	transferHex, err := CreateQRC20TokenEmptyRawTransaction(vins, transfer, vouts, 0, false, isTestNet)
+	if err != nil {
+		t.Errorf("create transfer failed: %v", err)
+		return
+	}
+
+	//同样的transfer调用数据，以任意调用数据的方式构建，结果应一致
+	callData := "a9059cbb000000000000000000000000a1b2c3d4e5f6a7b8c9d0e1f2a3b4c5d6e7f8a9b000000000000000000000000000000000000000000000000000000000000f4240"
+	call := Vcontract{ContractAddr: transfer.ContractAddr, GasLimit: "250000", GasPrice: "40", CallData: callData}
+	callHex, err := CreateQRC20TokenEmptyRawTransaction(vins, call, vouts, 0, false, isTestNet)
+	if err != nil {
+		t.Errorf("create contract call failed: %v", err)
+		return
+	}
+	if callHex != transferHex {
+		t.Errorf("contract call = %s, want %s", callHex, transferHex)
+	}
+
+	//transferFrom(address,address,uint256)，调用数据超过75字节
+	transferFrom := "23b872dd" + callData[8:72] + callData[8:]
+	call.CallData = transferFrom
+	callHex, err = CreateQRC20TokenEmptyRawTransaction(vins, call, vouts, 0, false, isTestNet)
+	if err != nil {
+		t.Errorf("create transferFrom call failed: %v", err)
+		return
+	}
+	if !strings.Contains(callHex, "4c64"+transferFrom+"1491a6081095ef860d28874c9db613e7a4107b0281c2") {
+		t.Errorf("transferFrom call script not found in %s", callHex)
+	}
+
+	emptyTrans, _ := hex.DecodeString(callHex)
+	tx, err := DecodeRawTransaction(emptyTrans)
+	if err != nil {
+		t.Errorf("decode contract call failed: %v", err)
+		return
+	}
+	if len(tx.Vouts) != 2 {
+		t.Errorf("decode contract call outputs = %d, want 2", len(tx.Vouts))
+	}
+}
+
+func Test_contractCallMaxAmount(t *testing.T) {
+	hash160, _ := hex.DecodeString("a1b2c3d4e5f6a7b8c9d0e1f2a3b4c5d6e7f8a9b0")
+	to := addressEncoder.AddressEncode(hash160, addressEncoder.QTUM_testnetAddressP2PKH)
+	vins := []Vin{{TxID: "e0c48b1a5d3d3c1d5f0f5c42b1c4e0a1d2c3b4a5968778695a4b3c2d1e0f1a2b", Vout: 1}}
+	vouts := []Vout{{Address: to, Amount: 100000}}
+
+	//2^256 - 1
+	max := new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 256), big.NewInt(1))
+	transfer := Vcontract{ContractAddr: "91a6081095ef860d28874c9db613e7a4107b0281", To: to, SendAmount: max, GasLimit: "250000", GasPrice: "40"}
+	emptyTrans, err := CreateQRC20TokenEmptyRawTransaction(vins, transfer, vouts, 0, false, isTestNet)
+	if err != nil {
+		t.Errorf("create transfer failed: %v", err)
+		return
+	}
+	if !strings.Contains(emptyTrans, "a1b2c3d4e5f6a7b8c9d0e1f2a3b4c5d6e7f8a9b0"+strings.Repeat("f", 64)) {
+		t.Errorf("transfer amount not encoded as uint256 in %s", emptyTrans)
+	}
+
+	//2^256 超出uint256范围
+	transfer.SendAmount = new(big.Int).Add(max, big.NewInt(1))
+	if _, err := CreateQRC20TokenEmptyRawTransaction(vins, transfer, vouts, 0, false, isTestNet); err == nil {
+		t.Errorf("create transfer with amount 2^256 should fail")
+	}
+}
+
+func Test_DecodeContractScript(t *testing.T) {
+	callData := "a9059cbb000000000000000000000000a1b2c3d4e5f6a7b8c9d0e1f2a3b4c5d6e7f8a9b000000000000000000000000000000000000000000000000000000000000f4240"
+	call := Vcontract{ContractAddr: "91a6081095ef860d28874c9db613e7a4107b0281", GasLimit: "250000", GasPrice: "40", CallData: callData}
+	contract, err := newTxContractForEmptyTrans(call, isTestNet)
+	if err != nil {
+		t.Errorf("new contract failed: %v", err)
+		return
+	}
+
+	//本地构建的脚本，虚拟机版本以数据推送的方式写入
+	//链上的脚本，虚拟机版本为OP_4
+	chainScript, _ := hex.DecodeString("540390d0030128" + "44" + callData + "1491a6081095ef860d28874c9db613e7a4107b0281c2")
+	for _, script := range [][]byte{contract.lockScript(), chainScript} {
+		ret, err := DecodeContractScript(script)
+		if err != nil {
+			t.Errorf("decode contract script failed: %v", err)
+			continue
+		}
+		if ret.VMVersion != 4 || ret.GasLimit != 250000 || ret.GasPrice != 40 {
+			t.Errorf("decode contract script vm = %d, gasLimit = %d, gasPrice = %d", ret.VMVersion, ret.GasLimit, ret.GasPrice)
+		}
+		if hex.EncodeToString(ret.CallData) != callData || hex.EncodeToString(ret.ContractAddr) != call.ContractAddr {
+			t.Errorf("decode contract script data = %x, contract = %x", ret.CallData, ret.ContractAddr)
+		}
+	}
+
+	//P2PKH脚本不是合约调用
+	p2pkh, _ := hex.DecodeString("76a914a1b2c3d4e5f6a7b8c9d0e1f2a3b4c5d6e7f8a9b088ac")
+	if _, err := DecodeContractScript(p2pkh); err == nil {
+		t.Errorf("decode p2pkh script should fail")
+	}
+}
+
+func Test_varInt(t *testing.T) {
+	tests := []struct {
+		n   uint64
+		hex string
+	}{
+		{0, "00"},
+		{0xFC, "fc"},
+		{0xFD, "fdfd00"},
+		{0xFFFF, "fdffff"},
+		{0x10000, "fe00000100"},
+		{0xFFFFFFFF, "feffffffff"},
+		{0x100000000, "ff0000000001000000"},
+	}
+	for _, test := range tests {
+		b := varIntToBytes(test.n)
+		if hex.EncodeToString(b) != test.hex {
+			t.Errorf("varIntToBytes(%d) = %x, want %s", test.n, b, test.hex)
+		}
+		n, size, err := bytesToVarInt(b)
+		if err != nil || n != test.n || size != len(b) {
+			t.Errorf("bytesToVarInt(%s) = %d, %d, %v", test.hex, n, size, err)
+		}
+	}
+
+	//非最短编码及长度不足
+	for _, s := range []string{"fdfc00", "feffff0000", "ffffffffff00000000", "fd01"} {
+		b, _ := hex.DecodeString(s)
+		if _, _, err := bytesToVarInt(b); err == nil {
+			t.Errorf("bytesToVarInt(%s) should fail", s)
+		}
+	}
+}
+
+//checkRoundTrip 解码再编码应还原原始字节，交易哈希与btcd的解析结果一致
+func checkRoundTrip(t *testing.T, raw []byte, txid string) *Transaction {
+	tx, err := DecodeRawTransaction(raw)
+	if err != nil {
+		t.Errorf("decode transaction failed: %v", err)
+		return nil
+	}
+	encoded, err := tx.encodeToBytes()
+	if err != nil {
+		t.Errorf("encode transaction failed: %v", err)
+		return nil
+	}
+	if !bytes.Equal(encoded, raw) {
+		t.Errorf("transaction round trip mismatch")
+	}
+	if id := reverseBytesToHex(doubleSha256(encoded)); id != txid {
+		t.Errorf("txid = %s, want %s", id, txid)
+	}
+	var msg wire.MsgTx
+	if err := msg.Deserialize(bytes.NewReader(raw)); err != nil {
+		t.Errorf("btcd decode transaction failed: %v", err)
+		return tx
+	}
+	if msg.TxHash().String() != txid || len(msg.TxIn) != len(tx.Vins) || len(msg.TxOut) != len(tx.Vouts) {
+		t.Errorf("btcd txid = %s, vins = %d, vouts = %d", msg.TxHash(), len(msg.TxIn), len(msg.TxOut))
+	}
+	return tx
+}
+
+//归集交易的输入输出超过252个
+func Test_largeTransactionRoundTrip(t *testing.T) {
+	hash160, _ := hex.DecodeString("a1b2c3d4e5f6a7b8c9d0e1f2a3b4c5d6e7f8a9b0")
+	to := addressEncoder.AddressEncode(hash160, addressEncoder.QTUM_testnetAddressP2PKH)
+
+	vins := make([]Vin, 0)
+	for i := 0; i < 300; i++ {
+		vins = append(vins, Vin{"6cb0425bb4bb962db8359b8d3cbaa66ed8121091db6cfc9253f5bf1e9cef604f", uint32(i)})
+	}
+	vouts := make([]Vout, 0)
+	for i := 0; i < 260; i++ {
+		vouts = append(vouts, Vout{to, uint64(10000 + i)})
+	}
+
+	emptyTrans, err := CreateEmptyRawTransaction(vins, vouts, 0, true, isTestNet)
+	if err != nil {
+		t.Errorf("create transaction failed: %v", err)
+		return
+	}
+	//版本号之后是输入数量0xfd2c01
+	if emptyTrans[8:14] != "fd2c01" {
+		t.Errorf("input count encoded as %s, want fd2c01", emptyTrans[8:14])
+	}
+
+	txBytes, _ := hex.DecodeString(emptyTrans)
+	var msg wire.MsgTx
+	if err := msg.Deserialize(bytes.NewReader(txBytes)); err != nil {
+		t.Errorf("btcd decode transaction failed: %v", err)
+		return
+	}
+	tx := checkRoundTrip(t, txBytes, msg.TxHash().String())
+	if tx == nil {
+		return
+	}
+	if len(tx.Vins) != 300 || len(tx.Vouts) != 260 {
+		t.Errorf("decode transaction vins = %d, vouts = %d", len(tx.Vins), len(tx.Vouts))
+	}
+	if tx.Vins[299].GetVout() != 299 || littleEndianBytesToUint64(tx.Vouts[259].amount) != 10259 {
+		t.Errorf("decode transaction last input or output mismatch")
+	}
+
+	//输入脚本超过252字节
+	tx.Vins[0].ScriptPubkeySignature = make([]byte, 300)
+	tx.Vins[0].ScriptPubkeySignature[299] = 0x01
+	encoded, err := tx.encodeToBytes()
+	if err != nil {
+		t.Errorf("encode transaction failed: %v", err)
+		return
+	}
+	decoded, err := DecodeRawTransaction(encoded)
+	if err != nil {
+		t.Errorf("decode transaction with long script failed: %v", err)
+		return
+	}
+	if len(decoded.Vins[0].ScriptPubkeySignature) != 300 {
+		t.Errorf("decode script length = %d, want 300", len(decoded.Vins[0].ScriptPubkeySignature))
+	}
+	reencoded, _ := decoded.encodeToBytes()
+	if hex.EncodeToString(reencoded) != hex.EncodeToString(encoded) {
+		t.Errorf("transaction round trip mismatch")
+	}
+
+	//截断的数据
+	if _, err := DecodeRawTransaction(encoded[:len(encoded)-5]); err == nil {
+		t.Errorf("decode truncated transaction should fail")
+	}
+}
+
+//序列化超过64KB的交易单，txid与btcd的计算结果一致
+func Test_largeTransactionHash(t *testing.T) {
+	hash160, _ := hex.DecodeString("a1b2c3d4e5f6a7b8c9d0e1f2a3b4c5d6e7f8a9b0")
+	to := addressEncoder.AddressEncode(hash160, addressEncoder.QTUM_testnetAddressP2PKH)
+
+	vins := make([]Vin, 0)
+	for i := 0; i < 2000; i++ {
+		vins = append(vins, Vin{"6cb0425bb4bb962db8359b8d3cbaa66ed8121091db6cfc9253f5bf1e9cef604f", uint32(i)})
+	}
+	emptyTrans, err := CreateEmptyRawTransaction(vins, []Vout{{to, 10000}}, 0, true, isTestNet)
+	if err != nil {
+		t.Errorf("create transaction failed: %v", err)
+		return
+	}
+
+	txBytes, _ := hex.DecodeString(emptyTrans)
+	if len(txBytes) <= 65536 {
+		t.Errorf("transaction size = %d, want more than 64KB", len(txBytes))
+	}
+	var msg wire.MsgTx
+	if err := msg.Deserialize(bytes.NewReader(txBytes)); err != nil {
+		t.Errorf("btcd decode transaction failed: %v", err)
+		return
+	}
+	checkRoundTrip(t, txBytes, msg.TxHash().String())
+}
+
+//合约脚本超过252字节，且有多个找零输出
+func Test_largeContractScript(t *testing.T) {
+	hash160, _ := hex.DecodeString("a1b2c3d4e5f6a7b8c9d0e1f2a3b4c5d6e7f8a9b0")
+	to := addressEncoder.AddressEncode(hash160, addressEncoder.QTUM_testnetAddressP2PKH)
+	vins := []Vin{{TxID: "e0c48b1a5d3d3c1d5f0f5c42b1c4e0a1d2c3b4a5968778695a4b3c2d1e0f1a2b", Vout: 1}}
+	vouts := []Vout{{Address: to, Amount: 100000}, {Address: to, Amount: 200000}}
+
+	callData := "12345678" + strings.Repeat("00", 300)
+	call := Vcontract{ContractAddr: "91a6081095ef860d28874c9db613e7a4107b0281", GasLimit: "250000", GasPrice: "40", CallData: callData}
+	emptyTrans, err := CreateQRC20TokenEmptyRawTransaction(vins, call, vouts, 0, false, isTestNet)
+	if err != nil {
+		t.Errorf("create contract call failed: %v", err)
+		return
+	}
+
+	txBytes, _ := hex.DecodeString(emptyTrans)
+	var msg wire.MsgTx
+	if err := msg.Deserialize(bytes.NewReader(txBytes)); err != nil {
+		t.Errorf("btcd decode contract call failed: %v", err)
+		return
+	}
+	tx := checkRoundTrip(t, txBytes, msg.TxHash().String())
+	if tx == nil {
+		return
+	}
+	//合约输出脚本超过252字节，长度编码为0xfd
+	if len(msg.TxOut[0].PkScript) <= 252 || !bytes.Equal(tx.Vouts[0].lockScript, msg.TxOut[0].PkScript) {
+		t.Errorf("decode contract script length = %d, btcd = %d", len(tx.Vouts[0].lockScript), len(msg.TxOut[0].PkScript))
+	}
+	if len(tx.Vouts) != 3 {
+		t.Errorf("decode contract call outputs = %d, want 3", len(tx.Vouts))
+		return
+	}
+	contract, err := DecodeContractScript(tx.Vouts[0].lockScript)
+	if err != nil {
+		t.Errorf("decode contract script failed: %v", err)
+		return
+	}
+	if hex.EncodeToString(contract.CallData) != callData {
+		t.Errorf("decode contract call data mismatch")
+	}
+	if littleEndianBytesToUint64(tx.Vouts[2].amount) != 200000 {
+		t.Errorf("decode contract call change amount mismatch")
+	}
+}
+
+//2-of-3多重签名输入与普通公钥哈希输入混合
+func Test_multiSigTransaction(t *testing.T) {
+	priA, _ := hex.DecodeString("c0fc3bdaaf3b9f29e1c561e1b8740362e867a8952231e9e76f4d23572b402795")
+	priB, _ := hex.DecodeString("4a11669ea664ea19b7029834e512a84654ef800a7161bcd131d2f47bfc07c52a")
+	priC, _ := hex.DecodeString("1f2e3d4c5b6a79881f2e3d4c5b6a79881f2e3d4c5b6a79881f2e3d4c5b6a7988")
+	pubkeys := make([][]byte, 0)
+	for _, pri := range [][]byte{priA, priB, priC} {
+		pub, _ := owcrypt.GenPubkey(pri, owcrypt.ECC_CURVE_SECP256K1)
+		pubkeys = append(pubkeys, owcrypt.PointCompress(pub, owcrypt.ECC_CURVE_SECP256K1))
+	}
+
+	address, redeem, err := CreateMultiSig(2, pubkeys, isTestNet)
+	if err != nil {
+		t.Errorf("create multisig failed: %v", err)
+		return
+	}
+	redeemBytes, _ := hex.DecodeString(redeem)
+	redeemHash := owcrypt.Hash(redeemBytes, 0, owcrypt.HASH_ALG_HASH160)
+	if address != EncodeCheck(testNetP2SHPrefix, redeemHash) {
+		t.Errorf("multisig address = %s is not the P2SH of the redeem script", address)
+	}
+
+	lockMultiSig := "a914" + hex.EncodeToString(redeemHash) + "87"
+	lockA := "76a914" + hex.EncodeToString(owcrypt.Hash(pubkeys[0], 0, owcrypt.HASH_ALG_HASH160)) + "88ac"
+	to := addressEncoder.AddressEncode(owcrypt.Hash(pubkeys[1], 0, owcrypt.HASH_ALG_HASH160), addressEncoder.QTUM_testnetAddressP2PKH)
+
+	vins := []Vin{
+		{"511bac90d2fe072e736d8b58161f34da631526508754febe263c40e3ce4e4b10", 0},
+		{"6cb0425bb4bb962db8359b8d3cbaa66ed8121091db6cfc9253f5bf1e9cef604f", 1},
+	}
+	emptyTrans, err := CreateEmptyRawTransaction(vins, []Vout{{to, 9800000}}, 0, false, isTestNet)
+	if err != nil {
+		t.Errorf("create transaction failed: %v", err)
+		return
+	}
+
+	unlocks := []TxUnlock{
+		{LockScript: lockMultiSig, RedeemScript: redeem},
+		{LockScript: lockA},
+	}
+	transHash, err := CreateRawTransactionHashForSig(emptyTrans, unlocks)
+	if err != nil {
+		t.Errorf("create transaction hash failed: %v", err)
+		return
+	}
+
+	//C和A分别签名多签输入，A签名自己的输入
+	sigC, err := SignRawTransactionHash(transHash[:1], []TxUnlock{{PrivateKey: priC}})
+	if err != nil {
+		t.Errorf("sign failed: %v", err)
+		return
+	}
+	sigA, err := SignRawTransactionHash(transHash, []TxUnlock{{PrivateKey: priA}, {PrivateKey: priA}})
+	if err != nil {
+		t.Errorf("sign failed: %v", err)
+		return
+	}
+	sigPub := []SignaturePubkey{sigC[0], sigA[0], sigA[1]}
+
+	signedTrans, err := InsertSignatureIntoEmptyTransaction(emptyTrans, sigPub, unlocks)
+	if err != nil {
+		t.Errorf("insert signature failed: %v", err)
+		return
+	}
+
+	txBytes, _ := hex.DecodeString(signedTrans)
+	tx, _ := DecodeRawTransaction(txBytes)
+	sigs, scriptRedeem, err := decodeMultiSigScript(tx.Vins[0].ScriptPubkeySignature)
+	if err != nil || len(sigs) != 2 || hex.EncodeToString(scriptRedeem) != redeem {
+		t.Errorf("decode multisig unlock script failed: %v", err)
+	}
+	//签名按赎回脚本中的公钥顺序排列：A在C之前
+	if hex.EncodeToString(sigs[0]) != hex.EncodeToString(sigA[0].Signature) {
+		t.Errorf("multisig signatures not in pubkey order")
+	}
+
+	if !VerifyRawTransaction(signedTrans, []TxUnlock{{LockScript: lockMultiSig}, {LockScript: lockA}}) {
+		t.Errorf("verify multisig transaction failed")
+	}
+
+	//签名数量不足
+	if _, err := InsertSignatureIntoEmptyTransaction(emptyTrans, []SignaturePubkey{sigC[0], sigA[1]}, unlocks); err == nil {
+		t.Errorf("insert with one multisig signature should fail")
+	}
+
+	//同一方重复签名
+	if _, err := InsertSignatureIntoEmptyTransaction(emptyTrans, []SignaturePubkey{sigA[0], sigA[0], sigA[1]}, unlocks); err == nil {
+		t.Errorf("insert with duplicated multisig signature should fail")
+	}
+}
+
+//多签输入和公钥哈希输入分别由不同签名方签名后合并PSBT
+func Test_PSBT(t *testing.T) {
+	priA, _ := hex.DecodeString("c0fc3bdaaf3b9f29e1c561e1b8740362e867a8952231e9e76f4d23572b402795")
+	priB, _ := hex.DecodeString("4a11669ea664ea19b7029834e512a84654ef800a7161bcd131d2f47bfc07c52a")
+	priC, _ := hex.DecodeString("1f2e3d4c5b6a79881f2e3d4c5b6a79881f2e3d4c5b6a79881f2e3d4c5b6a7988")
+	pubkeys := make([][]byte, 0)
+	for _, pri := range [][]byte{priA, priB, priC} {
+		pub, _ := owcrypt.GenPubkey(pri, owcrypt.ECC_CURVE_SECP256K1)
+		pubkeys = append(pubkeys, owcrypt.PointCompress(pub, owcrypt.ECC_CURVE_SECP256K1))
+	}
+
+	multiSigAddress, redeem, _ := CreateMultiSig(2, pubkeys, isTestNet)
+	redeemBytes, _ := hex.DecodeString(redeem)
+	lockMultiSig := "a914" + hex.EncodeToString(owcrypt.Hash(redeemBytes, 0, owcrypt.HASH_ALG_HASH160)) + "87"
+	hashA := owcrypt.Hash(pubkeys[0], 0, owcrypt.HASH_ALG_HASH160)
+	lockA := "76a914" + hex.EncodeToString(hashA) + "88ac"
+	addressA := addressEncoder.AddressEncode(hashA, addressEncoder.QTUM_testnetAddressP2PKH)
+
+	//前序交易：输出0到多签地址，输出1到A
+	prevHex, err := CreateEmptyRawTransaction(
+		[]Vin{{"511bac90d2fe072e736d8b58161f34da631526508754febe263c40e3ce4e4b10", 0}},
+		[]Vout{{multiSigAddress, 5000000}, {addressA, 5000000}}, 0, false, isTestNet)
+	if err != nil {
+		t.Errorf("create previous transaction failed: %v", err)
+		return
+	}
+	prevTx, _ := hex.DecodeString(prevHex)
+	prevID := reverseBytesToHex(owcrypt.Hash(prevTx, 0, owcrypt.HASh_ALG_DOUBLE_SHA256))
+
+	emptyTrans, err := CreateEmptyRawTransaction([]Vin{{prevID, 0}, {prevID, 1}}, []Vout{{addressA, 9800000}}, 0, false, isTestNet)
+	if err != nil {
+		t.Errorf("create transaction failed: %v", err)
+		return
+	}
+
+	unlocks := []TxUnlock{{LockScript: lockMultiSig, RedeemScript: redeem}, {LockScript: lockA}}
+	psbt, err := NewPSBT(emptyTrans, unlocks)
+	if err != nil {
+		t.Errorf("new psbt failed: %v", err)
+		return
+	}
+	for i := range psbt.Inputs {
+		if err := psbt.SetNonWitnessUtxo(i, prevTx); err != nil {
+			t.Errorf("set non-witness utxo failed: %v", err)
+			return
+		}
+	}
+	if err := psbt.SetNonWitnessUtxo(0, redeemBytes); err == nil {
+		t.Errorf("set unrelated previous transaction should fail")
+	}
+
+	exported, err := psbt.ToBase64()
+	if err != nil {
+		t.Errorf("serialize psbt failed: %v", err)
+		return
+	}
+
+	transHash, err := CreateRawTransactionHashForSig(emptyTrans, unlocks)
+	if err != nil {
+		t.Errorf("create transaction hash failed: %v", err)
+		return
+	}
+
+	//签名方各自解析PSBT，以PSBT中的信息计算待签名哈希
+	sign := func(pri []byte, inputs ...int) *PSBT {
+		signer, err := ParsePSBTBase64(exported)
+		if err != nil {
+			t.Fatalf("parse psbt failed: %v", err)
+		}
+		hashes, err := signer.SignatureHashes()
+		if err != nil {
+			t.Fatalf("psbt signature hashes failed: %v", err)
+		}
+		for i := range hashes {
+			if hashes[i] != transHash[i] {
+				t.Fatalf("psbt signature hash %d = %s, want %s", i, hashes[i], transHash[i])
+			}
+		}
+		for _, i := range inputs {
+			sigPub, err := SignRawTransactionHash(hashes[i:i+1], []TxUnlock{{PrivateKey: pri}})
+			if err != nil {
+				t.Fatalf("sign failed: %v", err)
+			}
+			if err := signer.AddPartialSig(i, sigPub[0]); err != nil {
+				t.Fatalf("add partial signature failed: %v", err)
+			}
+		}
+		return signer
+	}
+
+	signedC := sign(priC, 0)
+	signedA := sign(priA, 0, 1)
+
+	//序列化后解析结果一致
+	data, _ := signedA.Serialize()
+	parsed, err := ParsePSBT(data)
+	if err != nil {
+		t.Errorf("parse psbt failed: %v", err)
+		return
+	}
+	reData, _ := parsed.Serialize()
+	if !bytes.Equal(data, reData) {
+		t.Errorf("psbt round trip mismatch")
+	}
+
+	combined, _ := ParsePSBTBase64(exported)
+	if err := combined.Finalize(); err == nil {
+		t.Errorf("finalize without signatures should fail")
+	}
+	for _, signed := range []*PSBT{signedC, signedA} {
+		if err := combined.Merge(signed); err != nil {
+			t.Errorf("merge psbt failed: %v", err)
+			return
+		}
+	}
+	if len(combined.Inputs[0].PartialSigs) != 2 || len(combined.Inputs[1].PartialSigs) != 1 {
+		t.Errorf("merged partial signatures = %d, %d", len(combined.Inputs[0].PartialSigs), len(combined.Inputs[1].PartialSigs))
+	}
+
+	if err := combined.Finalize(); err != nil {
+		t.Errorf("finalize psbt failed: %v", err)
+		return
+	}
+	signedTrans, err := combined.Extract()
+	if err != nil {
+		t.Errorf("extract transaction failed: %v", err)
+		return
+	}
+
+	sigA, _ := signedA.PartialSignatures(0)
+	sigA1, _ := signedA.PartialSignatures(1)
+	sigC, _ := signedC.PartialSignatures(0)
+	expect, err := InsertSignatureIntoEmptyTransaction(emptyTrans, []SignaturePubkey{sigC[0], sigA[0], sigA1[0]}, unlocks)
+	if err != nil {
+		t.Errorf("insert signature failed: %v", err)
+		return
+	}
+	if signedTrans != expect {
+		t.Errorf("extracted transaction = %s, want %s", signedTrans, expect)
+	}
+	if !VerifyRawTransaction(signedTrans, []TxUnlock{{LockScript: lockMultiSig}, {LockScript: lockA}}) {
+		t.Errorf("verify extracted transaction failed")
+	}
+
+	//不同交易单的PSBT不能合并
+	other, _ := NewPSBT(emptyTrans[:len(emptyTrans)-8]+"01000000", unlocks)
+	if err := combined.Merge(other); err == nil {
+		t.Errorf("merge psbt of different transaction should fail")
+	}
+}
+
+//包含OP_CALL输出的合约交易导出PSBT
+func Test_PSBTContractCall(t *testing.T) {
+	pri, _ := hex.DecodeString("c0fc3bdaaf3b9f29e1c561e1b8740362e867a8952231e9e76f4d23572b402795")
+	pub, _ := owcrypt.GenPubkey(pri, owcrypt.ECC_CURVE_SECP256K1)
+	hash := owcrypt.Hash(owcrypt.PointCompress(pub, owcrypt.ECC_CURVE_SECP256K1), 0, owcrypt.HASH_ALG_HASH160)
+	lock := "76a914" + hex.EncodeToString(hash) + "88ac"
+	to := addressEncoder.AddressEncode(hash, addressEncoder.QTUM_testnetAddressP2PKH)
+
+	prevHex, _ := CreateEmptyRawTransaction([]Vin{{"6cb0425bb4bb962db8359b8d3cbaa66ed8121091db6cfc9253f5bf1e9cef604f", 1}}, []Vout{{to, 200000}}, 0, false, isTestNet)
+	prevTx, _ := hex.DecodeString(prevHex)
+	prevID := reverseBytesToHex(owcrypt.Hash(prevTx, 0, owcrypt.HASh_ALG_DOUBLE_SHA256))
+
+	transfer := Vcontract{ContractAddr: "91a6081095ef860d28874c9db613e7a4107b0281", To: to, SendAmount: big.NewInt(1000000), GasLimit: "250000", GasPrice: "40"}
+	emptyTrans, err := CreateQRC20TokenEmptyRawTransaction([]Vin{{prevID, 0}}, transfer, []Vout{{to, 100000}}, 0, false, isTestNet)
+	if err != nil {
+		t.Errorf("create transfer failed: %v", err)
+		return
+	}
+
+	psbt, err := NewPSBT(emptyTrans, []TxUnlock{{LockScript: lock}})
+	if err != nil {
+		t.Errorf("new psbt failed: %v", err)
+		return
+	}
+	if err := psbt.SetNonWitnessUtxo(0, prevTx); err != nil {
+		t.Errorf("set non-witness utxo failed: %v", err)
+		return
+	}
+	if len(psbt.Outputs) != 2 {
+		t.Errorf("psbt outputs = %d, want contract output and coin output", len(psbt.Outputs))
+	}
+
+	exported, err := psbt.ToBase64()
+	if err != nil {
+		t.Errorf("serialize psbt failed: %v", err)
+		return
+	}
+	parsed, err := ParsePSBTBase64(exported)
+	if err != nil {
+		t.Errorf("parse psbt failed: %v", err)
+		return
+	}
+	if parsed.UnsignedTxHex() != emptyTrans {
+		t.Errorf("unsigned transaction = %s, want %s", parsed.UnsignedTxHex(), emptyTrans)
+	}
+
+	tx, _ := parsed.unsignedTransaction()
+	contract, err := DecodeContractScript(tx.Vouts[0].lockScript)
+	if err != nil || contract.GasLimit != 250000 || contract.GasPrice != 40 {
+		t.Errorf("decode OP_CALL output from psbt failed: %v", err)
+	}
+
+	hashes, err := parsed.SignatureHashes()
+	if err != nil {
+		t.Errorf("psbt signature hashes failed: %v", err)
+		return
+	}
+	transHash, _ := CreateRawTransactionHashForSig(emptyTrans, []TxUnlock{{LockScript: lock}})
+	if hashes[0] != transHash[0] {
+		t.Errorf("psbt signature hash = %s, want %s", hashes[0], transHash[0])
+	}
+
+	sigPub, _ := SignRawTransactionHash(hashes, []TxUnlock{{PrivateKey: pri}})
+	parsed.AddPartialSig(0, sigPub[0])
+	if err := parsed.Finalize(); err != nil {
+		t.Errorf("finalize psbt failed: %v", err)
+		return
+	}
+	signedTrans, err := parsed.Extract()
+	if err != nil {
+		t.Errorf("extract transaction failed: %v", err)
+		return
+	}
+	if !VerifyRawTransaction(signedTrans, []TxUnlock{{LockScript: lock}}) {
+		t.Errorf("verify extracted contract transaction failed")
+	}
+}
+
+//替换交易保留原交易的输入输出和序列号，从找零中扣除追加的手续费
+func Test_replacementTransaction(t *testing.T) {
+	pri, _ := hex.DecodeString("c0fc3bdaaf3b9f29e1c561e1b8740362e867a8952231e9e76f4d23572b402795")
+	pub, _ := owcrypt.GenPubkey(pri, owcrypt.ECC_CURVE_SECP256K1)
+	hash := owcrypt.Hash(owcrypt.PointCompress(pub, owcrypt.ECC_CURVE_SECP256K1), 0, owcrypt.HASH_ALG_HASH160)
+	lock := "76a914" + hex.EncodeToString(hash) + "88ac"
+	address := addressEncoder.AddressEncode(hash, addressEncoder.QTUM_testnetAddressP2PKH)
+
+	vins := []Vin{{"6cb0425bb4bb962db8359b8d3cbaa66ed8121091db6cfc9253f5bf1e9cef604f", 1}}
+	vouts := []Vout{{address, 100000}, {address, 50000}}
+	unlocks := []TxUnlock{{LockScript: lock, PrivateKey: pri}}
+
+	finalTrans, _ := CreateEmptyRawTransaction(vins, vouts, 0, false, isTestNet)
+	if replaceable, _ := IsReplaceableTransaction(finalTrans); replaceable {
+		t.Errorf("final transaction should not be replaceable")
+	}
+
+	emptyTrans, _ := CreateEmptyRawTransaction(vins, vouts, 0, true, isTestNet)
+	signedTrans, err := SignEmptyRawTransaction(emptyTrans, unlocks)
+	if err != nil {
+		t.Errorf("sign transaction failed: %v", err)
+		return
+	}
+	if replaceable, _ := IsReplaceableTransaction(signedTrans); !replaceable {
+		t.Errorf("transaction should be replaceable")
+	}
+
+	replaceTrans, err := CreateReplacementRawTransaction(signedTrans, 1, 20000)
+	if err != nil {
+		t.Errorf("create replacement failed: %v", err)
+		return
+	}
+	expect, _ := CreateEmptyRawTransaction(vins, []Vout{{address, 100000}, {address, 30000}}, 0, true, isTestNet)
+	if replaceTrans != expect {
+		t.Errorf("replacement = %s, want %s", replaceTrans, expect)
+	}
+
+	if _, err := CreateReplacementRawTransaction(signedTrans, 1, 50000); err == nil {
+		t.Errorf("extra fee equal to change should fail")
+	}
+	if _, err := CreateReplacementRawTransaction(signedTrans, 2, 100); err == nil {
+		t.Errorf("change index out of range should fail")
+	}
+}
+
+//预估的虚拟大小不小于签名后的实际大小，DER签名每个最多短2字节
+func Test_EstimateRawTransactionVSize(t *testing.T) {
+	priA, _ := hex.DecodeString("c0fc3bdaaf3b9f29e1c561e1b8740362e867a8952231e9e76f4d23572b402795")
+	priB, _ := hex.DecodeString("4a11669ea664ea19b7029834e512a84654ef800a7161bcd131d2f47bfc07c52a")
+	pubkeys := make([][]byte, 0)
+	for _, pri := range [][]byte{priA, priB} {
+		pub, _ := owcrypt.GenPubkey(pri, owcrypt.ECC_CURVE_SECP256K1)
+		pubkeys = append(pubkeys, owcrypt.PointCompress(pub, owcrypt.ECC_CURVE_SECP256K1))
+	}
+	hashA := owcrypt.Hash(pubkeys[0], 0, owcrypt.HASH_ALG_HASH160)
+	lockA := "76a914" + hex.EncodeToString(hashA) + "88ac"
+	to := addressEncoder.AddressEncode(hashA, addressEncoder.QTUM_testnetAddressP2PKH)
+
+	_, redeem, _ := CreateMultiSig(2, pubkeys, isTestNet)
+	redeemBytes, _ := hex.DecodeString(redeem)
+	lockMultiSig := "a914" + hex.EncodeToString(owcrypt.Hash(redeemBytes, 0, owcrypt.HASH_ALG_HASH160)) + "87"
+
+	vins := []Vin{
+		{"511bac90d2fe072e736d8b58161f34da631526508754febe263c40e3ce4e4b10", 0},
+		{"6cb0425bb4bb962db8359b8d3cbaa66ed8121091db6cfc9253f5bf1e9cef604f", 1},
+	}
+	transfer := Vcontract{ContractAddr: "91a6081095ef860d28874c9db613e7a4107b0281", To: to, SendAmount: big.NewInt(1000000), GasLimit: "250000", GasPrice: "40"}
+	emptyTrans, err := CreateQRC20TokenEmptyRawTransaction(vins, transfer, []Vout{{to, 100000}}, 0, false, isTestNet)
+	if err != nil {
+		t.Errorf("create transfer failed: %v", err)
+		return
+	}
+
+	unlocks := []TxUnlock{{LockScript: lockA}, {LockScript: lockMultiSig, RedeemScript: redeem}}
+	vsize, err := EstimateRawTransactionVSize(emptyTrans, unlocks)
+	if err != nil {
+		t.Errorf("estimate vsize failed: %v", err)
+		return
+	}
+
+	transHash, _ := CreateRawTransactionHashForSig(emptyTrans, unlocks)
+	sigA, _ := SignRawTransactionHash(transHash, []TxUnlock{{PrivateKey: priA}, {PrivateKey: priA}})
+	sigB, _ := SignRawTransactionHash(transHash[1:], []TxUnlock{{PrivateKey: priB}})
+	signedTrans, err := InsertSignatureIntoEmptyTransaction(emptyTrans, []SignaturePubkey{sigA[0], sigA[1], sigB[0]}, unlocks)
+	if err != nil {
+		t.Errorf("insert signature failed: %v", err)
+		return
+	}
+	actual := uint64(len(signedTrans) / 2)
+	if vsize < actual || vsize > actual+3*2 {
+		t.Errorf("estimated vsize = %d, signed size = %d", vsize, actual)
+	}
+
+	//原生隔离见证输入：基础85字节，见证2+109字节
+	p2wpkh, _ := CreateEmptyRawTransaction(vins[:1], []Vout{{to, 100000}}, 0, false, isTestNet)
+	vsize, err = EstimateRawTransactionVSize(p2wpkh, []TxUnlock{{LockScript: "0014" + hex.EncodeToString(hashA)}})
+	if err != nil || vsize != 113 {
+		t.Errorf("estimated p2wpkh vsize = %d, want 113: %v", vsize, err)
+	}
+
+	if _, err := EstimateRawTransactionVSize(p2wpkh, unlocks); err == nil {
+		t.Errorf("mismatched unlock data should fail")
+	}
+
+	//已签名交易单的虚拟大小，非隔离见证交易等于序列化大小
+	if vsize, err := RawTransactionVSize(signedTrans); err != nil || vsize != actual {
+		t.Errorf("signed vsize = %d, want %d: %v", vsize, actual, err)
+	}
+
+	//隔离见证交易的见证数据按1/4计算，与btcd的结果一致
+	p2wpkhUnlocks := []TxUnlock{{LockScript: "0014" + hex.EncodeToString(hashA), PrivateKey: priA, Amount: 200000}}
+	transHash, _ = CreateRawTransactionHashForSig(p2wpkh, p2wpkhUnlocks)
+	sigs, _ := SignRawTransactionHash(transHash, p2wpkhUnlocks)
+	signedP2wpkh, err := InsertSignatureIntoEmptyTransaction(p2wpkh, sigs, p2wpkhUnlocks)
+	if err != nil {
+		t.Errorf("insert p2wpkh signature failed: %v", err)
+		return
+	}
+	signedBytes, _ := hex.DecodeString(signedP2wpkh)
+	var msg wire.MsgTx
+	if err := msg.Deserialize(bytes.NewReader(signedBytes)); err != nil || !msg.HasWitness() {
+		t.Errorf("btcd decode p2wpkh transaction failed: %v", err)
+		return
+	}
+	want := uint64((msg.SerializeSizeStripped()*3 + msg.SerializeSize() + 3) / 4)
+	if vsize, err := RawTransactionVSize(signedP2wpkh); err != nil || vsize != want {
+		t.Errorf("signed p2wpkh vsize = %d, want %d: %v", vsize, want, err)
+	}
+}
+
+//低于粉尘阈值的输出，合约调用输出除外
+func Test_DustOutputs(t *testing.T) {
+	pri, _ := hex.DecodeString("c0fc3bdaaf3b9f29e1c561e1b8740362e867a8952231e9e76f4d23572b402795")
+	pub, _ := owcrypt.GenPubkey(pri, owcrypt.ECC_CURVE_SECP256K1)
+	hash := owcrypt.Hash(owcrypt.PointCompress(pub, owcrypt.ECC_CURVE_SECP256K1), 0, owcrypt.HASH_ALG_HASH160)
+	to := addressEncoder.AddressEncode(hash, addressEncoder.QTUM_testnetAddressP2PKH)
+	p2sh := EncodeCheck(testNetP2SHPrefix, hash)
+
+	//P2PKH：34字节输出 + 148字节输入
+	threshold, err := OutputDustThreshold(to, isTestNet, DefaultDustRelayFee)
+	if err != nil || threshold != 72800 {
+		t.Errorf("p2pkh dust threshold = %d, want 72800: %v", threshold, err)
+	}
+	//P2SH：32字节输出 + 148字节输入
+	threshold, err = OutputDustThreshold(p2sh, isTestNet, DefaultDustRelayFee)
+	if err != nil || threshold != 72000 {
+		t.Errorf("p2sh dust threshold = %d, want 72000: %v", threshold, err)
+	}
+	if script, _ := hex.DecodeString("0014" + hex.EncodeToString(hash)); dustThreshold(script, DefaultDustRelayFee) != 39200 {
+		t.Errorf("p2wpkh dust threshold = %d, want 39200", dustThreshold(script, DefaultDustRelayFee))
+	}
+
+	vins := []Vin{{"6cb0425bb4bb962db8359b8d3cbaa66ed8121091db6cfc9253f5bf1e9cef604f", 1}}
+	emptyTrans, _ := CreateEmptyRawTransaction(vins, []Vout{{to, 100000}, {to, 72799}, {p2sh, 72000}}, 0, false, isTestNet)
+	dust, err := DustOutputs(emptyTrans, DefaultDustRelayFee)
+	if err != nil || len(dust) != 1 || dust[0] != 1 {
+		t.Errorf("dust outputs = %v, want [1]: %v", dust, err)
+	}
+
+	//只有合约调用输出的交易单
+	transfer := Vcontract{ContractAddr: "91a6081095ef860d28874c9db613e7a4107b0281", To: to, SendAmount: big.NewInt(1000000), GasLimit: "250000", GasPrice: "40"}
+	contractTrans, err := CreateQRC20TokenEmptyRawTransaction(vins, transfer, nil, 0, false, isTestNet)
+	if err != nil {
+		t.Errorf("create contract transaction without change failed: %v", err)
+		return
+	}
+	if dust, err = DustOutputs(contractTrans, DefaultDustRelayFee); err != nil || len(dust) != 0 {
+		t.Errorf("contract output should not be dust: %v %v", dust, err)
+	}
+}
@@ -2,6 +2,8 @@ package btcLikeTxDriver
 
 import (
 	"encoding/hex"
+	"errors"
+	"github.com/Assetsadapter/qtum-adapter/qtum/abi"
 	"github.com/blocktree/go-owcdrivers/addressEncoder"
 	"strconv"
 	"github.com/blocktree/openwallet/log"
@@ -13,6 +15,7 @@ type TxContract struct {
 	gasLimit     []byte
 	lenGasPrice  []byte
 	gasPrice     []byte
+	lenData      []byte
 	dataHex      []byte
 	lenContract  []byte
 	contractAddr []byte
@@ -81,18 +84,44 @@ func newTxContractForEmptyTrans(vcontract Vcontract, isTestNet bool) (*TxContrac
 		return nil, err
 	}
 
-	//AmountTo32ByteArg
-	amountDecimal := vcontract.SendAmount
-	sotashiAmount := amountDecimal.IntPart()
-	hexAmount := strconv.FormatInt(sotashiAmount, 16)
-	defaultLen := 64
-	addLen := defaultLen - len(hexAmount)
-	var bytesArg string
-	for i := 0; i<addLen; i++ {
-		bytesArg = bytesArg + "0"
+	//dataHex
+	var dataHex []byte
+	if len(vcontract.CallData) > 0 {
+		//调用方已完成ABI编码的任意合约方法
+		dataHex, err = hex.DecodeString(vcontract.CallData)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		dataHex, err = transferCallData(vcontract, isTestNet)
+		if err != nil {
+			return nil, err
+		}
+	}
+	lenData := pushDataPrefix(len(dataHex))
+
+	if int64(len(vcontract.ContractAddr))%2 == 1 {
+		log.Errorf("Contract address length error.")
+	}
+	lanAddressHex := strconv.FormatInt(int64(len(vcontract.ContractAddr))/2,16)
+	lanAddress, err := hex.DecodeString(lanAddressHex)
+	if err != nil {
+		return nil, err
+	}
+
+	contractAddr, err := hex.DecodeString(vcontract.ContractAddr)
+	if err != nil {
+		return nil, err
 	}
-	bytesArg = bytesArg + hexAmount
 
+	opCall := []byte{OpCall}
+
+	ret = TxContract{vmVersion,lenGasLimit,gasLimit,lenGasPrice,gasPrice,lenData,dataHex,lanAddress,contractAddr,opCall}
+	return &ret, nil
+}
+
+//transferCallData 编码transfer(address,uint256)调用数据
+func transferCallData(vcontract Vcontract, isTestNet bool) ([]byte, error) {
 
 	//addrTo32bytesArg
 	var addressToHash160 []byte
@@ -102,34 +131,76 @@ func newTxContractForEmptyTrans(vcontract Vcontract, isTestNet bool) (*TxContrac
 		addressToHash160, _ = addressEncoder.AddressDecode(vcontract.To, addressEncoder.QTUM_mainnetAddressP2PKH)
 	}
 
-	//fmt.Printf("addressToHash160: %s\n",hex.EncodeToString(addressToHash160))
-	addrTo32bytesArg := append([]byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}, addressToHash160[:]...)
-	//fmt.Printf("to32bytesArg: %s\n",hex.EncodeToString(to32bytesArg))
+	return abi.QRC20.Pack("transfer", addressToHash160, vcontract.SendAmount)
+}
 
-	//dataHex
-	combineString := hex.EncodeToString(append([]byte{0xa9, 0x05, 0x9c, 0xbb}, addrTo32bytesArg[:]...))
-	dataHexString := combineString + bytesArg
-	dataHex, err := hex.DecodeString(dataHexString)
-	if err != nil {
-		return nil, err
+//pushDataPrefix 数据入栈操作码
+func pushDataPrefix(length int) []byte {
+	if length < int(OpPushData1) {
+		return []byte{byte(length)}
+	} else if length <= 0xff {
+		return []byte{OpPushData1, byte(length)}
 	}
+	return []byte{OpPushData2, byte(length), byte(length >> 8)}
+}
 
-	if int64(len(vcontract.ContractAddr))%2 == 1 {
-		log.Errorf("Contract address length error.")
+//lockScript 合约输出的锁定脚本
+func (c TxContract) lockScript() []byte {
+	script := []byte{}
+	script = append(script, c.vmVersion...)
+	script = append(script, c.lenGasLimit...)
+	script = append(script, c.gasLimit...)
+	script = append(script, c.lenGasPrice...)
+	script = append(script, c.gasPrice...)
+	script = append(script, c.lenData...)
+	script = append(script, c.dataHex...)
+	script = append(script, c.lenContract...)
+	script = append(script, c.contractAddr...)
+	script = append(script, c.opCall...)
+	return script
+}
+//ContractScript 合约输出锁定脚本解析结果
+type ContractScript struct {
+	VMVersion    uint64
+	GasLimit     uint64
+	GasPrice     uint64 //单位为聪
+	CallData     []byte
+	ContractAddr []byte
+}
+
+//DecodeContractScript 解析OP_CALL锁定脚本：vmVersion gasLimit gasPrice data contract OP_CALL
+func DecodeContractScript(script []byte) (*ContractScript, error) {
+
+	if len(script) == 0 || script[len(script)-1] != OpCall {
+		return nil, errors.New("Not a contract call script!")
 	}
-	lanAddressHex := strconv.FormatInt(int64(len(vcontract.ContractAddr))/2,16)
-	lanAddress, err := hex.DecodeString(lanAddressHex)
+
+	pushes, err := scriptPushes(script[:len(script)-1])
 	if err != nil {
 		return nil, err
 	}
 
-	contractAddr, err := hex.DecodeString(vcontract.ContractAddr)
-	if err != nil {
-		return nil, err
+	if len(pushes) != 5 {
+		return nil, errors.New("Invalid contract script element count!")
 	}
 
-	opCall := []byte{0xC2}
+	return &ContractScript{
+		VMVersion:    scriptNumToUint64(pushes[0]),
+		GasLimit:     scriptNumToUint64(pushes[1]),
+		GasPrice:     scriptNumToUint64(pushes[2]),
+		CallData:     pushes[3],
+		ContractAddr: pushes[4],
+	}, nil
+}
 
-	ret = TxContract{vmVersion,lenGasLimit,gasLimit,lenGasPrice,gasPrice,dataHex,lanAddress,contractAddr,opCall}
-	return &ret, nil
-}
\ No newline at end of file
+//scriptNumToUint64 小端序的脚本数值
+func scriptNumToUint64(b []byte) uint64 {
+	if len(b) > 8 {
+		b = b[:8]
+	}
+	ret := uint64(0)
+	for i := len(b) - 1; i >= 0; i-- {
+		ret = ret<<8 | uint64(b[i])
+	}
+	return ret
+}
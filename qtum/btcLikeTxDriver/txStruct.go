@@ -64,90 +64,45 @@ func (t Transaction) encodeToBytes() ([]byte, error) {
 
 	ret := []byte{}
 	ret = append(ret, t.Version...)
-	if t.isMultiSig() {
-		if t.Witness == nil {
-			return nil, errors.New("No witness data for a multisig transaction!")
-		}
+	if t.Witness != nil {
 		ret = append(ret, SegWitSymbol, SegWitVersion)
-		ret = append(ret, byte(len(t.Vins)))
-		for _, in := range t.Vins {
-			if in.TxID == nil || len(in.TxID) != 32 || in.Vout == nil || len(in.Vout) != 4 {
-				return nil, errors.New("Invalid transaction input!")
-			}
-			ret = append(ret, in.TxID...)
-			ret = append(ret, in.Vout...)
-			redeemHash := calcRedeemHash(in.ScriptPubkeySignature)
-			ret = append(ret, byte(len(redeemHash)))
-			ret = append(ret, redeemHash...)
-			ret = append(ret, in.Sequence...)
-		}
-		ret = append(ret, byte(len(t.Vouts)))
-
-		for _, out := range t.Vouts {
-			if out.amount == nil || len(out.amount) != 8 || out.lockScript == nil {
-				return nil, errors.New("Invalid transaction output!")
-			}
-			ret = append(ret, out.amount...)
-			ret = append(ret, byte(len(out.lockScript)))
-			ret = append(ret, out.lockScript...)
-		}
-
-		ret = append(ret, byte(0x04), 0x00)
-		for _, w := range t.Witness {
-			if w.Signature == nil {
-				return nil, errors.New("Miss signature data for a multisig transaction!")
-			} else {
-				sig := w.encodeToScript(SigHashAll)
-				sig = sig[:len(sig)-34]
-				ret = append(ret, sig...)
-			}
-		}
-
-		ret = append(ret, byte(len(t.Vins[0].ScriptPubkeySignature)))
+	}
 
-		ret = append(ret, t.Vins[0].ScriptPubkeySignature...)
-	} else {
+	ret = append(ret, varIntToBytes(uint64(len(t.Vins)))...)
 
-		if t.Witness != nil {
-			ret = append(ret, SegWitSymbol, SegWitVersion)
+	for _, in := range t.Vins {
+		if in.TxID == nil || len(in.TxID) != 32 || in.Vout == nil || len(in.Vout) != 4 {
+			return nil, errors.New("Invalid transaction input!")
 		}
-
-		ret = append(ret, byte(len(t.Vins)))
-
-		for _, in := range t.Vins {
-			if in.TxID == nil || len(in.TxID) != 32 || in.Vout == nil || len(in.Vout) != 4 {
-				return nil, errors.New("Invalid transaction input!")
-			}
-			ret = append(ret, in.TxID...)
-			ret = append(ret, in.Vout...)
-			if in.ScriptPubkeySignature == nil {
-				ret = append(ret, 0x00)
-			} else {
-				ret = append(ret, byte(len(in.ScriptPubkeySignature)))
-				ret = append(ret, in.ScriptPubkeySignature...)
-			}
-			ret = append(ret, in.Sequence...)
+		ret = append(ret, in.TxID...)
+		ret = append(ret, in.Vout...)
+		if in.ScriptPubkeySignature == nil {
+			ret = append(ret, 0x00)
+		} else {
+			ret = append(ret, varIntToBytes(uint64(len(in.ScriptPubkeySignature)))...)
+			ret = append(ret, in.ScriptPubkeySignature...)
 		}
+		ret = append(ret, in.Sequence...)
+	}
 
-		ret = append(ret, byte(len(t.Vouts)))
+	ret = append(ret, varIntToBytes(uint64(len(t.Vouts)))...)
 
-		for _, out := range t.Vouts {
-			if out.amount == nil || len(out.amount) != 8 || out.lockScript == nil {
-				return nil, errors.New("Invalid transaction output!")
-			}
-			ret = append(ret, out.amount...)
-			ret = append(ret, byte(len(out.lockScript)))
-			ret = append(ret, out.lockScript...)
+	for _, out := range t.Vouts {
+		if out.amount == nil || len(out.amount) != 8 || out.lockScript == nil {
+			return nil, errors.New("Invalid transaction output!")
 		}
+		ret = append(ret, out.amount...)
+		ret = append(ret, varIntToBytes(uint64(len(out.lockScript)))...)
+		ret = append(ret, out.lockScript...)
+	}
 
-		if t.Witness != nil {
-			for _, w := range t.Witness {
-				if w.Signature == nil {
-					ret = append(ret, byte(0x00))
-				} else {
-					ret = append(ret, byte(0x02))
-					ret = append(ret, w.encodeToScript(SigHashAll)...)
-				}
+	if t.Witness != nil {
+		for _, w := range t.Witness {
+			if w.Signature == nil {
+				ret = append(ret, byte(0x00))
+			} else {
+				ret = append(ret, byte(0x02))
+				ret = append(ret, w.encodeToScript(SigHashAll)...)
 			}
 		}
 	}
@@ -187,119 +142,54 @@ func (t Contract) encodeToBytes() ([]byte, error) {
 		return nil, errors.New("No input found in the transaction struct!")
 	}
 
-	if len(t.Vouts) == 0 {
-		return nil, errors.New("No output found in the transaction struct!")
-	}
+	//合约调用输出本身满足交易单至少一个输出的要求，找零输出可以为空
 
 	ret := []byte{}
 	ret = append(ret, t.Version...)
-	if t.isMultiSig() {
-		if t.Witness == nil {
-			return nil, errors.New("No witness data for a multisig transaction!")
-		}
+	if t.Witness != nil {
 		ret = append(ret, SegWitSymbol, SegWitVersion)
-		ret = append(ret, byte(len(t.Vins)))
-		for _, in := range t.Vins {
-			if in.TxID == nil || len(in.TxID) != 32 || in.Vout == nil || len(in.Vout) != 4 {
-				return nil, errors.New("Invalid transaction input!")
-			}
-			ret = append(ret, in.TxID...)
-			ret = append(ret, in.Vout...)
-			redeemHash := calcRedeemHash(in.ScriptPubkeySignature)
-			ret = append(ret, byte(len(redeemHash)))
-			ret = append(ret, redeemHash...)
-			ret = append(ret, in.Sequence...)
-		}
+	}
 
-		//contract
-		ret = append(ret, byte(0x02), 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x63)
-		ret = append(ret, t.Vcontract.vmVersion...)
-		ret = append(ret, t.Vcontract.lenGasLimit...)
-		ret = append(ret, t.Vcontract.gasLimit...)
-		ret = append(ret, t.Vcontract.lenGasPrice...)
-		ret = append(ret, t.Vcontract.gasPrice...)
-		ret = append(ret,0x44)
-		ret = append(ret, t.Vcontract.dataHex...)
-		ret = append(ret,t.Vcontract.lenContract...)
-		ret = append(ret, t.Vcontract.contractAddr...)
-		ret = append(ret, t.Vcontract.opCall...)
-
-		for _, out := range t.Vouts {
-			if out.amount == nil || len(out.amount) != 8 || out.lockScript == nil {
-				return nil, errors.New("Invalid transaction output!")
-			}
-			ret = append(ret, out.amount...)
-			ret = append(ret, byte(len(out.lockScript)))
-			ret = append(ret, out.lockScript...)
-		}
+	ret = append(ret, varIntToBytes(uint64(len(t.Vins)))...)
 
-		ret = append(ret, byte(0x04), 0x00)
-		for _, w := range t.Witness {
-			if w.Signature == nil {
-				return nil, errors.New("Miss signature data for a multisig transaction!")
-			} else {
-				sig := w.encodeToScript(SigHashAll)
-				sig = sig[:len(sig)-34]
-				ret = append(ret, sig...)
-			}
+	for _, in := range t.Vins {
+		if in.TxID == nil || len(in.TxID) != 32 || in.Vout == nil || len(in.Vout) != 4 {
+			return nil, errors.New("Invalid transaction input!")
 		}
-
-		ret = append(ret, byte(len(t.Vins[0].ScriptPubkeySignature)))
-
-		ret = append(ret, t.Vins[0].ScriptPubkeySignature...)
-	} else {
-
-		if t.Witness != nil {
-			ret = append(ret, SegWitSymbol, SegWitVersion)
+		ret = append(ret, in.TxID...)
+		ret = append(ret, in.Vout...)
+		if in.ScriptPubkeySignature == nil {
+			ret = append(ret, 0x00)
+		} else {
+			ret = append(ret, varIntToBytes(uint64(len(in.ScriptPubkeySignature)))...)
+			ret = append(ret, in.ScriptPubkeySignature...)
 		}
+		ret = append(ret, in.Sequence...)
+	}
 
-		ret = append(ret, byte(len(t.Vins)))
+	//contract
+	contractScript := t.Vcontract.lockScript()
+	ret = append(ret, varIntToBytes(uint64(len(t.Vouts)+1))...)
+	ret = append(ret, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00)
+	ret = append(ret, varIntToBytes(uint64(len(contractScript)))...)
+	ret = append(ret, contractScript...)
 
-		for _, in := range t.Vins {
-			if in.TxID == nil || len(in.TxID) != 32 || in.Vout == nil || len(in.Vout) != 4 {
-				return nil, errors.New("Invalid transaction input!")
-			}
-			ret = append(ret, in.TxID...)
-			ret = append(ret, in.Vout...)
-			if in.ScriptPubkeySignature == nil {
-				ret = append(ret, 0x00)
-			} else {
-				ret = append(ret, byte(len(in.ScriptPubkeySignature)))
-				ret = append(ret, in.ScriptPubkeySignature...)
-			}
-			ret = append(ret, in.Sequence...)
-		}
-
-		//contract
-		ret = append(ret, byte(0x02), 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x63)
-		ret = append(ret, t.Vcontract.vmVersion...)
-		ret = append(ret, t.Vcontract.lenGasLimit...)
-		ret = append(ret, t.Vcontract.gasLimit...)
-		ret = append(ret, t.Vcontract.lenGasPrice...)
-		ret = append(ret, t.Vcontract.gasPrice...)
-		ret = append(ret,0x44)
-		ret = append(ret, t.Vcontract.dataHex...)
-		ret = append(ret,t.Vcontract.lenContract...)
-		ret = append(ret, t.Vcontract.contractAddr...)
-		ret = append(ret, t.Vcontract.opCall...)
-
-		for _, out := range t.Vouts {
-			if out.amount == nil || len(out.amount) != 8 || out.lockScript == nil {
-				return nil, errors.New("Invalid transaction output!")
-			}
-			ret = append(ret, out.amount...)
-			ret = append(ret, byte(len(out.lockScript)))
-			ret = append(ret, out.lockScript...)
+	for _, out := range t.Vouts {
+		if out.amount == nil || len(out.amount) != 8 || out.lockScript == nil {
+			return nil, errors.New("Invalid transaction output!")
 		}
+		ret = append(ret, out.amount...)
+		ret = append(ret, varIntToBytes(uint64(len(out.lockScript)))...)
+		ret = append(ret, out.lockScript...)
+	}
 
-		if t.Witness != nil {
-			for _, w := range t.Witness {
-				if w.Signature == nil {
-					ret = append(ret, byte(0x00))
-				} else {
-					ret = append(ret, byte(0x02))
-					ret = append(ret, w.encodeToScript(SigHashAll)...)
-				}
+	if t.Witness != nil {
+		for _, w := range t.Witness {
+			if w.Signature == nil {
+				ret = append(ret, byte(0x00))
+			} else {
+				ret = append(ret, byte(0x02))
+				ret = append(ret, w.encodeToScript(SigHashAll)...)
 			}
 		}
 	}
@@ -334,13 +224,13 @@ func DecodeRawTransaction(txBytes []byte) (*Transaction, error) {
 		index += 2
 	}
 
-	if index+1 > limit {
-		return nil, errors.New("Invalid transaction data length!")
+	numOfVins, size, err := bytesToVarInt(txBytes[index:])
+	if err != nil {
+		return nil, err
 	}
-	numOfVins := txBytes[index]
-	index++
+	index += size
 
-	for i := byte(0); i < numOfVins; i++ {
+	for i := uint64(0); i < numOfVins; i++ {
 		var tmpTxIn TxIn
 
 		if index+32 > limit {
@@ -355,15 +245,15 @@ func DecodeRawTransaction(txBytes []byte) (*Transaction, error) {
 		tmpTxIn.Vout = txBytes[index : index+4]
 		index += 4
 
-		if index+1 > limit {
-			return nil, errors.New("Invalid transaction data length!")
+		scriptLen, size, err := bytesToVarInt(txBytes[index:])
+		if err != nil {
+			return nil, err
 		}
-		scriptLen := txBytes[index]
-		index++
+		index += size
 		if scriptLen == 0 {
 			tmpTxIn.ScriptPubkeySignature = nil
 		} else {
-			if index+int(scriptLen) > limit {
+			if scriptLen > uint64(limit-index) {
 				return nil, errors.New("Invalid transaction data length!")
 			}
 			tmpTxIn.ScriptPubkeySignature = txBytes[index : index+int(scriptLen)]
@@ -379,13 +269,13 @@ func DecodeRawTransaction(txBytes []byte) (*Transaction, error) {
 		rawTx.Vins = append(rawTx.Vins, tmpTxIn)
 	}
 
-	if index+1 > limit {
-		return nil, errors.New("Invalid transaction data length!")
+	numOfVouts, size, err := bytesToVarInt(txBytes[index:])
+	if err != nil {
+		return nil, err
 	}
-	numOfVouts := txBytes[index]
-	index++
+	index += size
 
-	for i := byte(0); i < numOfVouts; i++ {
+	for i := uint64(0); i < numOfVouts; i++ {
 		var tmpTxOut TxOut
 
 		if index+8 > limit {
@@ -394,13 +284,13 @@ func DecodeRawTransaction(txBytes []byte) (*Transaction, error) {
 		tmpTxOut.amount = txBytes[index : index+8]
 		index += 8
 
-		if index+1 > limit {
-			return nil, errors.New("Invalid transaction data length!")
+		lockScriptLen, size, err := bytesToVarInt(txBytes[index:])
+		if err != nil {
+			return nil, err
 		}
-		lockScriptLen := txBytes[index]
-		index++
+		index += size
 
-		if index+int(lockScriptLen) > limit {
+		if lockScriptLen > uint64(limit-index) {
 			return nil, errors.New("Invalid transaction data length!")
 		}
 		tmpTxOut.lockScript = txBytes[index : index+int(lockScriptLen)]
@@ -410,7 +300,7 @@ func DecodeRawTransaction(txBytes []byte) (*Transaction, error) {
 	}
 
 	if segwit {
-		for i := byte(0); i < numOfVins; i++ {
+		for i := uint64(0); i < numOfVins; i++ {
 			if index+1 > limit {
 				return nil, errors.New("Invalid transaction data length!")
 			}
@@ -484,13 +374,10 @@ func calcSegwitHash(tx Transaction) ([]byte, []byte, []byte, error) {
 
 	for _, vout := range tx.Vouts {
 		hashOutputs = append(hashOutputs, vout.amount...)
-		hashOutputs = append(hashOutputs, byte(len(vout.lockScript)))
+		hashOutputs = append(hashOutputs, varIntToBytes(uint64(len(vout.lockScript)))...)
 		hashOutputs = append(hashOutputs, vout.lockScript...)
 	}
-	return owcrypt.Hash(hashPrevouts, 0, owcrypt.HASh_ALG_DOUBLE_SHA256),
-		owcrypt.Hash(hashSequence, 0, owcrypt.HASh_ALG_DOUBLE_SHA256),
-		owcrypt.Hash(hashOutputs, 0, owcrypt.HASh_ALG_DOUBLE_SHA256),
-		nil
+	return doubleSha256(hashPrevouts), doubleSha256(hashSequence), doubleSha256(hashOutputs), nil
 }
 
 func genScriptCodeFromRedeemScript(redeem string) ([]byte, error) {
@@ -537,7 +424,7 @@ func (tx Transaction) calcSegwitBytesForSig(unlockData TxUnlock, txid, vout, seq
 		return nil, err
 	}
 
-	sigBytes = append(sigBytes, byte(len(scriptCode)))
+	sigBytes = append(sigBytes, varIntToBytes(uint64(len(scriptCode)))...)
 	sigBytes = append(sigBytes, scriptCode...)
 
 	sigBytes = append(sigBytes, uint64ToLittleEndianBytes(unlockData.Amount)...)
@@ -570,7 +457,16 @@ func (t Transaction) getHashesForSig(unlockData []TxUnlock) ([][]byte, error) {
 		}
 
 		scriptType := checkScriptType(lockBytes)
-		if scriptType == TypeP2SH || scriptType == TypeBech32 {
+		if scriptType == TypeP2SH && isMultiSigRedeemScript(unlockData[i].RedeemScript) {
+			//P2SH多重签名，以赎回脚本代替锁定脚本计算签名哈希
+			redeemBytes, _ := hex.DecodeString(unlockData[i].RedeemScript)
+			t.Vins[i].ScriptPubkeySignature = redeemBytes
+
+			sigBytes, err = t.encodeToBytes()
+			if err != nil {
+				return nil, err
+			}
+		} else if scriptType == TypeP2SH || scriptType == TypeBech32 {
 			if scriptType == TypeBech32 {
 				unlockData[i].RedeemScript = unlockData[i].LockScript
 			}
@@ -592,7 +488,7 @@ func (t Transaction) getHashesForSig(unlockData []TxUnlock) ([][]byte, error) {
 
 		sigBytes = append(sigBytes, uint32ToLittleEndianBytes(DefaultHashType)...)
 
-		hash := owcrypt.Hash(sigBytes, 0, owcrypt.HASh_ALG_DOUBLE_SHA256)
+		hash := doubleSha256(sigBytes)
 
 		hashes = append(hashes, hash)
 	}
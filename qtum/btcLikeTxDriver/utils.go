@@ -1,6 +1,7 @@
 package btcLikeTxDriver
 
 import (
+	"crypto/sha256"
 	"encoding/binary"
 	"encoding/hex"
 	"errors"
@@ -39,6 +40,14 @@ func reverseBytesToHex(bytesVar []byte) string {
 	return hex.EncodeToString(reverseBytes(bytesVar))
 }
 
+//doubleSha256 计算交易哈希和签名哈希
+//owcrypt.Hash对64KB以上的数据计算结果错误，大额归集交易需要使用标准库
+func doubleSha256(data []byte) []byte {
+	first := sha256.Sum256(data)
+	second := sha256.Sum256(first[:])
+	return second[:]
+}
+
 //uint32ToLittleEndianBytes
 func uint32ToLittleEndianBytes(data uint32) []byte {
 	tmp := [4]byte{}
@@ -62,3 +71,95 @@ func uint64ToLittleEndianBytes(data uint64) []byte {
 func littleEndianBytesToUint64(data []byte) uint64 {
 	return binary.LittleEndian.Uint64(data)
 }
+
+//varIntToBytes 编码CompactSize变长整数，用于数量和脚本长度
+func varIntToBytes(n uint64) []byte {
+	switch {
+	case n < 0xFD:
+		return []byte{byte(n)}
+	case n <= 0xFFFF:
+		return []byte{0xFD, byte(n), byte(n >> 8)}
+	case n <= 0xFFFFFFFF:
+		return append([]byte{0xFE}, uint32ToLittleEndianBytes(uint32(n))...)
+	default:
+		return append([]byte{0xFF}, uint64ToLittleEndianBytes(n)...)
+	}
+}
+
+//bytesToVarInt 解码CompactSize变长整数，返回数值和占用的字节数
+func bytesToVarInt(data []byte) (uint64, int, error) {
+	if len(data) == 0 {
+		return 0, 0, errors.New("Invalid transaction data length!")
+	}
+
+	size := 1
+	switch data[0] {
+	case 0xFD:
+		size = 3
+	case 0xFE:
+		size = 5
+	case 0xFF:
+		size = 9
+	}
+	if len(data) < size {
+		return 0, 0, errors.New("Invalid transaction data length!")
+	}
+
+	var n uint64
+	switch size {
+	case 1:
+		return uint64(data[0]), size, nil
+	case 3:
+		n = uint64(data[1]) | uint64(data[2])<<8
+	case 5:
+		n = uint64(littleEndianBytesToUint32(data[1:5]))
+	default:
+		n = littleEndianBytesToUint64(data[1:9])
+	}
+
+	//必须使用最短编码
+	if (size == 3 && n < 0xFD) || (size == 5 && n <= 0xFFFF) || (size == 9 && n <= 0xFFFFFFFF) {
+		return 0, 0, errors.New("Non-canonical varint!")
+	}
+	return n, size, nil
+}
+
+//scriptPushes 解析只包含数据入栈操作的脚本，OP_0~OP_16视为对应数值的入栈
+func scriptPushes(script []byte) ([][]byte, error) {
+	pushes := make([][]byte, 0)
+	for i := 0; i < len(script); {
+		op := script[i]
+		i++
+		var length int
+		switch {
+		case op == 0x00:
+			pushes = append(pushes, []byte{})
+			continue
+		case op >= OpCode_1 && op <= OpCode_1+15:
+			pushes = append(pushes, []byte{op - OpCode_1 + 1})
+			continue
+		case op < OpPushData1:
+			length = int(op)
+		case op == OpPushData1:
+			if i+1 > len(script) {
+				return nil, errors.New("Invalid script push data!")
+			}
+			length = int(script[i])
+			i++
+		case op == OpPushData2:
+			if i+2 > len(script) {
+				return nil, errors.New("Invalid script push data!")
+			}
+			length = int(script[i]) | int(script[i+1])<<8
+			i += 2
+		default:
+			return nil, errors.New("Unsupported opcode in script!")
+		}
+		if i+length > len(script) {
+			return nil, errors.New("Invalid script push data!")
+		}
+		pushes = append(pushes, script[i:i+length])
+		i += length
+	}
+	return pushes, nil
+}
@@ -0,0 +1,127 @@
+package btcLikeTxDriver
+
+import (
+	"encoding/hex"
+	"errors"
+	"strings"
+)
+
+const (
+	//maxSignaturePushSize 入栈的DER签名最大长度：长度前缀 + 72字节DER签名 + 签名类型
+	maxSignaturePushSize = 1 + 72 + 1
+	//compressedPubkeyPushSize 入栈的压缩公钥长度
+	compressedPubkeyPushSize = 1 + 33
+	//witnessScaleFactor 隔离见证数据的权重折扣
+	witnessScaleFactor = 4
+)
+
+//estimateUnlockSize 预估输入签名后的解锁脚本长度和见证数据长度，不含解锁脚本的长度前缀
+func estimateUnlockSize(unlock TxUnlock) (int, int, error) {
+
+	//多重签名：OP_0 <sig>... <redeem>
+	if isMultiSigRedeemScript(unlock.RedeemScript) {
+		redeem, _ := hex.DecodeString(unlock.RedeemScript)
+		required, _, _ := decodeMultiSigRedeemScript(redeem)
+		return 1 + required*maxSignaturePushSize + len(pushDataPrefix(len(redeem))) + len(redeem), 0, nil
+	}
+
+	//见证数据：项数 <sig> <pubkey>
+	witnessSize := 1 + maxSignaturePushSize + compressedPubkeyPushSize
+
+	//原生隔离见证，解锁脚本为空
+	if unlock.RedeemScript == "" && strings.Index(unlock.LockScript, "0014") == 0 {
+		return 0, witnessSize, nil
+	}
+
+	//嵌套在P2SH中的隔离见证，解锁脚本为赎回脚本
+	if unlock.RedeemScript != "" {
+		redeem, err := hex.DecodeString(unlock.RedeemScript)
+		if err != nil {
+			return 0, 0, errors.New("Invalid redeem script!")
+		}
+		return 1 + len(redeem), witnessSize, nil
+	}
+
+	//P2PKH：<sig> <pubkey>
+	return maxSignaturePushSize + compressedPubkeyPushSize, 0, nil
+}
+
+//EstimateRawTransactionVSize 根据未签名的交易单和输入的解锁数据，预估签名后交易单的虚拟大小（vsize）
+//隔离见证数据按1/4计算，合约调用输出按实际脚本长度计算
+func EstimateRawTransactionVSize(txHex string, unlockData []TxUnlock) (uint64, error) {
+
+	txBytes, err := hex.DecodeString(txHex)
+	if err != nil {
+		return 0, errors.New("Invalid transaction hex data!")
+	}
+
+	emptyTrans, err := DecodeRawTransaction(txBytes)
+	if err != nil {
+		return 0, err
+	}
+
+	if len(emptyTrans.Vins) != len(unlockData) {
+		return 0, errors.New("The number of transaction inputs and the unlock data are not match!")
+	}
+
+	//version + locktime
+	baseSize := len(emptyTrans.Version) + len(emptyTrans.LockTime)
+	baseSize += len(varIntToBytes(uint64(len(emptyTrans.Vins))))
+	baseSize += len(varIntToBytes(uint64(len(emptyTrans.Vouts))))
+
+	witnessSize := 0
+	hasWitness := false
+	for i, in := range emptyTrans.Vins {
+		scriptSize, inputWitnessSize, err := estimateUnlockSize(unlockData[i])
+		if err != nil {
+			return 0, err
+		}
+
+		baseSize += len(in.TxID) + len(in.Vout) + len(in.Sequence)
+		baseSize += len(varIntToBytes(uint64(scriptSize))) + scriptSize
+
+		if inputWitnessSize > 0 {
+			hasWitness = true
+			witnessSize += inputWitnessSize
+		} else {
+			//非隔离见证输入的见证数据为空项
+			witnessSize++
+		}
+	}
+
+	for _, out := range emptyTrans.Vouts {
+		baseSize += len(out.amount) + len(varIntToBytes(uint64(len(out.lockScript)))) + len(out.lockScript)
+	}
+
+	weight := baseSize * witnessScaleFactor
+	if hasWitness {
+		//marker + flag
+		weight += 2 + witnessSize
+	}
+
+	return uint64((weight + witnessScaleFactor - 1) / witnessScaleFactor), nil
+}
+
+//RawTransactionVSize 已签名交易单的虚拟大小（vsize），见证数据按1/4计算
+func RawTransactionVSize(txHex string) (uint64, error) {
+
+	txBytes, err := hex.DecodeString(txHex)
+	if err != nil {
+		return 0, errors.New("Invalid transaction hex data!")
+	}
+
+	trx, err := DecodeRawTransaction(txBytes)
+	if err != nil {
+		return 0, err
+	}
+
+	//去掉marker、flag和见证数据后的大小
+	trx.Witness = nil
+	baseBytes, err := trx.encodeToBytes()
+	if err != nil {
+		return 0, err
+	}
+
+	weight := len(baseBytes)*(witnessScaleFactor-1) + len(txBytes)
+	return uint64((weight + witnessScaleFactor - 1) / witnessScaleFactor), nil
+}
@@ -0,0 +1,225 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package qtum
+
+import (
+	"encoding/hex"
+	"fmt"
+
+	"github.com/Assetsadapter/qtum-adapter/qtum/btcLikeTxDriver"
+	"github.com/blocktree/openwallet/openwallet"
+	"github.com/shopspring/decimal"
+)
+
+//BumpFeeRawTransaction 以更高的费率替换未确认的交易（BIP125）
+//rawTx提供账户和新的费率（FeeRate为空时使用预估费率），新交易单使用原交易的全部输入和输出，
+//追加的手续费从找零中扣除。原交易需要以扩展参数{"replaceable": true}构建
+func (decoder *TransactionDecoder) BumpFeeRawTransaction(wrapper openwallet.WalletDAI, rawTx *openwallet.RawTransaction, txid string) error {
+
+	var (
+		vins      = make([]btcLikeTxDriver.Vin, 0)
+		txUnlocks = make([]btcLikeTxDriver.TxUnlock, 0)
+		totalIn   = decimal.Zero
+		totalOut  = decimal.Zero
+		gasFee    = decimal.Zero
+		txFrom    = make([]string, 0)
+		txTo      = make([]string, 0)
+		sent      = decimal.Zero
+		feeRate   decimal.Decimal
+		err       error
+	)
+
+	if rawTx.Account == nil {
+		return fmt.Errorf("the raw transaction account is empty")
+	}
+	accountID := rawTx.Account.AccountID
+
+	origin, err := decoder.wm.GetTransaction(txid)
+	if err != nil {
+		return err
+	}
+	if origin.BlockHeight > 0 || origin.Confirmations > 0 {
+		return openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "transaction %s is already confirmed", txid)
+	}
+
+	originHex, err := decoder.wm.GetRawTransactionHex(txid)
+	if err != nil {
+		return err
+	}
+
+	replaceable, err := btcLikeTxDriver.IsReplaceableTransaction(originHex)
+	if err != nil {
+		return err
+	}
+	if !replaceable {
+		return openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "transaction %s does not signal replaceability", txid)
+	}
+
+	txBytes, _ := hex.DecodeString(originHex)
+	trx, err := btcLikeTxDriver.DecodeRawTransaction(txBytes)
+	if err != nil {
+		return err
+	}
+
+	//输入全部属于账户才能重新签名
+	for _, vin := range trx.Vins {
+		prevTx, err := decoder.wm.GetTransaction(vin.GetTxID())
+		if err != nil {
+			return err
+		}
+		if int(vin.GetVout()) >= len(prevTx.Vouts) {
+			return fmt.Errorf("can not find output %s:%d", vin.GetTxID(), vin.GetVout())
+		}
+		prevOut := prevTx.Vouts[vin.GetVout()]
+
+		if !decoder.isAccountAddress(wrapper, accountID, prevOut.Addr) {
+			return openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "input %s:%d does not belong to the account", vin.GetTxID(), vin.GetVout())
+		}
+
+		amount, _ := decimal.NewFromString(prevOut.Value)
+		totalIn = totalIn.Add(amount)
+
+		vins = append(vins, btcLikeTxDriver.Vin{TxID: vin.GetTxID(), Vout: vin.GetVout()})
+		txUnlock := btcLikeTxDriver.TxUnlock{
+			LockScript: prevOut.ScriptPubKey,
+			Address:    prevOut.Addr,
+			Amount:     uint64(amount.Shift(decoder.wm.Decimal()).IntPart()),
+		}
+		err = decoder.setMultiSigRedeemScript(wrapper, rawTx.Account, &txUnlock)
+		if err != nil {
+			return err
+		}
+		txUnlocks = append(txUnlocks, txUnlock)
+		txFrom = append(txFrom, fmt.Sprintf("%s:%s", prevOut.Addr, amount.StringFixed(decoder.wm.Decimal())))
+	}
+
+	//找零为属于账户的最大输出
+	changeIndex := -1
+	changeAmount := decimal.Zero
+	for i, out := range origin.Vouts {
+		amount, _ := decimal.NewFromString(out.Value)
+		totalOut = totalOut.Add(amount)
+
+		script, _ := hex.DecodeString(out.ScriptPubKey)
+		if contract, err := btcLikeTxDriver.DecodeContractScript(script); err == nil {
+			//合约调用的gas不受费率影响
+			gasFee = gasFee.Add(decimal.New(int64(contract.GasLimit*contract.GasPrice), -decoder.wm.Decimal()))
+			continue
+		}
+
+		if decoder.isAccountAddress(wrapper, accountID, out.Addr) {
+			if changeIndex < 0 || amount.GreaterThan(changeAmount) {
+				changeIndex = i
+				changeAmount = amount
+			}
+		} else {
+			sent = sent.Add(amount)
+			txTo = append(txTo, fmt.Sprintf("%s:%s", out.Addr, amount.StringFixed(decoder.wm.Decimal())))
+		}
+	}
+
+	if changeIndex < 0 {
+		return openwallet.Errorf(openwallet.ErrInsufficientFees, "transaction %s has no change output to pay the extra fee", txid)
+	}
+
+	//替换交易只修改找零金额，大小与未扣除手续费时相同
+	sizingTrans, err := btcLikeTxDriver.CreateReplacementRawTransaction(originHex, changeIndex, 0)
+	if err != nil {
+		return openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "create replacement transaction failed, unexpected error: %v", err)
+	}
+	vsize, err := btcLikeTxDriver.EstimateRawTransactionVSize(sizingTrans, txUnlocks)
+	if err != nil {
+		return err
+	}
+
+	newFees, err := decoder.vsizeFees(rawTx, sizingTrans, txUnlocks)
+	if err != nil {
+		return err
+	}
+	feeRate, _ = decimal.NewFromString(rawTx.FeeRate)
+	newFees = newFees.Add(gasFee)
+
+	oldFees := totalIn.Sub(totalOut)
+	if newFees.LessThanOrEqual(oldFees) {
+		return openwallet.Errorf(openwallet.ErrInsufficientFees, "fee rate %s does not raise the fees %s of the original transaction", feeRate.String(), oldFees.String())
+	}
+
+	if minFees := decoder.wm.replacementMinFees(oldFees, vsize); newFees.LessThan(minFees) {
+		newFees = minFees
+	}
+
+	extraFees := newFees.Sub(oldFees)
+	if changeAmount.LessThanOrEqual(extraFees) {
+		return openwallet.Errorf(openwallet.ErrInsufficientFees, "change %s is not enough to pay the extra fees %s", changeAmount.String(), extraFees.String())
+	}
+
+	emptyTrans, err := btcLikeTxDriver.CreateReplacementRawTransaction(originHex, changeIndex, uint64(extraFees.Shift(decoder.wm.Decimal()).IntPart()))
+	if err != nil {
+		return openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "create replacement transaction failed, unexpected error: %v", err)
+	}
+
+	transHash, err := btcLikeTxDriver.CreateRawTransactionHashForSig(emptyTrans, txUnlocks)
+	if err != nil {
+		return fmt.Errorf("create transaction hash for sig failed, unexpected error: %v", err)
+	}
+
+	rawTx.RawHex = emptyTrans
+
+	err = decoder.createKeySignatures(wrapper, rawTx, txUnlocks, transHash)
+	if err != nil {
+		return err
+	}
+
+	err = setRawTransactionPrevOuts(rawTx, vins, txUnlocks)
+	if err != nil {
+		return err
+	}
+
+	rawTx.SetExtParam("replaceable", true)
+	rawTx.SetExtParam("replaceTxID", txid)
+
+	decoder.wm.Log.Std.Notice("-----------------------------------------------")
+	decoder.wm.Log.Std.Notice("Replace Transaction: %s", txid)
+	decoder.wm.Log.Std.Notice("Original Fees: %v", oldFees.StringFixed(decoder.wm.Decimal()))
+	decoder.wm.Log.Std.Notice("Fees: %v", newFees.StringFixed(decoder.wm.Decimal()))
+	decoder.wm.Log.Std.Notice("Change: %v", changeAmount.Sub(extraFees).StringFixed(decoder.wm.Decimal()))
+	decoder.wm.Log.Std.Notice("-----------------------------------------------")
+
+	rawTx.FeeRate = feeRate.StringFixed(decoder.wm.Decimal())
+	rawTx.Fees = newFees.StringFixed(decoder.wm.Decimal())
+	rawTx.IsBuilt = true
+	rawTx.IsCompleted = false
+	rawTx.TxAmount = decimal.Zero.Sub(sent.Add(newFees)).StringFixed(decoder.wm.Decimal())
+	rawTx.TxFrom = txFrom
+	rawTx.TxTo = txTo
+
+	return nil
+}
+
+//replacementMinFees 替换交易的最低手续费（BIP125规则4）：原交易的手续费加上按增量中继费率计算的替换交易自身的手续费
+func (wm *WalletManager) replacementMinFees(oldFees decimal.Decimal, vsize uint64) decimal.Decimal {
+	incrementalFees := decimal.New(int64(vsize), 0).Div(decimal.New(1000, 0)).Mul(wm.config.IncrementalRelayFee).Round(wm.Decimal())
+	return oldFees.Add(incrementalFees)
+}
+
+//isAccountAddress 地址是否属于账户
+func (decoder *TransactionDecoder) isAccountAddress(wrapper openwallet.WalletDAI, accountID, address string) bool {
+	if len(address) == 0 {
+		return false
+	}
+	addresses, err := wrapper.GetAddressList(0, -1, "AccountID", accountID, "Address", address)
+	return err == nil && len(addresses) > 0
+}
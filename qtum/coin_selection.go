@@ -0,0 +1,390 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package qtum
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/blocktree/openwallet/openwallet"
+	"github.com/shopspring/decimal"
+	"github.com/tidwall/gjson"
+)
+
+const (
+	//CoinSelectionSequential 按给定顺序选择utxo（默认，CreateSimpleRawTransaction按小到大排序）
+	CoinSelectionSequential = "sequential"
+	//CoinSelectionLargestFirst 优先使用大额utxo，输入最少
+	CoinSelectionLargestFirst = "largestFirst"
+	//CoinSelectionSmallestFirst 优先使用小额utxo，用于归集零散的utxo
+	CoinSelectionSmallestFirst = "smallestFirst"
+	//CoinSelectionBranchAndBound 分支定界搜索无找零的组合，找不到时使用largestFirst
+	CoinSelectionBranchAndBound = "branchAndBound"
+	//CoinSelectionPrivacy 同一地址的utxo整体花费，减少地址之间的关联
+	CoinSelectionPrivacy = "privacy"
+)
+
+//bnbMaxTries 分支定界搜索的最大尝试次数
+const bnbMaxTries = 100000
+
+//CoinSelectionParams 选择utxo的参数
+type CoinSelectionParams struct {
+	//发送总额，不含手续费
+	Target decimal.Decimal
+	//接收方输出数量，不含找零
+	Outputs int64
+	//最大输入数量
+	MaxInputs int
+	//按输入和输出数量计算手续费
+	Fees func(inputs, outputs int64) (decimal.Decimal, error)
+	//花费一个输入的手续费，分支定界忽略金额不超过该值的utxo
+	InputFee decimal.Decimal
+	//创建并日后花费找零的成本，无找零时多付的金额不超过该值
+	CostOfChange decimal.Decimal
+}
+
+//CoinSelection 选择utxo的结果
+type CoinSelection struct {
+	UTXOs  []*Unspent
+	Total  decimal.Decimal
+	Fees   decimal.Decimal
+	Change decimal.Decimal
+}
+
+//CoinSelector utxo选择策略
+type CoinSelector interface {
+	Select(unspents []*Unspent, params *CoinSelectionParams) (*CoinSelection, error)
+}
+
+//CoinSelectorFunc 函数形式的选择策略
+type CoinSelectorFunc func(unspents []*Unspent, params *CoinSelectionParams) (*CoinSelection, error)
+
+//Select 实现CoinSelector
+func (f CoinSelectorFunc) Select(unspents []*Unspent, params *CoinSelectionParams) (*CoinSelection, error) {
+	return f(unspents, params)
+}
+
+var coinSelectors = map[string]CoinSelector{
+	strings.ToLower(CoinSelectionSequential):     CoinSelectorFunc(selectSequential),
+	strings.ToLower(CoinSelectionLargestFirst):   CoinSelectorFunc(selectLargestFirst),
+	strings.ToLower(CoinSelectionSmallestFirst):  CoinSelectorFunc(selectSmallestFirst),
+	strings.ToLower(CoinSelectionBranchAndBound): CoinSelectorFunc(selectBranchAndBound),
+	strings.ToLower(CoinSelectionPrivacy):        CoinSelectorFunc(selectPrivacy),
+	"bnb":                                        CoinSelectorFunc(selectBranchAndBound),
+}
+
+//RegisterCoinSelector 注册自定义的选择策略
+func RegisterCoinSelector(name string, selector CoinSelector) {
+	coinSelectors[strings.ToLower(name)] = selector
+}
+
+//NewCoinSelector 根据名称获取选择策略，名称为空时使用sequential
+func NewCoinSelector(name string) (CoinSelector, error) {
+	if len(name) == 0 {
+		name = CoinSelectionSequential
+	}
+	selector, ok := coinSelectors[strings.ToLower(name)]
+	if !ok {
+		return nil, fmt.Errorf("unknown coin selection strategy: %s", name)
+	}
+	return selector, nil
+}
+
+//coinSelector 交易单使用的选择策略
+//优先级：交易单扩展参数coinSelection > 账户扩展参数coinSelection > 配置文件coinSelection
+func (decoder *TransactionDecoder) coinSelector(rawTx *openwallet.RawTransaction) (CoinSelector, error) {
+	name := rawTx.GetExtParam().Get("coinSelection").String()
+	if len(name) == 0 && rawTx.Account != nil && len(rawTx.Account.ExtParam) > 0 {
+		name = gjson.Get(rawTx.Account.ExtParam, "coinSelection").String()
+	}
+	if len(name) == 0 {
+		name = decoder.wm.config.CoinSelection
+	}
+	return NewCoinSelector(name)
+}
+
+//spendableUnspents 可花费的utxo
+func spendableUnspents(unspents []*Unspent) []*Unspent {
+	spendable := make([]*Unspent, 0, len(unspents))
+	for _, u := range unspents {
+		if u.Spendable {
+			spendable = append(spendable, u)
+		}
+	}
+	return spendable
+}
+
+//unspentAmount utxo的金额
+func unspentAmount(u *Unspent) decimal.Decimal {
+	amount, _ := decimal.NewFromString(u.Amount)
+	return amount
+}
+
+//sortUnspents 按金额排序，金额相同时按txid和vout排序，保证结果确定
+func sortUnspents(unspents []*Unspent, desc bool) []*Unspent {
+	sorted := make([]*Unspent, len(unspents))
+	copy(sorted, unspents)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		a, b := unspentAmount(sorted[i]), unspentAmount(sorted[j])
+		if !a.Equal(b) {
+			if desc {
+				return a.GreaterThan(b)
+			}
+			return a.LessThan(b)
+		}
+		if sorted[i].TxID != sorted[j].TxID {
+			return sorted[i].TxID < sorted[j].TxID
+		}
+		return sorted[i].Vout < sorted[j].Vout
+	})
+	return sorted
+}
+
+//selectInOrder 按顺序累加utxo，直到足够支付发送总额和带找零的手续费
+func selectInOrder(unspents []*Unspent, params *CoinSelectionParams) (*CoinSelection, error) {
+
+	selection := &CoinSelection{UTXOs: make([]*Unspent, 0), Total: decimal.Zero}
+
+	for _, u := range unspents {
+		selection.UTXOs = append(selection.UTXOs, u)
+		selection.Total = selection.Total.Add(unspentAmount(u))
+		if selection.Total.LessThan(params.Target) {
+			continue
+		}
+
+		fees, err := params.Fees(int64(len(selection.UTXOs)), params.Outputs+1)
+		if err != nil {
+			return nil, err
+		}
+		if selection.Total.LessThan(params.Target.Add(fees)) {
+			continue
+		}
+
+		if params.MaxInputs > 0 && len(selection.UTXOs) > params.MaxInputs {
+			return nil, fmt.Errorf("The transaction is use max inputs over: %d", params.MaxInputs)
+		}
+
+		selection.Fees = fees
+		selection.Change = selection.Total.Sub(params.Target).Sub(fees)
+		return selection, nil
+	}
+
+	return nil, openwallet.Errorf(openwallet.ErrInsufficientBalanceOfAccount, "The balance: %s is not enough! ", selection.Total.String())
+}
+
+//selectSequential 按给定顺序选择
+func selectSequential(unspents []*Unspent, params *CoinSelectionParams) (*CoinSelection, error) {
+	return selectInOrder(spendableUnspents(unspents), params)
+}
+
+//selectLargestFirst 从大到小选择
+func selectLargestFirst(unspents []*Unspent, params *CoinSelectionParams) (*CoinSelection, error) {
+	return selectInOrder(sortUnspents(spendableUnspents(unspents), true), params)
+}
+
+//selectSmallestFirst 从小到大选择，超过最大输入数量时舍弃最小的utxo，在限制内花费尽可能多的小额utxo
+func selectSmallestFirst(unspents []*Unspent, params *CoinSelectionParams) (*CoinSelection, error) {
+
+	sorted := sortUnspents(spendableUnspents(unspents), false)
+
+	selection, err := selectInOrder(sorted, params)
+	if err == nil || params.MaxInputs <= 0 {
+		return selection, err
+	}
+	if _, ok := err.(*openwallet.Error); ok {
+		//余额不足
+		return nil, err
+	}
+
+	for start := 1; start < len(sorted); start++ {
+		window := sorted[start:]
+		if len(window) > params.MaxInputs {
+			window = window[:params.MaxInputs]
+		}
+		if selection, err = selectInOrder(window, params); err == nil {
+			return selection, nil
+		}
+	}
+
+	return nil, fmt.Errorf("The transaction is use max inputs over: %d", params.MaxInputs)
+}
+
+//selectBranchAndBound 深度优先搜索金额恰好覆盖发送总额和无找零手续费的组合，
+//多出的金额不超过找零成本并计入手续费；搜索失败时使用largestFirst
+func selectBranchAndBound(unspents []*Unspent, params *CoinSelectionParams) (*CoinSelection, error) {
+
+	candidates := make([]*Unspent, 0)
+	for _, u := range sortUnspents(spendableUnspents(unspents), true) {
+		if unspentAmount(u).GreaterThan(params.InputFee) {
+			candidates = append(candidates, u)
+		}
+	}
+
+	amounts := make([]decimal.Decimal, len(candidates))
+	remaining := make([]decimal.Decimal, len(candidates)+1)
+	remaining[len(candidates)] = decimal.Zero
+	for i := len(candidates) - 1; i >= 0; i-- {
+		amounts[i] = unspentAmount(candidates[i])
+		remaining[i] = remaining[i+1].Add(amounts[i])
+	}
+
+	var (
+		tries     = 0
+		selected  = make([]int, 0)
+		best      []int
+		bestTotal decimal.Decimal
+		bestFees  decimal.Decimal
+		bestWaste decimal.Decimal
+		searchErr error
+		search    func(index int, total decimal.Decimal) bool
+	)
+
+	//返回true时结束搜索
+	search = func(index int, total decimal.Decimal) bool {
+		tries++
+		if tries > bnbMaxTries {
+			return true
+		}
+
+		if len(selected) > 0 {
+			fees, err := params.Fees(int64(len(selected)), params.Outputs)
+			if err != nil {
+				searchErr = err
+				return true
+			}
+			required := params.Target.Add(fees)
+			if total.GreaterThanOrEqual(required) {
+				waste := total.Sub(required)
+				if waste.LessThanOrEqual(params.CostOfChange) && (best == nil || waste.LessThan(bestWaste)) {
+					best = append([]int{}, selected...)
+					bestTotal, bestFees, bestWaste = total, fees, waste
+				}
+				//继续加入输入只会多付
+				return best != nil && bestWaste.IsZero()
+			}
+		}
+
+		if index >= len(candidates) || total.Add(remaining[index]).LessThan(params.Target) {
+			return false
+		}
+		if params.MaxInputs > 0 && len(selected) >= params.MaxInputs {
+			return false
+		}
+
+		//包含当前utxo
+		selected = append(selected, index)
+		if search(index+1, total.Add(amounts[index])) {
+			return true
+		}
+		selected = selected[:len(selected)-1]
+
+		//不包含当前utxo
+		return search(index+1, total)
+	}
+
+	search(0, decimal.Zero)
+	if searchErr != nil {
+		return nil, searchErr
+	}
+
+	if best == nil {
+		return selectLargestFirst(unspents, params)
+	}
+
+	selection := &CoinSelection{UTXOs: make([]*Unspent, 0, len(best)), Total: bestTotal, Change: decimal.Zero}
+	for _, i := range best {
+		selection.UTXOs = append(selection.UTXOs, candidates[i])
+	}
+	//多出的金额计入手续费
+	selection.Fees = bestFees.Add(bestWaste)
+
+	return selection, nil
+}
+
+//selectPrivacy 以地址为单位整体花费utxo，优先使用能单独支付的最小地址，
+//否则从余额最大的地址开始合并，使交易关联的地址尽量少
+func selectPrivacy(unspents []*Unspent, params *CoinSelectionParams) (*CoinSelection, error) {
+
+	type addressGroup struct {
+		address  string
+		unspents []*Unspent
+		total    decimal.Decimal
+	}
+
+	groups := make([]*addressGroup, 0)
+	groupIndex := make(map[string]*addressGroup)
+	for _, u := range sortUnspents(spendableUnspents(unspents), true) {
+		group, ok := groupIndex[u.Address]
+		if !ok {
+			group = &addressGroup{address: u.Address, total: decimal.Zero}
+			groupIndex[u.Address] = group
+			groups = append(groups, group)
+		}
+		group.unspents = append(group.unspents, u)
+		group.total = group.total.Add(unspentAmount(u))
+	}
+
+	sort.SliceStable(groups, func(i, j int) bool {
+		if !groups[i].total.Equal(groups[j].total) {
+			return groups[i].total.LessThan(groups[j].total)
+		}
+		return groups[i].address < groups[j].address
+	})
+
+	//单个地址足够支付
+	for _, group := range groups {
+		if params.MaxInputs > 0 && len(group.unspents) > params.MaxInputs {
+			continue
+		}
+		fees, err := params.Fees(int64(len(group.unspents)), params.Outputs+1)
+		if err != nil {
+			return nil, err
+		}
+		if group.total.GreaterThanOrEqual(params.Target.Add(fees)) {
+			return &CoinSelection{
+				UTXOs:  group.unspents,
+				Total:  group.total,
+				Fees:   fees,
+				Change: group.total.Sub(params.Target).Sub(fees),
+			}, nil
+		}
+	}
+
+	//从余额最大的地址开始合并
+	selection := &CoinSelection{UTXOs: make([]*Unspent, 0), Total: decimal.Zero}
+	for i := len(groups) - 1; i >= 0; i-- {
+		selection.UTXOs = append(selection.UTXOs, groups[i].unspents...)
+		selection.Total = selection.Total.Add(groups[i].total)
+
+		fees, err := params.Fees(int64(len(selection.UTXOs)), params.Outputs+1)
+		if err != nil {
+			return nil, err
+		}
+		if selection.Total.LessThan(params.Target.Add(fees)) {
+			continue
+		}
+		if params.MaxInputs > 0 && len(selection.UTXOs) > params.MaxInputs {
+			//整体花费超过限制，退回按金额选择
+			return selectLargestFirst(unspents, params)
+		}
+		selection.Fees = fees
+		selection.Change = selection.Total.Sub(params.Target).Sub(fees)
+		return selection, nil
+	}
+
+	return nil, openwallet.Errorf(openwallet.ErrInsufficientBalanceOfAccount, "The balance: %s is not enough! ", selection.Total.String())
+}
@@ -0,0 +1,181 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package qtum
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/blocktree/openwallet/openwallet"
+	"github.com/shopspring/decimal"
+)
+
+//testUnspents 构造utxo，格式：地址:金额
+func testUnspents(items ...string) []*Unspent {
+	unspents := make([]*Unspent, 0, len(items))
+	for i, item := range items {
+		parts := strings.Split(item, ":")
+		unspents = append(unspents, &Unspent{
+			TxID:      fmt.Sprintf("%064x", i+1),
+			Vout:      uint64(i),
+			Address:   parts[0],
+			Amount:    parts[1],
+			Spendable: true,
+		})
+	}
+	return unspents
+}
+
+//testCoinSelectionParams 每个输入0.001，每个输出0.0005的线性手续费
+func testCoinSelectionParams(target string, maxInputs int) *CoinSelectionParams {
+	return &CoinSelectionParams{
+		Target:    decimal.RequireFromString(target),
+		Outputs:   1,
+		MaxInputs: maxInputs,
+		Fees: func(inputs, outputs int64) (decimal.Decimal, error) {
+			return decimal.New(inputs*10+outputs*5, -4), nil
+		},
+		InputFee:     decimal.New(10, -4),
+		CostOfChange: decimal.New(15, -4),
+	}
+}
+
+func selectionAmounts(selection *CoinSelection) string {
+	amounts := make([]string, 0, len(selection.UTXOs))
+	for _, u := range selection.UTXOs {
+		amounts = append(amounts, u.Amount)
+	}
+	return strings.Join(amounts, ",")
+}
+
+func TestCoinSelectors(t *testing.T) {
+
+	unspents := testUnspents("a:0.5", "b:3", "a:0.2", "c:1", "b:0.0005", "c:2", "a:0.3")
+
+	tests := []struct {
+		strategy string
+		target   string
+		want     string
+		fees     string
+		change   string
+	}{
+		//按给定顺序
+		{CoinSelectionSequential, "0.6", "0.5,3", "0.003", "2.897"},
+		//最大的一个即可支付
+		{CoinSelectionLargestFirst, "2.5", "3", "0.002", "0.498"},
+		//不足时继续加入次大的
+		{CoinSelectionLargestFirst, "3.5", "3,2", "0.003", "1.497"},
+		//从小额开始归集
+		{CoinSelectionSmallestFirst, "0.6", "0.0005,0.2,0.3,0.5", "0.005", "0.3955"},
+		//0.2+0.3+0.5恰好覆盖1.0-0.0035，无找零
+		{CoinSelectionBranchAndBound, "0.9965", "0.5,0.3,0.2", "0.0035", "0"},
+		//多出的0.001不超过找零成本，计入手续费
+		{CoinSelectionBranchAndBound, "0.9955", "0.5,0.3,0.2", "0.0045", "0"},
+		//没有无找零的组合，使用largestFirst
+		{CoinSelectionBranchAndBound, "2.5", "3", "0.002", "0.498"},
+		//地址c单独足够，且是足够的地址中余额最小的
+		{CoinSelectionPrivacy, "2.5", "2,1", "0.003", "0.497"},
+		//需要合并地址b和c
+		{CoinSelectionPrivacy, "4", "3,0.0005,2,1", "0.005", "1.9955"},
+	}
+
+	for _, test := range tests {
+		selector, err := NewCoinSelector(test.strategy)
+		if err != nil {
+			t.Fatalf("NewCoinSelector(%s) failed: %v", test.strategy, err)
+		}
+		selection, err := selector.Select(unspents, testCoinSelectionParams(test.target, 50))
+		if err != nil {
+			t.Errorf("%s target %s: unexpected error: %v", test.strategy, test.target, err)
+			continue
+		}
+		if got := selectionAmounts(selection); got != test.want {
+			t.Errorf("%s target %s: selected %s, want %s", test.strategy, test.target, got, test.want)
+		}
+		if !selection.Fees.Equal(decimal.RequireFromString(test.fees)) {
+			t.Errorf("%s target %s: fees %s, want %s", test.strategy, test.target, selection.Fees.String(), test.fees)
+		}
+		if !selection.Change.Equal(decimal.RequireFromString(test.change)) {
+			t.Errorf("%s target %s: change %s, want %s", test.strategy, test.target, selection.Change.String(), test.change)
+		}
+		if !selection.Total.Equal(decimal.RequireFromString(test.target).Add(selection.Fees).Add(selection.Change)) {
+			t.Errorf("%s target %s: total %s does not balance", test.strategy, test.target, selection.Total.String())
+		}
+	}
+}
+
+func TestCoinSelectorMaxInputs(t *testing.T) {
+
+	unspents := testUnspents("a:0.1", "a:0.1", "a:0.1", "a:0.1", "a:0.1", "a:1")
+	params := testCoinSelectionParams("0.35", 3)
+
+	//从小到大需要4个输入
+	if _, err := selectSequential(unspents, params); err == nil {
+		t.Errorf("sequential should fail over max inputs")
+	}
+
+	//smallestFirst舍弃最小的utxo，限制内尽量多花费小额utxo
+	selection, err := selectSmallestFirst(unspents, params)
+	if err != nil {
+		t.Fatalf("smallestFirst failed: %v", err)
+	}
+	if got := selectionAmounts(selection); got != "0.1,0.1,1" {
+		t.Errorf("smallestFirst selected %s, want 0.1,0.1,1", got)
+	}
+
+	//地址a整体超过限制，使用largestFirst
+	selection, err = selectPrivacy(unspents, params)
+	if err != nil {
+		t.Fatalf("privacy failed: %v", err)
+	}
+	if got := selectionAmounts(selection); got != "1" {
+		t.Errorf("privacy selected %s, want 1", got)
+	}
+
+	//余额不足
+	_, err = selectLargestFirst(unspents, testCoinSelectionParams("2", 3))
+	if e, ok := err.(*openwallet.Error); !ok || e.Code() != openwallet.ErrInsufficientBalanceOfAccount {
+		t.Errorf("largestFirst should fail with insufficient balance, got %v", err)
+	}
+
+	//不可花费的utxo不参与选择
+	unspents[5].Spendable = false
+	if _, err := selectBranchAndBound(unspents, testCoinSelectionParams("0.5", 3)); err == nil {
+		t.Errorf("branchAndBound should not use unspendable utxo")
+	}
+}
+
+func TestNewCoinSelector(t *testing.T) {
+	for _, name := range []string{"", "sequential", "LargestFirst", "smallestfirst", "bnb", "branchAndBound", "privacy"} {
+		if _, err := NewCoinSelector(name); err != nil {
+			t.Errorf("NewCoinSelector(%q) failed: %v", name, err)
+		}
+	}
+	if _, err := NewCoinSelector("random"); err == nil {
+		t.Errorf("unknown strategy should fail")
+	}
+
+	decoder := NewTransactionDecoder(NewWalletManager())
+	rawTx := &openwallet.RawTransaction{Account: &openwallet.AssetsAccount{ExtParam: `{"coinSelection":"unknown"}`}}
+	if _, err := decoder.coinSelector(rawTx); err == nil {
+		t.Errorf("account coinSelection should be used")
+	}
+	rawTx.SetExtParam("coinSelection", CoinSelectionPrivacy)
+	if _, err := decoder.coinSelector(rawTx); err != nil {
+		t.Errorf("rawTx coinSelection should override the account: %v", err)
+	}
+}
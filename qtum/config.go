@@ -18,6 +18,7 @@ package qtum
 import (
 	"fmt"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
@@ -109,10 +110,75 @@ type WalletConfig struct {
 	RPCServerType int
 	//数据目录
 	DataDir string
-	//代币转账最低成本
-	TokenTransferCost string
 	//最低手续费
 	MinFees decimal.Decimal
+	//合约调用默认的gas上限
+	GasLimit uint64
+	//合约调用默认的gas价格，单位QTUM
+	GasPrice decimal.Decimal
+	//是否通过callcontract试运行估算gas上限
+	GasEstimate bool
+	//估算gas上限的安全余量，0.2表示gasUsed的1.2倍
+	GasEstimateMargin decimal.Decimal
+	//指定合约的gas设置，合约地址（不带0x的小写十六进制） -> gas设置
+	ContractGas map[string]*ContractGas
+	//默认的utxo选择策略
+	CoinSelection string
+	//费率来源，依次尝试直到获得有效的费率
+	FeeRateSources []string
+	//确认目标 -> 确认区块数
+	FeeTargets map[string]int64
+	//固定费率，每KB
+	StaticFeeRate decimal.Decimal
+	//粉尘费率，每KB，低于按此费率计算的粉尘阈值的输出不被节点转发
+	DustRelayFee decimal.Decimal
+	//替换交易（BIP125）的增量中继费率，每KB，替换交易的手续费至少比原交易多出按此费率计算的手续费
+	IncrementalRelayFee decimal.Decimal
+	//区块扫描器维护观测地址的utxo索引，ListUnspent和余额查询不再依赖节点钱包
+	UTXOIndex bool
+	//区块扫描时并发预取的区块数量
+	ScanPrefetchBlocks int
+	//交易单确认数通知的阈值，为空时不通知
+	ConfirmationThresholds []uint64
+	//浏览器返回的代币转账数量已按精度调整，否则为合约记录的原始整数
+	ExplorerTokenAmountScaled bool
+}
+
+//ContractGas 合约调用的gas设置
+type ContractGas struct {
+	GasLimit uint64
+	GasPrice decimal.Decimal
+}
+
+//Fees 合约调用最多消耗的手续费，未使用的gas由区块退还给发送方
+func (gas *ContractGas) Fees() decimal.Decimal {
+	return gas.GasPrice.Mul(decimal.New(int64(gas.GasLimit), 0))
+}
+
+//parseContractGas 解析合约的gas设置，格式：gasLimit,gasPrice，缺省的项使用默认值
+func parseContractGas(value string, defaultGas ContractGas) (*ContractGas, error) {
+	gas := defaultGas
+	parts := strings.Split(value, ",")
+	if len(parts) > 2 {
+		return nil, fmt.Errorf("invalid contract gas setting: %s", value)
+	}
+	if limit := strings.TrimSpace(parts[0]); len(limit) > 0 {
+		gasLimit, err := strconv.ParseUint(limit, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid contract gas limit: %s", limit)
+		}
+		gas.GasLimit = gasLimit
+	}
+	if len(parts) == 2 {
+		if price := strings.TrimSpace(parts[1]); len(price) > 0 {
+			gasPrice, err := decimal.NewFromString(price)
+			if err != nil {
+				return nil, fmt.Errorf("invalid contract gas price: %s", price)
+			}
+			gas.GasPrice = gasPrice
+		}
+	}
+	return &gas, nil
 }
 
 func NewConfig() *WalletConfig {
@@ -170,6 +236,22 @@ func NewConfig() *WalletConfig {
 	c.CoinDecimal = decimal.NewFromFloat(100000000)
 	//后台数据源类型
 	c.RPCServerType = RPCServerCore
+	//合约调用的gas设置
+	c.GasLimit = DEFAULT_GAS_LIMIT
+	c.GasPrice = DEFAULT_GAS_PRICE
+	c.GasEstimate = false
+	c.GasEstimateMargin = decimal.NewFromFloat(0.2)
+	c.CoinSelection = CoinSelectionSequential
+	c.FeeRateSources = []string{FeeRateSourceNode, FeeRateSourceExplorer, FeeRateSourceBlocks, FeeRateSourceStatic}
+	c.FeeTargets = map[string]int64{FeeTargetFast: 2, FeeTargetNormal: 6, FeeTargetSlow: 24}
+	c.StaticFeeRate = decimal.RequireFromString("0.004")
+	c.DustRelayFee = decimal.RequireFromString("0.004")
+	c.IncrementalRelayFee = decimal.RequireFromString("0.004")
+	c.UTXOIndex = false
+	c.ScanPrefetchBlocks = 8
+	c.ConfirmationThresholds = make([]uint64, 0)
+	c.ExplorerTokenAmountScaled = false
+	c.ContractGas = make(map[string]*ContractGas)
 
 	//默认配置内容
 	c.defaultConfig = `
@@ -205,6 +287,39 @@ threshold = ""
 walletDataPath = ""
 # summary task timer cycle time, sample: 1h, 1h1m , 2m, 30s, 3m20s etc...
 cycleSeconds = ""
+# default gas limit of contract call
+gasLimit = 250000
+# default gas price of contract call, unit: QTUM
+gasPrice = "0.0000004"
+# estimate gas limit by a dry-run callcontract, only for CoreWallet RPC
+gasEstimate = false
+# safety margin of the estimated gas limit, 0.2 means gasUsed * 1.2
+gasEstimateMargin = "0.2"
+# default coin selection strategy: sequential, largestFirst, smallestFirst, branchAndBound, privacy
+coinSelection = "sequential"
+# fee rate sources in fallback order: node, explorer, blocks (recent scanned blocks), static
+feeRateSources = "node,explorer,blocks,static"
+# confirmation blocks of the fee targets
+feeTargetFast = 2
+feeTargetNormal = 6
+feeTargetSlow = 24
+# static fee rate per KB, the last fallback
+staticFeeRate = "0.004"
+# dust relay fee per KB, outputs below the dust threshold of their script type are rejected
+dustRelayFee = "0.004"
+# incremental relay fee per KB, a replacement transaction pays at least this rate on its own size above the original fees
+incrementalRelayFee = "0.004"
+# the block scanner keeps the utxo of watched addresses, ListUnspent and balance are served locally
+utxoIndex = false
+# number of blocks downloaded and extracted concurrently while catching up, 1 scans one block at a time
+scanPrefetchBlocks = 8
+# confirmation thresholds notified to observers, for example: 1,6,30,500, empty is disabled
+confirmationThresholds = ""
+# the explorer returns QRC20 transfer values already scaled by the token decimals, false means raw integers
+explorerTokenAmountScaled = false
+# gas setting of specific contracts, format: contractAddress = gasLimit,gasPrice
+# this section must be placed at the end of file
+[contractGas]
 `
 	//file.MkdirAll(c.dbPath)
 	//file.MkdirAll(c.backupDir)
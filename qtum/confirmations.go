@@ -0,0 +1,470 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package qtum
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/asdine/storm"
+	"github.com/asdine/storm/q"
+	"github.com/blocktree/openwallet/common/file"
+	"github.com/blocktree/openwallet/openwallet"
+)
+
+const (
+	//confirmationDBFile 本地保存确认数跟踪状态的数据库文件
+	confirmationDBFile = "confirmations.db"
+)
+
+//TxConfirmation 交易单确认数的通知
+type TxConfirmation struct {
+	SourceKey     string
+	WxID          string
+	TxID          string
+	Coin          openwallet.Coin
+	BlockHeight   uint64
+	BlockHash     string
+	Confirmations uint64 //当前确认数
+	Threshold     uint64 //达到的确认数阈值，被分叉丢弃时为0
+	IsStake       bool   //coinstake或coinbase的收益
+	Mature        bool   //收益满StakeConfirmations个确认后可以花费，非收益交易总是true
+	Dropped       bool   //所在区块被分叉孤立
+}
+
+//ConfirmationNotificationObject 观测者可选实现的确认数通知接口
+type ConfirmationNotificationObject interface {
+
+	//TxConfirmationNotify 交易单的确认数达到阈值，或所在区块被分叉孤立时通知
+	TxConfirmationNotify(confirmation *TxConfirmation) error
+}
+
+//ConfirmationRecord 保存的确认数跟踪状态，重启后继续通知
+type ConfirmationRecord struct {
+	Key         string `storm:"id"` //sourceKey_WxID
+	SourceKey   string
+	WxID        string
+	TxID        string
+	Coin        openwallet.Coin
+	BlockHeight uint64
+	BlockHash   string
+	IsStake     bool
+	Thresholds  []uint64 //未达到的阈值，从小到大
+}
+
+//ConfirmationDAI 确认数跟踪状态的持久化接口，BlockchainDAI同时实现该接口时，跟踪状态与区块头一起保存，
+//否则保存在本地数据库
+type ConfirmationDAI interface {
+	//SaveConfirmationRecords 保存或覆盖跟踪记录
+	SaveConfirmationRecords(symbol string, records []*ConfirmationRecord) error
+	//DeleteConfirmationRecords 删除跟踪记录
+	DeleteConfirmationRecords(symbol string, keys []string) error
+	//GetConfirmationRecords 查询全部跟踪记录
+	GetConfirmationRecords(symbol string) ([]*ConfirmationRecord, error)
+}
+
+//localConfirmationDB 保存在本地数据库的确认数跟踪状态
+type localConfirmationDB struct {
+	dbFile string
+}
+
+func (db *localConfirmationDB) open() (*storm.DB, error) {
+	file.MkdirAll(filepath.Dir(db.dbFile))
+	return storm.Open(db.dbFile)
+}
+
+func (db *localConfirmationDB) SaveConfirmationRecords(symbol string, records []*ConfirmationRecord) error {
+	if len(records) == 0 {
+		return nil
+	}
+	sdb, err := db.open()
+	if err != nil {
+		return err
+	}
+	defer sdb.Close()
+
+	tx, err := sdb.From(symbol).Begin(true)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, r := range records {
+		if err := tx.Save(r); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (db *localConfirmationDB) DeleteConfirmationRecords(symbol string, keys []string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	sdb, err := db.open()
+	if err != nil {
+		return err
+	}
+	defer sdb.Close()
+
+	tx, err := sdb.From(symbol).Begin(true)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, key := range keys {
+		if err := tx.DeleteStruct(&ConfirmationRecord{Key: key}); err != nil && err != storm.ErrNotFound {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (db *localConfirmationDB) GetConfirmationRecords(symbol string) ([]*ConfirmationRecord, error) {
+	sdb, err := db.open()
+	if err != nil {
+		return nil, err
+	}
+	defer sdb.Close()
+
+	var records []*ConfirmationRecord
+	err = sdb.From(symbol).Select(q.True()).Find(&records)
+	if err != nil && err != storm.ErrNotFound {
+		return nil, err
+	}
+	return records, nil
+}
+
+//pendingConfirmation 等待达到确认数阈值的交易单
+type pendingConfirmation struct {
+	TxConfirmation
+	thresholds []uint64 //未达到的阈值，从小到大
+}
+
+//confirmationTracker 跟踪已扫描交易单的确认数
+//跟踪状态在内存中变化，flush时写入store，重启后由load恢复
+type confirmationTracker struct {
+	mu      sync.Mutex
+	pending map[string]*pendingConfirmation
+	dirty   map[string]struct{} //上次保存后变化的记录
+	loaded  bool
+	symbol  string
+	store   func() ConfirmationDAI //为nil时只在内存中跟踪
+}
+
+func newConfirmationTracker(symbol string, store func() ConfirmationDAI) *confirmationTracker {
+	return &confirmationTracker{
+		pending: make(map[string]*pendingConfirmation),
+		dirty:   make(map[string]struct{}),
+		symbol:  symbol,
+		store:   store,
+	}
+}
+
+//load 首次使用前恢复保存的跟踪状态，内存中已有的记录不被覆盖，失败时下次重试
+func (tracker *confirmationTracker) load() error {
+
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+
+	if tracker.loaded || tracker.store == nil {
+		return nil
+	}
+	records, err := tracker.store().GetConfirmationRecords(tracker.symbol)
+	if err != nil {
+		return err
+	}
+	for _, r := range records {
+		if _, exist := tracker.pending[r.Key]; exist {
+			continue
+		}
+		tracker.pending[r.Key] = &pendingConfirmation{
+			TxConfirmation: TxConfirmation{
+				SourceKey:   r.SourceKey,
+				WxID:        r.WxID,
+				TxID:        r.TxID,
+				Coin:        r.Coin,
+				BlockHeight: r.BlockHeight,
+				BlockHash:   r.BlockHash,
+				IsStake:     r.IsStake,
+			},
+			thresholds: r.Thresholds,
+		}
+	}
+	tracker.loaded = true
+	return nil
+}
+
+//flush 保存上次保存后变化的记录，已不再跟踪的记录删除
+func (tracker *confirmationTracker) flush() error {
+
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+
+	if tracker.store == nil || len(tracker.dirty) == 0 {
+		return nil
+	}
+	saved := make([]*ConfirmationRecord, 0)
+	deleted := make([]string, 0)
+	for key := range tracker.dirty {
+		pending, ok := tracker.pending[key]
+		if !ok {
+			deleted = append(deleted, key)
+			continue
+		}
+		saved = append(saved, &ConfirmationRecord{
+			Key:         key,
+			SourceKey:   pending.SourceKey,
+			WxID:        pending.WxID,
+			TxID:        pending.TxID,
+			Coin:        pending.Coin,
+			BlockHeight: pending.BlockHeight,
+			BlockHash:   pending.BlockHash,
+			IsStake:     pending.IsStake,
+			Thresholds:  pending.thresholds,
+		})
+	}
+	store := tracker.store()
+	if err := store.SaveConfirmationRecords(tracker.symbol, saved); err != nil {
+		return err
+	}
+	if err := store.DeleteConfirmationRecords(tracker.symbol, deleted); err != nil {
+		return err
+	}
+	tracker.dirty = make(map[string]struct{})
+	return nil
+}
+
+//track 记录区块中提取的交易单，收益交易单额外跟踪到StakeConfirmations
+func (tracker *confirmationTracker) track(results []ExtractResult, thresholds []uint64) {
+
+	if len(thresholds) == 0 {
+		return
+	}
+
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+
+	add := func(sourceKey string, extractData *openwallet.TxExtractData, isStake bool) {
+		tx := extractData.Transaction
+		if tx == nil || tx.BlockHeight == 0 {
+			return
+		}
+		key := sourceKey + "_" + tx.WxID
+		if _, exist := tracker.pending[key]; exist {
+			return
+		}
+		pending := &pendingConfirmation{
+			TxConfirmation: TxConfirmation{
+				SourceKey:   sourceKey,
+				WxID:        tx.WxID,
+				TxID:        tx.TxID,
+				Coin:        tx.Coin,
+				BlockHeight: tx.BlockHeight,
+				BlockHash:   tx.BlockHash,
+				IsStake:     isStake,
+			},
+			thresholds: thresholds,
+		}
+		if isStake {
+			pending.thresholds = normalizeConfirmationThresholds(append(append([]uint64{}, thresholds...), StakeConfirmations))
+		}
+		tracker.pending[key] = pending
+		tracker.dirty[key] = struct{}{}
+	}
+
+	for _, result := range results {
+		if !result.Success {
+			continue
+		}
+		for sourceKey, extractData := range result.extractData {
+			add(sourceKey, extractData, result.isStake)
+		}
+		for sourceKey, list := range result.extractContractData {
+			for _, extractData := range list {
+				add(sourceKey, extractData, false)
+			}
+		}
+	}
+}
+
+//advance 已扫描到height，返回达到阈值的通知，达到全部阈值的交易单不再跟踪
+func (tracker *confirmationTracker) advance(height uint64) []*TxConfirmation {
+
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+
+	notifications := make([]*TxConfirmation, 0)
+	for key, pending := range tracker.pending {
+		if height < pending.BlockHeight {
+			continue
+		}
+		confirmations := height - pending.BlockHeight + 1
+		for len(pending.thresholds) > 0 && pending.thresholds[0] <= confirmations {
+			notification := pending.TxConfirmation
+			notification.Confirmations = confirmations
+			notification.Threshold = pending.thresholds[0]
+			notification.Mature = !pending.IsStake || confirmations >= StakeConfirmations
+			notifications = append(notifications, &notification)
+			pending.thresholds = pending.thresholds[1:]
+			tracker.dirty[key] = struct{}{}
+		}
+		if len(pending.thresholds) == 0 {
+			delete(tracker.pending, key)
+		}
+	}
+
+	sortConfirmations(notifications)
+	return notifications
+}
+
+//drop 区块被分叉孤立，返回其中交易单的丢弃通知
+func (tracker *confirmationTracker) drop(blockHash string) []*TxConfirmation {
+
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+
+	notifications := make([]*TxConfirmation, 0)
+	for key, pending := range tracker.pending {
+		if pending.BlockHash != blockHash {
+			continue
+		}
+		notification := pending.TxConfirmation
+		notification.Dropped = true
+		notifications = append(notifications, &notification)
+		delete(tracker.pending, key)
+		tracker.dirty[key] = struct{}{}
+	}
+
+	sortConfirmations(notifications)
+	return notifications
+}
+
+//sortConfirmations 按区块高度、阈值和WxID排序，保证通知顺序稳定
+func sortConfirmations(notifications []*TxConfirmation) {
+	sort.Slice(notifications, func(i, j int) bool {
+		a, b := notifications[i], notifications[j]
+		if a.BlockHeight != b.BlockHeight {
+			return a.BlockHeight < b.BlockHeight
+		}
+		if a.Threshold != b.Threshold {
+			return a.Threshold < b.Threshold
+		}
+		if a.WxID != b.WxID {
+			return a.WxID < b.WxID
+		}
+		return a.SourceKey < b.SourceKey
+	})
+}
+
+//normalizeConfirmationThresholds 去除0和重复的阈值，从小到大排列
+func normalizeConfirmationThresholds(thresholds []uint64) []uint64 {
+	sort.Slice(thresholds, func(i, j int) bool { return thresholds[i] < thresholds[j] })
+	normalized := make([]uint64, 0, len(thresholds))
+	for _, threshold := range thresholds {
+		if threshold == 0 || (len(normalized) > 0 && normalized[len(normalized)-1] == threshold) {
+			continue
+		}
+		normalized = append(normalized, threshold)
+	}
+	return normalized
+}
+
+//parseConfirmationThresholds 解析确认数阈值，格式：1,6,30,500
+func parseConfirmationThresholds(value string) ([]uint64, error) {
+	thresholds := make([]uint64, 0)
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if len(item) == 0 {
+			continue
+		}
+		threshold, err := strconv.ParseUint(item, 10, 64)
+		if err != nil || threshold == 0 {
+			return nil, fmt.Errorf("invalid confirmation threshold: %s", item)
+		}
+		thresholds = append(thresholds, threshold)
+	}
+	return normalizeConfirmationThresholds(thresholds), nil
+}
+
+//SetConfirmationThresholds 设置交易单确认数通知的阈值，为空时不跟踪确认数
+func (bs *BTCBlockScanner) SetConfirmationThresholds(thresholds ...uint64) {
+	bs.wm.config.ConfirmationThresholds = normalizeConfirmationThresholds(append([]uint64{}, thresholds...))
+}
+
+//confirmationStore 优先使用BlockchainDAI保存确认数跟踪状态
+func (bs *BTCBlockScanner) confirmationStore() ConfirmationDAI {
+	if store, ok := bs.BlockchainDAI.(ConfirmationDAI); ok {
+		return store
+	}
+	return &localConfirmationDB{dbFile: filepath.Join(bs.wm.config.dbPath, confirmationDBFile)}
+}
+
+//trackConfirmations 跟踪新区块的交易单，通知达到确认数阈值的交易单后保存跟踪状态
+//通知后、保存前中断时，重启后可能重复通知同一阈值
+func (bs *BTCBlockScanner) trackConfirmations(height uint64, results []ExtractResult) {
+
+	thresholds := bs.wm.config.ConfirmationThresholds
+	if len(thresholds) == 0 {
+		return
+	}
+	if err := bs.confirmations.load(); err != nil {
+		bs.wm.Log.Std.Error("block scanner can not load confirmation records; unexpected error: %v", err)
+	}
+	bs.confirmations.track(results, thresholds)
+	bs.notifyConfirmations(bs.confirmations.advance(height))
+	if err := bs.confirmations.flush(); err != nil {
+		bs.wm.Log.Std.Error("block scanner can not save confirmation records; unexpected error: %v", err)
+	}
+}
+
+//dropConfirmations 通知孤立区块中未达到全部确认数阈值的交易单，不再跟踪
+func (bs *BTCBlockScanner) dropConfirmations(blockHash string) {
+
+	if len(bs.wm.config.ConfirmationThresholds) == 0 {
+		return
+	}
+	if err := bs.confirmations.load(); err != nil {
+		bs.wm.Log.Std.Error("block scanner can not load confirmation records; unexpected error: %v", err)
+	}
+	bs.notifyConfirmations(bs.confirmations.drop(blockHash))
+	if err := bs.confirmations.flush(); err != nil {
+		bs.wm.Log.Std.Error("block scanner can not save confirmation records; unexpected error: %v", err)
+	}
+}
+
+//notifyConfirmations 通知实现了ConfirmationNotificationObject的观测者
+func (bs *BTCBlockScanner) notifyConfirmations(notifications []*TxConfirmation) {
+	if len(notifications) == 0 {
+		return
+	}
+	for o := range bs.Observers {
+		observer, ok := o.(ConfirmationNotificationObject)
+		if !ok {
+			continue
+		}
+		for _, notification := range notifications {
+			if err := observer.TxConfirmationNotify(notification); err != nil {
+				bs.wm.Log.Std.Error("TxConfirmationNotify unexpected error: %v", err)
+			}
+		}
+	}
+}
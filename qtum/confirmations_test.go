@@ -0,0 +1,216 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package qtum
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/blocktree/openwallet/openwallet"
+)
+
+//testConfirmationObserver 记录确认数通知
+type testConfirmationObserver struct {
+	testScanObserver
+	cmu           sync.Mutex
+	confirmations []*TxConfirmation
+}
+
+func (o *testConfirmationObserver) TxConfirmationNotify(confirmation *TxConfirmation) error {
+	o.cmu.Lock()
+	defer o.cmu.Unlock()
+	o.confirmations = append(o.confirmations, confirmation)
+	return nil
+}
+
+//count 统计达到阈值或被丢弃的通知数量
+func (o *testConfirmationObserver) count(threshold uint64, dropped bool) int {
+	o.cmu.Lock()
+	defer o.cmu.Unlock()
+	n := 0
+	for _, c := range o.confirmations {
+		if c.Threshold == threshold && c.Dropped == dropped {
+			n++
+		}
+	}
+	return n
+}
+
+func TestParseConfirmationThresholds(t *testing.T) {
+	thresholds, err := parseConfirmationThresholds(" 30,6, 1,500,6")
+	if err != nil || fmt.Sprint(thresholds) != "[1 6 30 500]" {
+		t.Errorf("thresholds = %v, err = %v; want [1 6 30 500]", thresholds, err)
+	}
+	if _, err := parseConfirmationThresholds("1,0"); err == nil {
+		t.Errorf("zero threshold should be rejected")
+	}
+	if _, err := parseConfirmationThresholds("1,six"); err == nil {
+		t.Errorf("invalid threshold should be rejected")
+	}
+}
+
+//testConfirmationResult 观测地址在height区块中的一笔交易单
+func testConfirmationResult(txid string, height uint64, isStake bool) ExtractResult {
+	tx := &openwallet.Transaction{TxID: txid, WxID: "wx_" + txid, BlockHeight: height, BlockHash: fmt.Sprintf("h%d", height)}
+	return ExtractResult{
+		extractData: map[string]*openwallet.TxExtractData{"account": {Transaction: tx}},
+		isStake:     isStake,
+		Success:     true,
+	}
+}
+
+func TestConfirmationTracker(t *testing.T) {
+
+	result := testConfirmationResult
+	tracker := newConfirmationTracker("QTUM", nil)
+	tracker.track([]ExtractResult{result("deposit", 100, false), result("stake", 100, true)}, []uint64{1, 6})
+	tracker.track([]ExtractResult{result("later", 101, false)}, []uint64{1, 6})
+
+	notifications := tracker.advance(101)
+	if len(notifications) != 3 || notifications[0].TxID != "deposit" || notifications[2].TxID != "later" {
+		t.Fatalf("notifications at 101 = %d, want deposit, stake and later", len(notifications))
+	}
+	if notifications[0].Confirmations != 2 || !notifications[0].Mature || notifications[1].Mature {
+		t.Errorf("deposit = %+v, stake = %+v", notifications[0], notifications[1])
+	}
+
+	//存款达到全部阈值后不再跟踪，收益继续跟踪到成熟
+	notifications = tracker.advance(105)
+	if len(notifications) != 2 || notifications[0].Threshold != 6 || notifications[1].Threshold != 6 {
+		t.Fatalf("notifications at 105 = %d, want 2 at threshold 6", len(notifications))
+	}
+	notifications = tracker.advance(100 + StakeConfirmations - 1)
+	if len(notifications) != 2 || notifications[0].TxID != "stake" || !notifications[0].Mature || notifications[0].Threshold != StakeConfirmations {
+		t.Fatalf("notifications at maturity = %d, want mature stake", len(notifications))
+	}
+
+	//孤立区块中仍在跟踪的交易单被丢弃
+	tracker.track([]ExtractResult{result("orphan", 700, false)}, []uint64{1, 6})
+	tracker.advance(700)
+	notifications = tracker.drop("h700")
+	if len(notifications) != 1 || notifications[0].TxID != "orphan" || !notifications[0].Dropped {
+		t.Fatalf("dropped notifications = %d, want orphan", len(notifications))
+	}
+	if len(tracker.pending) != 0 {
+		t.Errorf("pending = %d, want 0", len(tracker.pending))
+	}
+}
+
+func TestConfirmationTrackerRestore(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "confirmations")
+	if err != nil {
+		t.Fatalf("create temp dir failed: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	store := func() ConfirmationDAI {
+		return &localConfirmationDB{dbFile: filepath.Join(dir, confirmationDBFile)}
+	}
+
+	tracker := newConfirmationTracker("QTUM", store)
+	if err := tracker.load(); err != nil {
+		t.Fatalf("load failed: %v", err)
+	}
+	tracker.track([]ExtractResult{testConfirmationResult("deposit", 100, false), testConfirmationResult("orphan", 101, false)}, []uint64{1, 6})
+	if notifications := tracker.advance(101); len(notifications) != 2 {
+		t.Fatalf("notifications at 101 = %d, want 2", len(notifications))
+	}
+	if err := tracker.flush(); err != nil {
+		t.Fatalf("flush failed: %v", err)
+	}
+
+	//重启后从保存的状态继续通知，已达到的阈值不再通知
+	restored := newConfirmationTracker("QTUM", store)
+	if err := restored.load(); err != nil {
+		t.Fatalf("load failed: %v", err)
+	}
+	notifications := restored.advance(105)
+	if len(notifications) != 1 || notifications[0].TxID != "deposit" || notifications[0].Threshold != 6 {
+		t.Fatalf("notifications after restart = %+v", notifications)
+	}
+	if notifications = restored.drop("h101"); len(notifications) != 1 || !notifications[0].Dropped {
+		t.Fatalf("dropped notifications after restart = %+v", notifications)
+	}
+	if err := restored.flush(); err != nil {
+		t.Fatalf("flush failed: %v", err)
+	}
+
+	//不再跟踪的记录已删除
+	again := newConfirmationTracker("QTUM", store)
+	if err := again.load(); err != nil || len(again.pending) != 0 {
+		t.Errorf("pending after reload = %d, err = %v; want 0", len(again.pending), err)
+	}
+}
+
+func TestScanBlockTaskConfirmations(t *testing.T) {
+
+	chain := newFakeChain()
+	chain.extend("a", 0, 20)
+
+	dir, err := ioutil.TempDir("", "confirmations")
+	if err != nil {
+		t.Fatalf("create temp dir failed: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	wm := NewWalletManager()
+	wm.config.dbPath = dir
+	bs := wm.blockscanner
+	bs.chain = chain
+	bs.SetBlockScanAddressFunc(testScanAddress("a"))
+	bs.SetBlockchainDAI(&fakeBlockchainDAI{
+		heads:  []*openwallet.BlockHeader{{Height: 1, Hash: "a1"}},
+		blocks: make(map[uint64]*openwallet.BlockHeader),
+	})
+	bs.SetConfirmationThresholds(6, 1)
+	observer := &testConfirmationObserver{}
+	bs.AddObserver(observer)
+	bs.Scanning = true
+
+	bs.ScanBlockTask()
+
+	//高度2到20的区块各有一笔coinbase，高度2到15达到6个确认
+	if n := observer.count(1, false); n != 19 {
+		t.Errorf("threshold 1 notifications = %d, want 19", n)
+	}
+	if n := observer.count(6, false); n != 14 {
+		t.Errorf("threshold 6 notifications = %d, want 14", n)
+	}
+
+	//高度15之后的区块被替换，收益仍在等待成熟，孤立区块中的6笔全部丢弃
+	chain.extend("b", 15, 22)
+	bs.ScanBlockTask()
+
+	if n := observer.count(0, true); n != 6 {
+		t.Errorf("dropped notifications = %d, want 6", n)
+	}
+	if n := observer.count(1, false); n != 19+8 {
+		t.Errorf("threshold 1 notifications = %d, want 27", n)
+	}
+	if n := observer.count(6, false); n != 14+3 {
+		t.Errorf("threshold 6 notifications = %d, want 17", n)
+	}
+	observer.cmu.Lock()
+	defer observer.cmu.Unlock()
+	for _, c := range observer.confirmations {
+		if !c.IsStake || c.Mature {
+			t.Errorf("confirmation %s = stake %v, mature %v; want immature stake", c.TxID, c.IsStake, c.Mature)
+		}
+	}
+}
@@ -0,0 +1,175 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package qtum
+
+import (
+	"fmt"
+
+	"github.com/Assetsadapter/qtum-adapter/qtum/btcLikeTxDriver"
+	"github.com/blocktree/openwallet/openwallet"
+	"github.com/shopspring/decimal"
+)
+
+//CreateCPFPRawTransaction 子交易为父交易加速（CPFP）
+//花费未确认交易txid中属于账户的输出vout，子交易支付足够的手续费，使父子交易整体达到目标费率。
+//rawTx提供账户和目标费率（FeeRate为空时使用预估费率），子交易的输出返回到被花费输出的地址
+func (decoder *TransactionDecoder) CreateCPFPRawTransaction(wrapper openwallet.WalletDAI, rawTx *openwallet.RawTransaction, txid string, vout uint64) error {
+
+	var (
+		feeRate decimal.Decimal
+		err     error
+	)
+
+	if rawTx.Account == nil {
+		return fmt.Errorf("the raw transaction account is empty")
+	}
+
+	parent, err := decoder.wm.GetTransaction(txid)
+	if err != nil {
+		return err
+	}
+	if parent.BlockHeight > 0 || parent.Confirmations > 0 {
+		return openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "transaction %s is already confirmed", txid)
+	}
+	if vout >= uint64(len(parent.Vouts)) {
+		return fmt.Errorf("can not find output %s:%d", txid, vout)
+	}
+
+	address := parent.Vouts[vout].Addr
+	if !decoder.isAccountAddress(wrapper, rawTx.Account.AccountID, address) {
+		return openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "output %s:%d does not belong to the account", txid, vout)
+	}
+
+	//未确认的输出需要包含0确认的utxo
+	unspents, err := decoder.wm.ListUnspent(0, address)
+	if err != nil {
+		return openwallet.Errorf(openwallet.ErrCallFullNodeAPIFailed, err.Error())
+	}
+
+	var parentUTXO *Unspent
+	for _, u := range unspents {
+		if u.TxID == txid && u.Vout == vout {
+			parentUTXO = u
+			break
+		}
+	}
+	if parentUTXO == nil {
+		return openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "output %s:%d is spent or not found", txid, vout)
+	}
+
+	feeRate, err = decoder.rawTransactionFeeRate(rawTx)
+	if err != nil {
+		return err
+	}
+
+	parentFees, err := decoder.transactionFees(parent)
+	if err != nil {
+		return err
+	}
+
+	//父交易按虚拟大小计算费率，节点或浏览器没有返回时从原始交易计算
+	parentSize := parent.VSize
+	if parentSize == 0 {
+		rawHex, err := decoder.wm.GetRawTransactionHex(txid)
+		if err != nil {
+			return err
+		}
+		parentSize, err = btcLikeTxDriver.RawTransactionVSize(rawHex)
+		if err != nil {
+			return err
+		}
+	}
+
+	childFees, err := cpfpChildFees(decoder.wm, parentSize, parentFees, 1, feeRate)
+	if err != nil {
+		return err
+	}
+
+	amount, _ := decimal.NewFromString(parentUTXO.Amount)
+	if amount.LessThanOrEqual(childFees) {
+		return openwallet.Errorf(openwallet.ErrInsufficientFees, "output %s:%d amount %s is not enough to pay the fees %s", txid, vout, amount.String(), childFees.String())
+	}
+
+	decoder.wm.Log.Std.Notice("-----------------------------------------------")
+	decoder.wm.Log.Std.Notice("Accelerate Transaction: %s", txid)
+	decoder.wm.Log.Std.Notice("Parent Size: %d, Fees: %v", parentSize, parentFees.StringFixed(decoder.wm.Decimal()))
+	decoder.wm.Log.Std.Notice("Target Fee Rate: %v", feeRate.StringFixed(decoder.wm.Decimal()))
+	decoder.wm.Log.Std.Notice("Child Fees: %v", childFees.StringFixed(decoder.wm.Decimal()))
+	decoder.wm.Log.Std.Notice("-----------------------------------------------")
+
+	rawTx.FeeRate = feeRate.StringFixed(decoder.wm.Decimal())
+	rawTx.Fees = childFees.StringFixed(decoder.wm.Decimal())
+	rawTx.SetExtParam("cpfpParentTxID", txid)
+
+	outputAddrs := map[string]decimal.Decimal{address: amount.Sub(childFees)}
+
+	//子交易的手续费包含为父交易补足的部分，不按自身大小调整
+	return decoder.createSimpleRawTransaction(wrapper, rawTx, []*Unspent{parentUTXO}, outputAddrs, "")
+}
+
+//cpfpChildFees 计算子交易的手续费：父子交易整体按目标费率所需的手续费减去父交易已付的手续费，
+//且不低于子交易自身按目标费率所需的手续费
+func cpfpChildFees(wm *WalletManager, parentSize uint64, parentFees decimal.Decimal, inputs int64, feeRate decimal.Decimal) (decimal.Decimal, error) {
+
+	childFees, err := wm.EstimateFee(inputs, 1, feeRate)
+	if err != nil {
+		return decimal.Zero, err
+	}
+
+	parentRequired := decimal.New(int64(parentSize), 0).Div(decimal.New(1000, 0)).Mul(feeRate)
+	packageFees := parentRequired.Add(childFees).Sub(parentFees).Round(wm.Decimal())
+
+	if packageFees.GreaterThan(childFees) {
+		childFees = packageFees
+	}
+
+	return childFees, nil
+}
+
+//transactionFees 交易单的手续费，没有记录时按输入和输出计算
+func (decoder *TransactionDecoder) transactionFees(tx *Transaction) (decimal.Decimal, error) {
+
+	if len(tx.Fees) > 0 {
+		fees, err := decimal.NewFromString(tx.Fees)
+		if err == nil {
+			return fees, nil
+		}
+	}
+
+	totalIn := decimal.Zero
+	for _, vin := range tx.Vins {
+		if len(vin.Coinbase) > 0 {
+			return decimal.Zero, nil
+		}
+		prevTx, err := decoder.wm.GetTransaction(vin.TxID)
+		if err != nil {
+			return decimal.Zero, err
+		}
+		if vin.Vout >= uint64(len(prevTx.Vouts)) {
+			return decimal.Zero, fmt.Errorf("can not find output %s:%d", vin.TxID, vin.Vout)
+		}
+		amount, _ := decimal.NewFromString(prevTx.Vouts[vin.Vout].Value)
+		totalIn = totalIn.Add(amount)
+	}
+
+	totalOut := decimal.Zero
+	for _, out := range tx.Vouts {
+		amount, _ := decimal.NewFromString(out.Value)
+		totalOut = totalOut.Add(amount)
+	}
+
+	return totalIn.Sub(totalOut), nil
+}
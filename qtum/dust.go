@@ -0,0 +1,71 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package qtum
+
+import (
+	"github.com/Assetsadapter/qtum-adapter/qtum/btcLikeTxDriver"
+	"github.com/blocktree/openwallet/openwallet"
+	"github.com/shopspring/decimal"
+)
+
+//dustThreshold 向地址输出的粉尘阈值，由地址的脚本类型和配置的粉尘费率决定
+func (decoder *TransactionDecoder) dustThreshold(address string) (decimal.Decimal, error) {
+	dustRelayFee := uint64(decoder.wm.config.DustRelayFee.Shift(decoder.wm.Decimal()).IntPart())
+	threshold, err := btcLikeTxDriver.OutputDustThreshold(address, decoder.wm.config.isTestNet, dustRelayFee)
+	if err != nil {
+		return decimal.Zero, openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "invalid address %s: %v", address, err)
+	}
+	return decimal.New(int64(threshold), -decoder.wm.Decimal()), nil
+}
+
+//isDust 输出金额是否低于粉尘阈值
+func (decoder *TransactionDecoder) isDust(address string, amount decimal.Decimal) (bool, decimal.Decimal, error) {
+	threshold, err := decoder.dustThreshold(address)
+	if err != nil {
+		return false, decimal.Zero, err
+	}
+	return amount.LessThan(threshold), threshold, nil
+}
+
+//checkDustOutputs 检查每个输出是否低于其脚本类型的粉尘阈值
+func (decoder *TransactionDecoder) checkDustOutputs(to map[string]decimal.Decimal) error {
+	for addr, amount := range to {
+		dust, threshold, err := decoder.isDust(addr, amount)
+		if err != nil {
+			return err
+		}
+		if dust {
+			return openwallet.Errorf(openwallet.ErrDustLimit, "the amount %s sent to %s is below the dust threshold %s", amount.StringFixed(decoder.wm.Decimal()), addr, threshold.StringFixed(decoder.wm.Decimal()))
+		}
+	}
+	return nil
+}
+
+//isUneconomicalToSpend 花费这些utxo的手续费是否不低于其总金额，按每个P2PKH输入148字节估算
+func isUneconomicalToSpend(unspents []*Unspent, feeRate decimal.Decimal) bool {
+	total := decimal.Zero
+	count := int64(0)
+	for _, u := range unspents {
+		if !u.Spendable {
+			continue
+		}
+		amount, _ := decimal.NewFromString(u.Amount)
+		total = total.Add(amount)
+		count++
+	}
+	inputFees := feeRate.Mul(decimal.New(148, -3)).Mul(decimal.New(count, 0))
+	return total.LessThanOrEqual(inputFees)
+}
@@ -0,0 +1,55 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package qtum
+
+import (
+	"testing"
+
+	"github.com/blocktree/openwallet/openwallet"
+	"github.com/shopspring/decimal"
+)
+
+func TestDustThreshold(t *testing.T) {
+	decoder := NewTransactionDecoder(NewWalletManager())
+	address := "qHdSjkNTqSF3sVmiMpzU7ujSgZ9EobiTki"
+
+	//P2PKH：(34 + 148) * 0.004 / 1000
+	threshold, err := decoder.dustThreshold(address)
+	if err != nil || !threshold.Equal(decimal.RequireFromString("0.000728")) {
+		t.Errorf("dust threshold = %s, want 0.000728: %v", threshold.String(), err)
+	}
+
+	err = decoder.checkDustOutputs(map[string]decimal.Decimal{address: decimal.RequireFromString("0.000728")})
+	if err != nil {
+		t.Errorf("output equal to the dust threshold should pass: %v", err)
+	}
+	err = decoder.checkDustOutputs(map[string]decimal.Decimal{address: decimal.RequireFromString("0.000727")})
+	if e, ok := err.(*openwallet.Error); !ok || e.Code() != openwallet.ErrDustLimit {
+		t.Errorf("output below the dust threshold should fail with ErrDustLimit, got %v", err)
+	}
+}
+
+func TestIsUneconomicalToSpend(t *testing.T) {
+	feeRate := decimal.RequireFromString("0.004")
+
+	//每个输入0.000592
+	if !isUneconomicalToSpend(testUnspents("a:0.0005", "a:0.0006"), feeRate) {
+		t.Errorf("utxo of 0.0011 cost 0.001184 to spend")
+	}
+	if isUneconomicalToSpend(testUnspents("a:0.0005", "a:0.0007"), feeRate) {
+		t.Errorf("utxo of 0.0012 cost 0.001184 to spend")
+	}
+}
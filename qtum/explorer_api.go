@@ -24,6 +24,7 @@ import (
 	"github.com/shopspring/decimal"
 	"github.com/tidwall/gjson"
 	"net/http"
+	"strconv"
 	"strings"
 )
 
@@ -87,6 +88,16 @@ func (b *Explorer) Call(path string, request interface{}, method string) (*gjson
 	return &resp, nil
 }
 
+//explorerError 浏览器返回的错误状态
+type explorerError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *explorerError) Error() string {
+	return e.Message
+}
+
 //isError 是否报错
 func (b *Explorer) isError(resp *req.Resp) error {
 
@@ -95,7 +106,7 @@ func (b *Explorer) isError(resp *req.Resp) error {
 	}
 
 	if resp.Response().StatusCode != http.StatusOK {
-		return fmt.Errorf("%s", resp.String())
+		return &explorerError{StatusCode: resp.Response().StatusCode, Message: resp.String()}
 	}
 
 	return nil
@@ -155,6 +166,9 @@ func (wm *WalletManager) getTransactionByExplorer(txid string) (*Transaction, er
 
 	result, err := wm.ExplorerClient.Call(path, nil, "GET")
 	if err != nil {
+		if e, ok := err.(*explorerError); ok && e.StatusCode == http.StatusNotFound {
+			return nil, ErrTransactionNotFound
+		}
 		return nil, err
 	}
 
@@ -164,6 +178,19 @@ func (wm *WalletManager) getTransactionByExplorer(txid string) (*Transaction, er
 
 }
 
+//getRawTransactionHexByExplorer 获取交易单原始数据
+func (wm *WalletManager) getRawTransactionHexByExplorer(txid string) (string, error) {
+
+	path := fmt.Sprintf("rawtx/%s", txid)
+
+	result, err := wm.ExplorerClient.Call(path, nil, "GET")
+	if err != nil {
+		return "", err
+	}
+
+	return result.Get("rawtx").String(), nil
+}
+
 //listUnspentByExplorer 获取未花交易
 func (wm *WalletManager) listUnspentByExplorer(address ...string) ([]*Unspent, error) {
 
@@ -278,6 +305,7 @@ func (wm *WalletManager) newTxByExplorer(json *gjson.Result, isTestnet bool) *Tr
 	obj.Confirmations = gjson.Get(json.Raw, "confirmations").Uint()
 	obj.Blocktime = gjson.Get(json.Raw, "timestamp").Int()
 	obj.Size = gjson.Get(json.Raw, "size").Uint()
+	obj.VSize = parseVSize(json, obj.Size)
 	fees, _ := decimal.NewFromString(gjson.Get(json.Raw, "fees").String())
 	obj.Fees = fees.Shift(-wm.Decimal()).String()
 	obj.IsCoinBase = gjson.Get(json.Raw, "isCoinbase").Bool()
@@ -304,14 +332,20 @@ func (wm *WalletManager) newTxByExplorer(json *gjson.Result, isTestnet bool) *Tr
 	obj.TokenReceipts = make([]*TokenReceipt, 0)
 	if receipts := gjson.Get(json.Raw, "qrc20TokenTransfers"); receipts.IsArray() {
 		obj.Isqrc20Transfer = true
-		for _, receipt := range receipts.Array() {
-			token := newTokenReceiptByExplorer(&receipt, isTestnet)
+		logIndexes := transferLogIndexesByExplorer(gjson.Get(json.Raw, "outputs"))
+		for i, receipt := range receipts.Array() {
+			token := newTokenReceiptByExplorer(&receipt, isTestnet, wm.config.ExplorerTokenAmountScaled)
+			token.LogIndex = uint64(i)
+			if len(logIndexes) == len(receipts.Array()) {
+				token.LogIndex = logIndexes[i]
+			}
 			token.TxHash = obj.TxID
 			token.BlockHash = obj.BlockHash
 			token.BlockHeight = obj.BlockHeight
 			obj.TokenReceipts = append(obj.TokenReceipts, token)
 		}
 	}
+	obj.appendFailedTokenReceipts(isTestnet)
 
 	return &obj
 }
@@ -344,20 +378,57 @@ func (wm *WalletManager) newTxVoutByExplorer(json *gjson.Result) *Vout {
 	obj.Addr = gjson.Get(json.Raw, "address").String()
 	obj.Type = gjson.Get(json.Raw, "scriptPubKey.type").String()
 
+	//合约调用输出附带执行回执
+	if receipt := gjson.Get(json.Raw, "receipt"); receipt.IsObject() {
+		obj.Execution = &ContractExecution{
+			Sender:   receipt.Get("sender").String(),
+			GasUsed:  receipt.Get("gasUsed").Uint(),
+			Excepted: receipt.Get("excepted").String(),
+		}
+	}
+
 	return &obj
 }
 
-//newTokenReceiptByExplorer
-func newTokenReceiptByExplorer(json *gjson.Result, isTestnet bool) *TokenReceipt {
+//transferLogIndexesByExplorer 合约调用输出回执中Transfer事件在交易单全部日志中的序号，与节点回执的计数一致
+//浏览器没有返回日志时为空，代币转账记录按顺序编号
+func transferLogIndexesByExplorer(outputs gjson.Result) []uint64 {
+	indexes := make([]uint64, 0)
+	logIndex := uint64(0)
+	for _, output := range outputs.Array() {
+		for _, logInfo := range output.Get("receipt.logs").Array() {
+			if _, ok := unpackTransferLog(&logInfo); ok {
+				indexes = append(indexes, logIndex)
+			}
+			logIndex++
+		}
+	}
+	return indexes
+}
+
+//newTokenReceiptByExplorer 解析浏览器的代币转账记录，amountScaled为浏览器返回已按精度调整的数值
+func newTokenReceiptByExplorer(json *gjson.Result, isTestnet bool, amountScaled bool) *TokenReceipt {
 
 	obj := TokenReceipt{}
 	//解析json
 
 	obj.From = gjson.Get(json.Raw, "from").String()
 	obj.To = gjson.Get(json.Raw, "to").String()
-	obj.Amount = gjson.Get(json.Raw, "value").String()
 	obj.ContractAddress = "0x" + gjson.Get(json.Raw, "addressHex").String()
 
+	//不同版本的浏览器返回原始整数或已按精度调整的数值，由配置explorerTokenAmountScaled指定
+	value := gjson.Get(json.Raw, "value").String()
+	if amountScaled {
+		obj.Amount = value
+	} else {
+		obj.RawAmount = value
+		obj.Amount = value
+	}
+	//浏览器返回的精度只在合约查询失败时使用
+	if decimals := gjson.Get(json.Raw, "decimals"); decimals.Exists() {
+		obj.reportedDecimals = decimals.Uint()
+		obj.hasReportedDecimals = true
+	}
 
 	//obj.BlockHash = gjson.Get(json.Raw, "blockHash").String()
 	//obj.BlockHeight = gjson.Get(json.Raw, "blockNumber").Uint()
@@ -534,12 +605,22 @@ func (wm *WalletManager) getMultiAddrTransactionsByExplorer(offset, limit int, a
 	return trxs, nil
 }
 
-//estimateFeeRateByExplorer 通过浏览器获取费率
-func (wm *WalletManager) estimateFeeRateByExplorer() (decimal.Decimal, error) {
+//estimateFeeRateByExplorer 通过浏览器获取在blocks个区块内确认的费率，浏览器不支持时使用info的费率
+func (wm *WalletManager) estimateFeeRateByExplorer(blocks int64) (decimal.Decimal, error) {
 
-	path := "info"
+	path := fmt.Sprintf("utils/estimatefee?nbBlocks=%d", blocks)
 
 	result, err := wm.ExplorerClient.Call(path, nil, "GET")
+	if err == nil {
+		feeRate, _ := decimal.NewFromString(result.Get(strconv.FormatInt(blocks, 10)).String())
+		if feeRate.IsPositive() {
+			return feeRate, nil
+		}
+	}
+
+	path = "info"
+
+	result, err = wm.ExplorerClient.Call(path, nil, "GET")
 	if err != nil {
 		return decimal.New(0, 0), err
 	}
@@ -620,3 +701,23 @@ func (wm *WalletManager) getAddressTokenBalanceByExplorer(token openwallet.Smart
 	return decimal.New(0, 0), nil
 
 }
+
+//getQRC20TokenByExplorer 通过浏览器查询代币合约信息
+func (wm *WalletManager) getQRC20TokenByExplorer(contractAddress string) (*QRC20Token, error) {
+
+	trimContractAddr := strings.TrimPrefix(contractAddress, "0x")
+
+	path := fmt.Sprintf("qrc20/%s", trimContractAddr)
+
+	result, err := wm.ExplorerClient.Call(path, nil, "GET")
+	if err != nil {
+		return nil, err
+	}
+
+	token := newQRC20TokenByExplorer(result)
+	if len(strings.TrimPrefix(token.Address, "0x")) == 0 {
+		return nil, &explorerError{StatusCode: http.StatusNotFound, Message: fmt.Sprintf("token contract[%s] not found", contractAddress)}
+	}
+
+	return token, nil
+}
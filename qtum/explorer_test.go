@@ -23,6 +23,7 @@ import (
 	"github.com/blocktree/go-owcdrivers/addressEncoder"
 	"github.com/graarh/golang-socketio"
 	"github.com/graarh/golang-socketio/transport"
+	"github.com/tidwall/gjson"
 	"net/url"
 	"testing"
 )
@@ -145,7 +146,7 @@ func TestSocketIO(t *testing.T) {
 }
 
 func TestEstimateFeeRateByExplorer(t *testing.T) {
-	feeRate, _ := tw.estimateFeeRateByExplorer()
+	feeRate, _ := tw.estimateFeeRateByExplorer(6)
 	t.Logf("EstimateFee feeRate = %s\n", feeRate.StringFixed(8))
 	fees, _ := tw.EstimateFee(10, 2, feeRate)
 	t.Logf("EstimateFee fees = %s\n", fees.StringFixed(8))
@@ -190,4 +191,37 @@ func TestGetAddressTokenBalanceByExplorer(t *testing.T) {
 		return
 	}
 	t.Logf("getAddressTokenBalanceByExplorer = %v \n", raw)
-}
\ No newline at end of file
+}
+
+func TestNewTokenReceiptByExplorer(t *testing.T) {
+	tests := []struct {
+		raw       string
+		scaled    bool
+		amount    string
+		rawAmount string
+	}{
+		//原始整数，按合约精度调整
+		{`{"addressHex":"f2033ede578e17fa6231047265010445bca8cf1c","decimals":8,"value":"123450000"}`, false, "1.2345", "123450000"},
+		//已调整的数值
+		{`{"addressHex":"f2033ede578e17fa6231047265010445bca8cf1c","decimals":8,"value":"1.2345"}`, true, "1.2345", "123450000"},
+		//已调整的整数数值不能当作原始整数
+		{`{"addressHex":"f2033ede578e17fa6231047265010445bca8cf1c","decimals":8,"value":"100"}`, true, "100", "10000000000"},
+		//浏览器没有返回精度
+		{`{"addressHex":"f2033ede578e17fa6231047265010445bca8cf1c","value":"123450000"}`, false, "1.2345", "123450000"},
+	}
+	for i, test := range tests {
+		json := gjson.Parse(test.raw)
+		receipt := newTokenReceiptByExplorer(&json, true, test.scaled)
+		//扫描器以合约查询的精度为准
+		receipt.ResolveAmount(8)
+		if receipt.Amount != test.amount || receipt.RawAmount != test.rawAmount {
+			t.Errorf("case %d: receipt = %+v", i, receipt)
+		}
+	}
+
+	//合约查询失败时使用浏览器返回的精度
+	json := gjson.Parse(`{"addressHex":"f2033ede578e17fa6231047265010445bca8cf1c","decimals":6,"value":"1000000"}`)
+	if decimals, ok := newTokenReceiptByExplorer(&json, true, false).ReportedDecimals(); !ok || decimals != 6 {
+		t.Errorf("reported decimals = %d, %v; want 6", decimals, ok)
+	}
+}
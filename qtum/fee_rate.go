@@ -0,0 +1,285 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package qtum
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/blocktree/openwallet/openwallet"
+	"github.com/shopspring/decimal"
+)
+
+const (
+	//FeeTargetFast 快速确认
+	FeeTargetFast = "fast"
+	//FeeTargetNormal 正常确认
+	FeeTargetNormal = "normal"
+	//FeeTargetSlow 慢速确认
+	FeeTargetSlow = "slow"
+)
+
+const (
+	//FeeRateSourceNode 节点estimatesmartfee
+	FeeRateSourceNode = "node"
+	//FeeRateSourceExplorer 浏览器estimatefee
+	FeeRateSourceExplorer = "explorer"
+	//FeeRateSourceBlocks 已扫描区块中交易单费率的分位数
+	FeeRateSourceBlocks = "blocks"
+	//FeeRateSourceStatic 配置文件的固定费率
+	FeeRateSourceStatic = "static"
+)
+
+//feeRateBlocksWindow 统计费率的最近区块数量
+const feeRateBlocksWindow = 12
+
+//FeeRateEstimate 预估的费率及其来源
+type FeeRateEstimate struct {
+	//每KB的费率
+	FeeRate decimal.Decimal
+	//费率来源
+	Source string
+	//确认目标
+	Target string
+	//确认目标的区块数
+	Blocks int64
+}
+
+//blockFeeRates 已扫描区块中交易单的费率样本，只保留最近的区块
+type blockFeeRates struct {
+	mu      sync.Mutex
+	window  int
+	heights []uint64
+	rates   map[uint64][]decimal.Decimal
+}
+
+func newBlockFeeRates(window int) *blockFeeRates {
+	return &blockFeeRates{
+		window:  window,
+		heights: make([]uint64, 0),
+		rates:   make(map[uint64][]decimal.Decimal),
+	}
+}
+
+//add 记录区块中交易单的费率，替换该高度已有的样本，重扫同一区块不会重复计入
+func (b *blockFeeRates) add(height uint64, rates []decimal.Decimal) {
+	if len(rates) == 0 {
+		b.remove(height)
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.rates[height]; !ok {
+		if len(b.heights) >= b.window && height < b.heights[0] {
+			//比统计窗口更旧的区块
+			return
+		}
+		b.heights = append(b.heights, height)
+		sort.Slice(b.heights, func(i, j int) bool { return b.heights[i] < b.heights[j] })
+		for len(b.heights) > b.window {
+			delete(b.rates, b.heights[0])
+			b.heights = b.heights[1:]
+		}
+	}
+	b.rates[height] = rates
+}
+
+//remove 删除分叉区块的样本
+func (b *blockFeeRates) remove(height uint64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.rates[height]; !ok {
+		return
+	}
+	delete(b.rates, height)
+	for i, h := range b.heights {
+		if h == height {
+			b.heights = append(b.heights[:i], b.heights[i+1:]...)
+			break
+		}
+	}
+}
+
+//percentile 最近区块全部样本的分位数，没有样本时返回0
+func (b *blockFeeRates) percentile(p float64) decimal.Decimal {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	samples := make([]decimal.Decimal, 0)
+	for _, rates := range b.rates {
+		samples = append(samples, rates...)
+	}
+	if len(samples) == 0 {
+		return decimal.Zero
+	}
+
+	sort.Slice(samples, func(i, j int) bool { return samples[i].LessThan(samples[j]) })
+	return samples[int(p*float64(len(samples)-1)+0.5)]
+}
+
+//feeTargetPercentile 区块费率统计中确认目标对应的分位数，正常确认为中位数
+func feeTargetPercentile(target string) float64 {
+	switch target {
+	case FeeTargetFast:
+		return 0.75
+	case FeeTargetSlow:
+		return 0.25
+	default:
+		return 0.5
+	}
+}
+
+//feeTargetBlocks 确认目标对应的区块数
+func (wm *WalletManager) feeTargetBlocks(target string) (int64, error) {
+	if blocks, ok := wm.config.FeeTargets[target]; ok {
+		return blocks, nil
+	}
+	return 0, fmt.Errorf("unknown fee target: %s", target)
+}
+
+//EstimateFeeRate 预估的没KB手续费率
+func (wm *WalletManager) EstimateFeeRate() (decimal.Decimal, error) {
+	estimate, err := wm.EstimateFeeRateByTarget(FeeTargetNormal)
+	if err != nil {
+		return decimal.Zero, err
+	}
+	return estimate.FeeRate, nil
+}
+
+//EstimateFeeRateByTarget 按确认目标预估每KB的手续费率，依次尝试配置的费率来源，
+//来源不可用或结果不大于0时使用下一个来源
+func (wm *WalletManager) EstimateFeeRateByTarget(target string) (*FeeRateEstimate, error) {
+
+	if len(target) == 0 {
+		target = FeeTargetNormal
+	}
+
+	blocks, err := wm.feeTargetBlocks(target)
+	if err != nil {
+		return nil, err
+	}
+
+	failures := make([]string, 0)
+	for _, source := range wm.config.FeeRateSources {
+
+		var feeRate decimal.Decimal
+
+		switch source {
+		case FeeRateSourceNode:
+			if wm.walletClient == nil {
+				continue
+			}
+			feeRate, err = wm.estimateFeeRateByCore(blocks)
+		case FeeRateSourceExplorer:
+			if wm.ExplorerClient == nil {
+				continue
+			}
+			feeRate, err = wm.estimateFeeRateByExplorer(blocks)
+		case FeeRateSourceBlocks:
+			feeRate = wm.blockFeeRates.percentile(feeTargetPercentile(target))
+		case FeeRateSourceStatic:
+			feeRate = wm.config.StaticFeeRate
+		default:
+			err = fmt.Errorf("unknown source")
+		}
+
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %v", source, err))
+			err = nil
+			continue
+		}
+		if !feeRate.IsPositive() {
+			continue
+		}
+
+		return &FeeRateEstimate{FeeRate: feeRate, Source: source, Target: target, Blocks: blocks}, nil
+	}
+
+	return nil, openwallet.Errorf(openwallet.ErrCallFullNodeAPIFailed, "no fee rate source is available for target %s [%s]", target, strings.Join(failures, "; "))
+}
+
+//parseFeeRateSources 解析费率来源，格式：node,explorer,blocks,static
+func parseFeeRateSources(value string) ([]string, error) {
+	sources := make([]string, 0)
+	for _, source := range strings.Split(value, ",") {
+		source = strings.ToLower(strings.TrimSpace(source))
+		if len(source) == 0 {
+			continue
+		}
+		switch source {
+		case FeeRateSourceNode, FeeRateSourceExplorer, FeeRateSourceBlocks, FeeRateSourceStatic:
+			sources = append(sources, source)
+		default:
+			return nil, fmt.Errorf("unknown fee rate source: %s", source)
+		}
+	}
+	if len(sources) == 0 {
+		return nil, fmt.Errorf("fee rate sources is empty")
+	}
+	return sources, nil
+}
+
+//blockFeeRate 已确认的普通交易单按虚拟大小计算的费率样本，coinbase、coinstake和合约调用除外
+func (wm *WalletManager) blockFeeRate(trx *Transaction, fees decimal.Decimal) (decimal.Decimal, bool) {
+	if trx.BlockHeight == 0 || trx.VSize == 0 || trx.IsCoinBase || trx.IsCoinstake || trx.hasContractOutput() {
+		return decimal.Zero, false
+	}
+	if !fees.IsPositive() {
+		return decimal.Zero, false
+	}
+	return fees.Mul(decimal.New(1000, 0)).Div(decimal.New(int64(trx.VSize), 0)).Round(wm.Decimal()), true
+}
+
+//feeTarget 交易单扩展参数feeTarget指定的确认目标：fast、normal、slow
+func feeTarget(rawTx *openwallet.RawTransaction) string {
+	return rawTx.GetExtParam().Get("feeTarget").String()
+}
+
+//rawTransactionFeeRate 交易单的费率，没有指定时按确认目标预估，并在扩展参数中记录费率来源
+func (decoder *TransactionDecoder) rawTransactionFeeRate(rawTx *openwallet.RawTransaction) (decimal.Decimal, error) {
+
+	if len(rawTx.FeeRate) > 0 {
+		feeRate, _ := decimal.NewFromString(rawTx.FeeRate)
+		return feeRate, nil
+	}
+
+	estimate, err := decoder.wm.EstimateFeeRateByTarget(feeTarget(rawTx))
+	if err != nil {
+		return decimal.Zero, err
+	}
+
+	rawTx.SetExtParam("feeRateSource", estimate.Source)
+	rawTx.SetExtParam("feeTarget", estimate.Target)
+
+	return estimate.FeeRate, nil
+}
+
+//FeeRateEstimator 交易单解析器额外实现的费率接口
+//openwallet.TransactionDecoder的GetRawTransactionFeeRate只返回费率和单位，调用方断言该接口获取费率来源和确认目标
+type FeeRateEstimator interface {
+	//GetRawTransactionFeeRateByTarget 按确认目标获取费率，同时返回费率来源和确认区块数
+	GetRawTransactionFeeRateByTarget(target string) (*FeeRateEstimate, error)
+}
+
+//GetRawTransactionFeeRateByTarget 按确认目标获取费率，同时返回费率来源和确认区块数
+func (decoder *TransactionDecoder) GetRawTransactionFeeRateByTarget(target string) (*FeeRateEstimate, error) {
+	return decoder.wm.EstimateFeeRateByTarget(target)
+}
@@ -0,0 +1,146 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package qtum
+
+import (
+	"testing"
+
+	"github.com/blocktree/openwallet/openwallet"
+	"github.com/shopspring/decimal"
+	"github.com/tidwall/gjson"
+)
+
+//没有节点和浏览器时依次使用区块统计和固定费率
+func TestEstimateFeeRateByTarget(t *testing.T) {
+	wm := NewWalletManager()
+
+	estimate, err := wm.EstimateFeeRateByTarget(FeeTargetFast)
+	if err != nil {
+		t.Fatalf("EstimateFeeRateByTarget failed: %v", err)
+	}
+	if estimate.Source != FeeRateSourceStatic || !estimate.FeeRate.Equal(wm.config.StaticFeeRate) || estimate.Blocks != 2 {
+		t.Errorf("estimate = %+v, want the static fee rate", estimate)
+	}
+
+	for height, rate := range []string{"0.001", "0.002", "0.003", "0.004", "0.005"} {
+		wm.blockFeeRates.add(uint64(height+100), []decimal.Decimal{decimal.RequireFromString(rate)})
+	}
+
+	tests := map[string]string{FeeTargetFast: "0.004", FeeTargetNormal: "0.003", FeeTargetSlow: "0.002"}
+	for target, want := range tests {
+		estimate, err = wm.EstimateFeeRateByTarget(target)
+		if err != nil {
+			t.Fatalf("EstimateFeeRateByTarget(%s) failed: %v", target, err)
+		}
+		if estimate.Source != FeeRateSourceBlocks || !estimate.FeeRate.Equal(decimal.RequireFromString(want)) {
+			t.Errorf("%s estimate = %s from %s, want %s from blocks", target, estimate.FeeRate.String(), estimate.Source, want)
+		}
+	}
+
+	//分叉区块的样本被删除
+	wm.blockFeeRates.remove(104)
+	wm.blockFeeRates.remove(103)
+	if estimate, _ = wm.EstimateFeeRateByTarget(FeeTargetNormal); !estimate.FeeRate.Equal(decimal.RequireFromString("0.002")) {
+		t.Errorf("estimate after fork = %s, want 0.002", estimate.FeeRate.String())
+	}
+
+	if _, err := wm.EstimateFeeRateByTarget("asap"); err == nil {
+		t.Errorf("unknown target should fail")
+	}
+
+	wm.config.FeeRateSources = []string{FeeRateSourceNode}
+	if _, err := wm.EstimateFeeRateByTarget(FeeTargetNormal); err == nil {
+		t.Errorf("no available source should fail")
+	}
+
+	//交易单记录费率来源
+	wm.config.FeeRateSources = []string{FeeRateSourceNode, FeeRateSourceStatic}
+	rawTx := &openwallet.RawTransaction{ExtParam: `{"feeTarget":"slow"}`}
+	feeRate, err := NewTransactionDecoder(wm).rawTransactionFeeRate(rawTx)
+	if err != nil || !feeRate.Equal(wm.config.StaticFeeRate) {
+		t.Errorf("rawTransactionFeeRate = %s, %v", feeRate.String(), err)
+	}
+	if rawTx.GetExtParam().Get("feeRateSource").String() != FeeRateSourceStatic {
+		t.Errorf("fee rate source is not recorded: %s", rawTx.ExtParam)
+	}
+
+	//通过openwallet接口获取的解析器可以查询费率来源
+	var decoder openwallet.TransactionDecoder = NewTransactionDecoder(wm)
+	estimator, ok := decoder.(FeeRateEstimator)
+	if !ok {
+		t.Fatalf("transaction decoder should implement FeeRateEstimator")
+	}
+	if estimate, err := estimator.GetRawTransactionFeeRateByTarget(FeeTargetFast); err != nil || estimate.Source != FeeRateSourceStatic || estimate.Target != FeeTargetFast {
+		t.Errorf("GetRawTransactionFeeRateByTarget = %+v, %v", estimate, err)
+	}
+}
+
+//统计窗口只保留最近的区块
+func TestBlockFeeRatesWindow(t *testing.T) {
+	rates := newBlockFeeRates(3)
+	for height := uint64(1); height <= 5; height++ {
+		rates.add(height, []decimal.Decimal{decimal.New(int64(height), -3)})
+	}
+	//比窗口更旧的区块被忽略
+	rates.add(1, []decimal.Decimal{decimal.New(1, 0)})
+	//重复提交同一区块时替换样本
+	rates.add(5, []decimal.Decimal{decimal.New(5, -3), decimal.New(6, -3)})
+	rates.add(5, []decimal.Decimal{decimal.New(5, -3), decimal.New(6, -3)})
+	if len(rates.rates[5]) != 2 {
+		t.Errorf("block 5 samples = %v, want 2 samples", rates.rates[5])
+	}
+
+	if len(rates.heights) != 3 || rates.heights[0] != 3 {
+		t.Errorf("heights = %v, want [3 4 5]", rates.heights)
+	}
+	if p := rates.percentile(0); !p.Equal(decimal.New(3, -3)) {
+		t.Errorf("lowest rate = %s, want 0.003", p.String())
+	}
+
+	if _, err := parseFeeRateSources("node, Static"); err != nil {
+		t.Errorf("parseFeeRateSources failed: %v", err)
+	}
+	if _, err := parseFeeRateSources("node,oracle"); err == nil {
+		t.Errorf("unknown source should fail")
+	}
+}
+
+//区块费率样本按虚拟大小计算
+func TestBlockFeeRateVSize(t *testing.T) {
+	wm := NewWalletManager()
+
+	//隔离见证交易：size 222，weight 561，vsize 141
+	raw := gjson.Parse(`{"txid":"t1","size":222,"weight":561,"vin":[],"vout":[]}`)
+	trx := newTxByCore(&raw, true)
+	trx.BlockHeight = 100
+	if trx.VSize != 141 {
+		t.Errorf("vsize = %d, want 141", trx.VSize)
+	}
+	if rate, ok := wm.blockFeeRate(trx, decimal.RequireFromString("0.000564")); !ok || !rate.Equal(decimal.RequireFromString("0.004")) {
+		t.Errorf("fee rate = %s, want 0.004", rate.String())
+	}
+
+	//节点返回的vsize优先
+	raw = gjson.Parse(`{"txid":"t2","size":222,"vsize":150,"weight":561,"vin":[],"vout":[]}`)
+	if trx = newTxByCore(&raw, true); trx.VSize != 150 {
+		t.Errorf("vsize = %d, want 150", trx.VSize)
+	}
+	//没有见证数据时为序列化大小
+	raw = gjson.Parse(`{"txid":"t3","size":222,"vin":[],"vout":[]}`)
+	if trx = newTxByCore(&raw, true); trx.VSize != 222 {
+		t.Errorf("vsize = %d, want 222", trx.VSize)
+	}
+}
@@ -76,6 +76,7 @@ type WalletManager struct {
 	TxDecoder    openwallet.TransactionDecoder //交易单编码器
 	ContractDecoder openwallet.SmartContractDecoder //
 	Log            *log.OWLogger                 //日志工具
+	blockFeeRates  *blockFeeRates                //已扫描区块的交易费率
 }
 
 func NewWalletManager() *WalletManager {
@@ -91,6 +92,7 @@ func NewWalletManager() *WalletManager {
 	wm.TxDecoder = NewTransactionDecoder(&wm)
 	wm.ContractDecoder = NewContractDecoder(&wm)
 	wm.Log = log.NewOWLogger(wm.Symbol())
+	wm.blockFeeRates = newBlockFeeRates(feeRateBlocksWindow)
 	return &wm
 }
 
@@ -985,7 +987,9 @@ func (wm *WalletManager) GetBlockChainInfo() (*BlockchainInfo, error) {
 //ListUnspent 获取未花记录
 func (wm *WalletManager) ListUnspent(min uint64, addresses ...string) ([]*Unspent, error) {
 
-	if wm.config.RPCServerType == RPCServerExplorer {
+	if wm.config.UTXOIndex {
+		return wm.blockscanner.ListUnspentByIndex(min, addresses...)
+	} else if wm.config.RPCServerType == RPCServerExplorer {
 		return wm.listUnspentByExplorer(addresses...)
 	} else {
 		return wm.getListUnspentByCore(min, addresses...)
@@ -1696,7 +1700,12 @@ func (wm *WalletManager) EstimateFee(inputs, outputs int64, feeRate decimal.Deci
 	}
 
 	//计算公式如下：148 * 输入数额 + 34 * 输出数额 + 10
-	trx_bytes := decimal.New(inputs*148+outputs*34+piece*10, 0)
+	return wm.EstimateFeeBySize(uint64(inputs*148+outputs*34+piece*10), feeRate)
+}
+
+//EstimateFeeBySize 按交易单大小（字节）计算手续费，不低于最低手续费
+func (wm *WalletManager) EstimateFeeBySize(size uint64, feeRate decimal.Decimal) (decimal.Decimal, error) {
+	trx_bytes := decimal.New(int64(size), 0)
 	trx_fee := trx_bytes.Div(decimal.New(1000, 0)).Mul(feeRate)
 	trx_fee = trx_fee.Round(wm.Decimal())
 	if trx_fee.LessThan(wm.config.MinFees) {
@@ -1706,22 +1715,12 @@ func (wm *WalletManager) EstimateFee(inputs, outputs int64, feeRate decimal.Deci
 }
 
 
-//EstimateFeeRate 预估的没KB手续费率
-func (wm *WalletManager) EstimateFeeRate() (decimal.Decimal, error) {
-
-	if wm.config.RPCServerType == RPCServerExplorer {
-		return wm.estimateFeeRateByExplorer()
-	} else {
-		return wm.estimateFeeRateByCore()
-	}
-}
-
-//estimateFeeRateByCore 预估的没KB手续费率
-func (wm *WalletManager) estimateFeeRateByCore() (decimal.Decimal, error) {
+//estimateFeeRateByCore 预估在blocks个区块内确认的每KB手续费率
+func (wm *WalletManager) estimateFeeRateByCore(blocks int64) (decimal.Decimal, error) {
 
 	//估算交易大小 手续费
 	request := []interface{}{
-		6,
+		blocks,
 	}
 
 	estimatesmartfee, err := wm.walletClient.Call("estimatesmartfee", request)
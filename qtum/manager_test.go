@@ -556,4 +556,45 @@ func TestSendToAddress(t *testing.T){
 //	//添加汇总钱包的账户
 //	tw.AddWalletInSummary("", a)
 //	tw.SummaryWallets()
-//}
\ No newline at end of file
+//}
+func TestLoadContractGasConfig(t *testing.T) {
+	c, err := config.NewConfigData("ini", []byte(`
+rpcServerType = 1
+apiURL = "http://127.0.0.1:1/"
+dataDir = "data"
+gasLimit = 200000
+gasPrice = "0.0000005"
+[contractGas]
+0xF2033EDE578E17FA6231047265010445BCA8CF1C = 60000
+91a6081095ef860d28874c9db613e7a4107b0281 = 100000,0.0000006
+`))
+	if err != nil {
+		t.Errorf("NewConfigData failed unexpected error: %v\n", err)
+		return
+	}
+	wm := NewWalletManager()
+	if err := wm.LoadAssetsConfig(c); err != nil {
+		t.Errorf("LoadAssetsConfig failed unexpected error: %v\n", err)
+		return
+	}
+
+	tests := []struct {
+		contract string
+		gasLimit uint64
+		fees     string
+	}{
+		{"0x482be94ca327f1dd1d9857a5a212df091f44980f", 200000, "0.1"},
+		{"0xf2033ede578e17fa6231047265010445bca8cf1c", 60000, "0.03"},
+		{"0x91a6081095ef860d28874c9db613e7a4107b0281", 100000, "0.06"},
+	}
+	for _, test := range tests {
+		gas, err := wm.GetContractGas(test.contract, "", nil)
+		if err != nil {
+			t.Errorf("GetContractGas failed unexpected error: %v\n", err)
+			continue
+		}
+		if gas.GasLimit != test.gasLimit || gas.Fees().String() != test.fees {
+			t.Errorf("GetContractGas %s = %d, %s, want %d, %s", test.contract, gas.GasLimit, gas.Fees().String(), test.gasLimit, test.fees)
+		}
+	}
+}
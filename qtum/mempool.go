@@ -0,0 +1,303 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package qtum
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/blocktree/openwallet/openwallet"
+)
+
+const (
+	//MempoolTxReplaced 未确认交易单的输入被另一笔交易单花费
+	MempoolTxReplaced = "replaced"
+	//MempoolTxDropped 未确认交易单从交易池消失，且没有被打包
+	MempoolTxDropped = "dropped"
+)
+
+//mempoolEntry 已通知观测者的未确认交易单
+type mempoolEntry struct {
+	txID                string
+	outpoints           []string //花费的txid_vout
+	extractData         map[string]*openwallet.TxExtractData
+	extractContractData map[string][]*openwallet.TxExtractData
+}
+
+//mempoolRetraction 需要撤销的未确认交易单
+type mempoolRetraction struct {
+	entry       *mempoolEntry
+	reason      string
+	replacedBy  string
+	blockHeight uint64
+	blockHash   string
+}
+
+//mempoolTracker 记录已通知的未确认交易单及其花费的输出，检测双花、替换和丢弃
+type mempoolTracker struct {
+	mu      sync.Mutex
+	entries map[string]*mempoolEntry
+	spends  map[string]string   //txid_vout -> 已通知的未确认交易单
+	known   map[string]struct{} //交易池中已提取过的交易单，不再重复提取
+}
+
+func newMempoolTracker() *mempoolTracker {
+	return &mempoolTracker{
+		entries: make(map[string]*mempoolEntry),
+		spends:  make(map[string]string),
+		known:   make(map[string]struct{}),
+	}
+}
+
+//unknown 交易池中尚未提取的交易单，同时忘记已离开交易池的交易单
+func (tracker *mempoolTracker) unknown(txids []string) []string {
+
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+
+	pool := make(map[string]struct{}, len(txids))
+	unknown := make([]string, 0)
+	for _, txid := range txids {
+		pool[txid] = struct{}{}
+		if _, ok := tracker.known[txid]; !ok {
+			unknown = append(unknown, txid)
+		}
+	}
+	for txid := range tracker.known {
+		if _, ok := pool[txid]; !ok {
+			delete(tracker.known, txid)
+		}
+	}
+	return unknown
+}
+
+//missing 已通知但不在交易池中的未确认交易单
+func (tracker *mempoolTracker) missing(txids []string) []string {
+
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+
+	pool := make(map[string]struct{}, len(txids))
+	for _, txid := range txids {
+		pool[txid] = struct{}{}
+	}
+	missing := make([]string, 0)
+	for txid := range tracker.entries {
+		if _, ok := pool[txid]; !ok {
+			missing = append(missing, txid)
+		}
+	}
+	sort.Strings(missing)
+	return missing
+}
+
+//conflicts 提取结果中与已通知交易单花费相同输出的交易单，被冲突的交易单不再跟踪
+//交易池中的结果blockHeight为0
+func (tracker *mempoolTracker) conflicts(results []ExtractResult, blockHeight uint64, blockHash string) []*mempoolRetraction {
+
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+
+	retractions := make([]*mempoolRetraction, 0)
+	for _, result := range results {
+		if !result.Success {
+			continue
+		}
+		for _, outpoint := range result.spentOutpoints {
+			txid, ok := tracker.spends[outpoint]
+			if !ok || txid == result.TxID {
+				continue
+			}
+			retractions = append(retractions, &mempoolRetraction{
+				entry:       tracker.remove(txid),
+				reason:      MempoolTxReplaced,
+				replacedBy:  result.TxID,
+				blockHeight: blockHeight,
+				blockHash:   blockHash,
+			})
+		}
+	}
+	return retractions
+}
+
+//confirm 区块中已打包的交易单不再跟踪
+func (tracker *mempoolTracker) confirm(results []ExtractResult) {
+
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+
+	for _, result := range results {
+		if result.Success {
+			tracker.remove(result.TxID)
+		}
+	}
+}
+
+//track 记录交易池中新提取的交易单，只跟踪包含观测地址数据的交易单
+func (tracker *mempoolTracker) track(results []ExtractResult) {
+
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+
+	for _, result := range results {
+		if !result.Success {
+			continue
+		}
+		tracker.known[result.TxID] = struct{}{}
+		if len(result.extractData) == 0 && len(result.extractContractData) == 0 {
+			continue
+		}
+		tracker.entries[result.TxID] = &mempoolEntry{
+			txID:                result.TxID,
+			outpoints:           result.spentOutpoints,
+			extractData:         result.extractData,
+			extractContractData: result.extractContractData,
+		}
+		for _, outpoint := range result.spentOutpoints {
+			tracker.spends[outpoint] = result.TxID
+		}
+	}
+}
+
+//drop 删除丢弃的交易单
+func (tracker *mempoolTracker) drop(txid string) *mempoolEntry {
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+	return tracker.remove(txid)
+}
+
+//remove 删除交易单及其花费记录，调用者持有锁
+func (tracker *mempoolTracker) remove(txid string) *mempoolEntry {
+	entry, ok := tracker.entries[txid]
+	if !ok {
+		return nil
+	}
+	delete(tracker.entries, txid)
+	for _, outpoint := range entry.outpoints {
+		if tracker.spends[outpoint] == txid {
+			delete(tracker.spends, outpoint)
+		}
+	}
+	return entry
+}
+
+//ScanTxMemPool 扫描交易内存池
+//只提取新进入交易池的交易单，已通知的未确认交易单被双花替换或从交易池丢弃时，重新通知撤销
+func (bs *BTCBlockScanner) ScanTxMemPool() {
+
+	bs.wm.Log.Std.Info("block scanner scanning mempool ...")
+
+	//提取未确认的交易单
+	txIDsInMemPool, err := bs.chain.GetTxIDsInMemPool()
+	if err != nil {
+		bs.wm.Log.Std.Info("block scanner can not get mempool data; unexpected error: %v", err)
+		return
+	}
+
+	//离开交易池的交易单不再影响utxo索引
+	bs.utxoIndex.prunePending(txIDsInMemPool)
+
+	if txids := bs.mempool.unknown(txIDsInMemPool); len(txids) > 0 {
+
+		results, err := bs.extractBlockTransactions(0, "", txids)
+		if err != nil {
+			bs.wm.Log.Std.Info("block scanner can not extract mempool transactions; unexpected error: %v", err)
+			return
+		}
+
+		//先撤销被替换的交易单，再通知替换它的交易单
+		bs.notifyMempoolRetractions(bs.mempool.conflicts(results, 0, ""))
+
+		err = bs.commitExtractResults(0, "", results)
+		if err != nil {
+			bs.wm.Log.Std.Info("block scanner can not extractRechargeRecords; unexpected error: %v", err)
+		}
+
+		bs.mempool.track(results)
+	}
+
+	//离开交易池且节点确定不存在的交易单已丢弃，已打包的等待区块扫描确认，查询失败的下次重试
+	retractions := make([]*mempoolRetraction, 0)
+	for _, txid := range bs.mempool.missing(txIDsInMemPool) {
+		_, err := bs.chain.GetTransaction(txid)
+		if err != ErrTransactionNotFound {
+			if err != nil {
+				bs.wm.Log.Std.Info("block scanner can not get unconfirmed transaction: %s; unexpected error: %v", txid, err)
+			}
+			continue
+		}
+		if entry := bs.mempool.drop(txid); entry != nil {
+			retractions = append(retractions, &mempoolRetraction{entry: entry, reason: MempoolTxDropped})
+		}
+	}
+	bs.notifyMempoolRetractions(retractions)
+}
+
+//confirmMempoolTransactions 区块打包的交易单不再跟踪，与之冲突的未确认交易单通知撤销
+func (bs *BTCBlockScanner) confirmMempoolTransactions(blockHeight uint64, blockHash string, results []ExtractResult) {
+	bs.mempool.confirm(results)
+	bs.notifyMempoolRetractions(bs.mempool.conflicts(results, blockHeight, blockHash))
+}
+
+//notifyMempoolRetractions 以失败状态重新通知被撤销的未确认交易单，WxID与原通知一致
+func (bs *BTCBlockScanner) notifyMempoolRetractions(retractions []*mempoolRetraction) {
+	for _, retraction := range retractions {
+		if retraction.entry == nil {
+			continue
+		}
+
+		bs.wm.Log.Std.Info("unconfirmed transaction: %s is %s %s", retraction.entry.txID, retraction.reason, retraction.replacedBy)
+
+		for _, extractData := range retraction.entry.extractData {
+			markExtractDataRetracted(extractData, retraction)
+		}
+		for _, list := range retraction.entry.extractContractData {
+			for _, extractData := range list {
+				markExtractDataRetracted(extractData, retraction)
+			}
+		}
+
+		if err := bs.newExtractDataNotify(0, retraction.entry.extractData); err != nil {
+			bs.wm.Log.Std.Error("block scanner can not notify %s transaction: %s; unexpected error: %v", retraction.reason, retraction.entry.txID, err)
+		}
+		if err := bs.newExtractDataListNotify(0, retraction.entry.extractContractData); err != nil {
+			bs.wm.Log.Std.Error("block scanner can not notify %s transaction: %s; unexpected error: %v", retraction.reason, retraction.entry.txID, err)
+		}
+	}
+}
+
+//markExtractDataRetracted 将提取数据标记为已撤销的未确认交易
+//交易单状态为失败，扩展参数mempoolStatus为replaced或dropped，被替换时replacedBy为冲突的交易单
+func markExtractDataRetracted(extractData *openwallet.TxExtractData, retraction *mempoolRetraction) {
+	for _, output := range extractData.TxOutputs {
+		setRetractionExtParams(output.SetExtParam, retraction)
+	}
+	if tx := extractData.Transaction; tx != nil {
+		tx.Status = openwallet.TxStatusFail
+		setRetractionExtParams(tx.SetExtParam, retraction)
+	}
+}
+
+//setRetractionExtParams 记录撤销原因的扩展参数
+func setRetractionExtParams(setExtParam func(key string, value interface{}) error, retraction *mempoolRetraction) {
+	setExtParam("mempoolStatus", retraction.reason)
+	if len(retraction.replacedBy) > 0 {
+		setExtParam("replacedBy", retraction.replacedBy)
+	}
+	if retraction.blockHeight > 0 {
+		setExtParam("replacedInBlock", retraction.blockHash)
+	}
+}
@@ -0,0 +1,155 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package qtum
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/blocktree/openwallet/openwallet"
+)
+
+//testMempoolObserver 记录未确认交易单的通知
+type testMempoolObserver struct {
+	mu       sync.Mutex
+	notified []*openwallet.Transaction
+}
+
+func (o *testMempoolObserver) BlockScanNotify(header *openwallet.BlockHeader) error {
+	return nil
+}
+
+func (o *testMempoolObserver) BlockExtractDataNotify(sourceKey string, data *openwallet.TxExtractData) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.notified = append(o.notified, data.Transaction)
+	return nil
+}
+
+//take 取出已记录的通知
+func (o *testMempoolObserver) take() []*openwallet.Transaction {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	notified := o.notified
+	o.notified = nil
+	return notified
+}
+
+//testMempoolTx 花费prev:0，输出给to的未确认交易单
+func testMempoolTx(txid, prev, to string) *Transaction {
+	return &Transaction{
+		TxID:  txid,
+		Vins:  []*Vin{{TxID: prev, Vout: 0, Addr: "x", Value: "2"}},
+		Vouts: []*Vout{{N: 0, Addr: to, Value: "1"}},
+	}
+}
+
+func TestScanTxMemPool(t *testing.T) {
+
+	chain := newFakeChain()
+	for _, trx := range []*Transaction{
+		testMempoolTx("d1", "p1", "a"),
+		testMempoolTx("d2", "p2", "a"),
+		testMempoolTx("other", "p3", "y"),
+		testMempoolTx("r1", "p1", "y"),
+		testMempoolTx("d3", "p4", "a"),
+	} {
+		chain.txs[trx.TxID] = trx
+	}
+
+	wm := NewWalletManager()
+	bs := wm.blockscanner
+	bs.chain = chain
+	bs.SetBlockScanAddressFunc(testScanAddress("a"))
+	bs.SetBlockchainDAI(&fakeBlockchainDAI{blocks: make(map[uint64]*openwallet.BlockHeader)})
+	observer := &testMempoolObserver{}
+	bs.AddObserver(observer)
+
+	chain.mempool = []string{"d1", "d2", "other"}
+	bs.ScanTxMemPool()
+	if notified := observer.take(); len(notified) != 2 {
+		t.Fatalf("first pass notifications = %d, want d1 and d2", len(notified))
+	}
+
+	//已提取的交易单不再重复通知
+	bs.ScanTxMemPool()
+	if notified := observer.take(); len(notified) != 0 {
+		t.Errorf("second pass notifications = %d, want 0", len(notified))
+	}
+
+	//r1替换了d1
+	chain.mempool = []string{"d2", "other", "r1"}
+	bs.ScanTxMemPool()
+	notified := observer.take()
+	if len(notified) != 1 || notified[0].TxID != "d1" || notified[0].Status != openwallet.TxStatusFail ||
+		notified[0].GetExtParam().Get("mempoolStatus").String() != MempoolTxReplaced ||
+		notified[0].GetExtParam().Get("replacedBy").String() != "r1" {
+		t.Fatalf("replaced notifications = %+v", notified)
+	}
+
+	//d2离开交易池，查询失败时不能确定已丢弃
+	chain.mempool = []string{"other", "r1", "d3"}
+	chain.txErrs["d2"] = fmt.Errorf("connection refused")
+	bs.ScanTxMemPool()
+	notified = observer.take()
+	if len(notified) != 1 || notified[0].TxID != "d3" {
+		t.Fatalf("notifications on query failure = %+v", notified)
+	}
+	bs.ScanTxMemPool()
+	if notified := observer.take(); len(notified) != 0 {
+		t.Fatalf("notifications on retry = %+v", notified)
+	}
+
+	//节点确定d2不存在
+	chain.txErrs["d2"] = ErrTransactionNotFound
+	bs.ScanTxMemPool()
+	notified = observer.take()
+	if len(notified) != 1 || notified[0].TxID != "d2" ||
+		notified[0].GetExtParam().Get("mempoolStatus").String() != MempoolTxDropped {
+		t.Fatalf("dropped notifications = %+v", notified)
+	}
+
+	//区块中花费p4的交易单与d3冲突
+	bs.confirmMempoolTransactions(5, "b5", []ExtractResult{{TxID: "c1", Success: true, spentOutpoints: []string{utxoRecordKey("p4", 0)}}})
+	notified = observer.take()
+	if len(notified) != 1 || notified[0].TxID != "d3" || notified[0].GetExtParam().Get("replacedInBlock").String() != "b5" {
+		t.Fatalf("replaced in block notifications = %+v", notified)
+	}
+	if len(bs.mempool.entries) != 0 || len(bs.mempool.spends) != 0 {
+		t.Errorf("tracked entries = %d, spends = %d, want 0", len(bs.mempool.entries), len(bs.mempool.spends))
+	}
+}
+
+func TestMempoolTrackerConfirm(t *testing.T) {
+
+	tracker := newMempoolTracker()
+	tracker.track([]ExtractResult{{
+		TxID:           "d1",
+		Success:        true,
+		spentOutpoints: []string{"p1_0"},
+		extractData:    map[string]*openwallet.TxExtractData{"account": {Transaction: &openwallet.Transaction{TxID: "d1"}}},
+	}})
+
+	//打包后离开交易池不是丢弃
+	tracker.confirm([]ExtractResult{{TxID: "d1", Success: true, spentOutpoints: []string{"p1_0"}}})
+	if missing := tracker.missing(nil); len(missing) != 0 {
+		t.Errorf("missing = %v, want none", missing)
+	}
+	if retractions := tracker.conflicts([]ExtractResult{{TxID: "r1", Success: true, spentOutpoints: []string{"p1_0"}}}, 6, "b6"); len(retractions) != 0 {
+		t.Errorf("confirmed transaction should not be replaced")
+	}
+}
@@ -16,7 +16,13 @@
 package qtum
 
 import (
+	"encoding/hex"
+	"math/big"
+
+	"github.com/Assetsadapter/qtum-adapter/qtum/abi"
+	"github.com/Assetsadapter/qtum-adapter/qtum/btcLikeTxDriver"
 	"github.com/blocktree/openwallet/openwallet"
+	"github.com/shopspring/decimal"
 	"github.com/tidwall/gjson"
 )
 
@@ -299,9 +305,65 @@ func NewQRC20Unspent(json *gjson.Result) *QRC20Unspent {
 	return obj
 }
 
+//QRC20Token 代币合约信息
+type QRC20Token struct {
+	Address     string //0x开头的合约地址
+	Name        string
+	Symbol      string
+	Decimals    uint64
+	TotalSupply string //未按精度调整的总发行量
+}
+
+//SmartContract 转换为openwallet的合约模型，symbol为主链币种
+func (token *QRC20Token) SmartContract(symbol string) openwallet.SmartContract {
+	return openwallet.SmartContract{
+		ContractID: openwallet.GenContractID(symbol, token.Address),
+		Symbol:     symbol,
+		Address:    token.Address,
+		Token:      token.Symbol,
+		Protocol:   "qrc20",
+		Name:       token.Name,
+		Decimals:   token.Decimals,
+	}
+}
+
+//newQRC20TokenByExplorer
+func newQRC20TokenByExplorer(json *gjson.Result) *QRC20Token {
+	/*
+		{
+			"address": "QcFr4Cnd7iqXfEfDqyMhQRnJ2DbUd4hyhW",
+			"addressHex": "f2033ede578e17fa6231047265010445bca8cf1c",
+			"name": "QCash",
+			"symbol": "QC",
+			"decimals": 8,
+			"totalSupply": "1000000000000000000",
+			"version": null
+		}
+	*/
+	obj := &QRC20Token{}
+	obj.Address = "0x" + json.Get("addressHex").String()
+	obj.Name = json.Get("name").String()
+	obj.Symbol = json.Get("symbol").String()
+	obj.Decimals = json.Get("decimals").Uint()
+	obj.TotalSupply = json.Get("totalSupply").String()
+	return obj
+}
+
+//parseVSize 交易单的虚拟大小，优先使用vsize，其次按weight/4向上取整，都没有时使用序列化大小
+func parseVSize(json *gjson.Result, size uint64) uint64 {
+	if vsize := gjson.Get(json.Raw, "vsize").Uint(); vsize > 0 {
+		return vsize
+	}
+	if weight := gjson.Get(json.Raw, "weight").Uint(); weight > 0 {
+		return (weight + 3) / 4
+	}
+	return size
+}
+
 type Transaction struct {
 	TxID            string
 	Size            uint64
+	VSize           uint64 //虚拟大小，费率按此计算
 	Version         uint64
 	LockTime        int64
 	Hex             string
@@ -334,6 +396,24 @@ type Vout struct {
 	Value        string
 	ScriptPubKey string
 	Type         string
+	Execution    *ContractExecution //合约调用输出的执行结果
+}
+
+//ContractExecution 合约调用输出的执行结果
+type ContractExecution struct {
+	Sender   string
+	GasUsed  uint64
+	Excepted string
+}
+
+//Failed 合约执行是否异常回滚
+func (exec *ContractExecution) Failed() bool {
+	return isContractExcepted(exec.Excepted)
+}
+
+//isContractExcepted 回执excepted字段为None表示执行成功
+func isContractExcepted(excepted string) bool {
+	return len(excepted) > 0 && excepted != "None"
 }
 
 type TokenReceipt struct {
@@ -346,7 +426,35 @@ type TokenReceipt struct {
 	GasUsed         uint64
 	ContractAddress string
 	Excepted        string
-	Amount          string
+	Amount          string //按代币精度调整后的数量
+	RawAmount       string //合约中记录的uint256原始数值
+	Decimals        uint64 //代币精度
+	LogIndex        uint64 //Transfer事件在交易单全部日志中的序号，非Transfer事件同样计数
+
+	reportedDecimals    uint64 //浏览器返回的精度
+	hasReportedDecimals bool
+}
+
+//ResolveAmount 按代币精度补全原始数值和精度调整后的数量
+func (receipt *TokenReceipt) ResolveAmount(decimals uint64) {
+	receipt.Decimals = decimals
+	if len(receipt.RawAmount) > 0 {
+		raw, _ := decimal.NewFromString(receipt.RawAmount)
+		receipt.Amount = raw.Shift(-int32(decimals)).String()
+	} else if len(receipt.Amount) > 0 {
+		amount, _ := decimal.NewFromString(receipt.Amount)
+		receipt.RawAmount = amount.Shift(int32(decimals)).String()
+	}
+}
+
+//ReportedDecimals 浏览器返回的代币精度
+func (receipt *TokenReceipt) ReportedDecimals() (uint64, bool) {
+	return receipt.reportedDecimals, receipt.hasReportedDecimals
+}
+
+//Failed 合约调用是否异常回滚，回滚的转账不会改变代币余额
+func (receipt *TokenReceipt) Failed() bool {
+	return isContractExcepted(receipt.Excepted)
 }
 
 func newTxByCore(json *gjson.Result, isTestnet bool) *Transaction {
@@ -378,6 +486,7 @@ func newTxByCore(json *gjson.Result, isTestnet bool) *Transaction {
 	obj.Confirmations = gjson.Get(json.Raw, "confirmations").Uint()
 	obj.Blocktime = gjson.Get(json.Raw, "blocktime").Int()
 	obj.Size = gjson.Get(json.Raw, "size").Uint()
+	obj.VSize = parseVSize(json, obj.Size)
 	//obj.Fees = gjson.Get(json.Raw, "fees").String()
 
 	obj.Vins = make([]*Vin, 0)
@@ -397,6 +506,10 @@ func newTxByCore(json *gjson.Result, isTestnet bool) *Transaction {
 		}
 	}
 
+	//getrawtransaction没有coinbase和coinstake标记，按交易结构判断
+	obj.IsCoinBase = len(obj.Vins) > 0 && len(obj.Vins[0].Coinbase) > 0
+	obj.IsCoinstake = !obj.IsCoinBase && len(obj.Vins) > 0 && len(obj.Vouts) >= 2 && obj.Vouts[0].isEmpty()
+
 	return &obj
 }
 
@@ -460,3 +573,269 @@ func newTxVoutByCore(json *gjson.Result, isTestnet bool) *Vout {
 
 	return &obj
 }
+
+//hasContractCall 交易单是否包含OP_CALL合约调用输出
+func (tx *Transaction) hasContractCall() bool {
+	for _, out := range tx.Vouts {
+		if out.Type == "call" {
+			return true
+		}
+	}
+	return false
+}
+
+//appendFailedTokenReceipts 执行异常的合约调用没有Transfer日志，从调用数据还原转账并标记为失败
+func (tx *Transaction) appendFailedTokenReceipts(isTestnet bool) {
+
+	//排在交易单已有日志之后，避免与Transfer事件的序号重复
+	logIndex := uint64(0)
+	for _, receipt := range tx.TokenReceipts {
+		if receipt.LogIndex >= logIndex {
+			logIndex = receipt.LogIndex + 1
+		}
+	}
+
+	for _, out := range tx.Vouts {
+		if out.Execution == nil || !out.Execution.Failed() {
+			continue
+		}
+
+		script, _ := hex.DecodeString(out.ScriptPubKey)
+		contract, err := btcLikeTxDriver.DecodeContractScript(script)
+		if err != nil {
+			continue
+		}
+
+		//只处理transfer和transferFrom调用
+		method, values, err := abi.QRC20.UnpackInput(contract.CallData)
+		if err != nil {
+			continue
+		}
+
+		obj := TokenReceipt{}
+		switch method.Name {
+		case "transfer":
+			obj.From = out.Execution.Sender
+			obj.To = HashAddressToBaseAddress(values[0].(string), isTestnet)
+			obj.RawAmount = values[1].(*big.Int).String()
+		case "transferFrom":
+			obj.From = HashAddressToBaseAddress(values[0].(string), isTestnet)
+			obj.To = HashAddressToBaseAddress(values[1].(string), isTestnet)
+			obj.RawAmount = values[2].(*big.Int).String()
+		default:
+			continue
+		}
+
+		obj.TxHash = tx.TxID
+		obj.BlockHash = tx.BlockHash
+		obj.BlockHeight = tx.BlockHeight
+		obj.Sender = out.Execution.Sender
+		obj.GasUsed = out.Execution.GasUsed
+		obj.Excepted = out.Execution.Excepted
+		obj.ContractAddress = "0x" + hex.EncodeToString(contract.ContractAddr)
+		obj.Amount = obj.RawAmount
+		obj.LogIndex = logIndex
+		logIndex++
+
+		tx.TokenReceipts = append(tx.TokenReceipts, &obj)
+		tx.Isqrc20Transfer = true
+	}
+}
+
+//contractGasRefund 合约调用未消耗的gas，由区块的coinstake/coinbase退还给调用者
+func (tx *Transaction) contractGasRefund() decimal.Decimal {
+	refund := decimal.Zero
+	for _, r := range tx.contractGasRefunds() {
+		refund = refund.Add(r.Amount)
+	}
+	return refund
+}
+
+//contractGasRefunds 合约调用未消耗的gas，按调用者逐个输出统计，coinstake为每一项生成一个退款输出
+//调用者未知时Address为空，只计入手续费的扣除，不匹配退款输出
+func (tx *Transaction) contractGasRefunds() []*gasRefund {
+
+	refunds := make([]*gasRefund, 0)
+
+	for _, out := range tx.Vouts {
+		if out.Execution == nil {
+			continue
+		}
+
+		script, _ := hex.DecodeString(out.ScriptPubKey)
+		contract, err := btcLikeTxDriver.DecodeContractScript(script)
+		if err != nil {
+			continue
+		}
+
+		if contract.GasLimit > out.Execution.GasUsed {
+			refund := (contract.GasLimit - out.Execution.GasUsed) * contract.GasPrice
+			refunds = append(refunds, &gasRefund{Address: out.Execution.Sender, Amount: decimal.New(int64(refund), -8)})
+		}
+	}
+
+	return refunds
+}
+
+//isEmpty 空输出，coinstake的第一个输出
+func (out *Vout) isEmpty() bool {
+	value, _ := decimal.NewFromString(out.Value)
+	return value.IsZero() && len(out.ScriptPubKey) == 0
+}
+
+//hasContractOutput 交易单是否包含合约调用或创建的输出
+func (tx *Transaction) hasContractOutput() bool {
+	for _, out := range tx.Vouts {
+		script, _ := hex.DecodeString(out.ScriptPubKey)
+		if _, err := btcLikeTxDriver.DecodeContractScript(script); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+//hasFailedContractCall 交易单是否有执行异常的合约调用
+func (tx *Transaction) hasFailedContractCall() bool {
+	for _, out := range tx.Vouts {
+		if out.Execution != nil && out.Execution.Failed() {
+			return true
+		}
+	}
+	return false
+}
+
+//gasRefundOutputs coinstake/coinbase中退还合约调用者gas的输出序号
+//refunds为区块内合约调用应退还的gas，按调用者地址和金额逐个匹配，质押返还和出块奖励输出不参与匹配
+func (tx *Transaction) gasRefundOutputs(refunds []*gasRefund) map[uint64]bool {
+
+	matched := make(map[uint64]bool)
+	if !tx.IsCoinstake && !tx.IsCoinBase {
+		return matched
+	}
+
+	pending := append([]*gasRefund{}, refunds...)
+	for _, out := range tx.Vouts {
+		if len(out.Addr) == 0 {
+			continue
+		}
+		//coinstake的第一个非空输出是质押返还，coinbase的第一个输出是出块奖励
+		if (tx.IsCoinstake && out.N <= 1) || (tx.IsCoinBase && out.N == 0) {
+			continue
+		}
+		amount, _ := decimal.NewFromString(out.Value)
+		for i, refund := range pending {
+			if refund.Address == out.Addr && refund.Amount.Equal(amount) {
+				pending = append(pending[:i], pending[i+1:]...)
+				matched[out.N] = true
+				break
+			}
+		}
+	}
+
+	return matched
+}
+
+//setContractExecutionsByCore 按回执的outputIndex记录合约调用输出的执行结果
+func (tx *Transaction) setContractExecutionsByCore(json *gjson.Result, isTestnet bool) {
+	for _, receipt := range json.Array() {
+		n := receipt.Get("outputIndex").Uint()
+		if n >= uint64(len(tx.Vouts)) {
+			continue
+		}
+		tx.Vouts[n].Execution = &ContractExecution{
+			Sender:   HashAddressToBaseAddress(receipt.Get("from").String(), isTestnet),
+			GasUsed:  receipt.Get("gasUsed").Uint(),
+			Excepted: receipt.Get("excepted").String(),
+		}
+	}
+}
+
+//unpackTransferLog 解析QRC20的Transfer事件日志，日志格式：{"address", "topics": [hex], "data": hex}
+func unpackTransferLog(logInfo *gjson.Result) (map[string]interface{}, bool) {
+	topics := make([][]byte, 0)
+	for _, topic := range logInfo.Get("topics").Array() {
+		b, _ := hex.DecodeString(topic.String())
+		topics = append(topics, b)
+	}
+	data, _ := hex.DecodeString(logInfo.Get("data").String())
+
+	event, values, err := abi.QRC20.UnpackLog(topics, data)
+	if err != nil || event.Name != "Transfer" {
+		return nil, false
+	}
+	return values, true
+}
+
+//newTokenReceiptsByCore 解析gettransactionreceipt的回执，提取标准Transfer事件
+func newTokenReceiptsByCore(json *gjson.Result, isTestnet bool) []*TokenReceipt {
+
+	/*
+		[{
+			"blockHash": "3e5e3b2ca0b1b3b1f6a9aab0ee50f2f40ecbb02abc6ed1c6eb5ef3f6e0a6a3d9",
+			"blockNumber": 390006,
+			"transactionHash": "a8aab0a4e2a1b1b1f9b3d3e5f0e1b6e4c1d3a5b7a9c1e3f5a7b9c1d3e5f7a9b1",
+			"transactionIndex": 2,
+			"outputIndex": 0,
+			"from": "8d1ea1e1c2f2b4b8a3a9a3f9c1d9a7d6e7e8e9f0",
+			"to": "91a6081095ef860d28874c9db613e7a4107b0281",
+			"cumulativeGasUsed": 36024,
+			"gasUsed": 36024,
+			"contractAddress": "91a6081095ef860d28874c9db613e7a4107b0281",
+			"excepted": "None",
+			"log": [{
+				"address": "91a6081095ef860d28874c9db613e7a4107b0281",
+				"topics": [
+					"ddf252ad1be2c89b69c2b068fc378daa952ba7f163c4a11628f55a4df523b3ef",
+					"0000000000000000000000008d1ea1e1c2f2b4b8a3a9a3f9c1d9a7d6e7e8e9f0",
+					"000000000000000000000000a1b2c3d4e5f6a7b8c9d0e1f2a3b4c5d6e7f8a9b0"
+				],
+				"data": "00000000000000000000000000000000000000000000000000000000000f4240"
+			}]
+		}]
+	*/
+
+	receipts := make([]*TokenReceipt, 0)
+	//日志在交易单所有回执中的序号，非Transfer事件同样计数，与浏览器一致
+	logIndex := uint64(0)
+
+	for _, receipt := range json.Array() {
+
+		txHash := receipt.Get("transactionHash").String()
+		blockHash := receipt.Get("blockHash").String()
+		blockHeight := receipt.Get("blockNumber").Uint()
+		sender := HashAddressToBaseAddress(receipt.Get("from").String(), isTestnet)
+		gasUsed := receipt.Get("gasUsed").Uint()
+		excepted := receipt.Get("excepted").String()
+
+		for _, logInfo := range receipt.Get("log").Array() {
+			n := logIndex
+			logIndex++
+
+			//非Transfer事件或格式不符的日志跳过
+			values, ok := unpackTransferLog(&logInfo)
+			if !ok {
+				continue
+			}
+
+			obj := TokenReceipt{}
+			obj.TxHash = txHash
+			obj.BlockHash = blockHash
+			obj.BlockHeight = blockHeight
+			obj.Sender = sender
+			obj.GasUsed = gasUsed
+			obj.Excepted = excepted
+			obj.ContractAddress = "0x" + logInfo.Get("address").String()
+			obj.From = HashAddressToBaseAddress(values["_from"].(string), isTestnet)
+			obj.To = HashAddressToBaseAddress(values["_to"].(string), isTestnet)
+
+			//转化为10进制，精度由扫描器按合约补全
+			obj.RawAmount = values["_value"].(*big.Int).String()
+			obj.Amount = obj.RawAmount
+			obj.LogIndex = n
+
+			receipts = append(receipts, &obj)
+		}
+	}
+
+	return receipts
+}
@@ -0,0 +1,176 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package qtum
+
+import (
+	"encoding/hex"
+	"fmt"
+
+	"github.com/Assetsadapter/qtum-adapter/qtum/btcLikeTxDriver"
+	"github.com/blocktree/go-owcrypt"
+	"github.com/blocktree/openwallet/openwallet"
+)
+
+//ExportPSBT 导出交易单为BIP174格式的PSBT（base64编码），供硬件钱包、离线签名或qtum-cli签名
+//交易单中已有的签名作为部分签名一并导出
+func (decoder *TransactionDecoder) ExportPSBT(wrapper openwallet.WalletDAI, rawTx *openwallet.RawTransaction) (string, error) {
+
+	if !rawTx.IsBuilt || rawTx.IsCompleted {
+		return "", fmt.Errorf("only the built and unsigned transaction can be exported as PSBT")
+	}
+
+	txBytes, err := hex.DecodeString(rawTx.RawHex)
+	if err != nil {
+		return "", fmt.Errorf("Invalid transaction hex data! ")
+	}
+
+	trx, err := btcLikeTxDriver.DecodeRawTransaction(txBytes)
+	if err != nil {
+		return "", fmt.Errorf("Invalid transaction data! ")
+	}
+
+	txUnlocks, err := decoder.getRawTransactionTxUnlocks(wrapper, rawTx, trx)
+	if err != nil {
+		return "", err
+	}
+
+	psbt, err := btcLikeTxDriver.NewPSBT(rawTx.RawHex, txUnlocks)
+	if err != nil {
+		return "", err
+	}
+
+	//非隔离见证输入需要完整的前序交易
+	for i, vin := range trx.Vins {
+		if psbt.Inputs[i].WitnessUtxo != nil {
+			continue
+		}
+		prevHex, err := decoder.wm.GetRawTransactionHex(vin.GetTxID())
+		if err != nil {
+			return "", err
+		}
+		prevTx, err := hex.DecodeString(prevHex)
+		if err != nil {
+			return "", fmt.Errorf("invalid previous transaction: %s", vin.GetTxID())
+		}
+		err = psbt.SetNonWitnessUtxo(i, prevTx)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	transHash, err := psbt.SignatureHashes()
+	if err != nil {
+		return "", err
+	}
+
+	for _, keySignatures := range rawTx.Signatures {
+		for _, keySignature := range keySignatures {
+			if len(keySignature.Signature) == 0 {
+				continue
+			}
+			signature, _ := hex.DecodeString(keySignature.Signature)
+			pubkey, _ := hex.DecodeString(keySignature.Address.PublicKey)
+			for i, hash := range transHash {
+				if hash != keySignature.Message {
+					continue
+				}
+				err = psbt.AddPartialSig(i, btcLikeTxDriver.SignaturePubkey{Signature: signature, Pubkey: pubkey})
+				if err != nil {
+					return "", err
+				}
+			}
+		}
+	}
+
+	return psbt.ToBase64()
+}
+
+//MergePSBT 合并签名方返回的PSBT，部分签名写回交易单的签名信息
+//PSBT已完成签名时，直接提取并验证交易单
+func (decoder *TransactionDecoder) MergePSBT(wrapper openwallet.WalletDAI, rawTx *openwallet.RawTransaction, psbtBase64 string) error {
+
+	if rawTx.IsCompleted {
+		return fmt.Errorf("transaction is already completed")
+	}
+
+	psbt, err := btcLikeTxDriver.ParsePSBTBase64(psbtBase64)
+	if err != nil {
+		return err
+	}
+
+	if psbt.UnsignedTxHex() != rawTx.RawHex {
+		return fmt.Errorf("PSBT does not belong to the transaction")
+	}
+
+	if psbt.IsFinalized() {
+		signedTrans, err := psbt.Extract()
+		if err != nil {
+			return err
+		}
+		txUnlocks, err := psbt.TxUnlocks()
+		if err != nil {
+			return err
+		}
+		if !btcLikeTxDriver.VerifyRawTransaction(signedTrans, txUnlocks) {
+			return openwallet.Errorf(openwallet.ErrVerifyRawTransactionFailed, "PSBT signed transaction verify failed")
+		}
+		rawTx.RawHex = signedTrans
+		rawTx.IsCompleted = true
+		return nil
+	}
+
+	transHash, err := psbt.SignatureHashes()
+	if err != nil {
+		return err
+	}
+
+	merged := 0
+	for i, hash := range transHash {
+
+		sigPub, err := psbt.PartialSignatures(i)
+		if err != nil {
+			return err
+		}
+
+		hashBytes, _ := hex.DecodeString(hash)
+		for _, sp := range sigPub {
+
+			pubkey := sp.Pubkey
+			if len(pubkey) == 33 {
+				pubkey = owcrypt.PointDecompress(pubkey, owcrypt.ECC_CURVE_SECP256K1)
+			}
+			if owcrypt.Verify(pubkey[1:], nil, 0, hashBytes, 32, sp.Signature, owcrypt.ECC_CURVE_SECP256K1) != owcrypt.SUCCESS {
+				return openwallet.Errorf(openwallet.ErrVerifyRawTransactionFailed, "PSBT input %d has invalid signature", i)
+			}
+
+			for _, keySignatures := range rawTx.Signatures {
+				for _, keySignature := range keySignatures {
+					if keySignature.Message != hash || keySignature.Address.PublicKey != hex.EncodeToString(sp.Pubkey) {
+						continue
+					}
+					keySignature.Signature = hex.EncodeToString(sp.Signature)
+					merged++
+				}
+			}
+		}
+	}
+
+	if merged == 0 {
+		return fmt.Errorf("no signature in PSBT matched the transaction")
+	}
+
+	return nil
+}
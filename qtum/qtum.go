@@ -637,8 +637,70 @@ func (wm *WalletManager) LoadAssetsConfig(c config.Configer) error {
 	wm.config.rpcPassword = c.String("rpcPassword")
 	//wm.config.nodeInstallPath = c.String("nodeInstallPath")
 	wm.config.isTestNet, _ = c.Bool("isTestNet")
-	wm.config.TokenTransferCost = c.String("tokenTransferCost")
 	wm.config.MinFees, _ = decimal.NewFromString(c.String("minFees"))
+
+	//合约调用的gas设置，未配置时使用默认值
+	if gasLimit, err := c.Int64("gasLimit"); err == nil && gasLimit > 0 {
+		wm.config.GasLimit = uint64(gasLimit)
+	}
+	if gasPrice, err := decimal.NewFromString(c.String("gasPrice")); err == nil && gasPrice.GreaterThan(decimal.Zero) {
+		wm.config.GasPrice = gasPrice
+	}
+	wm.config.GasEstimate, _ = c.Bool("gasEstimate")
+	wm.config.UTXOIndex, _ = c.Bool("utxoIndex")
+	wm.config.ExplorerTokenAmountScaled, _ = c.Bool("explorerTokenAmountScaled")
+	if scanPrefetchBlocks, err := c.Int("scanPrefetchBlocks"); err == nil && scanPrefetchBlocks > 0 {
+		wm.config.ScanPrefetchBlocks = scanPrefetchBlocks
+	}
+	if value := c.String("confirmationThresholds"); len(value) > 0 {
+		thresholds, err := parseConfirmationThresholds(value)
+		if err != nil {
+			return err
+		}
+		wm.config.ConfirmationThresholds = thresholds
+	}
+	if margin, err := decimal.NewFromString(c.String("gasEstimateMargin")); err == nil && margin.GreaterThanOrEqual(decimal.Zero) {
+		wm.config.GasEstimateMargin = margin
+	}
+	if coinSelection := c.String("coinSelection"); len(coinSelection) > 0 {
+		if _, err := NewCoinSelector(coinSelection); err != nil {
+			return err
+		}
+		wm.config.CoinSelection = coinSelection
+	}
+	if value := c.String("feeRateSources"); len(value) > 0 {
+		sources, err := parseFeeRateSources(value)
+		if err != nil {
+			return err
+		}
+		wm.config.FeeRateSources = sources
+	}
+	for _, target := range []string{FeeTargetFast, FeeTargetNormal, FeeTargetSlow} {
+		key := "feeTarget" + strings.ToUpper(target[:1]) + target[1:]
+		if blocks, err := c.Int64(key); err == nil && blocks > 0 {
+			wm.config.FeeTargets[target] = blocks
+		}
+	}
+	if staticFeeRate, err := decimal.NewFromString(c.String("staticFeeRate")); err == nil && staticFeeRate.IsPositive() {
+		wm.config.StaticFeeRate = staticFeeRate
+	}
+	if dustRelayFee, err := decimal.NewFromString(c.String("dustRelayFee")); err == nil && !dustRelayFee.IsNegative() {
+		wm.config.DustRelayFee = dustRelayFee
+	}
+	if incrementalRelayFee, err := decimal.NewFromString(c.String("incrementalRelayFee")); err == nil && !incrementalRelayFee.IsNegative() {
+		wm.config.IncrementalRelayFee = incrementalRelayFee
+	}
+	wm.config.ContractGas = make(map[string]*ContractGas)
+	if section, err := c.GetSection("contractgas"); err == nil {
+		defaultGas := ContractGas{GasLimit: wm.config.GasLimit, GasPrice: wm.config.GasPrice}
+		for contract, value := range section {
+			gas, err := parseContractGas(strings.Trim(value, "\""), defaultGas)
+			if err != nil {
+				return err
+			}
+			wm.config.ContractGas[strings.ToLower(strings.TrimPrefix(contract, "0x"))] = gas
+		}
+	}
 	//if wm.config.isTestNet {
 	//	wm.config.walletDataPath = c.String("testNetDataPath")
 	//} else {
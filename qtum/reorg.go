@@ -0,0 +1,150 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package qtum
+
+import (
+	"fmt"
+
+	"github.com/blocktree/openwallet/openwallet"
+)
+
+//maxForkDepth 分叉回退的最大区块数，与utxo索引保留已花费记录的区块数一致
+const maxForkDepth = utxoIndexKeepSpentBlocks
+
+//findForkAncestor 从height开始逐个回退本地保存的区块，直到与节点的区块链一致
+//返回共同祖先和被孤立的本地区块（从高到低），本地没有记录的高度以节点的区块作为共同祖先
+func (bs *BTCBlockScanner) findForkAncestor(height uint64) (*Block, []*Block, error) {
+
+	orphaned := make([]*Block, 0)
+
+	for {
+		chainHash, err := bs.chain.GetBlockHash(height)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		localBlock, err := bs.GetLocalBlock(height)
+		if err != nil {
+			bs.wm.Log.Std.Error("block scanner can not get local block on height: %d; unexpected error: %v", height, err)
+			ancestor, err := bs.chain.GetBlock(chainHash)
+			if err != nil {
+				return nil, nil, err
+			}
+			return ancestor, orphaned, nil
+		}
+
+		if localBlock.Hash == chainHash || height == 0 {
+			return localBlock, orphaned, nil
+		}
+
+		orphaned = append(orphaned, localBlock)
+		if len(orphaned) > maxForkDepth {
+			return nil, nil, fmt.Errorf("fork is deeper than %d blocks", maxForkDepth)
+		}
+
+		height--
+	}
+}
+
+//rollbackForkBlock 撤销孤立区块：通知分叉，以回滚状态重新发送其交易单的提取数据，删除区块相关的本地记录
+func (bs *BTCBlockScanner) rollbackForkBlock(forkBlock *Block) {
+
+	bs.wm.Log.Std.Info("delete recharge records on block height: %d, hash: %s.", forkBlock.Height, forkBlock.Hash)
+
+	//通知分叉区块给观测者，异步处理
+	bs.newBlockNotify(forkBlock, true)
+
+	//观测者据此撤销孤立交易单的入账
+	bs.revertForkBlockTransactions(forkBlock)
+	//孤立区块中未达到全部确认数阈值的交易单
+	bs.dropConfirmations(forkBlock.Hash)
+
+	//删除分叉区块的未扫记录
+	bs.DeleteUnscanRecord(forkBlock.Height)
+	//分叉区块的交易费率不再参与统计
+	bs.wm.blockFeeRates.remove(forkBlock.Height)
+	//回滚分叉区块的utxo变化
+	if bs.wm.config.UTXOIndex {
+		if err := bs.utxoIndex.rollback(forkBlock.Height); err != nil {
+			bs.wm.Log.Std.Error("block scanner can not rollback utxo index on height: %d; unexpected error: %v", forkBlock.Height, err)
+		}
+	}
+}
+
+//revertForkBlockTransactions 重新提取孤立区块的交易单，标记为已回滚后通知观测者
+//交易单的WxID与原提取数据一致，节点已不能返回的区块或交易单无法撤销，只记录日志
+func (bs *BTCBlockScanner) revertForkBlockTransactions(forkBlock *Block) {
+
+	block, err := bs.chain.GetBlock(forkBlock.Hash)
+	if err != nil {
+		bs.wm.Log.Std.Error("block scanner can not get fork block: %s; unexpected error: %v", forkBlock.Hash, err)
+		return
+	}
+
+	results, err := bs.extractBlockTransactions(forkBlock.Height, forkBlock.Hash, block.tx)
+	if err != nil {
+		bs.wm.Log.Std.Error("block scanner can not extract fork block: %s; unexpected error: %v", forkBlock.Hash, err)
+		return
+	}
+
+	for _, result := range results {
+		if !result.Success {
+			bs.wm.Log.Std.Error("block scanner can not revert transaction: %s in fork block: %s", result.TxID, forkBlock.Hash)
+			continue
+		}
+
+		for _, extractData := range result.extractData {
+			markExtractDataReverted(extractData, forkBlock)
+		}
+		for _, list := range result.extractContractData {
+			for _, extractData := range list {
+				markExtractDataReverted(extractData, forkBlock)
+			}
+		}
+
+		if err := bs.newExtractDataNotify(forkBlock.Height, result.extractData); err != nil {
+			bs.wm.Log.Std.Error("block scanner can not notify reverted transaction: %s; unexpected error: %v", result.TxID, err)
+		}
+		if err := bs.newExtractDataListNotify(forkBlock.Height, result.extractContractData); err != nil {
+			bs.wm.Log.Std.Error("block scanner can not notify reverted transaction: %s; unexpected error: %v", result.TxID, err)
+		}
+
+		for _, reward := range result.stakeRewards {
+			reward.Reverted = true
+		}
+		bs.notifyStakeRewards(result.stakeRewards)
+	}
+}
+
+//markExtractDataReverted 将提取数据标记为孤立区块中已回滚的交易
+//交易单状态为失败，扩展参数reverted为true，区块信息保持为孤立区块
+func markExtractDataReverted(extractData *openwallet.TxExtractData, forkBlock *Block) {
+	for _, input := range extractData.TxInputs {
+		input.BlockHeight = forkBlock.Height
+		input.BlockHash = forkBlock.Hash
+	}
+	for _, output := range extractData.TxOutputs {
+		output.BlockHeight = forkBlock.Height
+		output.BlockHash = forkBlock.Hash
+		output.SetExtParam("reverted", true)
+	}
+	if tx := extractData.Transaction; tx != nil {
+		tx.BlockHeight = forkBlock.Height
+		tx.BlockHash = forkBlock.Hash
+		tx.Status = openwallet.TxStatusFail
+		tx.SetExtParam("reverted", true)
+	}
+}
@@ -0,0 +1,138 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package qtum
+
+import (
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/blocktree/openwallet/openwallet"
+)
+
+//testScanObserver 记录扫描器的通知
+type testScanObserver struct {
+	mu       sync.Mutex
+	forks    []uint64
+	reverted []*openwallet.Transaction
+}
+
+func (o *testScanObserver) BlockScanNotify(header *openwallet.BlockHeader) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if header.Fork {
+		o.forks = append(o.forks, header.Height)
+	}
+	return nil
+}
+
+func (o *testScanObserver) BlockExtractDataNotify(sourceKey string, data *openwallet.TxExtractData) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if data.Transaction != nil && data.Transaction.GetExtParam().Get("reverted").Bool() {
+		o.reverted = append(o.reverted, data.Transaction)
+	}
+	return nil
+}
+
+func TestScanBlockTaskDeepReorg(t *testing.T) {
+
+	chain := newFakeChain()
+	chain.extend("a", 0, 20)
+
+	wm := NewWalletManager()
+	bs := wm.blockscanner
+	bs.chain = chain
+	bs.SetBlockScanAddressFunc(testScanAddress("a"))
+	dai := &fakeBlockchainDAI{
+		heads:  []*openwallet.BlockHeader{{Height: 1, Hash: "a1"}},
+		blocks: make(map[uint64]*openwallet.BlockHeader),
+	}
+	bs.SetBlockchainDAI(dai)
+	observer := &testScanObserver{}
+	bs.AddObserver(observer)
+	bs.Scanning = true
+
+	bs.ScanBlockTask()
+
+	//高度15之后的6个区块被替换
+	chain.extend("b", 15, 22)
+	bs.ScanBlockTask()
+
+	head := dai.heads[len(dai.heads)-1]
+	if head.Height != 22 || head.Hash != "b22" {
+		t.Errorf("current block head = %d:%s, want 22:b22", head.Height, head.Hash)
+	}
+
+	//分叉通知是异步的
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		observer.mu.Lock()
+		n := len(observer.forks)
+		observer.mu.Unlock()
+		if n >= 6 || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	observer.mu.Lock()
+	defer observer.mu.Unlock()
+
+	sort.Slice(observer.forks, func(i, j int) bool { return observer.forks[i] < observer.forks[j] })
+	if len(observer.forks) != 6 || observer.forks[0] != 15 || observer.forks[5] != 20 {
+		t.Errorf("fork notifications = %v, want 15 to 20", observer.forks)
+	}
+
+	if len(observer.reverted) != 6 {
+		t.Fatalf("reverted transactions = %d, want 6", len(observer.reverted))
+	}
+	for _, tx := range observer.reverted {
+		if tx.Status != openwallet.TxStatusFail || tx.BlockHash != "a"+tx.TxID[len("coinbase_a"):] {
+			t.Errorf("reverted transaction %s = status %s in block %s", tx.TxID, tx.Status, tx.BlockHash)
+		}
+	}
+}
+
+func TestFindForkAncestor(t *testing.T) {
+
+	chain := newFakeChain()
+	chain.extend("a", 0, 10)
+
+	wm := NewWalletManager()
+	bs := wm.blockscanner
+	bs.chain = chain
+	dai := &fakeBlockchainDAI{blocks: make(map[uint64]*openwallet.BlockHeader)}
+	bs.SetBlockchainDAI(dai)
+	for height := uint64(4); height <= 10; height++ {
+		hash, _ := chain.GetBlockHash(height)
+		dai.SaveLocalBlockHead(&openwallet.BlockHeader{Height: height, Hash: hash})
+	}
+
+	chain.extend("b", 7, 12)
+	ancestor, orphaned, err := bs.findForkAncestor(10)
+	if err != nil || ancestor.Hash != "a6" || len(orphaned) != 4 || orphaned[0].Hash != "a10" {
+		t.Errorf("ancestor = %+v, orphaned = %d, err = %v; want a6 and 4 orphaned blocks", ancestor, len(orphaned), err)
+	}
+
+	//本地没有记录时以节点的区块作为共同祖先
+	chain.extend("c", 3, 12)
+	ancestor, orphaned, err = bs.findForkAncestor(10)
+	if err != nil || ancestor.Hash != "c3" || len(orphaned) != 7 {
+		t.Errorf("ancestor = %+v, orphaned = %d, err = %v; want c3 and 7 orphaned blocks", ancestor, len(orphaned), err)
+	}
+}
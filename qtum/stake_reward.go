@@ -0,0 +1,234 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package qtum
+
+import (
+	"github.com/blocktree/openwallet/openwallet"
+	"github.com/shopspring/decimal"
+)
+
+const (
+	//StakeRoleStaker 用自己的utxo质押出块
+	StakeRoleStaker = "staker"
+	//StakeRoleDelegator 把质押权重委托给超级质押者，自己的utxo不被花费，收到扣除委托费后的收益
+	StakeRoleDelegator = "delegator"
+	//StakeRoleSuperStaker 用委托者的质押权重和自己的utxo出块，收取委托费
+	StakeRoleSuperStaker = "superStaker"
+)
+
+//gasRefund 合约调用退还给调用者的gas
+type gasRefund struct {
+	Address string
+	Amount  decimal.Decimal
+}
+
+//StakeReward 质押者地址在一个区块中的收益记录
+type StakeReward struct {
+	SourceKey      string
+	Address        string
+	Role           string //staker，delegator或superStaker
+	TxID           string //coinstake交易单
+	BlockHash      string
+	BlockHeight    uint64
+	Staked         decimal.Decimal //质押的输入金额，委托者为0
+	Returned       decimal.Decimal //coinstake输出给该地址的金额，不含gas退款
+	Reward         decimal.Decimal //净收益
+	GasRefund      decimal.Decimal //出块收益中退还给合约调用者的gas
+	DelegationFee  decimal.Decimal //委托者支付或超级质押者收取的委托费
+	MaturityHeight uint64          //收益可以花费的高度
+	Reverted       bool            //所在区块被分叉孤立
+}
+
+//StakeRewardNotificationObject 观测者可选实现的质押收益通知接口
+type StakeRewardNotificationObject interface {
+
+	//StakeRewardNotify 扫描到观测地址的质押收益，或收益所在区块被分叉孤立时通知
+	StakeRewardNotify(reward *StakeReward) error
+}
+
+//stakeRewardMaturityHeight 收益满StakeConfirmations个确认后可以花费
+func stakeRewardMaturityHeight(height uint64) uint64 {
+	return height + StakeConfirmations - 1
+}
+
+//computeStakeRewards 按地址拆分coinstake的收益
+//离线质押时超级质押者签名coinstake并花费自己的utxo，委托者的utxo不被花费：
+//输入地址是出块者，refunds为区块中其他交易单应退还的gas，coinstake中与之匹配的输出是gas退款，
+//其余输出给非输入地址的是委托者的收益，此时出块者是超级质押者，其净收益为委托费。只返回观测地址的记录
+func computeStakeRewards(trx *Transaction, refunds []*gasRefund, scanAddressFunc openwallet.BlockScanAddressFunc) []*StakeReward {
+
+	if trx == nil || !trx.IsCoinstake || len(trx.Vins) == 0 {
+		return nil
+	}
+
+	staker := trx.Vins[0].Addr
+	staked := make(map[string]decimal.Decimal)
+	stakers := make([]string, 0)
+	for _, input := range trx.Vins {
+		if _, ok := staked[input.Addr]; !ok {
+			stakers = append(stakers, input.Addr)
+		}
+		amount, _ := decimal.NewFromString(input.Value)
+		staked[input.Addr] = staked[input.Addr].Add(amount)
+	}
+
+	var (
+		isRefund    = trx.gasRefundOutputs(refunds)
+		returned    = make(map[string]decimal.Decimal)
+		totalRefund = decimal.Zero
+		delegated   = make(map[string]decimal.Decimal)
+		delegators  = make([]string, 0)
+	)
+	for _, output := range trx.Vouts {
+		if len(output.Addr) == 0 {
+			continue
+		}
+		amount, _ := decimal.NewFromString(output.Value)
+		_, isStaker := staked[output.Addr]
+		if isRefund[output.N] {
+			totalRefund = totalRefund.Add(amount)
+		} else if isStaker {
+			returned[output.Addr] = returned[output.Addr].Add(amount)
+		} else {
+			if _, ok := delegated[output.Addr]; !ok {
+				delegators = append(delegators, output.Addr)
+			}
+			delegated[output.Addr] = delegated[output.Addr].Add(amount)
+		}
+	}
+
+	role := StakeRoleStaker
+	if len(delegators) > 0 {
+		role = StakeRoleSuperStaker
+	}
+
+	newReward := func(sourceKey, address, role string) *StakeReward {
+		return &StakeReward{
+			SourceKey:      sourceKey,
+			Address:        address,
+			Role:           role,
+			TxID:           trx.TxID,
+			BlockHash:      trx.BlockHash,
+			BlockHeight:    trx.BlockHeight,
+			Staked:         decimal.Zero,
+			Returned:       decimal.Zero,
+			Reward:         decimal.Zero,
+			GasRefund:      decimal.Zero,
+			DelegationFee:  decimal.Zero,
+			MaturityHeight: stakeRewardMaturityHeight(trx.BlockHeight),
+		}
+	}
+
+	//超级质押者的净收益即委托费
+	delegationFee := decimal.Zero
+	if role == StakeRoleSuperStaker {
+		for _, address := range stakers {
+			delegationFee = delegationFee.Add(returned[address].Sub(staked[address]))
+		}
+	}
+
+	rewards := make([]*StakeReward, 0)
+	for _, address := range stakers {
+		sourceKey, ok := scanAddressFunc(address)
+		if !ok {
+			continue
+		}
+		reward := newReward(sourceKey, address, role)
+		reward.Staked = staked[address]
+		reward.Returned = returned[address]
+		reward.Reward = reward.Returned.Sub(reward.Staked)
+		//gas退款由第一个输入的出块者承担，委托费由其收取
+		if address == staker {
+			reward.GasRefund = totalRefund
+			reward.DelegationFee = delegationFee
+		}
+		rewards = append(rewards, reward)
+	}
+	for i, address := range delegators {
+		sourceKey, ok := scanAddressFunc(address)
+		if !ok {
+			continue
+		}
+		reward := newReward(sourceKey, address, StakeRoleDelegator)
+		reward.Returned = delegated[address]
+		reward.Reward = delegated[address]
+		//委托费由第一个委托者支付
+		if i == 0 {
+			reward.DelegationFee = delegationFee
+		}
+		rewards = append(rewards, reward)
+	}
+
+	return rewards
+}
+
+//extractStakeRewards 区块的交易单全部提取后，计算coinstake中观测地址的收益，
+//并记录到质押者提取数据的交易单扩展参数。有交易单提取失败时无法确定gas退款，等待重扫
+func (bs *BTCBlockScanner) extractStakeRewards(results []ExtractResult) {
+
+	var (
+		stake   *ExtractResult
+		refunds = make([]*gasRefund, 0)
+	)
+	for i := range results {
+		if !results[i].Success {
+			return
+		}
+		if results[i].rewardTx != nil && results[i].rewardTx.IsCoinstake {
+			stake = &results[i]
+		}
+		refunds = append(refunds, results[i].gasRefunds...)
+	}
+	if stake == nil {
+		return
+	}
+
+	stake.stakeRewards = computeStakeRewards(stake.rewardTx, refunds, bs.ScanAddressFunc)
+
+	for _, reward := range stake.stakeRewards {
+		extractData := stake.extractData[reward.SourceKey]
+		if extractData == nil || extractData.Transaction == nil {
+			continue
+		}
+		tx := extractData.Transaction
+		tx.SetExtParam("stakeRole", reward.Role)
+		tx.SetExtParam("stakeReward", reward.Reward.StringFixed(bs.wm.Decimal()))
+		tx.SetExtParam("stakeDelegationFee", reward.DelegationFee.StringFixed(bs.wm.Decimal()))
+		tx.SetExtParam("stakeGasRefund", reward.GasRefund.StringFixed(bs.wm.Decimal()))
+		tx.SetExtParam("maturityHeight", reward.MaturityHeight)
+	}
+}
+
+//notifyStakeRewards 通知实现了StakeRewardNotificationObject的观测者
+func (bs *BTCBlockScanner) notifyStakeRewards(rewards []*StakeReward) error {
+	if len(rewards) == 0 {
+		return nil
+	}
+	var failed error
+	for o := range bs.Observers {
+		observer, ok := o.(StakeRewardNotificationObject)
+		if !ok {
+			continue
+		}
+		for _, reward := range rewards {
+			if err := observer.StakeRewardNotify(reward); err != nil {
+				bs.wm.Log.Std.Error("StakeRewardNotify unexpected error: %v", err)
+				failed = err
+			}
+		}
+	}
+	return failed
+}
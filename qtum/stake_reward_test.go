@@ -0,0 +1,133 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package qtum
+
+import (
+	"testing"
+
+	"github.com/blocktree/openwallet/openwallet"
+	"github.com/shopspring/decimal"
+	"github.com/tidwall/gjson"
+)
+
+func testGasRefund(address, amount string) *gasRefund {
+	return &gasRefund{Address: address, Amount: decimal.RequireFromString(amount)}
+}
+
+func TestComputeStakeRewards(t *testing.T) {
+
+	//自己质押，拆分为两个输出，退还调用者c的gas
+	coinstake := &Transaction{
+		TxID:        "stake",
+		BlockHash:   "h100",
+		BlockHeight: 100,
+		IsCoinstake: true,
+		Vins:        []*Vin{{Addr: "a", Value: "100"}},
+		Vouts: []*Vout{
+			{N: 0, Value: "0"},
+			{N: 1, Addr: "a", Value: "52"},
+			{N: 2, Addr: "a", Value: "52"},
+			{N: 3, Addr: "c", Value: "0.5"},
+		},
+	}
+	rewards := computeStakeRewards(coinstake, []*gasRefund{testGasRefund("c", "0.5")}, testScanAddress("a", "c"))
+	if len(rewards) != 1 {
+		t.Fatalf("rewards = %d, want 1", len(rewards))
+	}
+	r := rewards[0]
+	if r.Role != StakeRoleStaker || r.Reward.String() != "4" || r.GasRefund.String() != "0.5" || !r.DelegationFee.IsZero() {
+		t.Errorf("staker reward = %s %s, gas refund %s, delegation fee %s", r.Role, r.Reward, r.GasRefund, r.DelegationFee)
+	}
+	if r.MaturityHeight != 100+StakeConfirmations-1 {
+		t.Errorf("maturity height = %d", r.MaturityHeight)
+	}
+
+	//离线质押：超级质押者s花费自己的utxo出块，委托者d收到扣除委托费后的收益，c的退款不是委托者收益
+	coinstake.Vins = []*Vin{{Addr: "s", Value: "100"}}
+	coinstake.Vouts = []*Vout{
+		{N: 0, Value: "0"},
+		{N: 1, Addr: "s", Value: "100.4"},
+		{N: 2, Addr: "d", Value: "3.6"},
+		{N: 3, Addr: "c", Value: "0.5"},
+	}
+	rewards = computeStakeRewards(coinstake, []*gasRefund{testGasRefund("c", "0.5")}, testScanAddress("s", "d"))
+	if len(rewards) != 2 {
+		t.Fatalf("rewards = %d, want 2", len(rewards))
+	}
+	if r := rewards[0]; r.Role != StakeRoleSuperStaker || r.Address != "s" || r.Staked.String() != "100" || r.Reward.String() != "0.4" || r.DelegationFee.String() != "0.4" || r.GasRefund.String() != "0.5" {
+		t.Errorf("super staker reward = %s %s, staked %s, delegation fee %s, gas refund %s", r.Role, r.Reward, r.Staked, r.DelegationFee, r.GasRefund)
+	}
+	if r := rewards[1]; r.Role != StakeRoleDelegator || r.Address != "d" || r.Reward.String() != "3.6" || r.DelegationFee.String() != "0.4" || !r.Staked.IsZero() || !r.GasRefund.IsZero() {
+		t.Errorf("delegator reward = %s %s %s, delegation fee %s", r.Role, r.Address, r.Reward, r.DelegationFee)
+	}
+
+	//退款金额不匹配时按委托者收益处理
+	rewards = computeStakeRewards(coinstake, nil, testScanAddress("c"))
+	if len(rewards) != 1 || rewards[0].Role != StakeRoleDelegator || rewards[0].Reward.String() != "0.5" {
+		t.Errorf("unmatched refund should be a delegator reward")
+	}
+}
+
+func TestExtractStakeRewards(t *testing.T) {
+
+	bs := NewBTCBlockScanner(NewWalletManager())
+	bs.SetBlockScanAddressFunc(testScanAddress("a"))
+
+	stakeTx := &openwallet.Transaction{TxID: "stake", TxAction: "coinstake"}
+	results := []ExtractResult{
+		{TxID: "coinbase", Success: true},
+		{
+			TxID:    "stake",
+			Success: true,
+			rewardTx: &Transaction{
+				TxID:        "stake",
+				BlockHeight: 100,
+				IsCoinstake: true,
+				Vins:        []*Vin{{Addr: "a", Value: "100"}},
+				Vouts:       []*Vout{{N: 0, Value: "0"}, {N: 1, Addr: "a", Value: "104"}, {N: 2, Addr: "c", Value: "0.5"}},
+			},
+			extractData: map[string]*openwallet.TxExtractData{"account": {Transaction: stakeTx}},
+		},
+		{TxID: "call", Success: true, gasRefunds: []*gasRefund{testGasRefund("c", "0.5")}},
+	}
+
+	bs.extractStakeRewards(results)
+	if len(results[1].stakeRewards) != 1 || results[1].stakeRewards[0].Reward.String() != "4" {
+		t.Fatalf("stake rewards = %+v", results[1].stakeRewards)
+	}
+	if stakeTx.GetExtParam().Get("stakeReward").String() != "4.00000000" || stakeTx.GetExtParam().Get("maturityHeight").Uint() != 599 {
+		t.Errorf("stake transaction ext params = %s", stakeTx.ExtParam)
+	}
+
+	//有交易单提取失败时无法确定gas退款
+	results[1].stakeRewards = nil
+	results[2].Success = false
+	bs.extractStakeRewards(results)
+	if len(results[1].stakeRewards) != 0 {
+		t.Errorf("stake rewards should wait for rescan when a transaction failed")
+	}
+}
+
+func TestNewTxByCoreCoinstake(t *testing.T) {
+	raw := gjson.Parse(`{"txid":"stake","vin":[{"txid":"prev","vout":1}],"vout":[{"value":0,"n":0,"scriptPubKey":{"hex":""}},{"value":104,"n":1,"scriptPubKey":{"hex":"76a9","addresses":["a"]}}]}`)
+	if trx := newTxByCore(&raw, true); !trx.IsCoinstake || trx.IsCoinBase {
+		t.Errorf("coinstake = %v, coinbase = %v; want coinstake", trx.IsCoinstake, trx.IsCoinBase)
+	}
+	raw = gjson.Parse(`{"txid":"coinbase","vin":[{"coinbase":"03a0"}],"vout":[{"value":0,"n":0,"scriptPubKey":{"hex":""}},{"value":0,"n":1,"scriptPubKey":{"hex":"6a24"}}]}`)
+	if trx := newTxByCore(&raw, true); trx.IsCoinstake || !trx.IsCoinBase {
+		t.Errorf("coinstake = %v, coinbase = %v; want coinbase", trx.IsCoinstake, trx.IsCoinBase)
+	}
+}
@@ -19,8 +19,10 @@ import (
 	"encoding/hex"
 	"fmt"
 	"testing"
-	"github.com/blocktree/qtum-adapter/qtum/btcLikeTxDriver"
+	"github.com/Assetsadapter/qtum-adapter/qtum/btcLikeTxDriver"
 	"github.com/blocktree/go-owcdrivers/addressEncoder"
+	"github.com/blocktree/openwallet/openwallet"
+	"github.com/shopspring/decimal"
 )
 
 //案例一：
@@ -381,3 +383,117 @@ func Test_case3(t *testing.T) {
 		t.Error("验证失败")
 	}
 }
+
+//构建时记录的输入信息，签名验证时无需查询节点
+func TestRawTransactionPrevOuts(t *testing.T) {
+	decoder := NewTransactionDecoder(NewWalletManager())
+	pkHash, _ := hex.DecodeString("dbb494b649a48b22bfd6383dca1712cc401cddde")
+	address := addressEncoder.AddressEncode(pkHash, addressEncoder.QTUM_testnetAddressP2PKH)
+
+	vins := []btcLikeTxDriver.Vin{
+		{"511bac90d2fe072e736d8b58161f34da631526508754febe263c40e3ce4e4b10", 0},
+		{"6cb0425bb4bb962db8359b8d3cbaa66ed8121091db6cfc9253f5bf1e9cef604f", 1},
+	}
+	txUnlocks := []btcLikeTxDriver.TxUnlock{
+		{LockScript: "76a914dbb494b649a48b22bfd6383dca1712cc401cddde88ac", Address: address, Amount: 64467249},
+		{LockScript: "a914b9b2a5b9ea2d2a50bd4ce4e1d0e3d4e2bbd4a3a987", RedeemScript: "5221aa", Amount: 1000},
+	}
+	emptyTrans, err := btcLikeTxDriver.CreateEmptyRawTransaction(vins, []btcLikeTxDriver.Vout{{address, 64000000}}, 0, false, true)
+	if err != nil {
+		t.Fatalf("create transaction failed: %v", err)
+	}
+
+	rawTx := &openwallet.RawTransaction{RawHex: emptyTrans, ExtParam: `{"contractAddress":"0x91a6081095ef860d28874c9db613e7a4107b0281"}`}
+	if err := setRawTransactionPrevOuts(rawTx, vins, txUnlocks); err != nil {
+		t.Fatalf("set prevOuts failed: %v", err)
+	}
+	if rawTx.GetExtParam().Get("contractAddress").String() == "" {
+		t.Errorf("existing extParam was lost")
+	}
+
+	txBytes, _ := hex.DecodeString(emptyTrans)
+	trx, _ := btcLikeTxDriver.DecodeRawTransaction(txBytes)
+	got, err := decoder.getRawTransactionTxUnlocks(nil, rawTx, trx)
+	if err != nil {
+		t.Fatalf("get txUnlocks failed: %v", err)
+	}
+	for i := range txUnlocks {
+		if got[i].LockScript != txUnlocks[i].LockScript || got[i].RedeemScript != txUnlocks[i].RedeemScript ||
+			got[i].Address != txUnlocks[i].Address || got[i].Amount != txUnlocks[i].Amount {
+			t.Errorf("txUnlock %d = %+v, want %+v", i, got[i], txUnlocks[i])
+		}
+	}
+
+	//记录与交易单输入不一致
+	swapped, _ := btcLikeTxDriver.CreateEmptyRawTransaction([]btcLikeTxDriver.Vin{vins[1], vins[0]}, []btcLikeTxDriver.Vout{{address, 64000000}}, 0, false, true)
+	txBytes, _ = hex.DecodeString(swapped)
+	trx, _ = btcLikeTxDriver.DecodeRawTransaction(txBytes)
+	if _, err := decoder.getRawTransactionTxUnlocks(nil, rawTx, trx); err == nil {
+		t.Errorf("mismatched prevOuts should fail")
+	}
+}
+
+//子交易的手续费使父子交易整体达到目标费率
+func TestCPFPChildFees(t *testing.T) {
+	wm := NewWalletManager()
+	feeRate := decimal.RequireFromString("0.004")
+
+	//子交易192字节，单独需要0.000768；父交易200字节只付了0.0001，整体还差0.0007
+	fees, err := cpfpChildFees(wm, 200, decimal.RequireFromString("0.0001"), 1, feeRate)
+	if err != nil {
+		t.Fatalf("cpfpChildFees failed: %v", err)
+	}
+	if !fees.Equal(decimal.RequireFromString("0.001468")) {
+		t.Errorf("child fees = %s, want 0.001468", fees.String())
+	}
+
+	//父交易已达到目标费率，子交易只需支付自身的手续费
+	fees, _ = cpfpChildFees(wm, 200, decimal.RequireFromString("0.01"), 1, feeRate)
+	if !fees.Equal(decimal.RequireFromString("0.000768")) {
+		t.Errorf("child fees = %s, want 0.000768", fees.String())
+	}
+}
+
+//手续费按签名后交易单的虚拟大小计算
+func TestVSizeFees(t *testing.T) {
+	wm := NewWalletManager()
+	decoder := NewTransactionDecoder(wm)
+	wm.config.MinFees = decimal.Zero
+
+	pkHash, _ := hex.DecodeString("dbb494b649a48b22bfd6383dca1712cc401cddde")
+	address := addressEncoder.AddressEncode(pkHash, addressEncoder.QTUM_testnetAddressP2PKH)
+	vins := []btcLikeTxDriver.Vin{{"511bac90d2fe072e736d8b58161f34da631526508754febe263c40e3ce4e4b10", 0}}
+	txUnlocks := []btcLikeTxDriver.TxUnlock{{LockScript: "76a914dbb494b649a48b22bfd6383dca1712cc401cddde88ac"}}
+	emptyTrans, _ := btcLikeTxDriver.CreateEmptyRawTransaction(vins, []btcLikeTxDriver.Vout{{address, 64000000}, {address, 1000}}, 0, false, true)
+
+	//1个P2PKH输入149字节，2个P2PKH输出68字节，其余10字节
+	rawTx := &openwallet.RawTransaction{FeeRate: "0.004"}
+	fees, err := decoder.vsizeFees(rawTx, emptyTrans, txUnlocks)
+	if err != nil {
+		t.Fatalf("vsizeFees failed: %v", err)
+	}
+	if !fees.Equal(decimal.RequireFromString("0.000908")) {
+		t.Errorf("fees = %s, want 0.000908", fees.String())
+	}
+
+	//不低于最低手续费
+	wm.config.MinFees = decimal.RequireFromString("0.001")
+	if fees, _ = decoder.vsizeFees(rawTx, emptyTrans, txUnlocks); !fees.Equal(wm.config.MinFees) {
+		t.Errorf("fees = %s, want the minimum fees", fees.String())
+	}
+}
+
+//替换交易的手续费至少比原交易多出按增量中继费率计算的自身手续费
+func TestReplacementMinFees(t *testing.T) {
+	wm := NewWalletManager()
+
+	//227字节的替换交易，增量费率0.004/KB
+	if fees := wm.replacementMinFees(decimal.RequireFromString("0.0005"), 227); !fees.Equal(decimal.RequireFromString("0.001408")) {
+		t.Errorf("replacement min fees = %s, want 0.001408", fees.String())
+	}
+
+	wm.config.IncrementalRelayFee = decimal.RequireFromString("0.01")
+	if fees := wm.replacementMinFees(decimal.RequireFromString("0.0005"), 1000); !fees.Equal(decimal.RequireFromString("0.0105")) {
+		t.Errorf("replacement min fees = %s, want 0.0105", fees.String())
+	}
+}
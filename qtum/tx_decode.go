@@ -19,17 +19,22 @@ import (
 	"encoding/hex"
 	"errors"
 	"fmt"
+	"github.com/blocktree/go-owcdrivers/owkeychain"
+	"github.com/blocktree/openwallet/hdkeystore"
 	"github.com/blocktree/openwallet/openwallet"
-	"github.com/blocktree/qtum-adapter/qtum/btcLikeTxDriver"
+	"github.com/Assetsadapter/qtum-adapter/qtum/btcLikeTxDriver"
 	"github.com/shopspring/decimal"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 )
 
 var (
-	DEFAULT_GAS_LIMIT = "250000"
+	DEFAULT_GAS_LIMIT = uint64(250000)
 	DEFAULT_GAS_PRICE = decimal.New(4, -7)
+	//QTUM允许的最低gas上限
+	MIN_GAS_LIMIT = uint64(10000)
 )
 
 type TransactionDecoder struct {
@@ -198,6 +203,12 @@ func (decoder *TransactionDecoder) CreateSimpleSummaryRawTransaction(wrapper ope
 			return nil, err
 		}
 
+		//花费utxo的手续费不低于其金额的地址不汇总
+		if isUneconomicalToSpend(unspents, feesRate) {
+			decoder.wm.Log.Std.Warning("skip summary address %s, the fees of spending its %d utxo exceed the balance", addr, len(unspents))
+			unspents = nil
+		}
+
 		//尽可能筹够最大input数
 		unspentLimit := decoder.wm.config.maxTxInputs - len(sumUnspents)
 		if unspentLimit > 0 {
@@ -269,7 +280,7 @@ func (decoder *TransactionDecoder) CreateSimpleSummaryRawTransaction(wrapper ope
 					Required: 1,
 				}
 
-				createErr = decoder.createSimpleRawTransaction(wrapper, rawTx, sumUnspents, outputAddrs)
+				createErr = decoder.createSimpleRawTransaction(wrapper, rawTx, sumUnspents, outputAddrs, sumRawTx.SummaryAddress)
 				rawTxWithErr := &openwallet.RawTransactionWithError{
 					RawTx: rawTx,
 					Error: openwallet.ConvertError(createErr),
@@ -324,10 +335,6 @@ func (decoder *TransactionDecoder) CreateQRC20RawTransaction(wrapper openwallet.
 	tokenCoin := rawTx.Coin.Contract.Token
 	tokenDecimals := int32(rawTx.Coin.Contract.Decimals)
 
-	//TODO: 应该通过配置文件设置
-	//合约手续费在普通交易基础上加0.1个qtum, 小数点8位
-	actualFees, _ = decimal.NewFromString("0.1")
-
 	address, err := wrapper.GetAddressList(0, 200, "AccountID", rawTx.Account.AccountID)
 	if err != nil {
 		return err
@@ -449,6 +456,18 @@ func (decoder *TransactionDecoder) CreateQRC20RawTransaction(wrapper openwallet.
 	//选择一个地址作为发送
 	//txFrom = []string{fmt.Sprintf("%s:%s", availableUTXO[0].Address, toAmount.StringFixed(tokenDecimals))}
 
+	//合约调用的gas设置，gas上限×gas价格为合约最多消耗的手续费
+	callData, err := QRC20TransferCallData(toAddress, toAmount, tokenDecimals, decoder.wm.config.isTestNet)
+	if err != nil {
+		return openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "%v", err)
+	}
+
+	gas, err := decoder.wm.GetContractGas(rawTx.Coin.Contract.Address, useTokenAddress, callData)
+	if err != nil {
+		return err
+	}
+	actualFees = gas.Fees()
+
 	//获取utxo，按小到大排序
 	sort.Sort(UnspentSort{availableUTXO, func(a, b *Unspent) int {
 
@@ -470,13 +489,9 @@ func (decoder *TransactionDecoder) CreateQRC20RawTransaction(wrapper openwallet.
 	}
 
 	//获取手续费率
-	if len(rawTx.FeeRate) == 0 {
-		feesRate, err = decoder.wm.EstimateFeeRate()
-		if err != nil {
-			return err
-		}
-	} else {
-		feesRate, _ = decimal.NewFromString(rawTx.FeeRate)
+	feesRate, err = decoder.rawTransactionFeeRate(rawTx)
+	if err != nil {
+		return err
 	}
 
 	decoder.wm.Log.Info("Calculating wallet unspent record to build transaction...")
@@ -512,7 +527,7 @@ func (decoder *TransactionDecoder) CreateQRC20RawTransaction(wrapper openwallet.
 
 		//如果要手续费有发送支付，得计算加入手续费后，计算余额是否足够
 		//总共要发送的
-		actualFees = actualFees.Add(fees)
+		actualFees = gas.Fees().Add(fees)
 		if actualFees.GreaterThan(balance) {
 			continue
 		}
@@ -552,7 +567,7 @@ func (decoder *TransactionDecoder) CreateQRC20RawTransaction(wrapper openwallet.
 
 	tokenOutputAddrs[toAddress] = toAmount.StringFixed(tokenDecimals)
 
-	err = decoder.createQRC2ORawTransaction(wrapper, rawTx, usedUTXO, outputAddrs, tokenOutputAddrs)
+	err = decoder.createQRC2ORawTransaction(wrapper, rawTx, usedUTXO, outputAddrs, tokenOutputAddrs, gas)
 	if err != nil {
 		return err
 	}
@@ -594,8 +609,6 @@ func (decoder *TransactionDecoder) CreateQRC20SummaryRawTransaction(wrapper open
 
 	tokenDecimals := int32(sumRawTx.Coin.Contract.Decimals)
 
-	//合约手续费在普通交易基础上加0.1个qtum, 小数点8位
-	transferCost, _ := decimal.NewFromString(decoder.wm.config.TokenTransferCost)
 	//coinDecimals := decoder.wm.Decimal()
 
 	if minTransfer.LessThan(retainedBalance) {
@@ -667,6 +680,28 @@ func (decoder *TransactionDecoder) CreateQRC20SummaryRawTransaction(wrapper open
 			}
 
 		}
+		//计算汇总数量
+		sumTokenAmount := tokenBalance.Sub(retainedBalance)
+
+		//合约调用的gas设置，合约最多消耗的手续费为最低转账成本
+		callData, createErr := QRC20TransferCallData(sumRawTx.SummaryAddress, sumTokenAmount, tokenDecimals, decoder.wm.config.isTestNet)
+		if createErr != nil {
+			return nil, openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "%v", createErr)
+		}
+		gas, createErr := decoder.wm.GetContractGas(sumRawTx.Coin.Contract.Address, address.Address, callData)
+		if createErr != nil {
+			rawTxWithErr := &openwallet.RawTransactionWithError{
+				RawTx: &openwallet.RawTransaction{
+					Coin:    sumRawTx.Coin,
+					Account: sumRawTx.Account,
+				},
+				Error: openwallet.ConvertError(createErr),
+			}
+			rawTxArray = append(rawTxArray, rawTxWithErr)
+			continue
+		}
+		transferCost := gas.Fees()
+
 		//decoder.wm.Log.Debug("addrBalance:", addrBalance)
 		//计算手续费，构建交易单inputs，输出2个，1个为目标地址，1个为OP_CALL
 		fees, createErr := decoder.wm.EstimateFee(int64(len(sumUnspents)), 2, feesRate)
@@ -763,8 +798,6 @@ func (decoder *TransactionDecoder) CreateQRC20SummaryRawTransaction(wrapper open
 			//outputAddrs[address.Address] = changeAmount.StringFixed(coinDecimals)
 		}
 
-		//计算汇总数量
-		sumTokenAmount := tokenBalance.Sub(retainedBalance)
 		//token输出汇总地址及汇总数量
 		tokenOutputAddrs[sumRawTx.SummaryAddress] = sumTokenAmount.StringFixed(tokenDecimals)
 
@@ -784,7 +817,7 @@ func (decoder *TransactionDecoder) CreateQRC20SummaryRawTransaction(wrapper open
 			Required: 1,
 		}
 
-		createErr = decoder.createQRC2ORawTransaction(wrapper, rawTx, sumUnspents, outputAddrs, tokenOutputAddrs)
+		createErr = decoder.createQRC2ORawTransaction(wrapper, rawTx, sumUnspents, outputAddrs, tokenOutputAddrs, gas)
 		rawTxWithErr := &openwallet.RawTransactionWithError{
 			RawTx: rawTx,
 			Error: openwallet.ConvertError(createErr),
@@ -825,6 +858,10 @@ func (decoder *TransactionDecoder) SignRawTransaction(wrapper openwallet.WalletD
 		return err
 	}
 
+	if isMultiSigAccount(rawTx.Account) {
+		return decoder.signMultiSigRawTransaction(wrapper, key, rawTx)
+	}
+
 	keySignatures := rawTx.Signatures[rawTx.Account.AccountID]
 	if keySignatures != nil {
 		for _, keySignature := range keySignatures {
@@ -849,18 +886,15 @@ func (decoder *TransactionDecoder) SignRawTransaction(wrapper openwallet.WalletD
 		return errors.New("Invalid transaction data! ")
 	}
 
-	for i, vin := range trx.Vins {
-
-		utxo, err := decoder.wm.GetTxOut(vin.GetTxID(), uint64(vin.GetVout()))
-		if err != nil {
-			return err
-		}
+	if len(privateKeys) != len(trx.Vins) {
+		return fmt.Errorf("the number of signatures and transaction inputs are not match")
+	}
 
-		keyBytes := privateKeys[i]
+	//签名只需要私钥和待签名哈希，不需要查询节点
+	for i := range trx.Vins {
 
 		txUnlock := btcLikeTxDriver.TxUnlock{
-			LockScript: utxo.ScriptPubKey,
-			PrivateKey: keyBytes,
+			PrivateKey: privateKeys[i],
 		}
 		txUnlocks = append(txUnlocks, txUnlock)
 
@@ -899,6 +933,71 @@ func (decoder *TransactionDecoder) SignRawTransaction(wrapper openwallet.WalletD
 	return nil
 }
 
+//signMultiSigRawTransaction 多重签名交易单，只为当前钱包持有的拥有者公钥签名
+func (decoder *TransactionDecoder) signMultiSigRawTransaction(wrapper openwallet.WalletDAI, key *hdkeystore.HDKey, rawTx *openwallet.RawTransaction) error {
+
+	signed := 0
+
+	for ownerID, keySignatures := range rawTx.Signatures {
+
+		account, err := wrapper.GetAssetsAccountInfo(ownerID)
+		if err != nil || account == nil {
+			//非当前钱包的拥有者，跳过
+			if openwallet.GenAccountID(rawTx.Account.PublicKey) != ownerID {
+				continue
+			}
+			account = rawTx.Account
+		}
+
+		for _, keySignature := range keySignatures {
+
+			if len(keySignature.Signature) > 0 {
+				continue
+			}
+
+			paths := strings.Split(keySignature.Address.HDPath, "/")
+			if len(paths) < 2 {
+				return fmt.Errorf("invalid address hdPath: %s", keySignature.Address.HDPath)
+			}
+
+			derivedPath := fmt.Sprintf("%s/%s/%s", account.HDPath, paths[len(paths)-2], paths[len(paths)-1])
+
+			childKey, err := key.DerivedKeyWithPath(derivedPath, keySignature.EccType)
+			if err != nil {
+				return err
+			}
+
+			//公钥不一致，说明不是当前钱包的拥有者密钥
+			if hex.EncodeToString(childKey.GetPublicKeyBytes()) != keySignature.Address.PublicKey {
+				break
+			}
+
+			keyBytes, err := childKey.GetPrivateKeyBytes()
+			if err != nil {
+				return err
+			}
+
+			sigPub, err := btcLikeTxDriver.SignRawTransactionHash(
+				[]string{keySignature.Message},
+				[]btcLikeTxDriver.TxUnlock{{PrivateKey: keyBytes}})
+			if err != nil {
+				return fmt.Errorf("transaction hash sign failed, unexpected error: %v", err)
+			}
+
+			keySignature.Signature = hex.EncodeToString(sigPub[0].Signature)
+			signed++
+		}
+	}
+
+	if signed == 0 {
+		return fmt.Errorf("no multisig owner key of this wallet matched the transaction")
+	}
+
+	decoder.wm.Log.Info("transaction hash sign success")
+
+	return nil
+}
+
 //VerifyRawTransaction 验证交易单，验证交易单并返回加入签名后的交易单
 func (decoder *TransactionDecoder) VerifyRawTransaction(wrapper openwallet.WalletDAI, rawTx *openwallet.RawTransaction) error {
 
@@ -919,7 +1018,9 @@ func (decoder *TransactionDecoder) VerifyRawTransaction(wrapper openwallet.Walle
 		return fmt.Errorf("transaction signature is empty")
 	}
 
-	//:待支持多重签名
+	if isMultiSigAccount(rawTx.Account) {
+		return decoder.verifyMultiSigRawTransaction(wrapper, rawTx)
+	}
 
 	for accountID, keySignatures := range rawTx.Signatures {
 		decoder.wm.Log.Debug("accountID Signatures:", accountID)
@@ -950,16 +1051,9 @@ func (decoder *TransactionDecoder) VerifyRawTransaction(wrapper openwallet.Walle
 		return errors.New("Invalid transaction data! ")
 	}
 
-	for _, vin := range trx.Vins {
-
-		utxo, err := decoder.wm.GetTxOut(vin.GetTxID(), uint64(vin.GetVout()))
-		if err != nil {
-			return err
-		}
-
-		txUnlock := btcLikeTxDriver.TxUnlock{LockScript: utxo.ScriptPubKey}
-		txUnlocks = append(txUnlocks, txUnlock)
-
+	txUnlocks, err = decoder.getRawTransactionTxUnlocks(wrapper, rawTx, trx)
+	if err != nil {
+		return err
 	}
 
 	//decoder.wm.Log.Debug(emptyTrans)
@@ -990,6 +1084,90 @@ func (decoder *TransactionDecoder) VerifyRawTransaction(wrapper openwallet.Walle
 	return nil
 }
 
+//verifyMultiSigRawTransaction 验证多重签名交易单，每个输入收集足够数量的拥有者签名后合并
+func (decoder *TransactionDecoder) verifyMultiSigRawTransaction(wrapper openwallet.WalletDAI, rawTx *openwallet.RawTransaction) error {
+
+	var (
+		txUnlocks  = make([]btcLikeTxDriver.TxUnlock, 0)
+		emptyTrans = rawTx.RawHex
+		sigPub     = make([]btcLikeTxDriver.SignaturePubkey, 0)
+		required   = int(rawTx.Account.Required)
+	)
+
+	txBytes, err := hex.DecodeString(emptyTrans)
+	if err != nil {
+		return errors.New("Invalid transaction hex data!")
+	}
+
+	trx, err := btcLikeTxDriver.DecodeRawTransaction(txBytes)
+	if err != nil {
+		return errors.New("Invalid transaction data! ")
+	}
+
+	txUnlocks, err = decoder.getRawTransactionTxUnlocks(wrapper, rawTx, trx)
+	if err != nil {
+		return err
+	}
+
+	transHash, err := btcLikeTxDriver.CreateRawTransactionHashForSig(emptyTrans, txUnlocks)
+	if err != nil {
+		return fmt.Errorf("create transaction hash for sig failed, unexpected error: %v", err)
+	}
+
+	for i, hash := range transHash {
+
+		collected := make(map[string]bool)
+		count := 0
+
+		for _, keySignatures := range rawTx.Signatures {
+			for _, keySignature := range keySignatures {
+				if count >= required {
+					break
+				}
+				if keySignature.Message != hash || len(keySignature.Signature) == 0 {
+					continue
+				}
+				if collected[keySignature.Address.PublicKey] {
+					continue
+				}
+
+				signature, _ := hex.DecodeString(keySignature.Signature)
+				pubkey, _ := hex.DecodeString(keySignature.Address.PublicKey)
+
+				sigPub = append(sigPub, btcLikeTxDriver.SignaturePubkey{
+					Signature: signature,
+					Pubkey:    pubkey,
+				})
+				collected[keySignature.Address.PublicKey] = true
+				count++
+			}
+		}
+
+		if count < required {
+			decoder.wm.Log.Debug("input", i, "signatures not enough:", count, "/", required)
+			rawTx.IsCompleted = false
+			return nil
+		}
+	}
+
+	signedTrans, err := btcLikeTxDriver.InsertSignatureIntoEmptyTransaction(emptyTrans, sigPub, txUnlocks)
+	if err != nil {
+		return fmt.Errorf("transaction compose signatures failed")
+	}
+
+	pass := btcLikeTxDriver.VerifyRawTransaction(signedTrans, txUnlocks)
+	if pass {
+		decoder.wm.Log.Debug("transaction verify passed")
+		rawTx.IsCompleted = true
+		rawTx.RawHex = signedTrans
+	} else {
+		decoder.wm.Log.Debug("transaction verify failed")
+		rawTx.IsCompleted = false
+	}
+
+	return nil
+}
+
 //SendRawTransaction 广播交易单
 func (decoder *TransactionDecoder) SubmitRawTransaction(wrapper openwallet.WalletDAI, rawTx *openwallet.RawTransaction) (*openwallet.Transaction, error) {
 
@@ -1026,6 +1204,11 @@ func (decoder *TransactionDecoder) SubmitRawTransaction(wrapper openwallet.Walle
 	rawTx.TxID = txid
 	rawTx.IsSubmit = true
 
+	//本地utxo索引记录已花费的utxo，避免交易单确认前重复使用
+	if err := decoder.wm.blockscanner.trackSubmittedTransaction(txid); err != nil {
+		decoder.wm.Log.Std.Warning("can not track submitted transaction: %s; unexpected error: %v", txid, err)
+	}
+
 	//记录一个交易单
 	tx := &openwallet.Transaction{
 		From:       rawTx.TxFrom,
@@ -1045,14 +1228,18 @@ func (decoder *TransactionDecoder) SubmitRawTransaction(wrapper openwallet.Walle
 	return tx, nil
 }
 
-//GetRawTransactionFeeRate 获取交易单的费率
+//GetRawTransactionFeeRate 获取交易单的费率，按正常确认目标预估，单位K为每千字节的费率
+//返回值不包含费率来源和确认目标，需要时断言FeeRateEstimator接口调用GetRawTransactionFeeRateByTarget。
+//交易单扩展参数feeRateSource和feeTarget只由创建交易单的方法设置，本方法不会设置
 func (decoder *TransactionDecoder) GetRawTransactionFeeRate() (feeRate string, unit string, err error) {
-	rate, err := decoder.wm.EstimateFeeRate()
+	estimate, err := decoder.wm.EstimateFeeRateByTarget(FeeTargetNormal)
 	if err != nil {
 		return "", "", err
 	}
 
-	return rate.StringFixed(decoder.wm.Decimal()), "K", nil
+	decoder.wm.Log.Debugf("fee rate: %s, source: %s, target: %s (%d blocks)", estimate.FeeRate.String(), estimate.Source, estimate.Target, estimate.Blocks)
+
+	return estimate.FeeRate.StringFixed(decoder.wm.Decimal()), "K", nil
 }
 
 //createSimpleRawTransactionWithUTXO
@@ -1086,62 +1273,46 @@ func (decoder *TransactionDecoder) createSimpleRawTransactionWithUTXO(
 		deamount, _ := decimal.NewFromString(amount)
 		totalSend = totalSend.Add(deamount)
 		destinations = append(destinations, addr)
+		outputAddrs = appendOutput(outputAddrs, addr, deamount)
 	}
 
-	if len(rawTx.FeeRate) == 0 {
-		feesRate, err = decoder.wm.EstimateFeeRate()
-		if err != nil {
-			return err
-		}
-	} else {
-		feesRate, _ = decimal.NewFromString(rawTx.FeeRate)
+	//低于粉尘阈值的转账节点不会转发
+	err = decoder.checkDustOutputs(outputAddrs)
+	if err != nil {
+		return err
 	}
+	outputAddrs = make(map[string]decimal.Decimal)
 
-	decoder.wm.Log.Info("Calculating wallet unspent record to build transaction...")
-	computeTotalSend := totalSend
-	//循环的计算余额是否足够支付发送数额+手续费
-	for {
-
-		usedUTXO = make([]*Unspent, 0)
-		balance = decimal.New(0, 0)
-
-		//计算一个可用于支付的余额
-		for _, u := range unspents {
-
-			if u.Spendable {
-				ua, _ := decimal.NewFromString(u.Amount)
-				balance = balance.Add(ua)
-				usedUTXO = append(usedUTXO, u)
-				if balance.GreaterThanOrEqual(computeTotalSend) {
-					break
-				}
-			}
-		}
-
-		if balance.LessThan(computeTotalSend) {
-			return openwallet.Errorf(openwallet.ErrInsufficientBalanceOfAccount, "The balance: %s is not enough! ", balance.StringFixed(decoder.wm.Decimal()))
-		}
-
-		//计算手续费，找零地址有2个，一个是发送，一个是新创建的
-		fees, err := decoder.wm.EstimateFee(int64(len(usedUTXO)), int64(len(destinations)+1), feesRate)
-		if err != nil {
-			return err
-		}
-
-		//如果要手续费有发送支付，得计算加入手续费后，计算余额是否足够
-		//总共要发送的
-		computeTotalSend = totalSend.Add(fees)
-		if computeTotalSend.GreaterThan(balance) {
-			continue
-		}
-		computeTotalSend = totalSend
-
-		actualFees = fees
+	feesRate, err = decoder.rawTransactionFeeRate(rawTx)
+	if err != nil {
+		return err
+	}
 
-		break
+	selector, err := decoder.coinSelector(rawTx)
+	if err != nil {
+		return err
+	}
 
+	decoder.wm.Log.Info("Calculating wallet unspent record to build transaction...")
+	computeTotalSend := totalSend
+	selection, err := selector.Select(unspents, &CoinSelectionParams{
+		Target:    totalSend,
+		Outputs:   int64(len(destinations)),
+		MaxInputs: decoder.wm.config.maxTxInputs,
+		Fees: func(inputs, outputs int64) (decimal.Decimal, error) {
+			return decoder.wm.EstimateFee(inputs, outputs, feesRate)
+		},
+		InputFee:     feesRate.Mul(decimal.New(148, -3)),
+		CostOfChange: feesRate.Mul(decimal.New(148+34, -3)),
+	})
+	if err != nil {
+		return err
 	}
 
+	usedUTXO = selection.UTXOs
+	balance = selection.Total
+	actualFees = selection.Fees
+
 	//UTXO如果大于设定限制，则分拆成多笔交易单发送
 	if len(usedUTXO) > decoder.wm.config.maxTxInputs {
 		errStr := fmt.Sprintf("The transaction is use max inputs over: %d", decoder.wm.config.maxTxInputs)
@@ -1151,7 +1322,20 @@ func (decoder *TransactionDecoder) createSimpleRawTransactionWithUTXO(
 	//取账户最后一个地址
 	changeAddress := usedUTXO[0].Address
 
-	changeAmount := balance.Sub(computeTotalSend).Sub(actualFees)
+	changeAmount := selection.Change
+
+	//低于粉尘阈值的找零计入手续费
+	if changeAmount.IsPositive() {
+		dust, _, err := decoder.isDust(changeAddress, changeAmount)
+		if err != nil {
+			return err
+		}
+		if dust {
+			actualFees = actualFees.Add(changeAmount)
+			changeAmount = decimal.Zero
+		}
+	}
+
 	rawTx.FeeRate = feesRate.StringFixed(decoder.wm.Decimal())
 	rawTx.Fees = actualFees.StringFixed(decoder.wm.Decimal())
 
@@ -1174,9 +1358,12 @@ func (decoder *TransactionDecoder) createSimpleRawTransactionWithUTXO(
 	//changeAmount := balance.Sub(totalSend).Sub(actualFees)
 	if changeAmount.GreaterThan(decimal.New(0, 0)) {
 		outputAddrs = appendOutput(outputAddrs, changeAddress, changeAmount)
+	} else {
+		//无找零时多出的金额已计入手续费
+		changeAddress = ""
 	}
 
-	err = decoder.createSimpleRawTransaction(wrapper, rawTx, usedUTXO, outputAddrs)
+	err = decoder.createSimpleRawTransaction(wrapper, rawTx, usedUTXO, outputAddrs, changeAddress)
 	if err != nil {
 		return err
 	}
@@ -1190,11 +1377,13 @@ func (decoder *TransactionDecoder) createSimpleRawTransactionWithUTXO(
 }
 
 //createSimpleRawTransaction 创建原始交易单
+//changeAddress不为空时，按签名后交易单的虚拟大小重新计算手续费，与预估的差额由该地址的输出承担
 func (decoder *TransactionDecoder) createSimpleRawTransaction(
 	wrapper openwallet.WalletDAI,
 	rawTx *openwallet.RawTransaction,
 	usedUTXO []*Unspent,
 	to map[string]decimal.Decimal,
+	changeAddress string,
 ) error {
 
 	var (
@@ -1203,6 +1392,7 @@ func (decoder *TransactionDecoder) createSimpleRawTransaction(
 		vouts            = make([]btcLikeTxDriver.Vout, 0)
 		txUnlocks        = make([]btcLikeTxDriver.TxUnlock, 0)
 		totalSend        = decimal.New(0, 0)
+		totalInput       = decimal.Zero
 		destinations     = make([]string, 0)
 		accountTotalSent = decimal.Zero
 		txFrom           = make([]string, 0)
@@ -1218,18 +1408,6 @@ func (decoder *TransactionDecoder) createSimpleRawTransaction(
 		return fmt.Errorf("Receiver addresses is empty! ")
 	}
 
-	//计算总发送金额
-	for addr, amount := range to {
-		totalSend = totalSend.Add(amount)
-		destinations = append(destinations, addr)
-		//计算账户的实际转账amount
-		addresses, findErr := wrapper.GetAddressList(0, -1, "AccountID", accountID, "Address", addr)
-		if findErr != nil || len(addresses) == 0 {
-			//amountDec, _ := decimal.NewFromString(amount)
-			accountTotalSent = accountTotalSent.Add(amount)
-		}
-	}
-
 	//UTXO如果大于设定限制，则分拆成多笔交易单发送
 	if len(usedUTXO) > decoder.wm.config.maxTxInputs {
 		errStr := fmt.Sprintf("The transaction is use max inputs over: %d", decoder.wm.config.maxTxInputs)
@@ -1241,12 +1419,86 @@ func (decoder *TransactionDecoder) createSimpleRawTransaction(
 		in := btcLikeTxDriver.Vin{utxo.TxID, uint32(utxo.Vout)}
 		vins = append(vins, in)
 
-		txUnlock := btcLikeTxDriver.TxUnlock{LockScript: utxo.ScriptPubKey, Address: utxo.Address}
+		utxoAmount, _ := decimal.NewFromString(utxo.Amount)
+		txUnlock := btcLikeTxDriver.TxUnlock{
+			LockScript: utxo.ScriptPubKey,
+			Address:    utxo.Address,
+			Amount:     uint64(utxoAmount.Shift(decoder.wm.Decimal()).IntPart()),
+		}
+		err = decoder.setMultiSigRedeemScript(wrapper, rawTx.Account, &txUnlock)
+		if err != nil {
+			return err
+		}
 		txUnlocks = append(txUnlocks, txUnlock)
+		totalInput = totalInput.Add(utxoAmount)
 
 		txFrom = append(txFrom, fmt.Sprintf("%s:%s", utxo.Address, utxo.Amount))
 	}
 
+	//按签名后交易单的虚拟大小计算手续费，与预估的差额由找零承担
+	if changeAmount, ok := to[changeAddress]; ok {
+		sizingVouts := make([]btcLikeTxDriver.Vout, 0, len(to))
+		for addr, amount := range to {
+			sizingVouts = append(sizingVouts, btcLikeTxDriver.Vout{addr, uint64(amount.Shift(decoder.wm.Decimal()).IntPart())})
+		}
+		sizingTrans, err := btcLikeTxDriver.CreateEmptyRawTransaction(vins, sizingVouts, 0, false, decoder.wm.config.isTestNet)
+		if err != nil {
+			return fmt.Errorf("create transaction failed, unexpected error: %v", err)
+		}
+		fees, err := decoder.vsizeFees(rawTx, sizingTrans, txUnlocks)
+		if err != nil {
+			return err
+		}
+
+		otherSend := decimal.Zero
+		for _, amount := range to {
+			otherSend = otherSend.Add(amount)
+		}
+		otherSend = otherSend.Sub(changeAmount)
+
+		newChange := totalInput.Sub(otherSend).Sub(fees)
+		if newChange.LessThanOrEqual(decimal.Zero) {
+			return openwallet.Errorf(openwallet.ErrInsufficientFees, "The balance: %s is not enough to pay the fees: %s", totalInput.Sub(otherSend).StringFixed(decoder.wm.Decimal()), fees.StringFixed(decoder.wm.Decimal()))
+		}
+		to[changeAddress] = newChange
+		//单纯的找零低于粉尘阈值时计入手续费，汇总的接收金额由下面的粉尘检查拒绝
+		if _, isPayout := rawTx.To[changeAddress]; !isPayout {
+			dust, _, err := decoder.isDust(changeAddress, newChange)
+			if err != nil {
+				return err
+			}
+			if dust && len(to) > 1 {
+				delete(to, changeAddress)
+				fees = fees.Add(newChange)
+			}
+		}
+		//汇总交易的接收金额即该输出
+		if sent, ok := rawTx.To[changeAddress]; ok {
+			if sentAmount, _ := decimal.NewFromString(sent); sentAmount.Equal(changeAmount) {
+				rawTx.To[changeAddress] = newChange.StringFixed(decoder.wm.Decimal())
+			}
+		}
+		rawTx.Fees = fees.StringFixed(decoder.wm.Decimal())
+	}
+
+	//每个输出都不能低于其脚本类型的粉尘阈值
+	err = decoder.checkDustOutputs(to)
+	if err != nil {
+		return err
+	}
+
+	//计算总发送金额
+	for addr, amount := range to {
+		totalSend = totalSend.Add(amount)
+		destinations = append(destinations, addr)
+		//计算账户的实际转账amount
+		addresses, findErr := wrapper.GetAddressList(0, -1, "AccountID", accountID, "Address", addr)
+		if findErr != nil || len(addresses) == 0 {
+			//amountDec, _ := decimal.NewFromString(amount)
+			accountTotalSent = accountTotalSent.Add(amount)
+		}
+	}
+
 	//装配输入
 	for to, amount := range to {
 		txTo = append(txTo, fmt.Sprintf("%s:%s", to, amount))
@@ -1258,8 +1510,8 @@ func (decoder *TransactionDecoder) createSimpleRawTransaction(
 	//锁定时间
 	lockTime := uint32(0)
 
-	//追加手续费支持
-	replaceable := false
+	//追加手续费支持，通过扩展参数replaceable开启
+	replaceable := isReplaceable(rawTx)
 
 	/////////构建空交易单
 	emptyTrans, err := btcLikeTxDriver.CreateEmptyRawTransaction(vins, vouts, lockTime, replaceable, decoder.wm.config.isTestNet)
@@ -1278,38 +1530,22 @@ func (decoder *TransactionDecoder) createSimpleRawTransaction(
 
 	rawTx.RawHex = emptyTrans
 
-	if rawTx.Signatures == nil {
-		rawTx.Signatures = make(map[string][]*openwallet.KeySignature)
-	}
-
 	//装配签名
-	keySigs := make([]*openwallet.KeySignature, 0)
-
-	for i, unlock := range txUnlocks {
-
-		beSignHex := transHash[i]
-
-		addr, err := wrapper.GetAddress(unlock.Address)
-		if err != nil {
-			return err
-		}
-
-		signature := openwallet.KeySignature{
-			EccType: decoder.wm.config.CurveType,
-			Nonce:   "",
-			Address: addr,
-			Message: beSignHex,
-		}
-
-		keySigs = append(keySigs, &signature)
+	err = decoder.createKeySignatures(wrapper, rawTx, txUnlocks, transHash)
+	if err != nil {
+		return err
+	}
 
+	//记录输入的锁定脚本和金额，签名和验证时无需再查询节点
+	err = setRawTransactionPrevOuts(rawTx, vins, txUnlocks)
+	if err != nil {
+		return err
 	}
 
 	feesDec, _ := decimal.NewFromString(rawTx.Fees)
 	accountTotalSent = accountTotalSent.Add(feesDec)
 	accountTotalSent = decimal.Zero.Sub(accountTotalSent)
 
-	rawTx.Signatures[rawTx.Account.AccountID] = keySigs
 	rawTx.IsBuilt = true
 	rawTx.TxAmount = accountTotalSent.StringFixed(decoder.wm.Decimal())
 	rawTx.TxFrom = txFrom
@@ -1319,12 +1555,14 @@ func (decoder *TransactionDecoder) createSimpleRawTransaction(
 }
 
 //createQRC20RawTransaction 创建QRC20原始交易单
+//coinTo唯一的输出为找零，按签名后交易单的虚拟大小重新计算手续费后调整找零金额
 func (decoder *TransactionDecoder) createQRC2ORawTransaction(
 	wrapper openwallet.WalletDAI,
 	rawTx *openwallet.RawTransaction,
 	usedUTXO []*Unspent,
 	coinTo map[string]decimal.Decimal,
 	tokenTo map[string]string,
+	gas *ContractGas,
 ) error {
 
 	var (
@@ -1335,6 +1573,7 @@ func (decoder *TransactionDecoder) createQRC2ORawTransaction(
 		txUnlocks        = make([]btcLikeTxDriver.TxUnlock, 0)
 		accountTotalSent = decimal.Zero
 		toAmount         = decimal.Zero
+		totalInput       = decimal.Zero
 		txFrom           = make([]string, 0)
 		txTo             = make([]string, 0)
 		accountID        = rawTx.Account.AccountID
@@ -1382,8 +1621,18 @@ func (decoder *TransactionDecoder) createQRC2ORawTransaction(
 		in := btcLikeTxDriver.Vin{utxo.TxID, uint32(utxo.Vout)}
 		vins = append(vins, in)
 
-		txUnlock := btcLikeTxDriver.TxUnlock{LockScript: utxo.ScriptPubKey, Address: utxo.Address}
+		utxoAmount, _ := decimal.NewFromString(utxo.Amount)
+		txUnlock := btcLikeTxDriver.TxUnlock{
+			LockScript: utxo.ScriptPubKey,
+			Address:    utxo.Address,
+			Amount:     uint64(utxoAmount.Shift(decoder.wm.Decimal()).IntPart()),
+		}
+		err = decoder.setMultiSigRedeemScript(wrapper, rawTx.Account, &txUnlock)
+		if err != nil {
+			return err
+		}
 		txUnlocks = append(txUnlocks, txUnlock)
+		totalInput = totalInput.Add(utxoAmount)
 
 		//txFrom = append(txFrom, fmt.Sprintf("%s:%s", utxo.Address, utxo.Amount))
 	}
@@ -1401,18 +1650,52 @@ func (decoder *TransactionDecoder) createQRC2ORawTransaction(
 		return fmt.Errorf("the number of change addresses must be equal to one. ")
 	}
 
-	SotashiGasPriceDec := DEFAULT_GAS_PRICE.Shift(decoder.wm.Decimal())
+	SotashiGasPriceDec := gas.GasPrice.Shift(decoder.wm.Decimal())
 	gasPrice := SotashiGasPriceDec.String()
-	sendAmount := toAmount.Shift(tokenDecimals)
+	gasLimit := strconv.FormatUint(gas.GasLimit, 10)
+
+	callData, err := QRC20TransferCallData(to, toAmount, tokenDecimals, decoder.wm.config.isTestNet)
+	if err != nil {
+		return openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "%v", err)
+	}
 
 	//装配合约
-	vcontract := btcLikeTxDriver.Vcontract{contractAddr, to, sendAmount, DEFAULT_GAS_LIMIT, gasPrice, 0}
+	vcontract := btcLikeTxDriver.Vcontract{ContractAddr: contractAddr, GasLimit: gasLimit, GasPrice: gasPrice, CallData: hex.EncodeToString(callData)}
+
+	//按签名后交易单的虚拟大小（包含OP_CALL输出）计算手续费，与预估的差额由找零承担
+	sizingTrans, err := btcLikeTxDriver.CreateQRC20TokenEmptyRawTransaction(vins, vcontract, vouts, 0, false, decoder.wm.config.isTestNet)
+	if err != nil {
+		return fmt.Errorf("create transaction failed, unexpected error: %v", err)
+	}
+	fees, err := decoder.vsizeFees(rawTx, sizingTrans, txUnlocks)
+	if err != nil {
+		return err
+	}
+	fees = fees.Add(gas.Fees())
+	changeAmount := totalInput.Sub(fees)
+	if changeAmount.LessThanOrEqual(decimal.Zero) {
+		return openwallet.Errorf(openwallet.ErrInsufficientFees, "The [%s] available utxo balance: %s is not enough to pay the fees: %s", decoder.wm.Symbol(), totalInput.StringFixed(decoder.wm.Decimal()), fees.StringFixed(decoder.wm.Decimal()))
+	}
+	//低于粉尘阈值的找零计入手续费，交易单只保留合约调用输出
+	dust, _, err := decoder.isDust(vouts[0].Address, changeAmount)
+	if err != nil {
+		return err
+	}
+	if dust {
+		delete(coinTo, vouts[0].Address)
+		vouts = vouts[:0]
+		fees = fees.Add(changeAmount)
+	} else {
+		coinTo[vouts[0].Address] = changeAmount
+		vouts[0].Amount = uint64(changeAmount.Shift(decoder.wm.Decimal()).IntPart())
+	}
+	rawTx.Fees = fees.StringFixed(decoder.wm.Decimal())
 
 	//锁定时间
 	lockTime := uint32(0)
 
-	//追加手续费支持
-	replaceable := false
+	//追加手续费支持，通过扩展参数replaceable开启
+	replaceable := isReplaceable(rawTx)
 
 	/////////构建空交易单
 	emptyTrans, err := btcLikeTxDriver.CreateQRC20TokenEmptyRawTransaction(vins, vcontract, vouts, lockTime, replaceable, decoder.wm.config.isTestNet)
@@ -1431,38 +1714,22 @@ func (decoder *TransactionDecoder) createQRC2ORawTransaction(
 
 	rawTx.RawHex = emptyTrans
 
-	if rawTx.Signatures == nil {
-		rawTx.Signatures = make(map[string][]*openwallet.KeySignature)
-	}
-
 	//装配签名
-	keySigs := make([]*openwallet.KeySignature, 0)
-
-	for i, unlock := range txUnlocks {
-
-		beSignHex := transHash[i]
-
-		addr, err := wrapper.GetAddress(unlock.Address)
-		if err != nil {
-			return err
-		}
-
-		signature := openwallet.KeySignature{
-			EccType: decoder.wm.config.CurveType,
-			Nonce:   "",
-			Address: addr,
-			Message: beSignHex,
-		}
-
-		keySigs = append(keySigs, &signature)
+	err = decoder.createKeySignatures(wrapper, rawTx, txUnlocks, transHash)
+	if err != nil {
+		return err
+	}
 
+	//记录输入的锁定脚本和金额，签名和验证时无需再查询节点
+	err = setRawTransactionPrevOuts(rawTx, vins, txUnlocks)
+	if err != nil {
+		return err
 	}
 
 	//feesDec, _ := decimal.NewFromString(rawTx.Fees)
 	//accountTotalSent = accountTotalSent.Add(feesDec)
 	accountTotalSent = decimal.Zero.Sub(accountTotalSent)
 
-	rawTx.Signatures[rawTx.Account.AccountID] = keySigs
 	rawTx.IsBuilt = true
 	rawTx.TxAmount = accountTotalSent.StringFixed(tokenDecimals)
 	rawTx.TxFrom = txFrom
@@ -1536,6 +1803,257 @@ func (decoder *TransactionDecoder) getUTXOSatisfyAmount(unspents []*Unspent, amo
 	return usedUTXO, balance, nil
 }
 
+//isReplaceable 交易单是否开启追加手续费（BIP125），扩展参数：{"replaceable": true}
+func isReplaceable(rawTx *openwallet.RawTransaction) bool {
+	return rawTx.GetExtParam().Get("replaceable").Bool()
+}
+
+//vsizeFees 按签名后交易单的预估虚拟大小计算手续费，交易单没有指定费率时使用预估费率
+func (decoder *TransactionDecoder) vsizeFees(rawTx *openwallet.RawTransaction, emptyTrans string, txUnlocks []btcLikeTxDriver.TxUnlock) (decimal.Decimal, error) {
+
+	feeRate, err := decoder.rawTransactionFeeRate(rawTx)
+	if err != nil {
+		return decimal.Zero, err
+	}
+	rawTx.FeeRate = feeRate.StringFixed(decoder.wm.Decimal())
+
+	vsize, err := btcLikeTxDriver.EstimateRawTransactionVSize(emptyTrans, txUnlocks)
+	if err != nil {
+		return decimal.Zero, err
+	}
+
+	return decoder.wm.EstimateFeeBySize(vsize, feeRate)
+}
+
+//txPrevOut 交易单输入花费的输出，构建交易单时记录在扩展参数prevOuts中
+type txPrevOut struct {
+	TxID         string `json:"txid"`
+	Vout         uint32 `json:"vout"`
+	Address      string `json:"address"`
+	LockScript   string `json:"lockScript"`
+	RedeemScript string `json:"redeemScript,omitempty"`
+	Amount       uint64 `json:"amount"`
+}
+
+//setRawTransactionPrevOuts 记录交易单各输入的锁定脚本、赎回脚本和金额
+func setRawTransactionPrevOuts(rawTx *openwallet.RawTransaction, vins []btcLikeTxDriver.Vin, txUnlocks []btcLikeTxDriver.TxUnlock) error {
+
+	if len(vins) != len(txUnlocks) {
+		return fmt.Errorf("the number of inputs and unlock data are not match")
+	}
+
+	prevOuts := make([]txPrevOut, 0, len(vins))
+	for i, vin := range vins {
+		prevOuts = append(prevOuts, txPrevOut{
+			TxID:         vin.TxID,
+			Vout:         vin.Vout,
+			Address:      txUnlocks[i].Address,
+			LockScript:   txUnlocks[i].LockScript,
+			RedeemScript: txUnlocks[i].RedeemScript,
+			Amount:       txUnlocks[i].Amount,
+		})
+	}
+
+	return rawTx.SetExtParam("prevOuts", prevOuts)
+}
+
+//getRawTransactionTxUnlocks 获取交易单各输入的解锁数据，优先使用构建时记录的prevOuts
+//旧版本构建的交易单没有记录时，通过节点查询输出
+func (decoder *TransactionDecoder) getRawTransactionTxUnlocks(wrapper openwallet.WalletDAI, rawTx *openwallet.RawTransaction, trx *btcLikeTxDriver.Transaction) ([]btcLikeTxDriver.TxUnlock, error) {
+
+	txUnlocks := make([]btcLikeTxDriver.TxUnlock, 0, len(trx.Vins))
+
+	prevOuts := rawTx.GetExtParam().Get("prevOuts").Array()
+	if len(prevOuts) == len(trx.Vins) {
+		for i, vin := range trx.Vins {
+			prevOut := prevOuts[i]
+			if prevOut.Get("txid").String() != vin.GetTxID() || uint32(prevOut.Get("vout").Uint()) != vin.GetVout() {
+				return nil, fmt.Errorf("recorded prevOuts do not match the transaction input %d", i)
+			}
+			txUnlocks = append(txUnlocks, btcLikeTxDriver.TxUnlock{
+				LockScript:   prevOut.Get("lockScript").String(),
+				RedeemScript: prevOut.Get("redeemScript").String(),
+				Address:      prevOut.Get("address").String(),
+				Amount:       prevOut.Get("amount").Uint(),
+			})
+		}
+		return txUnlocks, nil
+	}
+
+	for _, vin := range trx.Vins {
+
+		utxo, err := decoder.wm.GetTxOut(vin.GetTxID(), uint64(vin.GetVout()))
+		if err != nil {
+			return nil, err
+		}
+
+		amount, _ := decimal.NewFromString(utxo.Value)
+		txUnlock := btcLikeTxDriver.TxUnlock{
+			LockScript: utxo.ScriptPubKey,
+			Address:    utxo.Addr,
+			Amount:     uint64(amount.Shift(decoder.wm.Decimal()).IntPart()),
+		}
+		err = decoder.setMultiSigRedeemScript(wrapper, rawTx.Account, &txUnlock)
+		if err != nil {
+			return nil, err
+		}
+		txUnlocks = append(txUnlocks, txUnlock)
+	}
+
+	return txUnlocks, nil
+}
+
+//multiSigOwnerKeys 多重签名账户的拥有者公钥
+func multiSigOwnerKeys(account *openwallet.AssetsAccount) []string {
+	owners := make([]string, 0)
+	if account == nil {
+		return owners
+	}
+	for _, owner := range account.OwnerKeys {
+		if len(owner) == 0 {
+			continue
+		}
+		owners = append(owners, owner)
+	}
+	return owners
+}
+
+//isMultiSigAccount 是否多重签名账户
+func isMultiSigAccount(account *openwallet.AssetsAccount) bool {
+	return len(multiSigOwnerKeys(account)) > 1
+}
+
+//multiSigRedeemScript 根据账户拥有者公钥和地址路径生成多重签名赎回脚本，并返回各拥有者的子公钥
+func (decoder *TransactionDecoder) multiSigRedeemScript(account *openwallet.AssetsAccount, hdPath string) ([]byte, [][]byte, error) {
+
+	paths := strings.Split(hdPath, "/")
+	if len(paths) < 2 {
+		return nil, nil, fmt.Errorf("invalid address hdPath: %s", hdPath)
+	}
+
+	change, err := strconv.ParseUint(paths[len(paths)-2], 10, 32)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid address hdPath: %s", hdPath)
+	}
+
+	index, err := strconv.ParseUint(paths[len(paths)-1], 10, 32)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid address hdPath: %s", hdPath)
+	}
+
+	pubkeys := make([][]byte, 0)
+	for _, owner := range multiSigOwnerKeys(account) {
+		ownerKey, err := owkeychain.OWDecode(owner)
+		if err != nil {
+			return nil, nil, err
+		}
+		childKey, err := ownerKey.GenPublicChild(uint32(change))
+		if err != nil {
+			return nil, nil, err
+		}
+		childKey, err = childKey.GenPublicChild(uint32(index))
+		if err != nil {
+			return nil, nil, err
+		}
+		pubkeys = append(pubkeys, childKey.GetPublicKeyBytes())
+	}
+
+	if account.Required > uint64(len(pubkeys)) {
+		return nil, nil, fmt.Errorf("account required signatures %d exceed the number of owners %d", account.Required, len(pubkeys))
+	}
+
+	redeem, err := btcLikeTxDriver.MultiSigRedeemScript(byte(account.Required), pubkeys)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return redeem, pubkeys, nil
+}
+
+//setMultiSigRedeemScript 多重签名账户的输入需要填充赎回脚本
+func (decoder *TransactionDecoder) setMultiSigRedeemScript(wrapper openwallet.WalletDAI, account *openwallet.AssetsAccount, txUnlock *btcLikeTxDriver.TxUnlock) error {
+
+	if !isMultiSigAccount(account) {
+		return nil
+	}
+
+	addr, err := wrapper.GetAddress(txUnlock.Address)
+	if err != nil {
+		return err
+	}
+
+	redeem, _, err := decoder.multiSigRedeemScript(account, addr.HDPath)
+	if err != nil {
+		return err
+	}
+
+	txUnlock.RedeemScript = hex.EncodeToString(redeem)
+
+	return nil
+}
+
+//createKeySignatures 装配待签名数据，多重签名账户为每个拥有者生成签名信息
+func (decoder *TransactionDecoder) createKeySignatures(wrapper openwallet.WalletDAI, rawTx *openwallet.RawTransaction, txUnlocks []btcLikeTxDriver.TxUnlock, transHash []string) error {
+
+	if rawTx.Signatures == nil {
+		rawTx.Signatures = make(map[string][]*openwallet.KeySignature)
+	}
+
+	account := rawTx.Account
+	multiSig := isMultiSigAccount(account)
+	owners := multiSigOwnerKeys(account)
+	keySigs := make([]*openwallet.KeySignature, 0)
+
+	for i, unlock := range txUnlocks {
+
+		beSignHex := transHash[i]
+
+		addr, err := wrapper.GetAddress(unlock.Address)
+		if err != nil {
+			return err
+		}
+
+		if !multiSig {
+			signature := openwallet.KeySignature{
+				EccType: decoder.wm.config.CurveType,
+				Nonce:   "",
+				Address: addr,
+				Message: beSignHex,
+			}
+
+			keySigs = append(keySigs, &signature)
+			continue
+		}
+
+		_, pubkeys, err := decoder.multiSigRedeemScript(account, addr.HDPath)
+		if err != nil {
+			return err
+		}
+
+		//每个拥有者各自签名，签名地址的公钥为拥有者派生的子公钥
+		for j, owner := range owners {
+			ownerAddr := *addr
+			ownerAddr.PublicKey = hex.EncodeToString(pubkeys[j])
+
+			signature := openwallet.KeySignature{
+				EccType: decoder.wm.config.CurveType,
+				Nonce:   "",
+				Address: &ownerAddr,
+				Message: beSignHex,
+			}
+
+			ownerID := openwallet.GenAccountID(owner)
+			rawTx.Signatures[ownerID] = append(rawTx.Signatures[ownerID], &signature)
+		}
+	}
+
+	if !multiSig {
+		rawTx.Signatures[account.AccountID] = keySigs
+	}
+
+	return nil
+}
+
 // removeUTXO
 func removeUTXO(slice []*Unspent, elem *Unspent) []*Unspent {
 	if len(slice) == 0 {
@@ -0,0 +1,485 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package qtum
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/asdine/storm"
+	"github.com/asdine/storm/q"
+	"github.com/blocktree/openwallet/common/file"
+	"github.com/blocktree/openwallet/openwallet"
+)
+
+const (
+	//utxoIndexKeepSpentBlocks 已花费的utxo保留的区块数，超过后分叉回滚无法恢复
+	utxoIndexKeepSpentBlocks = 1000
+	//utxoIndexDBFile 本地utxo索引的数据库文件
+	utxoIndexDBFile = "utxo.db"
+)
+
+//UTXORecord 区块扫描器索引的观测地址utxo
+type UTXORecord struct {
+	Key          string `storm:"id"` //txid_vout
+	TxID         string
+	Vout         uint64
+	Address      string `storm:"index"`
+	ScriptPubKey string
+	Amount       string
+	BlockHeight  uint64 `storm:"index"` //创建的区块高度
+	BlockHash    string
+	IsCoinBase   bool   //coinbase或coinstake的输出，成熟后才能花费
+	SpentTxID    string //花费的交易单
+	SpentHeight  uint64 `storm:"index"` //花费的区块高度，0为未花费
+}
+
+func utxoRecordKey(txid string, vout uint64) string {
+	return fmt.Sprintf("%s_%d", txid, vout)
+}
+
+//utxoSpend 区块中花费观测地址utxo的输入
+type utxoSpend struct {
+	Key  string
+	TxID string
+}
+
+//UTXOIndexDAI 扫描器utxo索引的持久化接口，BlockchainDAI同时实现该接口时，utxo索引与区块头一起保存，
+//否则保存在本地数据库
+type UTXOIndexDAI interface {
+	//SaveUTXORecords 保存或覆盖utxo记录
+	SaveUTXORecords(symbol string, records []*UTXORecord) error
+	//DeleteUTXORecords 删除utxo记录
+	DeleteUTXORecords(symbol string, keys []string) error
+	//GetUTXORecords 按txid_vout查询utxo记录，不存在的记录忽略
+	GetUTXORecords(symbol string, keys []string) ([]*UTXORecord, error)
+	//GetUTXORecordsByAddress 查询地址未花费的utxo记录
+	GetUTXORecordsByAddress(symbol string, address ...string) ([]*UTXORecord, error)
+	//GetUTXORecordsByHeight 查询在该高度创建或花费的utxo记录
+	GetUTXORecordsByHeight(symbol string, height uint64) ([]*UTXORecord, error)
+	//DeleteSpentUTXORecords 删除不高于该高度花费的utxo记录
+	DeleteSpentUTXORecords(symbol string, height uint64) error
+}
+
+//localUTXOIndexDB 保存在本地数据库的utxo索引
+type localUTXOIndexDB struct {
+	dbFile string
+}
+
+func (db *localUTXOIndexDB) open() (*storm.DB, error) {
+	file.MkdirAll(filepath.Dir(db.dbFile))
+	return storm.Open(db.dbFile)
+}
+
+func (db *localUTXOIndexDB) SaveUTXORecords(symbol string, records []*UTXORecord) error {
+	if len(records) == 0 {
+		return nil
+	}
+	sdb, err := db.open()
+	if err != nil {
+		return err
+	}
+	defer sdb.Close()
+
+	tx, err := sdb.From(symbol).Begin(true)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, r := range records {
+		if err := tx.Save(r); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (db *localUTXOIndexDB) DeleteUTXORecords(symbol string, keys []string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	sdb, err := db.open()
+	if err != nil {
+		return err
+	}
+	defer sdb.Close()
+
+	tx, err := sdb.From(symbol).Begin(true)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, key := range keys {
+		if err := tx.DeleteStruct(&UTXORecord{Key: key}); err != nil && err != storm.ErrNotFound {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (db *localUTXOIndexDB) GetUTXORecords(symbol string, keys []string) ([]*UTXORecord, error) {
+	return db.find(symbol, q.In("Key", keys))
+}
+
+func (db *localUTXOIndexDB) GetUTXORecordsByAddress(symbol string, address ...string) ([]*UTXORecord, error) {
+	return db.find(symbol, q.And(q.In("Address", address), q.Eq("SpentHeight", uint64(0))))
+}
+
+func (db *localUTXOIndexDB) GetUTXORecordsByHeight(symbol string, height uint64) ([]*UTXORecord, error) {
+	return db.find(symbol, q.Or(q.Eq("BlockHeight", height), q.Eq("SpentHeight", height)))
+}
+
+func (db *localUTXOIndexDB) DeleteSpentUTXORecords(symbol string, height uint64) error {
+	sdb, err := db.open()
+	if err != nil {
+		return err
+	}
+	defer sdb.Close()
+
+	err = sdb.From(symbol).Select(q.Gt("SpentHeight", uint64(0)), q.Lte("SpentHeight", height)).Delete(&UTXORecord{})
+	if err != nil && err != storm.ErrNotFound {
+		return err
+	}
+	return nil
+}
+
+func (db *localUTXOIndexDB) find(symbol string, matcher q.Matcher) ([]*UTXORecord, error) {
+	sdb, err := db.open()
+	if err != nil {
+		return nil, err
+	}
+	defer sdb.Close()
+
+	var records []*UTXORecord
+	err = sdb.From(symbol).Select(matcher).Find(&records)
+	if err != nil && err != storm.ErrNotFound {
+		return nil, err
+	}
+	return records, nil
+}
+
+//utxoPendingTx 交易池中未确认交易单新增和花费的utxo，只保存在内存中
+type utxoPendingTx struct {
+	created []*UTXORecord
+	spent   []*utxoSpend
+}
+
+//utxoIndex 区块扫描器维护的观测地址utxo集合
+type utxoIndex struct {
+	mu      sync.Mutex
+	symbol  string
+	store   func() UTXOIndexDAI
+	pending map[string]*utxoPendingTx //txid -> 未确认交易单
+}
+
+//applyMempool 记录未确认交易单新增和花费的utxo，重复记录同一交易单的结果不变
+func (index *utxoIndex) applyMempool(txid string, created []*UTXORecord, spent []*utxoSpend) {
+
+	if len(created) == 0 && len(spent) == 0 {
+		return
+	}
+
+	index.mu.Lock()
+	defer index.mu.Unlock()
+
+	if index.pending == nil {
+		index.pending = make(map[string]*utxoPendingTx)
+	}
+	index.pending[txid] = &utxoPendingTx{created: created, spent: spent}
+}
+
+//prunePending 删除已离开交易池的未确认交易单，被打包、替换或丢弃的交易单不再影响utxo集合
+func (index *utxoIndex) prunePending(txids []string) {
+
+	index.mu.Lock()
+	defer index.mu.Unlock()
+
+	pool := make(map[string]struct{}, len(txids))
+	for _, txid := range txids {
+		pool[txid] = struct{}{}
+	}
+	for txid := range index.pending {
+		if _, ok := pool[txid]; !ok {
+			delete(index.pending, txid)
+		}
+	}
+}
+
+//hasPending 是否有未确认交易单
+func (index *utxoIndex) hasPending() bool {
+	index.mu.Lock()
+	defer index.mu.Unlock()
+	return len(index.pending) > 0
+}
+
+//applyBlock 记录区块中观测地址新增和花费的utxo，重复扫描同一区块的结果不变
+func (index *utxoIndex) applyBlock(height uint64, created []*UTXORecord, spent []*utxoSpend) error {
+
+	if len(created) == 0 && len(spent) == 0 {
+		return nil
+	}
+
+	index.mu.Lock()
+	defer index.mu.Unlock()
+
+	store := index.store()
+
+	keys := make([]string, 0, len(created)+len(spent))
+	for _, r := range created {
+		keys = append(keys, r.Key)
+	}
+	for _, s := range spent {
+		keys = append(keys, s.Key)
+	}
+	existing, err := store.GetUTXORecords(index.symbol, keys)
+	if err != nil {
+		return err
+	}
+	records := make(map[string]*UTXORecord)
+	for _, r := range existing {
+		records[r.Key] = r
+	}
+
+	changed := make(map[string]*UTXORecord)
+	for _, r := range created {
+		delete(index.pending, r.TxID)
+		//重扫时保留已记录的花费
+		if old, ok := records[r.Key]; ok && old.SpentHeight > 0 {
+			r.SpentTxID = old.SpentTxID
+			r.SpentHeight = old.SpentHeight
+		}
+		records[r.Key] = r
+		changed[r.Key] = r
+	}
+	for _, s := range spent {
+		delete(index.pending, s.TxID)
+		//索引开始前创建的utxo不在集合中
+		r, ok := records[s.Key]
+		if !ok {
+			continue
+		}
+		r.SpentTxID = s.TxID
+		r.SpentHeight = height
+		changed[r.Key] = r
+	}
+
+	save := make([]*UTXORecord, 0, len(changed))
+	for _, r := range changed {
+		save = append(save, r)
+	}
+	err = store.SaveUTXORecords(index.symbol, save)
+	if err != nil {
+		return err
+	}
+
+	if height > utxoIndexKeepSpentBlocks {
+		return store.DeleteSpentUTXORecords(index.symbol, height-utxoIndexKeepSpentBlocks)
+	}
+	return nil
+}
+
+//rollback 回滚分叉区块：删除该区块创建的utxo，恢复该区块花费的utxo
+func (index *utxoIndex) rollback(height uint64) error {
+
+	index.mu.Lock()
+	defer index.mu.Unlock()
+
+	store := index.store()
+
+	records, err := store.GetUTXORecordsByHeight(index.symbol, height)
+	if err != nil {
+		return err
+	}
+
+	deleted := make([]string, 0)
+	restored := make([]*UTXORecord, 0)
+	for _, r := range records {
+		if r.BlockHeight == height {
+			deleted = append(deleted, r.Key)
+		} else if r.SpentHeight == height {
+			r.SpentTxID = ""
+			r.SpentHeight = 0
+			restored = append(restored, r)
+		}
+	}
+
+	err = store.DeleteUTXORecords(index.symbol, deleted)
+	if err != nil {
+		return err
+	}
+	return store.SaveUTXORecords(index.symbol, restored)
+}
+
+//listUnspent 按已扫描高度计算确认数，返回地址确认数不低于min的utxo
+//未确认交易单花费的utxo不再返回，min为0时包含未确认交易单新增的utxo
+func (index *utxoIndex) listUnspent(scannedHeight, min uint64, addresses ...string) ([]*Unspent, error) {
+
+	records, err := index.store().GetUTXORecordsByAddress(index.symbol, addresses...)
+	if err != nil {
+		return nil, err
+	}
+
+	index.mu.Lock()
+	pendingSpent := make(map[string]struct{})
+	pendingCreated := make([]*UTXORecord, 0)
+	for _, tx := range index.pending {
+		for _, s := range tx.spent {
+			pendingSpent[s.Key] = struct{}{}
+		}
+		pendingCreated = append(pendingCreated, tx.created...)
+	}
+	index.mu.Unlock()
+
+	if min == 0 {
+		watched := make(map[string]struct{}, len(addresses))
+		for _, a := range addresses {
+			watched[a] = struct{}{}
+		}
+		confirmed := make(map[string]struct{}, len(records))
+		for _, r := range records {
+			confirmed[r.Key] = struct{}{}
+		}
+		for _, r := range pendingCreated {
+			if _, ok := watched[r.Address]; !ok {
+				continue
+			}
+			if _, ok := confirmed[r.Key]; ok {
+				continue
+			}
+			records = append(records, r)
+		}
+	}
+
+	sort.Slice(records, func(i, j int) bool {
+		//未确认的utxo排在最后
+		hi, hj := records[i].BlockHeight, records[j].BlockHeight
+		if (hi == 0) != (hj == 0) {
+			return hj == 0
+		}
+		if hi != hj {
+			return hi < hj
+		}
+		return records[i].Key < records[j].Key
+	})
+
+	utxos := make([]*Unspent, 0, len(records))
+	for _, r := range records {
+		if _, ok := pendingSpent[r.Key]; ok {
+			continue
+		}
+		confirmations := uint64(0)
+		if r.BlockHeight > 0 && scannedHeight >= r.BlockHeight {
+			confirmations = scannedHeight - r.BlockHeight + 1
+		}
+		if confirmations < min {
+			continue
+		}
+		utxos = append(utxos, &Unspent{
+			TxID:          r.TxID,
+			Vout:          r.Vout,
+			Address:       r.Address,
+			ScriptPubKey:  r.ScriptPubKey,
+			Amount:        r.Amount,
+			Confirmations: confirmations,
+			Spendable:     !r.IsCoinBase || confirmations >= StakeConfirmations,
+			Solvable:      true,
+		})
+	}
+	return utxos, nil
+}
+
+//utxoIndexStore 优先使用BlockchainDAI保存utxo索引
+func (bs *BTCBlockScanner) utxoIndexStore() UTXOIndexDAI {
+	if store, ok := bs.BlockchainDAI.(UTXOIndexDAI); ok {
+		return store
+	}
+	return &localUTXOIndexDB{dbFile: filepath.Join(bs.wm.config.dbPath, utxoIndexDBFile)}
+}
+
+//collectUTXOChanges 记录交易单中观测地址新增和花费的utxo，未确认交易单的BlockHeight为0
+func (bs *BTCBlockScanner) collectUTXOChanges(trx *Transaction, result *ExtractResult, scanAddressFunc openwallet.BlockScanAddressFunc) {
+
+	if !bs.wm.config.UTXOIndex || trx == nil {
+		return
+	}
+
+	if !trx.IsCoinBase {
+		for _, input := range trx.Vins {
+			if _, ok := scanAddressFunc(input.Addr); ok {
+				result.utxoSpent = append(result.utxoSpent, &utxoSpend{Key: utxoRecordKey(input.TxID, input.Vout), TxID: trx.TxID})
+			}
+		}
+	}
+
+	for _, output := range trx.Vouts {
+		if _, ok := scanAddressFunc(output.Addr); !ok {
+			continue
+		}
+		result.utxoCreated = append(result.utxoCreated, &UTXORecord{
+			Key:          utxoRecordKey(trx.TxID, output.N),
+			TxID:         trx.TxID,
+			Vout:         output.N,
+			Address:      output.Addr,
+			ScriptPubKey: output.ScriptPubKey,
+			Amount:       output.Value,
+			BlockHeight:  trx.BlockHeight,
+			BlockHash:    trx.BlockHash,
+			IsCoinBase:   trx.IsCoinBase || trx.IsCoinstake,
+		})
+	}
+}
+
+//trackSubmittedTransaction 记录已广播交易单花费和新增的utxo，扫描到交易池或区块前，
+//再次创建交易单不会使用已花费的utxo
+func (bs *BTCBlockScanner) trackSubmittedTransaction(txid string) error {
+
+	if !bs.wm.config.UTXOIndex {
+		return nil
+	}
+
+	trx, err := bs.chain.GetTransaction(txid)
+	if err != nil {
+		return err
+	}
+
+	//只记录交易单本身的变化，查询时按地址过滤
+	result := &ExtractResult{}
+	bs.collectUTXOChanges(trx, result, func(address string) (string, bool) {
+		return "", len(address) > 0
+	})
+	if trx.BlockHeight == 0 {
+		bs.utxoIndex.applyMempool(txid, result.utxoCreated, result.utxoSpent)
+	}
+	return nil
+}
+
+//ListUnspentByIndex 从扫描器的utxo索引查询地址的utxo
+//查询前删除已离开交易池的未确认交易单，交易池查询失败时保留，宁可少返回utxo也不双花
+func (bs *BTCBlockScanner) ListUnspentByIndex(min uint64, addresses ...string) ([]*Unspent, error) {
+	if bs.utxoIndex.hasPending() {
+		txids, err := bs.chain.GetTxIDsInMemPool()
+		if err != nil {
+			bs.wm.Log.Std.Warning("block scanner can not get mempool data; unexpected error: %v", err)
+		} else {
+			bs.utxoIndex.prunePending(txids)
+		}
+	}
+	return bs.utxoIndex.listUnspent(bs.GetScannedBlockHeight(), min, addresses...)
+}
@@ -0,0 +1,161 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package qtum
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func testUTXOIndexScanner(t *testing.T) (*BTCBlockScanner, func()) {
+	dir, err := ioutil.TempDir("", "utxoindex")
+	if err != nil {
+		t.Fatalf("create temp dir failed: %v", err)
+	}
+	wm := NewWalletManager()
+	wm.config.dbPath = dir
+	wm.config.UTXOIndex = true
+	return wm.blockscanner, func() { os.RemoveAll(dir) }
+}
+
+func testScanAddress(watched ...string) func(address string) (string, bool) {
+	return func(address string) (string, bool) {
+		for _, a := range watched {
+			if a == address {
+				return "account", true
+			}
+		}
+		return "", false
+	}
+}
+
+func unspentKeys(utxos []*Unspent) string {
+	keys := make([]string, 0, len(utxos))
+	for _, u := range utxos {
+		keys = append(keys, utxoRecordKey(u.TxID, u.Vout))
+	}
+	return strings.Join(keys, ",")
+}
+
+func TestUTXOIndex(t *testing.T) {
+	bs, clean := testUTXOIndexScanner(t)
+	defer clean()
+
+	scanAddress := testScanAddress("a", "b")
+
+	//区块100：a收到两笔，coinstake给b
+	block100 := &ExtractResult{}
+	bs.collectUTXOChanges(&Transaction{TxID: "t1", BlockHeight: 100, Vouts: []*Vout{{N: 0, Addr: "a", Value: "1"}, {N: 1, Addr: "x", Value: "2"}}}, block100, scanAddress)
+	bs.collectUTXOChanges(&Transaction{TxID: "t2", BlockHeight: 100, Vouts: []*Vout{{N: 0, Addr: "a", Value: "0.5"}}}, block100, scanAddress)
+	bs.collectUTXOChanges(&Transaction{TxID: "t3", BlockHeight: 100, IsCoinstake: true, Vouts: []*Vout{{N: 1, Addr: "b", Value: "4"}}}, block100, scanAddress)
+	if err := bs.utxoIndex.applyBlock(100, block100.utxoCreated, block100.utxoSpent); err != nil {
+		t.Fatalf("apply block 100 failed: %v", err)
+	}
+
+	//区块101：花费t1_0，找零给a，同一区块中花费刚创建的t4_1
+	block101 := &ExtractResult{}
+	bs.collectUTXOChanges(&Transaction{TxID: "t5", BlockHeight: 101, Vins: []*Vin{{TxID: "t4", Vout: 1, Addr: "a", Value: "0.3"}}, Vouts: []*Vout{{N: 0, Addr: "x", Value: "0.2"}}}, block101, scanAddress)
+	bs.collectUTXOChanges(&Transaction{TxID: "t4", BlockHeight: 101, Vins: []*Vin{{TxID: "t1", Vout: 0, Addr: "a", Value: "1"}}, Vouts: []*Vout{{N: 0, Addr: "x", Value: "0.6"}, {N: 1, Addr: "a", Value: "0.3"}}}, block101, scanAddress)
+	if err := bs.utxoIndex.applyBlock(101, block101.utxoCreated, block101.utxoSpent); err != nil {
+		t.Fatalf("apply block 101 failed: %v", err)
+	}
+	//重复扫描结果不变
+	if err := bs.utxoIndex.applyBlock(100, block100.utxoCreated, block100.utxoSpent); err != nil {
+		t.Fatalf("rescan block 100 failed: %v", err)
+	}
+
+	utxos, err := bs.utxoIndex.listUnspent(101, 0, "a", "b")
+	if err != nil {
+		t.Fatalf("listUnspent failed: %v", err)
+	}
+	if got := unspentKeys(utxos); got != "t2_0,t3_1" {
+		t.Errorf("unspent after block 101 = %s, want t2_0,t3_1", got)
+	}
+	if utxos[0].Confirmations != 2 || !utxos[0].Spendable {
+		t.Errorf("t2_0 = %+v, want 2 confirmations and spendable", utxos[0])
+	}
+	//coinstake输出未成熟
+	if utxos[1].Spendable {
+		t.Errorf("immature coinstake output should not be spendable")
+	}
+	if utxos, _ := bs.utxoIndex.listUnspent(101, 3, "a"); len(utxos) != 0 {
+		t.Errorf("min confirmations should filter the utxo: %s", unspentKeys(utxos))
+	}
+
+	//区块101分叉
+	if err := bs.utxoIndex.rollback(101); err != nil {
+		t.Fatalf("rollback failed: %v", err)
+	}
+	utxos, _ = bs.utxoIndex.listUnspent(100, 0, "a")
+	if got := unspentKeys(utxos); got != "t1_0,t2_0" {
+		t.Errorf("unspent after rollback = %s, want t1_0,t2_0", got)
+	}
+}
+
+func TestUTXOIndexMempool(t *testing.T) {
+	bs, clean := testUTXOIndexScanner(t)
+	defer clean()
+
+	scanAddress := testScanAddress("a")
+
+	block100 := &ExtractResult{}
+	bs.collectUTXOChanges(&Transaction{TxID: "t1", BlockHeight: 100, Vouts: []*Vout{{N: 0, Addr: "a", Value: "1"}, {N: 1, Addr: "a", Value: "2"}}}, block100, scanAddress)
+	if err := bs.utxoIndex.applyBlock(100, block100.utxoCreated, block100.utxoSpent); err != nil {
+		t.Fatalf("apply block 100 failed: %v", err)
+	}
+
+	//交易池中t2花费t1_0，找零给a
+	mempool := &ExtractResult{}
+	bs.collectUTXOChanges(&Transaction{TxID: "t2", Vins: []*Vin{{TxID: "t1", Vout: 0, Addr: "a", Value: "1"}}, Vouts: []*Vout{{N: 0, Addr: "x", Value: "0.6"}, {N: 1, Addr: "a", Value: "0.3"}}}, mempool, scanAddress)
+	bs.utxoIndex.applyMempool("t2", mempool.utxoCreated, mempool.utxoSpent)
+
+	//已花费的utxo不再返回，未确认的找零只在min为0时返回
+	utxos, _ := bs.utxoIndex.listUnspent(100, 1, "a")
+	if got := unspentKeys(utxos); got != "t1_1" {
+		t.Errorf("confirmed unspent = %s, want t1_1", got)
+	}
+	utxos, _ = bs.utxoIndex.listUnspent(100, 0, "a")
+	if got := unspentKeys(utxos); got != "t1_1,t2_1" {
+		t.Errorf("unspent with mempool = %s, want t1_1,t2_1", got)
+	}
+	if utxos[1].Confirmations != 0 || !utxos[1].Spendable {
+		t.Errorf("t2_1 = %+v, want 0 confirmations and spendable", utxos[1])
+	}
+
+	//交易单被丢弃，t1_0恢复可用
+	bs.utxoIndex.prunePending([]string{})
+	utxos, _ = bs.utxoIndex.listUnspent(100, 0, "a")
+	if got := unspentKeys(utxos); got != "t1_0,t1_1" {
+		t.Errorf("unspent after drop = %s, want t1_0,t1_1", got)
+	}
+
+	//交易单被打包后由区块记录
+	bs.utxoIndex.applyMempool("t2", mempool.utxoCreated, mempool.utxoSpent)
+	block101 := &ExtractResult{}
+	bs.collectUTXOChanges(&Transaction{TxID: "t2", BlockHeight: 101, Vins: []*Vin{{TxID: "t1", Vout: 0, Addr: "a", Value: "1"}}, Vouts: []*Vout{{N: 0, Addr: "x", Value: "0.6"}, {N: 1, Addr: "a", Value: "0.3"}}}, block101, scanAddress)
+	if err := bs.utxoIndex.applyBlock(101, block101.utxoCreated, block101.utxoSpent); err != nil {
+		t.Fatalf("apply block 101 failed: %v", err)
+	}
+	if bs.utxoIndex.hasPending() {
+		t.Errorf("confirmed transaction should not be pending")
+	}
+	utxos, _ = bs.utxoIndex.listUnspent(101, 0, "a")
+	if got := unspentKeys(utxos); got != "t1_1,t2_1" {
+		t.Errorf("unspent after block 101 = %s, want t1_1,t2_1", got)
+	}
+}